@@ -14,11 +14,20 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"net"
+	"net/netip"
 	"net/textproto"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort"
@@ -36,6 +45,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 )
 
 var (
@@ -45,6 +55,64 @@ var (
 		TCP:  DefaultTCPProbe,
 		ICMP: DefaultICMPProbe,
 		DNS:  DefaultDNSProbe,
+		TLS:  DefaultTLSProbe,
+		UDP:  DefaultUDPProbe,
+
+		Traceroute: DefaultTracerouteProbe,
+		SMTP:       DefaultSMTPProbe,
+		IMAP:       DefaultIMAPProbe,
+		POP3:       DefaultPOP3Probe,
+		SSH:        DefaultSSHProbe,
+		LDAP:       DefaultLDAPProbe,
+		MQTT:       DefaultMQTTProbe,
+		Kafka:      DefaultKafkaProbe,
+		Memcached:  DefaultMemcachedProbe,
+		Postgres:   DefaultPostgresProbe,
+		MySQL:      DefaultMySQLProbe,
+		MongoDB:    DefaultMongoDBProbe,
+		SNMP:       DefaultSNMPProbe,
+		RTSP:       DefaultRTSPProbe,
+		Modbus:     DefaultModbusProbe,
+		STUN:       DefaultSTUNProbe,
+		TURN:       DefaultTURNProbe,
+		RADIUS:     DefaultRADIUSProbe,
+		DHCP:       DefaultDHCPProbe,
+	}
+
+	// DefaultRemoteWriteConfig set default value for RemoteWriteConfig
+	DefaultRemoteWriteConfig = RemoteWriteConfig{
+		QueueCapacity:    10000,
+		MaxBackoff:       30 * time.Second,
+		HTTPClientConfig: config.DefaultHTTPClientConfig,
+	}
+
+	// DefaultScheduledProbe set default value for ScheduledProbe
+	DefaultScheduledProbe = ScheduledProbe{
+		Interval: time.Minute,
+	}
+
+	// DefaultFileSDConfig set default value for FileSDConfig
+	DefaultFileSDConfig = FileSDConfig{
+		RefreshInterval: 30 * time.Second,
+	}
+
+	// DefaultDNSSDConfig set default value for DNSSDConfig
+	DefaultDNSSDConfig = DNSSDConfig{
+		RefreshInterval: 30 * time.Second,
+	}
+
+	// DefaultHTTPSDConfig set default value for HTTPSDConfig
+	DefaultHTTPSDConfig = HTTPSDConfig{
+		RefreshInterval:  time.Minute,
+		HTTPClientConfig: config.DefaultHTTPClientConfig,
+	}
+
+	// DefaultRelabelConfig set default value for RelabelConfig
+	DefaultRelabelConfig = RelabelConfig{
+		Separator:   ";",
+		Regex:       MustNewRegexp("(.*)"),
+		Replacement: "$1",
+		Action:      "replace",
 	}
 
 	// DefaultHTTPProbe set default value for HTTPProbe
@@ -59,6 +127,211 @@ var (
 		IPProtocolFallback: true,
 	}
 
+	// DefaultTLSProbe set default value for TLSProbe
+	DefaultTLSProbe = TLSProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultUDPProbe set default value for UDPProbe
+	DefaultUDPProbe = UDPProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultSMTPProbe set default value for SMTPProbe
+	DefaultSMTPProbe = SMTPProbe{
+		IPProtocolFallback: true,
+		EHLODomain:         "localhost",
+	}
+
+	// DefaultIMAPProbe set default value for IMAPProbe
+	DefaultIMAPProbe = IMAPProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultPOP3Probe set default value for POP3Probe
+	DefaultPOP3Probe = POP3Probe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultSSHProbe set default value for SSHProbe
+	DefaultSSHProbe = SSHProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultLDAPProbe set default value for LDAPProbe
+	DefaultLDAPProbe = LDAPProbe{
+		IPProtocolFallback: true,
+		SearchFilter:       "(objectClass=*)",
+	}
+
+	// DefaultMQTTProbe set default value for MQTTProbe
+	DefaultMQTTProbe = MQTTProbe{
+		IPProtocolFallback: true,
+		ProtocolVersion:    "3.1.1",
+	}
+
+	// DefaultKafkaProbe set default value for KafkaProbe
+	DefaultKafkaProbe = KafkaProbe{
+		IPProtocolFallback: true,
+		ClientID:           "blackbox_exporter",
+	}
+
+	// DefaultMemcachedProbe set default value for MemcachedProbe
+	DefaultMemcachedProbe = MemcachedProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultPostgresProbe set default value for PostgresProbe
+	DefaultPostgresProbe = PostgresProbe{
+		IPProtocolFallback: true,
+		Username:           "postgres",
+		Query:              "SELECT 1",
+	}
+
+	// DefaultMySQLProbe set default value for MySQLProbe
+	DefaultMySQLProbe = MySQLProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultMongoDBProbe set default value for MongoDBProbe
+	DefaultMongoDBProbe = MongoDBProbe{
+		IPProtocolFallback: true,
+		AuthSource:         "admin",
+		AuthMechanism:      "SCRAM-SHA-256",
+	}
+
+	// DefaultSNMPProbe set default value for SNMPProbe
+	DefaultSNMPProbe = SNMPProbe{
+		IPProtocolFallback: true,
+		Version:            "2c",
+		Community:          "public",
+		AuthProtocol:       "SHA",
+	}
+
+	// DefaultRTSPProbe set default value for RTSPProbe
+	DefaultRTSPProbe = RTSPProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultModbusProbe set default value for ModbusProbe
+	DefaultModbusProbe = ModbusProbe{
+		IPProtocolFallback: true,
+		UnitID:             1,
+	}
+
+	// DefaultSTUNProbe set default value for STUNProbe
+	DefaultSTUNProbe = STUNProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultTURNProbe set default value for TURNProbe
+	DefaultTURNProbe = TURNProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultRADIUSProbe set default value for RADIUSProbe
+	DefaultRADIUSProbe = RADIUSProbe{
+		IPProtocolFallback: true,
+		AuthMethod:         "PAP",
+		NASIdentifier:      "blackbox_exporter",
+	}
+
+	// DefaultDHCPProbe set default value for DHCPProbe
+	DefaultDHCPProbe = DHCPProbe{}
+
+	// DefaultTFTPProbe set default value for TFTPProbe
+	DefaultTFTPProbe = TFTPProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultSyslogProbe set default value for SyslogProbe
+	DefaultSyslogProbe = SyslogProbe{
+		IPProtocolFallback: true,
+		Protocol:           "udp",
+		Framing:            "octet-counting",
+		AppName:            "blackbox_exporter",
+		Message:            "blackbox_exporter test message",
+	}
+
+	// DefaultNATSProbe set default value for NATSProbe
+	DefaultNATSProbe = NATSProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultEtcdProbe set default value for EtcdProbe
+	DefaultEtcdProbe = EtcdProbe{
+		IPProtocolFallback: true,
+		HTTPClientConfig:   config.DefaultHTTPClientConfig,
+	}
+
+	// DefaultElasticsearchProbe set default value for ElasticsearchProbe
+	DefaultElasticsearchProbe = ElasticsearchProbe{
+		IPProtocolFallback: true,
+		HTTPClientConfig:   config.DefaultHTTPClientConfig,
+		MinimumStatus:      "yellow",
+	}
+
+	// DefaultGitProbe set default value for GitProbe
+	DefaultGitProbe = GitProbe{
+		IPProtocolFallback: true,
+		HTTPClientConfig:   config.DefaultHTTPClientConfig,
+	}
+
+	// DefaultRegistryProbe set default value for RegistryProbe
+	DefaultRegistryProbe = RegistryProbe{
+		IPProtocolFallback: true,
+		Reference:          "latest",
+	}
+
+	// DefaultSMBProbe set default value for SMBProbe
+	DefaultSMBProbe = SMBProbe{
+		IPProtocolFallback: true,
+	}
+
+	// DefaultNFSProbe set default value for NFSProbe
+	DefaultNFSProbe = NFSProbe{
+		IPProtocolFallback: true,
+		Services:           []string{"nfs"},
+		NFSVersion:         3,
+	}
+
+	// DefaultOPCUAProbe set default value for OPCUAProbe
+	DefaultOPCUAProbe = OPCUAProbe{
+		IPProtocolFallback: true,
+		NodeID:             "i=2259",
+	}
+
+	// DefaultWebDAVProbe set default value for WebDAVProbe
+	DefaultWebDAVProbe = WebDAVProbe{
+		IPProtocolFallback: true,
+		HTTPClientConfig:   config.DefaultHTTPClientConfig,
+	}
+
+	// DefaultTelnetProbe set default value for TelnetProbe
+	DefaultTelnetProbe = TelnetProbe{
+		IPProtocolFallback: true,
+		Expect:             MustNewRegexp(`(?i)(login|user ?name|password)\s*:`),
+	}
+
+	// DefaultIRCProbe set default value for IRCProbe
+	DefaultIRCProbe = IRCProbe{
+		IPProtocolFallback: true,
+		Nick:               "blackbox",
+	}
+
+	// DefaultKubernetesProbe set default value for KubernetesProbe
+	DefaultKubernetesProbe = KubernetesProbe{
+		IPProtocolFallback: true,
+		HTTPClientConfig:   config.DefaultHTTPClientConfig,
+	}
+
+	// DefaultTracerouteProbe set default value for TracerouteProbe
+	DefaultTracerouteProbe = TracerouteProbe{
+		IPProtocolFallback: true,
+		MaxHops:            30,
+		HopTimeout:         time.Second,
+	}
+
 	// DefaultTCPProbe set default value for TCPProbe
 	DefaultTCPProbe = TCPProbe{
 		IPProtocolFallback: true,
@@ -69,6 +342,8 @@ var (
 	DefaultICMPProbe = ICMPProbe{
 		IPProtocolFallback: true,
 		TTL:                DefaultICMPTTL,
+		PacketCount:        1,
+		PacketInterval:     200 * time.Millisecond,
 	}
 
 	// DefaultDNSProbe set default value for DNSProbe
@@ -78,8 +353,105 @@ var (
 	}
 )
 
+// MaxTCPScanPorts limits the number of ports a single TCP probe scans.
+const MaxTCPScanPorts = 1024
+
+// validateDSCP checks that the DSCP value can be set on probe packets.
+func validateDSCP(dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return errors.New("\"dscp\" must be in the range [0, 63]")
+	}
+	if runtime.GOOS == "windows" && dscp != 0 {
+		return errors.New("\"dscp\" is not supported on windows platforms")
+	}
+	return nil
+}
+
+// MaxICMPPayloadSize is the largest payload of an echo request that fits into
+// an IPv4 packet.
+const MaxICMPPayloadSize = 65507
+
 type Config struct {
-	Modules map[string]Module `yaml:"modules"`
+	// Include lists files or glob patterns, relative to the configuration
+	// file, whose modules are merged into the configuration.
+	Include []string `yaml:"include,omitempty"`
+	// LabelParams lists the /probe parameters that are added as labels to
+	// all metrics of a probe.
+	LabelParams []string `yaml:"label_params,omitempty"`
+	// TargetFilter restricts the targets of all modules.
+	TargetFilter TargetFilter `yaml:"target_filter,omitempty"`
+	// Exemplars adds a histogram of the probe duration whose exemplar
+	// identifies the probe in the history and the trace of the scrape.
+	Exemplars bool `yaml:"exemplars,omitempty"`
+	// RemoteWrite configures pushing the results of all probes to a remote
+	// write endpoint.
+	RemoteWrite *RemoteWriteConfig `yaml:"remote_write,omitempty"`
+	// ScheduledProbes are probed by the exporter itself on their own
+	// intervals, independent of scrapes.
+	ScheduledProbes []ScheduledProbe  `yaml:"scheduled_probes,omitempty"`
+	Modules         map[string]Module `yaml:"modules"`
+}
+
+// ScheduledProbe is a set of targets that the exporter probes with a module
+// on a fixed interval.
+type ScheduledProbe struct {
+	Module   string        `yaml:"module"`
+	Targets  []string      `yaml:"targets,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// The targets of service discoveries are added to the static ones.
+	FileSDConfigs []FileSDConfig `yaml:"file_sd_configs,omitempty"`
+	DNSSDConfigs  []DNSSDConfig  `yaml:"dns_sd_configs,omitempty"`
+	HTTPSDConfigs []HTTPSDConfig `yaml:"http_sd_configs,omitempty"`
+	// RelabelConfigs filter and rewrite the targets, whose address is the
+	// __address__ label.
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs,omitempty"`
+}
+
+// FileSDConfig discovers targets from files of target groups in the format
+// of Prometheus file service discovery.
+type FileSDConfig struct {
+	// Files are glob patterns, relative to the configuration file.
+	Files           []string      `yaml:"files"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+// DNSSDConfig discovers targets from DNS SRV records.
+type DNSSDConfig struct {
+	Names           []string      `yaml:"names"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+// HTTPSDConfig discovers targets from an HTTP endpoint serving target groups
+// in the format of Prometheus HTTP service discovery.
+type HTTPSDConfig struct {
+	URL              config.URL              `yaml:"url"`
+	RefreshInterval  time.Duration           `yaml:"refresh_interval,omitempty"`
+	HTTPClientConfig config.HTTPClientConfig `yaml:",inline"`
+}
+
+// RelabelConfig is a relabeling step applied to discovered targets, with the
+// semantics of Prometheus relabeling.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	// Regex is anchored.
+	Regex       Regexp `yaml:"regex,omitempty"`
+	TargetLabel string `yaml:"target_label,omitempty"`
+	Replacement string `yaml:"replacement,omitempty"`
+	// Action is replace, keep or drop.
+	Action string `yaml:"action,omitempty"`
+}
+
+// RemoteWriteConfig configures a Prometheus remote write endpoint.
+type RemoteWriteConfig struct {
+	URL config.URL `yaml:"url"`
+	// ExternalLabels are added to all pushed series.
+	ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
+	// QueueCapacity is the maximum number of series kept while the endpoint
+	// is unavailable. The oldest are dropped first.
+	QueueCapacity    int                     `yaml:"queue_capacity,omitempty"`
+	MaxBackoff       time.Duration           `yaml:"max_backoff,omitempty"`
+	HTTPClientConfig config.HTTPClientConfig `yaml:",inline"`
 }
 
 type SafeConfig struct {
@@ -87,9 +459,19 @@ type SafeConfig struct {
 	C                   *Config
 	configReloadSuccess prometheus.Gauge
 	configReloadSeconds prometheus.Gauge
+	configRevision      prometheus.Gauge
+	configHash          prometheus.Gauge
+	// ExpandEnv enables the expansion of ${VAR} references to environment
+	// variables in the configuration file.
+	ExpandEnv bool
+	// version identifies the applied configuration if it was fetched from
+	// a URL.
+	version configVersion
 }
 
 func NewSafeConfig(reg prometheus.Registerer) *SafeConfig {
+	// The reload metrics keep the names they have always been exported
+	// with, and the metrics added later share their prefix.
 	configReloadSuccess := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
 		Namespace: "blackbox_exporter",
 		Name:      "config_last_reload_successful",
@@ -101,31 +483,89 @@ func NewSafeConfig(reg prometheus.Registerer) *SafeConfig {
 		Name:      "config_last_reload_success_timestamp_seconds",
 		Help:      "Timestamp of the last successful configuration reload.",
 	})
-	return &SafeConfig{C: &Config{}, configReloadSuccess: configReloadSuccess, configReloadSeconds: configReloadSeconds}
+	configRevision := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Namespace: "blackbox_exporter",
+		Name:      "config_revision",
+		Help:      "Revision of the applied configuration in Consul or etcd.",
+	})
+	configHash := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Namespace: "blackbox_exporter",
+		Name:      "config_hash",
+		Help:      "Hash of the applied configuration.",
+	})
+	return &SafeConfig{C: &Config{}, configReloadSuccess: configReloadSuccess, configReloadSeconds: configReloadSeconds, configRevision: configRevision, configHash: configHash}
 }
 
 func (sc *SafeConfig) ReloadConfig(confFile string, logger log.Logger) (err error) {
 	var c = &Config{}
+	notModified := false
 	defer func() {
 		if err != nil {
 			sc.configReloadSuccess.Set(0)
 		} else {
 			sc.configReloadSuccess.Set(1)
-			sc.configReloadSeconds.SetToCurrentTime()
+			if !notModified {
+				sc.configReloadSeconds.SetToCurrentTime()
+			}
 		}
 	}()
 
-	yamlReader, err := os.Open(confFile)
+	sc.RLock()
+	previous := sc.version
+	sc.RUnlock()
+	content, version, err := readConfig(confFile, previous)
+	if errors.Is(err, errConfigNotModified) {
+		// The applied configuration is still current.
+		notModified = true
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("error reading config file: %s", err)
+		return err
 	}
-	defer yamlReader.Close()
-	decoder := yaml.NewDecoder(yamlReader)
-	decoder.KnownFields(true)
-
-	if err = decoder.Decode(c); err != nil {
+	if sc.ExpandEnv {
+		if content, err = expandEnv(content); err != nil {
+			return fmt.Errorf("error parsing config file: %s", err)
+		}
+	}
+	// The decoder reports invalid content.
+	document, _ := parseDocument(content)
+	included, err := sc.readIncludes(document, confFile)
+	if err != nil {
+		return fmt.Errorf("error parsing config file: %s", err)
+	}
+	// Modules can extend modules of included files and vice versa.
+	if err = resolveExtends(append([]*configDocument{document}, included...)...); err != nil {
+		return fmt.Errorf("error parsing config file: %s", err)
+	}
+	if err = document.decode(c); err != nil {
+		return fmt.Errorf("error parsing config file: %s", err)
+	}
+	if err = includeModules(c, confFile, included); err != nil {
 		return fmt.Errorf("error parsing config file: %s", err)
 	}
+	if c.TargetFilter.FiltersAddrs() {
+		for name, module := range c.Modules {
+			if module.TCP.ProxyResolvesTarget() {
+				return fmt.Errorf("error parsing config file: target_filter cannot check the addresses of targets resolved by the tcp proxy_url of module %q", name)
+			}
+		}
+	}
+	// Modules can be defined in included files.
+	for _, sp := range c.ScheduledProbes {
+		if _, ok := c.Modules[sp.Module]; !ok {
+			return fmt.Errorf("error parsing config file: unknown module %q in scheduled_probes", sp.Module)
+		}
+		if IsRemote(confFile) || IsWatched(confFile) {
+			continue
+		}
+		for _, sd := range sp.FileSDConfigs {
+			for i, file := range sd.Files {
+				if !filepath.IsAbs(file) {
+					sd.Files[i] = filepath.Join(filepath.Dir(confFile), file)
+				}
+			}
+		}
+	}
 
 	for name, module := range c.Modules {
 		if module.HTTP.NoFollowRedirects != nil {
@@ -140,227 +580,2117 @@ func (sc *SafeConfig) ReloadConfig(confFile string, logger log.Logger) (err erro
 
 	sc.Lock()
 	sc.C = c
+	sc.version = version
 	sc.Unlock()
+	if version.revision != 0 {
+		sc.configRevision.Set(float64(version.revision))
+	}
+	if hash, err := configHashValue(c); err == nil {
+		sc.configHash.Set(hash)
+	}
 
 	return nil
 }
 
-// Regexp encapsulates a regexp.Regexp and makes it YAML marshalable.
-type Regexp struct {
-	*regexp.Regexp
-	original string
+// configHashValue returns a hash of the configuration that can be used as a
+// metric value. The configuration is hashed as it is marshalled for the
+// /config page, so it is independent of formatting, comments, included files
+// and environment variables, and secrets do not change the hash.
+func configHashValue(c *Config) (float64, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(b)
+	// Six bytes fit into the mantissa of a float64 without loss of precision.
+	var v [8]byte
+	copy(v[:], sum[:6])
+	return float64(binary.LittleEndian.Uint64(v[:])), nil
 }
 
-// NewRegexp creates a new anchored Regexp and returns an error if the
-// passed-in regular expression does not compile.
-func NewRegexp(s string) (Regexp, error) {
-	regex, err := regexp.Compile(s)
-	return Regexp{
-		Regexp:   regex,
-		original: s,
-	}, err
+// readIncludes parses the files included by the configuration file. Included
+// files cannot include further files.
+func (sc *SafeConfig) readIncludes(document *configDocument, confFile string) ([]*configDocument, error) {
+	node := document.value("include")
+	if node == nil {
+		return nil, nil
+	}
+	var include []string
+	if err := node.Decode(&include); err != nil {
+		return nil, err
+	}
+	if len(include) == 0 {
+		return nil, nil
+	}
+	if IsRemote(confFile) || IsWatched(confFile) {
+		return nil, errors.New("include is only supported for config files on the file system")
+	}
+	var included []*configDocument
+	// A glob like *.yml may match the configuration file itself or files
+	// matched by another glob.
+	seen := map[string]bool{filepath.Clean(confFile): true}
+	for _, pattern := range include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(confFile), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %q: %s", pattern, err)
+		}
+		if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("included file %s not found", pattern)
+		}
+		for _, file := range files {
+			if seen[filepath.Clean(file)] {
+				continue
+			}
+			seen[filepath.Clean(file)] = true
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if sc.ExpandEnv {
+				if content, err = expandEnv(content); err != nil {
+					return nil, fmt.Errorf("%s: %s", file, err)
+				}
+			}
+			// The decoder reports invalid content.
+			document, _ := parseDocument(content)
+			document.file = file
+			included = append(included, document)
+		}
+	}
+	return included, nil
+}
+
+// includeModules merges the modules of the files included by the
+// configuration file into the configuration. Each module can only be defined
+// once.
+func includeModules(c *Config, confFile string, documents []*configDocument) error {
+	if len(documents) == 0 {
+		return nil
+	}
+	if c.Modules == nil {
+		c.Modules = map[string]Module{}
+	}
+	sources := map[string]string{}
+	for name := range c.Modules {
+		sources[name] = confFile
+	}
+	for _, document := range documents {
+		file := document.file
+		var included Config
+		// An empty file, e.g. a placeholder in a directory, is valid.
+		if err := document.decode(&included); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		if len(included.Include) > 0 {
+			return fmt.Errorf("%s: include is only allowed in the main config file", file)
+		}
+		if len(included.LabelParams) > 0 {
+			return fmt.Errorf("%s: label_params is only allowed in the main config file", file)
+		}
+		if len(included.ScheduledProbes) > 0 {
+			return fmt.Errorf("%s: scheduled_probes is only allowed in the main config file", file)
+		}
+		for name, module := range included.Modules {
+			if source, ok := sources[name]; ok {
+				return fmt.Errorf("module %q is defined in both %s and %s", name, source, file)
+			}
+			sources[name] = file
+			c.Modules[name] = module
+		}
+	}
+	return nil
+}
+
+// mergeNodes returns a mapping with the entries of the base mapping
+// overridden by those of the override mapping, merging nested mappings.
+func mergeNodes(base, override *yaml.Node) *yaml.Node {
+	// The merged mapping keeps the position of the override mapping.
+	merged := *override
+	merged.Content = append([]*yaml.Node(nil), base.Content...)
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+		found := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value != key.Value {
+				continue
+			}
+			found = true
+			if value.Kind == yaml.MappingNode && merged.Content[j+1].Kind == yaml.MappingNode {
+				merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+			} else {
+				merged.Content[j+1] = value
+			}
+		}
+		if !found {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+	return &merged
+}
+
+// configDocument is a configuration file parsed into YAML nodes, so that the
+// modules extending other modules can be merged before it is decoded.
+type configDocument struct {
+	// file is the name of an included file.
+	file    string
+	content []byte
+	root    yaml.Node
+	// extended is set once modules have been merged, so that the content no
+	// longer matches the nodes.
+	extended bool
+}
+
+// parseDocument parses the content of a configuration file. The document of
+// invalid content has no modules.
+func parseDocument(content []byte) (*configDocument, error) {
+	d := &configDocument{content: content}
+	if err := yaml.Unmarshal(content, &d.root); err != nil {
+		d.root = yaml.Node{}
+		return d, err
+	}
+	return d, nil
+}
+
+// value returns the node of a top-level key of the document, or nil if the
+// key is not set.
+func (d *configDocument) value(key string) *yaml.Node {
+	if len(d.root.Content) == 0 || d.root.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	document := d.root.Content[0]
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		if document.Content[i].Value == key {
+			return document.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// modules returns the mapping of the modules of the document, or nil if it
+// has none.
+func (d *configDocument) modules() *yaml.Node {
+	if modules := d.value("modules"); modules != nil && modules.Kind == yaml.MappingNode {
+		return modules
+	}
+	return nil
+}
+
+var errorLineRegexp = regexp.MustCompile(`^line (\d+):`)
+
+// decode decodes the document into v and rejects unknown fields. A document
+// with extended modules is decoded from its marshalled nodes, and the lines
+// of errors are mapped back to the lines of the file.
+func (d *configDocument) decode(v interface{}) error {
+	content := d.content
+	lines := map[int]int{}
+	if d.extended {
+		var err error
+		if content, err = yaml.Marshal(&d.root); err != nil {
+			return err
+		}
+		var marshalled yaml.Node
+		if err := yaml.Unmarshal(content, &marshalled); err != nil {
+			return err
+		}
+		mapLines(lines, &d.root, &marshalled)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err := decoder.Decode(v)
+	var typeErr *yaml.TypeError
+	if d.extended && errors.As(err, &typeErr) {
+		for i, msg := range typeErr.Errors {
+			typeErr.Errors[i] = errorLineRegexp.ReplaceAllStringFunc(msg, func(prefix string) string {
+				line, _ := strconv.Atoi(errorLineRegexp.FindStringSubmatch(prefix)[1])
+				if original, ok := lines[line]; ok {
+					line = original
+				}
+				return fmt.Sprintf("line %d:", line)
+			})
+		}
+	}
+	return err
+}
+
+// mapLines maps the lines of the nodes parsed from marshalled nodes to the
+// lines of the original nodes. Merged nodes keep the lines they were parsed
+// from.
+func mapLines(lines map[int]int, original, marshalled *yaml.Node) {
+	if _, ok := lines[marshalled.Line]; !ok && original.Line > 0 {
+		lines[marshalled.Line] = original.Line
+	}
+	for i := 0; i < len(original.Content) && i < len(marshalled.Content); i++ {
+		mapLines(lines, original.Content[i], marshalled.Content[i])
+	}
+}
+
+// resolveExtends merges the settings of the module each module extends into
+// the nodes of the module.
+func resolveExtends(docs ...*configDocument) error {
+	nodes := map[string]*yaml.Node{}
+	bases := map[string]string{}
+	sources := map[string]*configDocument{}
+	for _, d := range docs {
+		modules := d.modules()
+		if modules == nil {
+			continue
+		}
+		for i := 0; i+1 < len(modules.Content); i += 2 {
+			name, module := modules.Content[i].Value, modules.Content[i+1]
+			nodes[name] = module
+			sources[name] = d
+			if module.Kind != yaml.MappingNode {
+				continue
+			}
+			for j := 0; j+1 < len(module.Content); j += 2 {
+				if module.Content[j].Value != "extends" {
+					continue
+				}
+				bases[name] = module.Content[j+1].Value
+				module.Content = append(module.Content[:j:j], module.Content[j+2:]...)
+				break
+			}
+		}
+	}
+
+	resolved := map[string]bool{}
+	var resolve func(name string, chain []string) error
+	resolve = func(name string, chain []string) error {
+		base, ok := bases[name]
+		if !ok || resolved[name] {
+			return nil
+		}
+		for _, seen := range chain {
+			if seen == name {
+				return fmt.Errorf("modules %s extend each other", strings.Join(append(chain, name), " -> "))
+			}
+		}
+		if nodes[base] == nil || nodes[base].Kind != yaml.MappingNode {
+			return fmt.Errorf("module %q extends unknown module %q", name, base)
+		}
+		if err := resolve(base, append(chain, name)); err != nil {
+			return err
+		}
+		*nodes[name] = *mergeNodes(nodes[base], nodes[name])
+		sources[name].extended = true
+		resolved[name] = true
+		return nil
+	}
+	names := make([]string, 0, len(bases))
+	for name := range bases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := resolve(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModuleError is an error in the configuration of a single module.
+type ModuleError struct {
+	Module string
+	// Line is the line of the configuration file the module starts at.
+	Line int
+	Err  error
+}
+
+func (e *ModuleError) Error() string {
+	return fmt.Sprintf("line %d: module %q: %s", e.Line, e.Module, e.Err)
+}
+
+// CheckModules decodes each module of a configuration file separately and
+// returns the errors of all invalid modules, not just the first one. Errors of
+// the validation of module fields don't contain a line, so the line the
+// module starts at is returned with each of them.
+func (sc *SafeConfig) CheckModules(confFile string) ([]*ModuleError, error) {
+	content, _, err := readConfig(confFile, configVersion{})
+	if err != nil {
+		return nil, err
+	}
+	if sc.ExpandEnv {
+		if content, err = expandEnv(content); err != nil {
+			return nil, fmt.Errorf("error parsing config file: %s", err)
+		}
+	}
+	document, err := parseDocument(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file: %s", err)
+	}
+	included, err := sc.readIncludes(document, confFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file: %s", err)
+	}
+	// The merged nodes keep their lines.
+	if err := resolveExtends(append([]*configDocument{document}, included...)...); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %s", err)
+	}
+	modules := document.modules()
+	if modules == nil {
+		return nil, nil
+	}
+	var errs []*ModuleError
+	for i := 0; i+1 < len(modules.Content); i += 2 {
+		var module Module
+		if err := modules.Content[i+1].Decode(&module); err != nil {
+			errs = append(errs, &ModuleError{Module: modules.Content[i].Value, Line: modules.Content[i].Line, Err: err})
+		}
+	}
+	return errs, nil
+}
+
+// Regexp encapsulates a regexp.Regexp and makes it YAML marshalable.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp creates a new anchored Regexp and returns an error if the
+// passed-in regular expression does not compile.
+func NewRegexp(s string) (Regexp, error) {
+	regex, err := regexp.Compile(s)
+	return Regexp{
+		Regexp:   regex,
+		original: s,
+	}, err
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return fmt.Errorf("\"Could not compile regular expression\" regexp=\"%s\"", s)
+	}
+	*re = r
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	if re.original != "" {
+		return re.original, nil
+	}
+	return nil, nil
+}
+
+// MustNewRegexp works like NewRegexp, but panics if the regular expression does not compile.
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// HexBytes is a byte slice that is written as a hexadecimal string in YAML.
+// Whitespace is ignored, so "01 02 ff" and "0102ff" are equivalent.
+type HexBytes []byte
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (h *HexBytes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return fmt.Errorf("\"Could not decode hex string\" hex=\"%s\"", s)
+	}
+	*h = b
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (h HexBytes) MarshalYAML() (interface{}, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	return hex.EncodeToString(h), nil
+}
+
+// PortRange is an inclusive range of ports, written as "80" or "8000-8010"
+// in YAML.
+type PortRange struct {
+	First uint16
+	Last  uint16
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (r *PortRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+	f, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+	if err != nil || f == 0 {
+		return fmt.Errorf("invalid port range %q", s)
+	}
+	l, err := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+	if err != nil || l < f {
+		return fmt.Errorf("invalid port range %q", s)
+	}
+	*r = PortRange{First: uint16(f), Last: uint16(l)}
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (r PortRange) MarshalYAML() (interface{}, error) {
+	if r.First == r.Last {
+		return strconv.Itoa(int(r.First)), nil
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last), nil
+}
+
+// Contains returns whether port is within the range.
+func (r PortRange) Contains(port uint16) bool {
+	return port >= r.First && port <= r.Last
+}
+
+type Module struct {
+	Prober        string             `yaml:"prober,omitempty"`
+	Timeout       time.Duration      `yaml:"timeout,omitempty"`
+	HTTP          HTTPProbe          `yaml:"http,omitempty"`
+	TCP           TCPProbe           `yaml:"tcp,omitempty"`
+	ICMP          ICMPProbe          `yaml:"icmp,omitempty"`
+	DNS           DNSProbe           `yaml:"dns,omitempty"`
+	GRPC          GRPCProbe          `yaml:"grpc,omitempty"`
+	TLS           TLSProbe           `yaml:"tls,omitempty"`
+	UDP           UDPProbe           `yaml:"udp,omitempty"`
+	Traceroute    TracerouteProbe    `yaml:"traceroute,omitempty"`
+	ARP           ARPProbe           `yaml:"arp,omitempty"`
+	NDP           NDPProbe           `yaml:"ndp,omitempty"`
+	SMTP          SMTPProbe          `yaml:"smtp,omitempty"`
+	IMAP          IMAPProbe          `yaml:"imap,omitempty"`
+	POP3          POP3Probe          `yaml:"pop3,omitempty"`
+	SSH           SSHProbe           `yaml:"ssh,omitempty"`
+	LDAP          LDAPProbe          `yaml:"ldap,omitempty"`
+	MQTT          MQTTProbe          `yaml:"mqtt,omitempty"`
+	Kafka         KafkaProbe         `yaml:"kafka,omitempty"`
+	Memcached     MemcachedProbe     `yaml:"memcached,omitempty"`
+	Postgres      PostgresProbe      `yaml:"postgres,omitempty"`
+	MySQL         MySQLProbe         `yaml:"mysql,omitempty"`
+	MongoDB       MongoDBProbe       `yaml:"mongodb,omitempty"`
+	SNMP          SNMPProbe          `yaml:"snmp,omitempty"`
+	RTSP          RTSPProbe          `yaml:"rtsp,omitempty"`
+	Modbus        ModbusProbe        `yaml:"modbus,omitempty"`
+	STUN          STUNProbe          `yaml:"stun,omitempty"`
+	TURN          TURNProbe          `yaml:"turn,omitempty"`
+	RADIUS        RADIUSProbe        `yaml:"radius,omitempty"`
+	DHCP          DHCPProbe          `yaml:"dhcp,omitempty"`
+	TFTP          TFTPProbe          `yaml:"tftp,omitempty"`
+	Syslog        SyslogProbe        `yaml:"syslog,omitempty"`
+	NATS          NATSProbe          `yaml:"nats,omitempty"`
+	Etcd          EtcdProbe          `yaml:"etcd,omitempty"`
+	Elasticsearch ElasticsearchProbe `yaml:"elasticsearch,omitempty"`
+	Git           GitProbe           `yaml:"git,omitempty"`
+	Registry      RegistryProbe      `yaml:"registry,omitempty"`
+	SMB           SMBProbe           `yaml:"smb,omitempty"`
+	NFS           NFSProbe           `yaml:"nfs,omitempty"`
+	OPCUA         OPCUAProbe         `yaml:"opcua,omitempty"`
+	WebDAV        WebDAVProbe        `yaml:"webdav,omitempty"`
+	Telnet        TelnetProbe        `yaml:"telnet,omitempty"`
+	IRC           IRCProbe           `yaml:"irc,omitempty"`
+	Kubernetes    KubernetesProbe    `yaml:"kubernetes,omitempty"`
+
+	// AllowedOverrides lists the fields that can be overridden with /probe
+	// parameters of the same name.
+	AllowedOverrides []string `yaml:"allowed_overrides,omitempty"`
+	// TargetFilter restricts the targets of the module in addition to the
+	// global filter.
+	TargetFilter TargetFilter `yaml:"target_filter,omitempty"`
+	// MaxConcurrent limits the number of concurrent probes of the module.
+	MaxConcurrent int `yaml:"max_concurrent,omitempty"`
+	// CacheTTL is how long the result of a probe is served to identical
+	// /probe requests instead of probing again.
+	CacheTTL time.Duration `yaml:"cache_ttl,omitempty"`
+	// LogLevel overrides the log level of the probes of the module.
+	LogLevel string `yaml:"log_level,omitempty"`
+}
+
+type HTTPProbe struct {
+	// Defaults to 2xx.
+	ValidStatusCodes             []int                   `yaml:"valid_status_codes,omitempty"`
+	ValidHTTPVersions            []string                `yaml:"valid_http_versions,omitempty"`
+	IPProtocol                   string                  `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback           bool                    `yaml:"ip_protocol_fallback,omitempty"`
+	SkipResolvePhaseWithProxy    bool                    `yaml:"skip_resolve_phase_with_proxy,omitempty"`
+	SourceIPAddress              string                  `yaml:"source_ip_address,omitempty"`
+	SourceInterface              string                  `yaml:"source_interface,omitempty"`
+	NoFollowRedirects            *bool                   `yaml:"no_follow_redirects,omitempty"`
+	FailIfSSL                    bool                    `yaml:"fail_if_ssl,omitempty"`
+	FailIfNotSSL                 bool                    `yaml:"fail_if_not_ssl,omitempty"`
+	Method                       string                  `yaml:"method,omitempty"`
+	Headers                      map[string]string       `yaml:"headers,omitempty"`
+	FailIfBodyMatchesRegexp      []Regexp                `yaml:"fail_if_body_matches_regexp,omitempty"`
+	FailIfBodyNotMatchesRegexp   []Regexp                `yaml:"fail_if_body_not_matches_regexp,omitempty"`
+	FailIfHeaderMatchesRegexp    []HeaderMatch           `yaml:"fail_if_header_matches,omitempty"`
+	FailIfHeaderNotMatchesRegexp []HeaderMatch           `yaml:"fail_if_header_not_matches,omitempty"`
+	Body                         string                  `yaml:"body,omitempty"`
+	BodyFile                     string                  `yaml:"body_file,omitempty"`
+	HTTPClientConfig             config.HTTPClientConfig `yaml:"http_client_config,inline"`
+	Compression                  string                  `yaml:"compression,omitempty"`
+	BodySizeLimit                units.Base2Bytes        `yaml:"body_size_limit,omitempty"`
+}
+
+type GRPCProbe struct {
+	Service             string           `yaml:"service,omitempty"`
+	TLS                 bool             `yaml:"tls,omitempty"`
+	TLSConfig           config.TLSConfig `yaml:"tls_config,omitempty"`
+	IPProtocolFallback  bool             `yaml:"ip_protocol_fallback,omitempty"`
+	PreferredIPProtocol string           `yaml:"preferred_ip_protocol,omitempty"`
+}
+
+type TLSProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+}
+
+type UDPProbe struct {
+	IPProtocol         string        `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool          `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string        `yaml:"source_ip_address,omitempty"`
+	Send               string        `yaml:"send,omitempty"`
+	SendHex            HexBytes      `yaml:"send_hex,omitempty"`
+	Expect             Regexp        `yaml:"expect,omitempty"`
+	ExpectHex          HexBytes      `yaml:"expect_hex,omitempty"`
+	PacketTimeout      time.Duration `yaml:"packet_timeout,omitempty"`
+	Retries            int           `yaml:"retries,omitempty"`
+}
+
+type TracerouteProbe struct {
+	IPProtocol         string        `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool          `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string        `yaml:"source_ip_address,omitempty"`
+	MaxHops            int           `yaml:"max_hops,omitempty"`
+	HopTimeout         time.Duration `yaml:"hop_timeout,omitempty"`
+}
+
+type SMTPProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	StartTLS           bool             `yaml:"starttls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	EHLODomain         string           `yaml:"ehlo_domain,omitempty"`
+	AuthUsername       string           `yaml:"auth_username,omitempty"`
+	AuthPassword       config.Secret    `yaml:"auth_password,omitempty"`
+	AuthPasswordFile   string           `yaml:"auth_password_file,omitempty"`
+	MailFrom           string           `yaml:"mail_from,omitempty"`
+	RcptTo             []string         `yaml:"rcpt_to,omitempty"`
+	Data               string           `yaml:"data,omitempty"`
+}
+
+type IMAPProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	StartTLS           bool             `yaml:"starttls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	Mailbox            string           `yaml:"mailbox,omitempty"`
+}
+
+type POP3Probe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	StartTLS           bool             `yaml:"starttls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+}
+
+type SSHProbe struct {
+	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
+	KnownHostsFile     string `yaml:"known_hosts_file,omitempty"`
+	HostKeyFingerprint string `yaml:"host_key_fingerprint,omitempty"`
+}
+
+type LDAPProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	StartTLS           bool             `yaml:"starttls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	BindDN             string           `yaml:"bind_dn,omitempty"`
+	BindPassword       config.Secret    `yaml:"bind_password,omitempty"`
+	BindPasswordFile   string           `yaml:"bind_password_file,omitempty"`
+	Search             bool             `yaml:"search,omitempty"`
+	SearchBaseDN       string           `yaml:"search_base_dn,omitempty"`
+	SearchFilter       string           `yaml:"search_filter,omitempty"`
+}
+
+type MQTTProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	ProtocolVersion    string           `yaml:"protocol_version,omitempty"`
+	ClientID           string           `yaml:"client_id,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	Topic              string           `yaml:"topic,omitempty"`
+}
+
+type KafkaProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	ClientID           string           `yaml:"client_id,omitempty"`
+	SASLMechanism      string           `yaml:"sasl_mechanism,omitempty"`
+	SASLUsername       string           `yaml:"sasl_username,omitempty"`
+	SASLPassword       config.Secret    `yaml:"sasl_password,omitempty"`
+	SASLPasswordFile   string           `yaml:"sasl_password_file,omitempty"`
+	Topic              string           `yaml:"topic,omitempty"`
+}
+
+type MemcachedProbe struct {
+	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
+	Key                string `yaml:"key,omitempty"`
+}
+
+type PostgresProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	Database           string           `yaml:"database,omitempty"`
+	Query              string           `yaml:"query,omitempty"`
+}
+
+type MySQLProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	Database           string           `yaml:"database,omitempty"`
+	Query              string           `yaml:"query,omitempty"`
+}
+
+type MongoDBProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	AuthSource         string           `yaml:"auth_source,omitempty"`
+	AuthMechanism      string           `yaml:"auth_mechanism,omitempty"`
+}
+
+type SNMPProbe struct {
+	IPProtocol         string        `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool          `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string        `yaml:"source_ip_address,omitempty"`
+	Version            string        `yaml:"version,omitempty"`
+	Community          config.Secret `yaml:"community,omitempty"`
+	CommunityFile      string        `yaml:"community_file,omitempty"`
+	Username           string        `yaml:"username,omitempty"`
+	AuthProtocol       string        `yaml:"auth_protocol,omitempty"`
+	AuthPassword       config.Secret `yaml:"auth_password,omitempty"`
+	AuthPasswordFile   string        `yaml:"auth_password_file,omitempty"`
+	OID                string        `yaml:"oid,omitempty"`
+}
+
+type RTSPProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	ExpectMedia        []string         `yaml:"expect_media,omitempty"`
+}
+
+type ModbusProbe struct {
+	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
+	UnitID             uint8  `yaml:"unit_id,omitempty"`
+	Register           uint16 `yaml:"register,omitempty"`
+}
+
+type STUNProbe struct {
+	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
+}
+
+type TURNProbe struct {
+	IPProtocol         string        `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool          `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string        `yaml:"source_ip_address,omitempty"`
+	Username           string        `yaml:"username,omitempty"`
+	Password           config.Secret `yaml:"password,omitempty"`
+	PasswordFile       string        `yaml:"password_file,omitempty"`
+}
+
+type RADIUSProbe struct {
+	IPProtocol         string        `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool          `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string        `yaml:"source_ip_address,omitempty"`
+	Secret             config.Secret `yaml:"secret,omitempty"`
+	SecretFile         string        `yaml:"secret_file,omitempty"`
+	Username           string        `yaml:"username,omitempty"`
+	Password           config.Secret `yaml:"password,omitempty"`
+	PasswordFile       string        `yaml:"password_file,omitempty"`
+	AuthMethod         string        `yaml:"auth_method,omitempty"`
+	NASIdentifier      string        `yaml:"nas_identifier,omitempty"`
+	ExpectReject       bool          `yaml:"expect_reject,omitempty"`
+}
+
+type DHCPProbe struct {
+	SourceIPAddress string `yaml:"source_ip_address,omitempty"`
+	Relay           bool   `yaml:"relay,omitempty"`
+	ClientPort      int    `yaml:"client_port,omitempty"`
+	HardwareAddress string `yaml:"hardware_address,omitempty"`
+}
+
+type TFTPProbe struct {
+	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
+	Filename           string `yaml:"filename,omitempty"`
+	Expect             Regexp `yaml:"expect,omitempty"`
+}
+
+type SyslogProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	Protocol           string           `yaml:"protocol,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Framing            string           `yaml:"framing,omitempty"`
+	AppName            string           `yaml:"app_name,omitempty"`
+	Message            string           `yaml:"message,omitempty"`
+}
+
+type NATSProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Token              config.Secret    `yaml:"token,omitempty"`
+	TokenFile          string           `yaml:"token_file,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	CredentialsFile    string           `yaml:"credentials_file,omitempty"`
+	Subject            string           `yaml:"subject,omitempty"`
+	Payload            string           `yaml:"payload,omitempty"`
+}
+
+type EtcdProbe struct {
+	IPProtocol         string                  `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool                    `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string                  `yaml:"source_ip_address,omitempty"`
+	HTTPClientConfig   config.HTTPClientConfig `yaml:"http_client_config,inline"`
+	CheckMembers       bool                    `yaml:"check_members,omitempty"`
+}
+
+type ElasticsearchProbe struct {
+	IPProtocol         string                  `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool                    `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string                  `yaml:"source_ip_address,omitempty"`
+	HTTPClientConfig   config.HTTPClientConfig `yaml:"http_client_config,inline"`
+	MinimumStatus      string                  `yaml:"minimum_status,omitempty"`
+}
+
+type GitProbe struct {
+	IPProtocol         string                  `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool                    `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string                  `yaml:"source_ip_address,omitempty"`
+	HTTPClientConfig   config.HTTPClientConfig `yaml:"http_client_config,inline"`
+	SSHPrivateKeyFile  string                  `yaml:"ssh_private_key_file,omitempty"`
+	KnownHostsFile     string                  `yaml:"known_hosts_file,omitempty"`
+	ExpectRef          string                  `yaml:"expect_ref,omitempty"`
+}
+
+type RegistryProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Username           string           `yaml:"username,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+	Repository         string           `yaml:"repository,omitempty"`
+	Reference          string           `yaml:"reference,omitempty"`
+}
+
+type SMBProbe struct {
+	IPProtocol         string        `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool          `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string        `yaml:"source_ip_address,omitempty"`
+	Username           string        `yaml:"username,omitempty"`
+	Password           config.Secret `yaml:"password,omitempty"`
+	PasswordFile       string        `yaml:"password_file,omitempty"`
+	Domain             string        `yaml:"domain,omitempty"`
+	Share              string        `yaml:"share,omitempty"`
+}
+
+type NFSProbe struct {
+	IPProtocol         string   `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool     `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string   `yaml:"source_ip_address,omitempty"`
+	Services           []string `yaml:"services,omitempty"`
+	NFSVersion         int      `yaml:"nfs_version,omitempty"`
+	NullCall           bool     `yaml:"null_call,omitempty"`
+}
+
+type OPCUAProbe struct {
+	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
+	NodeID             string `yaml:"node_id,omitempty"`
+}
+
+type WebDAVProbe struct {
+	IPProtocol         string                  `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool                    `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string                  `yaml:"source_ip_address,omitempty"`
+	HTTPClientConfig   config.HTTPClientConfig `yaml:"http_client_config,inline"`
+	Depth              int                     `yaml:"depth,omitempty"`
+}
+
+type TelnetProbe struct {
+	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
+	Expect             Regexp `yaml:"expect,omitempty"`
+}
+
+type IRCProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	Nick               string           `yaml:"nick,omitempty"`
+	Password           config.Secret    `yaml:"password,omitempty"`
+	PasswordFile       string           `yaml:"password_file,omitempty"`
+}
+
+type KubernetesProbe struct {
+	IPProtocol         string                  `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool                    `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string                  `yaml:"source_ip_address,omitempty"`
+	HTTPClientConfig   config.HTTPClientConfig `yaml:"http_client_config,inline"`
+	ExcludeChecks      []string                `yaml:"exclude_checks,omitempty"`
+}
+
+type ARPProbe struct {
+	SourceInterface string `yaml:"source_interface,omitempty"`
+}
+
+type NDPProbe struct {
+	SourceInterface string `yaml:"source_interface,omitempty"`
+}
+
+type HeaderMatch struct {
+	Header       string `yaml:"header,omitempty"`
+	Regexp       Regexp `yaml:"regexp,omitempty"`
+	AllowMissing bool   `yaml:"allow_missing,omitempty"`
+}
+
+type Label struct {
+	Name  string `yaml:"name,omitempty"`
+	Value string `yaml:"value,omitempty"`
+}
+
+type QueryResponse struct {
+	Expect    Regexp        `yaml:"expect,omitempty"`
+	ExpectHex HexBytes      `yaml:"expect_hex,omitempty"`
+	Labels    []Label       `yaml:"labels,omitempty"`
+	Send      string        `yaml:"send,omitempty"`
+	SendHex   HexBytes      `yaml:"send_hex,omitempty"`
+	StartTLS  bool          `yaml:"starttls,omitempty"`
+	Timeout   time.Duration `yaml:"timeout,omitempty"`
+}
+
+type TCPProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	SourceInterface    string           `yaml:"source_interface,omitempty"`
+	QueryResponse      []QueryResponse  `yaml:"query_response,omitempty"`
+	CaptureBanner      bool             `yaml:"capture_banner,omitempty"`
+	HalfOpen           bool             `yaml:"half_open,omitempty"`
+	ExpectFailure      bool             `yaml:"expect_failure,omitempty"`
+	ConnectCount       int              `yaml:"connect_count,omitempty"`
+	IdleHold           time.Duration    `yaml:"idle_hold,omitempty"`
+	KeepAliveInterval  time.Duration    `yaml:"keepalive_interval,omitempty"`
+	LineTimeout        time.Duration    `yaml:"line_timeout,omitempty"`
+	LineEnding         string           `yaml:"line_ending,omitempty"`
+	HappyEyeballs      bool             `yaml:"happy_eyeballs,omitempty"`
+	DSCP               int              `yaml:"dscp,omitempty"`
+	Ports              []PortRange      `yaml:"ports,omitempty"`
+	ExpectedOpenPorts  []PortRange      `yaml:"expected_open_ports,omitempty"`
+	ProxyURL           config.URL       `yaml:"proxy_url,omitempty"`
+	ProxyPasswordFile  string           `yaml:"proxy_password_file,omitempty"`
+	TLS                bool             `yaml:"tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+}
+
+// ProxyResolvesTarget reports whether the target is resolved by the proxy
+// instead of locally.
+func (s *TCPProbe) ProxyResolvesTarget() bool {
+	return s.ProxyURL.URL != nil && s.ProxyURL.Scheme != "socks5"
+}
+
+type ICMPProbe struct {
+	IPProtocol         string        `yaml:"preferred_ip_protocol,omitempty"` // Defaults to "ip6".
+	IPProtocolFallback bool          `yaml:"ip_protocol_fallback,omitempty"`
+	SourceIPAddress    string        `yaml:"source_ip_address,omitempty"`
+	SourceInterface    string        `yaml:"source_interface,omitempty"`
+	PayloadSize        int           `yaml:"payload_size,omitempty"`
+	PayloadPattern     HexBytes      `yaml:"payload_pattern,omitempty"`
+	DontFragment       bool          `yaml:"dont_fragment,omitempty"`
+	TTL                int           `yaml:"ttl,omitempty"`
+	PacketCount        int           `yaml:"packet_count,omitempty"`
+	PacketInterval     time.Duration `yaml:"packet_interval,omitempty"`
+	DSCP               int           `yaml:"dscp,omitempty"`
+	Timestamp          bool          `yaml:"timestamp,omitempty"`
+	Identifier         int           `yaml:"identifier,omitempty"`
+	BurstCount         int           `yaml:"burst_count,omitempty"`
+}
+
+type DNSProbe struct {
+	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
+	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
+	DNSOverTLS         bool             `yaml:"dns_over_tls,omitempty"`
+	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
+	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
+	TransportProtocol  string           `yaml:"transport_protocol,omitempty"`
+	QueryClass         string           `yaml:"query_class,omitempty"` // Defaults to IN.
+	QueryName          string           `yaml:"query_name,omitempty"`
+	QueryType          string           `yaml:"query_type,omitempty"`        // Defaults to ANY.
+	Recursion          bool             `yaml:"recursion_desired,omitempty"` // Defaults to true.
+	ValidRcodes        []string         `yaml:"valid_rcodes,omitempty"`      // Defaults to NOERROR.
+	ExpectNXDomain     bool             `yaml:"expect_nxdomain,omitempty"`
+	ValidateAnswer     DNSRRValidator   `yaml:"validate_answer_rrs,omitempty"`
+	ValidateAuthority  DNSRRValidator   `yaml:"validate_authority_rrs,omitempty"`
+	ValidateAdditional DNSRRValidator   `yaml:"validate_additional_rrs,omitempty"`
+}
+
+type DNSRRValidator struct {
+	FailIfMatchesRegexp     []string `yaml:"fail_if_matches_regexp,omitempty"`
+	FailIfAllMatchRegexp    []string `yaml:"fail_if_all_match_regexp,omitempty"`
+	FailIfNotMatchesRegexp  []string `yaml:"fail_if_not_matches_regexp,omitempty"`
+	FailIfNoneMatchesRegexp []string `yaml:"fail_if_none_matches_regexp,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	for _, name := range s.LabelParams {
+		if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q in label_params", name)
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ScheduledProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultScheduledProbe
+	type plain ScheduledProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if s.Module == "" {
+		return errors.New("module is missing in scheduled_probes")
+	}
+	if len(s.Targets)+len(s.FileSDConfigs)+len(s.DNSSDConfigs)+len(s.HTTPSDConfigs) == 0 {
+		return fmt.Errorf("no targets for module %q in scheduled_probes", s.Module)
+	}
+	if s.Interval <= 0 {
+		return fmt.Errorf("interval of module %q in scheduled_probes must be positive", s.Module)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *FileSDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultFileSDConfig
+	type plain FileSDConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if len(s.Files) == 0 {
+		return errors.New("file_sd_configs must contain at least one file")
+	}
+	for _, file := range s.Files {
+		switch filepath.Ext(file) {
+		case ".json", ".yml", ".yaml":
+		default:
+			return fmt.Errorf("file %q in file_sd_configs must end in .json, .yml or .yaml", file)
+		}
+	}
+	if s.RefreshInterval <= 0 {
+		return errors.New("file_sd_configs refresh_interval must be positive")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *DNSSDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultDNSSDConfig
+	type plain DNSSDConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if len(s.Names) == 0 {
+		return errors.New("dns_sd_configs must contain at least one name")
+	}
+	if s.RefreshInterval <= 0 {
+		return errors.New("dns_sd_configs refresh_interval must be positive")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *HTTPSDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultHTTPSDConfig
+	type plain HTTPSDConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if s.URL.URL == nil || (s.URL.Scheme != "http" && s.URL.Scheme != "https") {
+		return errors.New("http_sd_configs url must be an http or https URL")
+	}
+	if s.RefreshInterval <= 0 {
+		return errors.New("http_sd_configs refresh_interval must be positive")
+	}
+	return s.HTTPClientConfig.Validate()
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultRelabelConfig
+	type plain RelabelConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	s.Regex = Regexp{Regexp: regexp.MustCompile("^(?:" + s.Regex.original + ")$"), original: s.Regex.original}
+	switch s.Action {
+	case "replace":
+		if !model.LabelName(s.TargetLabel).IsValidLegacy() {
+			return fmt.Errorf("invalid target_label %q in relabel_configs", s.TargetLabel)
+		}
+	case "keep", "drop":
+	default:
+		return fmt.Errorf("unknown action %q in relabel_configs", s.Action)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultRemoteWriteConfig
+	type plain RemoteWriteConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if s.URL.URL == nil || (s.URL.Scheme != "http" && s.URL.Scheme != "https") {
+		return errors.New("remote_write url must be an http or https URL")
+	}
+	for name := range s.ExternalLabels {
+		if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q in external_labels", name)
+		}
+	}
+	if s.QueueCapacity <= 0 {
+		return errors.New("remote_write queue_capacity must be positive")
+	}
+	if s.MaxBackoff <= 0 {
+		return errors.New("remote_write max_backoff must be positive")
+	}
+	return s.HTTPClientConfig.Validate()
+}
+
+// TargetFilter restricts the targets that can be probed, so that users of
+// the exporter cannot probe arbitrary internal addresses. Denied targets take
+// precedence over allowed ones, and if any targets are allowed, all others
+// are denied.
+type TargetFilter struct {
+	// The host name regexps are anchored.
+	AllowHostnames []Regexp       `yaml:"allow_hostnames,omitempty"`
+	DenyHostnames  []Regexp       `yaml:"deny_hostnames,omitempty"`
+	AllowCIDRs     []netip.Prefix `yaml:"allow_cidrs,omitempty"`
+	DenyCIDRs      []netip.Prefix `yaml:"deny_cidrs,omitempty"`
+	// DenyPrivateTargets denies private, loopback, link-local and
+	// unspecified addresses, even if they are allowed.
+	DenyPrivateTargets bool `yaml:"deny_private_targets,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *TargetFilter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TargetFilter
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	for _, hostnames := range [][]Regexp{s.AllowHostnames, s.DenyHostnames} {
+		for i, re := range hostnames {
+			hostnames[i] = Regexp{Regexp: regexp.MustCompile("^(?:" + re.original + ")$"), original: re.original}
+		}
+	}
+	return nil
+}
+
+// AllowsHostname reports whether the filter allows probing the host name.
+func (s *TargetFilter) AllowsHostname(hostname string) bool {
+	for _, re := range s.DenyHostnames {
+		if re.MatchString(hostname) {
+			return false
+		}
+	}
+	for _, re := range s.AllowHostnames {
+		if re.MatchString(hostname) {
+			return true
+		}
+	}
+	return len(s.AllowHostnames) == 0
+}
+
+// FiltersAddrs reports whether the filter checks IP addresses, which is not
+// possible for targets that a proxy resolves.
+func (s *TargetFilter) FiltersAddrs() bool {
+	return len(s.AllowCIDRs)+len(s.DenyCIDRs) > 0 || s.DenyPrivateTargets
+}
+
+// AllowsAddr reports whether the filter allows probing the IP address.
+func (s *TargetFilter) AllowsAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if s.DenyPrivateTargets && (addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsUnspecified()) {
+		return false
+	}
+	for _, prefix := range s.DenyCIDRs {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	for _, prefix := range s.AllowCIDRs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return len(s.AllowCIDRs) == 0
+}
+
+// secretFile is a secret of a probe that can also be read from a file.
+type secretFile struct {
+	name   string
+	secret *config.Secret
+	file   string
+}
+
+// secretFiles returns the secrets of all probes that can be read from files.
+func (s *Module) secretFiles() []secretFile {
+	return []secretFile{
+		{"auth_password", &s.SMTP.AuthPassword, s.SMTP.AuthPasswordFile},
+		{"password", &s.IMAP.Password, s.IMAP.PasswordFile},
+		{"password", &s.POP3.Password, s.POP3.PasswordFile},
+		{"bind_password", &s.LDAP.BindPassword, s.LDAP.BindPasswordFile},
+		{"password", &s.MQTT.Password, s.MQTT.PasswordFile},
+		{"sasl_password", &s.Kafka.SASLPassword, s.Kafka.SASLPasswordFile},
+		{"password", &s.Postgres.Password, s.Postgres.PasswordFile},
+		{"password", &s.MySQL.Password, s.MySQL.PasswordFile},
+		{"password", &s.MongoDB.Password, s.MongoDB.PasswordFile},
+		{"community", &s.SNMP.Community, s.SNMP.CommunityFile},
+		{"auth_password", &s.SNMP.AuthPassword, s.SNMP.AuthPasswordFile},
+		{"password", &s.RTSP.Password, s.RTSP.PasswordFile},
+		{"password", &s.TURN.Password, s.TURN.PasswordFile},
+		{"secret", &s.RADIUS.Secret, s.RADIUS.SecretFile},
+		{"password", &s.RADIUS.Password, s.RADIUS.PasswordFile},
+		{"token", &s.NATS.Token, s.NATS.TokenFile},
+		{"password", &s.NATS.Password, s.NATS.PasswordFile},
+		{"password", &s.Registry.Password, s.Registry.PasswordFile},
+		{"password", &s.SMB.Password, s.SMB.PasswordFile},
+		{"password", &s.IRC.Password, s.IRC.PasswordFile},
+	}
+}
+
+// LoadSecretFiles sets the secrets of the module that are configured as
+// files to the content of the files. The files are read for each probe, so
+// that rotated secrets are used without a reload.
+func (s *Module) LoadSecretFiles() error {
+	for _, secret := range s.secretFiles() {
+		if secret.file == "" {
+			continue
+		}
+		content, err := os.ReadFile(secret.file)
+		if err != nil {
+			return fmt.Errorf("unable to read %s_file: %s", secret.name, err)
+		}
+		*secret.secret = config.Secret(strings.TrimSpace(string(content)))
+	}
+	if s.TCP.ProxyPasswordFile != "" {
+		content, err := os.ReadFile(s.TCP.ProxyPasswordFile)
+		if err != nil {
+			return fmt.Errorf("unable to read proxy_password_file: %s", err)
+		}
+		// The URL is shared with the configuration, so a copy is changed.
+		proxyURL := *s.TCP.ProxyURL.URL
+		proxyURL.User = url.UserPassword(proxyURL.User.Username(), strings.TrimSpace(string(content)))
+		s.TCP.ProxyURL = config.URL{URL: &proxyURL}
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Module) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultModule
+	type plain Module
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	for _, secret := range s.secretFiles() {
+		if *secret.secret != "" && secret.file != "" {
+			return fmt.Errorf("at most one of %s & %s_file must be configured", secret.name, secret.name)
+		}
+	}
+	if s.Timeout > 0 && s.TCP.IdleHold >= s.Timeout {
+		return errors.New("tcp idle_hold must be shorter than the module timeout")
+	}
+	// The burst is sent one packet_interval after the last paced request.
+	pacedRequests := s.ICMP.PacketCount - 1
+	if s.ICMP.BurstCount > 0 {
+		pacedRequests++
+	}
+	if s.Timeout > 0 && time.Duration(pacedRequests)*s.ICMP.PacketInterval >= s.Timeout {
+		return errors.New("icmp packet_count requests packet_interval apart must be sent within the module timeout")
+	}
+	if s.MaxConcurrent < 0 {
+		return errors.New("max_concurrent must not be negative")
+	}
+	if s.CacheTTL < 0 {
+		return errors.New("cache_ttl must not be negative")
+	}
+	if s.TCP.ProxyResolvesTarget() && s.TargetFilter.FiltersAddrs() {
+		return errors.New("target_filter cannot check the addresses of targets resolved by the tcp proxy_url")
+	}
+	switch s.LogLevel {
+	case "", "debug", "info", "warn", "error", "none":
+	default:
+		return fmt.Errorf("invalid log_level %q, must be one of debug, info, warn, error or none", s.LogLevel)
+	}
+	for _, name := range s.AllowedOverrides {
+		prober, ok := moduleOverrides[name]
+		if !ok {
+			return fmt.Errorf("unknown override %q in allowed_overrides", name)
+		}
+		if prober != s.Prober {
+			return fmt.Errorf("override %q is not supported by the %s prober", name, s.Prober)
+		}
+	}
+	return nil
+}
+
+// moduleOverrides maps the fields that can be overridden with /probe
+// parameters to the prober they belong to.
+var moduleOverrides = map[string]string{
+	"valid_status_codes": "http",
+	"query_name":         "dns",
+	"query_type":         "dns",
+}
+
+// ApplyOverrides sets the fields of the module that are overridden by the
+// parameters of a probe request. Overriding a field that is not listed in
+// allowed_overrides is an error. Parameters that do not name a field of the
+// module's prober are ignored.
+func (s *Module) ApplyOverrides(params url.Values) error {
+	names := make([]string, 0, len(moduleOverrides))
+	for name, prober := range moduleOverrides {
+		if prober == s.Prober && params.Has(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		allowed := false
+		for _, n := range s.AllowedOverrides {
+			allowed = allowed || n == name
+		}
+		if !allowed {
+			return fmt.Errorf("overriding %s is not allowed by the module", name)
+		}
+		value := params.Get(name)
+		switch name {
+		case "valid_status_codes":
+			var codes []int
+			for _, v := range strings.Split(value, ",") {
+				code, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil || code < 100 || code > 599 {
+					return fmt.Errorf("invalid status code %q in valid_status_codes", v)
+				}
+				codes = append(codes, code)
+			}
+			s.HTTP.ValidStatusCodes = codes
+		case "query_name":
+			if value == "" {
+				return errors.New("query_name must not be empty")
+			}
+			s.DNS.QueryName = value
+		case "query_type":
+			if _, ok := dns.StringToType[value]; !ok {
+				return fmt.Errorf("query type '%s' is not valid", value)
+			}
+			s.DNS.QueryType = value
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *HTTPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultHTTPProbe
+	type plain HTTPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	// BodySizeLimit == 0 means no limit. By leaving it at 0 we
+	// avoid setting up the limiter.
+	if s.BodySizeLimit < 0 || s.BodySizeLimit == math.MaxInt64 {
+		// The implementation behind http.MaxBytesReader tries
+		// to add 1 to the specified limit causing it to wrap
+		// around and become negative, and then it tries to use
+		// that result to index an slice.
+		s.BodySizeLimit = math.MaxInt64 - 1
+	}
+
+	if err := s.HTTPClientConfig.Validate(); err != nil {
+		return err
+	}
+
+	if s.NoFollowRedirects != nil {
+		s.HTTPClientConfig.FollowRedirects = !*s.NoFollowRedirects
+	}
+
+	if s.Body != "" && s.BodyFile != "" {
+		return errors.New("setting body and body_file both are not allowed")
+	}
+
+	if s.SourceIPAddress != "" && s.SourceInterface != "" {
+		return errors.New("setting source_ip_address and source_interface both are not allowed")
+	}
+
+	for key, value := range s.Headers {
+		switch textproto.CanonicalMIMEHeaderKey(key) {
+		case "Accept-Encoding":
+			if !isCompressionAcceptEncodingValid(s.Compression, value) {
+				return fmt.Errorf(`invalid configuration "%s: %s", "compression: %s"`, key, value, s.Compression)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sensitiveHeaderRE matches the names of headers that usually carry
+// credentials, such as Authorization, Cookie or X-Api-Key.
+var sensitiveHeaderRE = regexp.MustCompile(`(?i)auth|cookie|token|secret|passw|key`)
+
+// MarshalYAML implements the yaml.Marshaler interface. The values of headers
+// that carry credentials are masked like secrets.
+func (s HTTPProbe) MarshalYAML() (interface{}, error) {
+	type plain HTTPProbe
+	if len(s.Headers) > 0 {
+		headers := make(map[string]string, len(s.Headers))
+		for name, value := range s.Headers {
+			if sensitiveHeaderRE.MatchString(name) {
+				value = "<secret>"
+			}
+			headers[name] = value
+		}
+		s.Headers = headers
+	}
+	return plain(s), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *GRPCProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultGRPCProbe
+	type plain GRPCProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *TLSProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultTLSProbe
+	type plain TLSProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *SMTPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultSMTPProbe
+	type plain SMTPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.TLS && s.StartTLS {
+		return errors.New("setting tls and starttls both are not allowed")
+	}
+	if (s.AuthPassword != "" || s.AuthPasswordFile != "") && s.AuthUsername == "" {
+		return errors.New("auth_password requires auth_username to be set")
+	}
+	if len(s.RcptTo) > 0 && s.MailFrom == "" {
+		return errors.New("rcpt_to requires mail_from to be set")
+	}
+	if s.Data != "" && len(s.RcptTo) == 0 {
+		return errors.New("data requires rcpt_to to be set")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *IMAPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultIMAPProbe
+	type plain IMAPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.TLS && s.StartTLS {
+		return errors.New("setting tls and starttls both are not allowed")
+	}
+	if (s.Password != "" || s.PasswordFile != "") && s.Username == "" {
+		return errors.New("password requires username to be set")
+	}
+	if s.Mailbox != "" && s.Username == "" {
+		return errors.New("mailbox requires username to be set")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *POP3Probe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultPOP3Probe
+	type plain POP3Probe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.TLS && s.StartTLS {
+		return errors.New("setting tls and starttls both are not allowed")
+	}
+	if (s.Password != "" || s.PasswordFile != "") && s.Username == "" {
+		return errors.New("password requires username to be set")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *SSHProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultSSHProbe
+	type plain SSHProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.KnownHostsFile != "" && s.HostKeyFingerprint != "" {
+		return errors.New("setting known_hosts_file and host_key_fingerprint both are not allowed")
+	}
+	if s.HostKeyFingerprint != "" && !strings.HasPrefix(s.HostKeyFingerprint, "SHA256:") {
+		return fmt.Errorf("host_key_fingerprint %q must be a SHA256 fingerprint such as \"SHA256:...\"", s.HostKeyFingerprint)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *LDAPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultLDAPProbe
+	type plain LDAPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.TLS && s.StartTLS {
+		return errors.New("setting tls and starttls both are not allowed")
+	}
+	if (s.BindPassword != "" || s.BindPasswordFile != "") && s.BindDN == "" {
+		return errors.New("bind_password requires bind_dn to be set")
+	}
+	if (s.SearchBaseDN != "" || s.SearchFilter != DefaultLDAPProbe.SearchFilter) && !s.Search {
+		return errors.New("search_base_dn and search_filter require search to be enabled")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *MQTTProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultMQTTProbe
+	type plain MQTTProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.ProtocolVersion != "3.1.1" && s.ProtocolVersion != "5" {
+		return fmt.Errorf("protocol_version %q must be \"3.1.1\" or \"5\"", s.ProtocolVersion)
+	}
+	if (s.Password != "" || s.PasswordFile != "") && s.Username == "" {
+		return errors.New("password requires username to be set")
+	}
+	if strings.ContainsAny(s.Topic, "+#") {
+		return fmt.Errorf("topic %q must not contain wildcards", s.Topic)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *KafkaProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultKafkaProbe
+	type plain KafkaProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	switch s.SASLMechanism {
+	case "":
+		if s.SASLUsername != "" || s.SASLPassword != "" || s.SASLPasswordFile != "" {
+			return errors.New("sasl_username and sasl_password require sasl_mechanism to be set")
+		}
+	case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+		if s.SASLUsername == "" {
+			return errors.New("sasl_mechanism requires sasl_username to be set")
+		}
+	default:
+		return fmt.Errorf("sasl_mechanism %q must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", s.SASLMechanism)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *MemcachedProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultMemcachedProbe
+	type plain MemcachedProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if len(s.Key) > 250 || strings.IndexFunc(s.Key, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+		return fmt.Errorf("key %q must be at most 250 bytes without whitespace or control characters", s.Key)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *PostgresProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultPostgresProbe
+	type plain PostgresProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.Username == "" {
+		return errors.New("username must not be empty")
+	}
+	if s.Query == "" {
+		return errors.New("query must not be empty")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *MySQLProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultMySQLProbe
+	type plain MySQLProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.Username == "" && (s.Password != "" || s.PasswordFile != "" || s.Database != "" || s.Query != "") {
+		return errors.New("password, database and query require username to be set")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *MongoDBProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultMongoDBProbe
+	type plain MongoDBProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.AuthMechanism != "SCRAM-SHA-1" && s.AuthMechanism != "SCRAM-SHA-256" {
+		return fmt.Errorf("auth_mechanism %q must be SCRAM-SHA-1 or SCRAM-SHA-256", s.AuthMechanism)
+	}
+	if (s.Password != "" || s.PasswordFile != "") && s.Username == "" {
+		return errors.New("password requires username to be set")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *SNMPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultSNMPProbe
+	type plain SNMPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	components := strings.Split(strings.TrimPrefix(s.OID, "."), ".")
+	if len(components) < 2 {
+		return fmt.Errorf("oid %q must be a numeric object identifier", s.OID)
+	}
+	for _, component := range components {
+		if _, err := strconv.ParseUint(component, 10, 31); err != nil {
+			return fmt.Errorf("oid %q must be a numeric object identifier", s.OID)
+		}
+	}
+	switch s.Version {
+	case "2c":
+	case "3":
+		if s.Username == "" {
+			return errors.New("version 3 requires username to be set")
+		}
+	default:
+		return fmt.Errorf("version %q must be 2c or 3", s.Version)
+	}
+	if s.AuthProtocol != "MD5" && s.AuthProtocol != "SHA" {
+		return fmt.Errorf("auth_protocol %q must be MD5 or SHA", s.AuthProtocol)
+	}
+	// The default community is replaced by the one read from the file.
+	if s.CommunityFile != "" && s.Community == DefaultSNMPProbe.Community {
+		s.Community = ""
+	}
+	if s.AuthPassword != "" && len(s.AuthPassword) < 8 {
+		return errors.New("auth_password must be at least 8 characters long")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *RTSPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultRTSPProbe
+	type plain RTSPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if (s.Password != "" || s.PasswordFile != "") && s.Username == "" {
+		return errors.New("password requires username to be set")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ModbusProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultModbusProbe
+	type plain ModbusProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *STUNProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultSTUNProbe
+	type plain STUNProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *TURNProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultTURNProbe
+	type plain TURNProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if (s.Password != "" || s.PasswordFile != "") && s.Username == "" {
+		return errors.New("password requires username to be set")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *RADIUSProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultRADIUSProbe
+	type plain RADIUSProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if (s.Secret == "" && s.SecretFile == "") || s.Username == "" {
+		return errors.New("secret and username must be set")
+	}
+	if s.AuthMethod != "PAP" && s.AuthMethod != "CHAP" {
+		return fmt.Errorf("auth_method %q must be PAP or CHAP", s.AuthMethod)
+	}
+	if len(s.Password) > 128 {
+		return errors.New("password must not be longer than 128 characters")
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *DHCPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultDHCPProbe
+	type plain DHCPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.ClientPort < 0 || s.ClientPort > 65535 {
+		return fmt.Errorf("client_port %d is out of range", s.ClientPort)
+	}
+	if s.HardwareAddress != "" {
+		if _, err := net.ParseMAC(s.HardwareAddress); err != nil {
+			return fmt.Errorf("invalid hardware_address: %w", err)
+		}
+	}
+	if s.SourceIPAddress != "" && net.ParseIP(s.SourceIPAddress).To4() == nil {
+		return fmt.Errorf("source_ip_address %q must be an IPv4 address", s.SourceIPAddress)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *TFTPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultTFTPProbe
+	type plain TFTPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.Filename == "" {
+		return errors.New("filename must be set")
+	}
+	return nil
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
-func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	var s string
-	if err := unmarshal(&s); err != nil {
+func (s *SyslogProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultSyslogProbe
+	type plain SyslogProbe
+	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
-	r, err := NewRegexp(s)
-	if err != nil {
-		return fmt.Errorf("\"Could not compile regular expression\" regexp=\"%s\"", s)
+
+	if s.Protocol != "udp" && s.Protocol != "tcp" {
+		return fmt.Errorf("protocol %q must be udp or tcp", s.Protocol)
+	}
+	if s.TLS && s.Protocol != "tcp" {
+		return errors.New("tls requires protocol tcp")
+	}
+	if s.Framing != "octet-counting" && s.Framing != "non-transparent" {
+		return fmt.Errorf("framing %q must be octet-counting or non-transparent", s.Framing)
 	}
-	*re = r
 	return nil
 }
 
-// MarshalYAML implements the yaml.Marshaler interface.
-func (re Regexp) MarshalYAML() (interface{}, error) {
-	if re.original != "" {
-		return re.original, nil
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *NATSProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultNATSProbe
+	type plain NATSProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
 	}
-	return nil, nil
-}
 
-// MustNewRegexp works like NewRegexp, but panics if the regular expression does not compile.
-func MustNewRegexp(s string) Regexp {
-	re, err := NewRegexp(s)
-	if err != nil {
-		panic(err)
+	authMethods := 0
+	for _, set := range []bool{s.Token != "" || s.TokenFile != "", s.Username != "", s.CredentialsFile != ""} {
+		if set {
+			authMethods++
+		}
 	}
-	return re
+	if authMethods > 1 {
+		return errors.New("at most one of token, username and credentials_file must be set")
+	}
+	if strings.ContainsAny(s.Subject, " \t\r\n") {
+		return fmt.Errorf("subject %q must not contain whitespace", s.Subject)
+	}
+	return nil
 }
 
-type Module struct {
-	Prober  string        `yaml:"prober,omitempty"`
-	Timeout time.Duration `yaml:"timeout,omitempty"`
-	HTTP    HTTPProbe     `yaml:"http,omitempty"`
-	TCP     TCPProbe      `yaml:"tcp,omitempty"`
-	ICMP    ICMPProbe     `yaml:"icmp,omitempty"`
-	DNS     DNSProbe      `yaml:"dns,omitempty"`
-	GRPC    GRPCProbe     `yaml:"grpc,omitempty"`
-}
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *EtcdProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultEtcdProbe
+	type plain EtcdProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
 
-type HTTPProbe struct {
-	// Defaults to 2xx.
-	ValidStatusCodes             []int                   `yaml:"valid_status_codes,omitempty"`
-	ValidHTTPVersions            []string                `yaml:"valid_http_versions,omitempty"`
-	IPProtocol                   string                  `yaml:"preferred_ip_protocol,omitempty"`
-	IPProtocolFallback           bool                    `yaml:"ip_protocol_fallback,omitempty"`
-	SkipResolvePhaseWithProxy    bool                    `yaml:"skip_resolve_phase_with_proxy,omitempty"`
-	NoFollowRedirects            *bool                   `yaml:"no_follow_redirects,omitempty"`
-	FailIfSSL                    bool                    `yaml:"fail_if_ssl,omitempty"`
-	FailIfNotSSL                 bool                    `yaml:"fail_if_not_ssl,omitempty"`
-	Method                       string                  `yaml:"method,omitempty"`
-	Headers                      map[string]string       `yaml:"headers,omitempty"`
-	FailIfBodyMatchesRegexp      []Regexp                `yaml:"fail_if_body_matches_regexp,omitempty"`
-	FailIfBodyNotMatchesRegexp   []Regexp                `yaml:"fail_if_body_not_matches_regexp,omitempty"`
-	FailIfHeaderMatchesRegexp    []HeaderMatch           `yaml:"fail_if_header_matches,omitempty"`
-	FailIfHeaderNotMatchesRegexp []HeaderMatch           `yaml:"fail_if_header_not_matches,omitempty"`
-	Body                         string                  `yaml:"body,omitempty"`
-	BodyFile                     string                  `yaml:"body_file,omitempty"`
-	HTTPClientConfig             config.HTTPClientConfig `yaml:"http_client_config,inline"`
-	Compression                  string                  `yaml:"compression,omitempty"`
-	BodySizeLimit                units.Base2Bytes        `yaml:"body_size_limit,omitempty"`
+	if err := s.HTTPClientConfig.Validate(); err != nil {
+		return err
+	}
+	return nil
 }
 
-type GRPCProbe struct {
-	Service             string           `yaml:"service,omitempty"`
-	TLS                 bool             `yaml:"tls,omitempty"`
-	TLSConfig           config.TLSConfig `yaml:"tls_config,omitempty"`
-	IPProtocolFallback  bool             `yaml:"ip_protocol_fallback,omitempty"`
-	PreferredIPProtocol string           `yaml:"preferred_ip_protocol,omitempty"`
-}
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ElasticsearchProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultElasticsearchProbe
+	type plain ElasticsearchProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
 
-type HeaderMatch struct {
-	Header       string `yaml:"header,omitempty"`
-	Regexp       Regexp `yaml:"regexp,omitempty"`
-	AllowMissing bool   `yaml:"allow_missing,omitempty"`
+	if err := s.HTTPClientConfig.Validate(); err != nil {
+		return err
+	}
+	switch s.MinimumStatus {
+	case "green", "yellow", "red":
+	default:
+		return fmt.Errorf("minimum_status %q must be green, yellow or red", s.MinimumStatus)
+	}
+	return nil
 }
 
-type Label struct {
-	Name  string `yaml:"name,omitempty"`
-	Value string `yaml:"value,omitempty"`
-}
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *GitProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultGitProbe
+	type plain GitProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
 
-type QueryResponse struct {
-	Expect   Regexp  `yaml:"expect,omitempty"`
-	Labels   []Label `yaml:"labels,omitempty"`
-	Send     string  `yaml:"send,omitempty"`
-	StartTLS bool    `yaml:"starttls,omitempty"`
+	if err := s.HTTPClientConfig.Validate(); err != nil {
+		return err
+	}
+	if s.ExpectRef != "" && !strings.HasPrefix(s.ExpectRef, "refs/") && s.ExpectRef != "HEAD" {
+		return fmt.Errorf("expect_ref %q must be HEAD or start with refs/", s.ExpectRef)
+	}
+	return nil
 }
 
-type TCPProbe struct {
-	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
-	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
-	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
-	QueryResponse      []QueryResponse  `yaml:"query_response,omitempty"`
-	TLS                bool             `yaml:"tls,omitempty"`
-	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
-}
+// registryRepositoryRE matches the repository names of the OCI distribution
+// specification.
+var registryRepositoryRE = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
 
-type ICMPProbe struct {
-	IPProtocol         string `yaml:"preferred_ip_protocol,omitempty"` // Defaults to "ip6".
-	IPProtocolFallback bool   `yaml:"ip_protocol_fallback,omitempty"`
-	SourceIPAddress    string `yaml:"source_ip_address,omitempty"`
-	PayloadSize        int    `yaml:"payload_size,omitempty"`
-	DontFragment       bool   `yaml:"dont_fragment,omitempty"`
-	TTL                int    `yaml:"ttl,omitempty"`
-}
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *RegistryProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultRegistryProbe
+	type plain RegistryProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
 
-type DNSProbe struct {
-	IPProtocol         string           `yaml:"preferred_ip_protocol,omitempty"`
-	IPProtocolFallback bool             `yaml:"ip_protocol_fallback,omitempty"`
-	DNSOverTLS         bool             `yaml:"dns_over_tls,omitempty"`
-	TLSConfig          config.TLSConfig `yaml:"tls_config,omitempty"`
-	SourceIPAddress    string           `yaml:"source_ip_address,omitempty"`
-	TransportProtocol  string           `yaml:"transport_protocol,omitempty"`
-	QueryClass         string           `yaml:"query_class,omitempty"` // Defaults to IN.
-	QueryName          string           `yaml:"query_name,omitempty"`
-	QueryType          string           `yaml:"query_type,omitempty"`        // Defaults to ANY.
-	Recursion          bool             `yaml:"recursion_desired,omitempty"` // Defaults to true.
-	ValidRcodes        []string         `yaml:"valid_rcodes,omitempty"`      // Defaults to NOERROR.
-	ValidateAnswer     DNSRRValidator   `yaml:"validate_answer_rrs,omitempty"`
-	ValidateAuthority  DNSRRValidator   `yaml:"validate_authority_rrs,omitempty"`
-	ValidateAdditional DNSRRValidator   `yaml:"validate_additional_rrs,omitempty"`
+	if s.Repository != "" && !registryRepositoryRE.MatchString(s.Repository) {
+		return fmt.Errorf("invalid repository %q", s.Repository)
+	}
+	if s.Reference == "" || strings.ContainsAny(s.Reference, "/ ") {
+		return fmt.Errorf("invalid reference %q", s.Reference)
+	}
+	return nil
 }
 
-type DNSRRValidator struct {
-	FailIfMatchesRegexp     []string `yaml:"fail_if_matches_regexp,omitempty"`
-	FailIfAllMatchRegexp    []string `yaml:"fail_if_all_match_regexp,omitempty"`
-	FailIfNotMatchesRegexp  []string `yaml:"fail_if_not_matches_regexp,omitempty"`
-	FailIfNoneMatchesRegexp []string `yaml:"fail_if_none_matches_regexp,omitempty"`
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *SMBProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultSMBProbe
+	type plain SMBProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.Share != "" && s.Username == "" {
+		return errors.New("share requires username")
+	}
+	if strings.ContainsAny(s.Share, `\/`) {
+		return fmt.Errorf("invalid share %q", s.Share)
+	}
+	return nil
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
-func (s *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	type plain Config
+func (s *NFSProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultNFSProbe
+	type plain NFSProbe
 	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
+
+	if len(s.Services) == 0 {
+		return errors.New("at least one service must be set")
+	}
+	for _, service := range s.Services {
+		switch service {
+		case "nfs", "mountd", "nlockmgr", "status":
+		default:
+			return fmt.Errorf("unknown service %q", service)
+		}
+	}
+	if s.NFSVersion != 3 && s.NFSVersion != 4 {
+		return fmt.Errorf("invalid nfs_version %d", s.NFSVersion)
+	}
 	return nil
 }
 
+// opcuaNodeIDRE matches the string notation of numeric, string and opaque
+// node ids.
+var opcuaNodeIDRE = regexp.MustCompile(`^(ns=\d+;)?(i=\d+|s=.+|b=[A-Za-z0-9+/]+=*)$`)
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
-func (s *Module) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	*s = DefaultModule
-	type plain Module
+func (s *OPCUAProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultOPCUAProbe
+	type plain OPCUAProbe
 	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
+
+	if !opcuaNodeIDRE.MatchString(s.NodeID) {
+		return fmt.Errorf("invalid node_id %q", s.NodeID)
+	}
 	return nil
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
-func (s *HTTPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	*s = DefaultHTTPProbe
-	type plain HTTPProbe
+func (s *WebDAVProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultWebDAVProbe
+	type plain WebDAVProbe
 	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
 
-	// BodySizeLimit == 0 means no limit. By leaving it at 0 we
-	// avoid setting up the limiter.
-	if s.BodySizeLimit < 0 || s.BodySizeLimit == math.MaxInt64 {
-		// The implementation behind http.MaxBytesReader tries
-		// to add 1 to the specified limit causing it to wrap
-		// around and become negative, and then it tries to use
-		// that result to index an slice.
-		s.BodySizeLimit = math.MaxInt64 - 1
+	if err := s.HTTPClientConfig.Validate(); err != nil {
+		return err
 	}
+	if s.Depth != 0 && s.Depth != 1 {
+		return fmt.Errorf("invalid depth %d, must be 0 or 1", s.Depth)
+	}
+	return nil
+}
 
-	if err := s.HTTPClientConfig.Validate(); err != nil {
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *TelnetProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultTelnetProbe
+	type plain TelnetProbe
+	return unmarshal((*plain)(s))
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *IRCProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultIRCProbe
+	type plain IRCProbe
+	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
 
-	if s.NoFollowRedirects != nil {
-		s.HTTPClientConfig.FollowRedirects = !*s.NoFollowRedirects
+	if s.Nick == "" || strings.ContainsAny(s.Nick, " ,*?!@:#&") {
+		return fmt.Errorf("invalid nick %q", s.Nick)
 	}
+	return nil
+}
 
-	if s.Body != "" && s.BodyFile != "" {
-		return errors.New("setting body and body_file both are not allowed")
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *KubernetesProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultKubernetesProbe
+	type plain KubernetesProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
 	}
 
-	for key, value := range s.Headers {
-		switch textproto.CanonicalMIMEHeaderKey(key) {
-		case "Accept-Encoding":
-			if !isCompressionAcceptEncodingValid(s.Compression, value) {
-				return fmt.Errorf(`invalid configuration "%s: %s", "compression: %s"`, key, value, s.Compression)
-			}
+	if err := s.HTTPClientConfig.Validate(); err != nil {
+		return err
+	}
+	for _, check := range s.ExcludeChecks {
+		if check == "" || strings.ContainsAny(check, " ,") {
+			return fmt.Errorf("invalid check name %q in exclude_checks", check)
 		}
 	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *UDPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultUDPProbe
+	type plain UDPProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
 
+	if s.Send != "" && len(s.SendHex) > 0 {
+		return errors.New("setting send and send_hex both are not allowed")
+	}
+	if s.Expect.Regexp != nil && len(s.ExpectHex) > 0 {
+		return errors.New("setting expect and expect_hex both are not allowed")
+	}
+	if s.PacketTimeout < 0 {
+		return errors.New("packet_timeout cannot be negative")
+	}
+	if s.Retries < 0 {
+		return errors.New("retries cannot be negative")
+	}
+	if s.Retries > 0 && s.PacketTimeout == 0 {
+		return errors.New("retries requires packet_timeout to be set")
+	}
 	return nil
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
-func (s *GRPCProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	*s = DefaultGRPCProbe
-	type plain GRPCProbe
+func (s *TracerouteProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultTracerouteProbe
+	type plain TracerouteProbe
 	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
+
+	if s.MaxHops < 1 || s.MaxHops > 255 {
+		return errors.New("max_hops must be in the range [1, 255]")
+	}
+	if s.HopTimeout <= 0 {
+		return errors.New("hop_timeout must be positive")
+	}
 	return nil
 }
 
@@ -384,6 +2714,9 @@ func (s *DNSProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			return fmt.Errorf("query type '%s' is not valid", s.QueryType)
 		}
 	}
+	if s.ExpectNXDomain && len(s.ValidRcodes) > 0 {
+		return errors.New("setting expect_nxdomain and valid_rcodes both are not allowed")
+	}
 
 	return nil
 }
@@ -395,6 +2728,86 @@ func (s *TCPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
+
+	startTLSSteps := 0
+	for _, qr := range s.QueryResponse {
+		if qr.StartTLS {
+			startTLSSteps++
+		}
+	}
+	if startTLSSteps > 1 {
+		return errors.New("at most one query_response step can set starttls")
+	}
+	if startTLSSteps > 0 && s.TLS {
+		return errors.New("setting tls and starttls both are not allowed")
+	}
+	if s.SourceIPAddress != "" && s.SourceInterface != "" {
+		return errors.New("setting source_ip_address and source_interface both are not allowed")
+	}
+	if s.HalfOpen && (s.TLS || s.CaptureBanner || len(s.QueryResponse) > 0) {
+		return errors.New("half_open cannot be combined with tls, capture_banner or query_response")
+	}
+	if len(s.Ports) > 0 {
+		if s.HalfOpen || s.TLS || s.CaptureBanner || len(s.QueryResponse) > 0 {
+			return errors.New("ports cannot be combined with half_open, tls, capture_banner or query_response")
+		}
+		count := 0
+		for _, r := range s.Ports {
+			count += int(r.Last-r.First) + 1
+		}
+		if count > MaxTCPScanPorts {
+			return fmt.Errorf("ports cannot cover more than %d ports", MaxTCPScanPorts)
+		}
+	} else if len(s.ExpectedOpenPorts) > 0 {
+		return errors.New("expected_open_ports requires ports to be set")
+	}
+	if s.ProxyURL.URL != nil {
+		switch s.ProxyURL.Scheme {
+		case "socks5", "socks5h", "http", "https":
+		default:
+			return fmt.Errorf("unsupported proxy_url scheme %q", s.ProxyURL.Scheme)
+		}
+		if s.HalfOpen || len(s.Ports) > 0 {
+			return errors.New("proxy_url cannot be combined with half_open or ports")
+		}
+	}
+	if s.ProxyPasswordFile != "" {
+		if s.ProxyURL.URL == nil || s.ProxyURL.User == nil {
+			return errors.New("proxy_password_file requires a user in proxy_url")
+		}
+		if _, ok := s.ProxyURL.User.Password(); ok {
+			return errors.New("at most one of the proxy_url password & proxy_password_file must be configured")
+		}
+	}
+	if s.ExpectFailure && (s.HalfOpen || len(s.Ports) > 0 || s.TLS || s.CaptureBanner || len(s.QueryResponse) > 0) {
+		return errors.New("expect_failure cannot be combined with half_open, ports, tls, capture_banner or query_response")
+	}
+	if s.ConnectCount < 0 {
+		return errors.New("connect_count cannot be negative")
+	}
+	if s.ConnectCount > 1 && (s.HalfOpen || len(s.Ports) > 0 || s.ExpectFailure) {
+		return errors.New("connect_count cannot be combined with half_open, ports or expect_failure")
+	}
+	if s.IdleHold < 0 || s.KeepAliveInterval < 0 {
+		return errors.New("idle_hold and keepalive_interval cannot be negative")
+	}
+	if s.IdleHold > 0 && (s.HalfOpen || len(s.Ports) > 0 || s.ExpectFailure) {
+		return errors.New("idle_hold cannot be combined with half_open, ports or expect_failure")
+	}
+	if s.LineTimeout < 0 {
+		return errors.New("line_timeout cannot be negative")
+	}
+	switch s.LineEnding {
+	case "", "lf", "crlf":
+	default:
+		return fmt.Errorf("unsupported line_ending %q, must be one of lf or crlf", s.LineEnding)
+	}
+	if s.HappyEyeballs && (s.HalfOpen || len(s.Ports) > 0 || s.ExpectFailure || s.ConnectCount > 1 || s.ProxyURL.URL != nil || s.SourceIPAddress != "" || s.SourceInterface != "") {
+		return errors.New("happy_eyeballs cannot be combined with half_open, ports, expect_failure, connect_count, proxy_url, source_ip_address or source_interface")
+	}
+	if err := validateDSCP(s.DSCP); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -425,6 +2838,36 @@ func (s *ICMPProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if s.TTL > 255 {
 		return errors.New("\"ttl\" cannot exceed 255")
 	}
+	if s.PayloadSize < 0 {
+		return errors.New("\"payload_size\" cannot be negative")
+	}
+	if s.PayloadSize > MaxICMPPayloadSize {
+		return fmt.Errorf("\"payload_size\" cannot exceed %d", MaxICMPPayloadSize)
+	}
+	if s.PacketCount < 1 {
+		return errors.New("\"packet_count\" must be at least 1")
+	}
+	if s.PacketInterval < 0 {
+		return errors.New("\"packet_interval\" cannot be negative")
+	}
+	if err := validateDSCP(s.DSCP); err != nil {
+		return err
+	}
+	if s.SourceIPAddress != "" && s.SourceInterface != "" {
+		return errors.New("setting source_ip_address and source_interface both are not allowed")
+	}
+	if s.Identifier < 0 || s.Identifier > 65535 {
+		return errors.New("\"identifier\" must be in the range [0, 65535]")
+	}
+	if s.BurstCount < 0 {
+		return errors.New("\"burst_count\" cannot be negative")
+	}
+	if s.BurstCount > 0 && (s.PacketCount < 2 || s.PacketInterval == 0) {
+		return errors.New("burst_count requires packet_count above 1 and a positive packet_interval")
+	}
+	if s.Timestamp && (s.PacketCount > 1 || s.BurstCount > 0 || s.PayloadSize > 0 || len(s.PayloadPattern) > 0 || s.DontFragment) {
+		return errors.New("timestamp cannot be combined with packet_count, burst_count, payload_size, payload_pattern or dont_fragment")
+	}
 	return nil
 }
 
@@ -435,6 +2878,16 @@ func (s *QueryResponse) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
+	if s.Timeout < 0 {
+		return errors.New("query_response \"timeout\" cannot be negative")
+	}
+	if s.Expect.Regexp != nil && len(s.ExpectHex) > 0 {
+		return errors.New("setting expect and expect_hex both are not allowed")
+	}
+	if s.Send != "" && len(s.SendHex) > 0 {
+		return errors.New("setting send and send_hex both are not allowed")
+	}
+
 	return nil
 }
 
@@ -14,10 +14,17 @@
 package config
 
 import (
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	yaml "gopkg.in/yaml.v3"
 )
 
@@ -30,6 +37,84 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfigInclude(t *testing.T) {
+	sc := NewSafeConfig(prometheus.NewRegistry())
+	if err := sc.ReloadConfig("testdata/include/blackbox.yml", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tcp_connect", "http_2xx", "http_post_2xx", "dns_soa"} {
+		if _, ok := sc.C.Modules[name]; !ok {
+			t.Errorf("Module %q is missing", name)
+		}
+	}
+	if method := sc.C.Modules["http_post_2xx"].HTTP.Method; method != "POST" {
+		t.Errorf("Unexpected method %q of included module", method)
+	}
+	// Modules can extend modules defined in other files.
+	if http := sc.C.Modules["http_post_json"].HTTP; http.Method != "POST" || http.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected settings of module http_post_json: %q %v", http.Method, http.Headers)
+	}
+	if module := sc.C.Modules["tcp_tls"]; module.Prober != "tcp" || !module.TCP.TLS {
+		t.Errorf("Unexpected settings of module tcp_tls: %q %t", module.Prober, module.TCP.TLS)
+	}
+}
+
+func TestLoadConfigScheduledProbes(t *testing.T) {
+	sc := NewSafeConfig(prometheus.NewRegistry())
+	if err := sc.ReloadConfig("testdata/blackbox-scheduled.yml", nil); err != nil {
+		t.Fatal(err)
+	}
+	probes := sc.C.ScheduledProbes
+	if len(probes) != 2 {
+		t.Fatalf("Expected 2 scheduled probes, got %d", len(probes))
+	}
+	if probes[0].Interval != time.Minute || probes[1].Interval != 15*time.Second {
+		t.Errorf("Unexpected intervals %v and %v", probes[0].Interval, probes[1].Interval)
+	}
+	sd := probes[1]
+	if files := sd.FileSDConfigs[0].Files; !reflect.DeepEqual(files, []string{filepath.Join("testdata", "targets", "*.json")}) {
+		t.Errorf("Files are not relative to the config file: %v", files)
+	}
+	if sd.FileSDConfigs[0].RefreshInterval != 30*time.Second || sd.DNSSDConfigs[0].RefreshInterval != 30*time.Second || sd.HTTPSDConfigs[0].RefreshInterval != 5*time.Minute {
+		t.Errorf("Unexpected refresh intervals")
+	}
+	keep, replace := sd.RelabelConfigs[0], sd.RelabelConfigs[1]
+	if keep.Action != "keep" || keep.Regex.MatchString("production") || !keep.Regex.MatchString("prod") {
+		t.Errorf("Unexpected keep relabel config %+v", keep)
+	}
+	if replace.Action != "replace" || replace.Separator != ";" || replace.Regex.String() != "^(?:(.*))$" || replace.Replacement != "https://$1/" {
+		t.Errorf("Unexpected replace relabel config %+v", replace)
+	}
+}
+
+func TestLoadConfigExtends(t *testing.T) {
+	sc := NewSafeConfig(prometheus.NewRegistry())
+	if err := sc.ReloadConfig("testdata/blackbox-extends.yml", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		module      string
+		method      string
+		statusCodes []int
+		headers     map[string]string
+	}{
+		{module: "http_api", method: "GET", statusCodes: []int{200}, headers: map[string]string{"Accept": "application/json"}},
+		{module: "http_api_tenant_a", method: "GET", statusCodes: []int{200}, headers: map[string]string{"Accept": "application/json", "X-Tenant": "a"}},
+		{module: "http_api_created", method: "POST", statusCodes: []int{201, 202}, headers: map[string]string{"Accept": "application/json", "X-Tenant": "a"}},
+	} {
+		module := sc.C.Modules[tc.module]
+		if module.Prober != "http" || module.Timeout != 5*time.Second {
+			t.Errorf("Module %q did not inherit prober and timeout: %q %v", tc.module, module.Prober, module.Timeout)
+		}
+		if module.HTTP.Method != tc.method || !reflect.DeepEqual(module.HTTP.ValidStatusCodes, tc.statusCodes) || !reflect.DeepEqual(module.HTTP.Headers, tc.headers) {
+			t.Errorf("Unexpected settings of module %q: %q %v %v", tc.module, module.HTTP.Method, module.HTTP.ValidStatusCodes, module.HTTP.Headers)
+		}
+	}
+	if dns := sc.C.Modules["dns_mx"].DNS; dns.QueryName != "example.com" || dns.QueryType != "MX" {
+		t.Errorf("Unexpected settings of module dns_mx: %q %q", dns.QueryName, dns.QueryType)
+	}
+}
+
 func TestLoadBadConfigs(t *testing.T) {
 	sc := NewSafeConfig(prometheus.NewRegistry())
 	tests := []struct {
@@ -56,6 +141,10 @@ func TestLoadBadConfigs(t *testing.T) {
 			input: "testdata/invalid-dns-type.yml",
 			want:  "error parsing config file: query type 'X' is not valid",
 		},
+		{
+			input: "testdata/invalid-dns-nxdomain-rcodes.yml",
+			want:  "error parsing config file: setting expect_nxdomain and valid_rcodes both are not allowed",
+		},
 		{
 			input: "testdata/invalid-http-header-match.yml",
 			want:  "error parsing config file: regexp must be set for HTTP header matchers",
@@ -96,6 +185,262 @@ func TestLoadBadConfigs(t *testing.T) {
 			input: "testdata/invalid-tcp-query-response-regexp.yml",
 			want:  `error parsing config file: "Could not compile regular expression" regexp=":["`,
 		},
+		{
+			input: "testdata/invalid-tcp-starttls-with-tls.yml",
+			want:  `error parsing config file: setting tls and starttls both are not allowed`,
+		},
+		{
+			input: "testdata/invalid-tcp-multiple-starttls.yml",
+			want:  `error parsing config file: at most one query_response step can set starttls`,
+		},
+		{
+			input: "testdata/invalid-tcp-query-response-hex.yml",
+			want:  `error parsing config file: "Could not decode hex string" hex="0x01"`,
+		},
+		{
+			input: "testdata/invalid-tcp-query-response-send-and-send-hex.yml",
+			want:  `error parsing config file: setting send and send_hex both are not allowed`,
+		},
+		{
+			input: "testdata/invalid-tcp-source-ip-and-interface.yml",
+			want:  `error parsing config file: setting source_ip_address and source_interface both are not allowed`,
+		},
+		{
+			input: "testdata/invalid-tcp-half-open-query-response.yml",
+			want:  `error parsing config file: half_open cannot be combined with tls, capture_banner or query_response`,
+		},
+		{
+			input: "testdata/invalid-tcp-ports-range.yml",
+			want:  `error parsing config file: invalid port range "8010-8000"`,
+		},
+		{
+			input: "testdata/invalid-tcp-proxy-url-scheme.yml",
+			want:  `error parsing config file: unsupported proxy_url scheme "ftp"`,
+		},
+		{
+			input: "testdata/invalid-udp-retries.yml",
+			want:  `error parsing config file: retries requires packet_timeout to be set`,
+		},
+		{
+			input: "testdata/invalid-tcp-idle-hold.yml",
+			want:  `error parsing config file: tcp idle_hold must be shorter than the module timeout`,
+		},
+		{
+			input: "testdata/invalid-tcp-line-ending.yml",
+			want:  `error parsing config file: unsupported line_ending "cr", must be one of lf or crlf`,
+		},
+		{
+			input: "testdata/invalid-icmp-dscp.yml",
+			want:  `error parsing config file: "dscp" must be in the range [0, 63]`,
+		},
+		{
+			input: "testdata/invalid-icmp-identifier.yml",
+			want:  `error parsing config file: "identifier" must be in the range [0, 65535]`,
+		},
+		{
+			input: "testdata/invalid-icmp-packet-interval.yml",
+			want:  "error parsing config file: icmp packet_count requests packet_interval apart must be sent within the module timeout",
+		},
+		{
+			input: "testdata/invalid-icmp-burst-count.yml",
+			want:  "error parsing config file: burst_count requires packet_count above 1 and a positive packet_interval",
+		},
+		{
+			input: "testdata/invalid-icmp-timestamp.yml",
+			want:  "error parsing config file: timestamp cannot be combined with packet_count, burst_count, payload_size, payload_pattern or dont_fragment",
+		},
+		{
+			input: "testdata/invalid-smtp-rcpt-to.yml",
+			want:  "error parsing config file: rcpt_to requires mail_from to be set",
+		},
+		{
+			input: "testdata/invalid-imap-mailbox.yml",
+			want:  "error parsing config file: mailbox requires username to be set",
+		},
+		{
+			input: "testdata/invalid-pop3-tls.yml",
+			want:  "error parsing config file: setting tls and starttls both are not allowed",
+		},
+		{
+			input: "testdata/invalid-ssh-fingerprint.yml",
+			want:  `error parsing config file: host_key_fingerprint "d4:1d:8c:d9:8f:00:b2:04" must be a SHA256 fingerprint such as "SHA256:..."`,
+		},
+		{
+			input: "testdata/invalid-ldap-search.yml",
+			want:  "error parsing config file: search_base_dn and search_filter require search to be enabled",
+		},
+		{
+			input: "testdata/invalid-mqtt-topic.yml",
+			want:  `error parsing config file: topic "sensors/#" must not contain wildcards`,
+		},
+		{
+			input: "testdata/invalid-kafka-sasl.yml",
+			want:  `error parsing config file: sasl_mechanism "GSSAPI" must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512`,
+		},
+		{
+			input: "testdata/invalid-memcached-key.yml",
+			want:  `error parsing config file: key "blackbox probe" must be at most 250 bytes without whitespace or control characters`,
+		},
+		{
+			input: "testdata/invalid-postgres-query.yml",
+			want:  "error parsing config file: query must not be empty",
+		},
+		{
+			input: "testdata/invalid-mysql-query.yml",
+			want:  "error parsing config file: password, database and query require username to be set",
+		},
+		{
+			input: "testdata/invalid-mongodb-mechanism.yml",
+			want:  `error parsing config file: auth_mechanism "PLAIN" must be SCRAM-SHA-1 or SCRAM-SHA-256`,
+		},
+		{
+			input: "testdata/invalid-snmp-version.yml",
+			want:  `error parsing config file: version "1" must be 2c or 3`,
+		},
+		{
+			input: "testdata/invalid-rtsp-password.yml",
+			want:  "error parsing config file: password requires username to be set",
+		},
+		{
+			input: "testdata/invalid-modbus-unit-id.yml",
+			want:  "error parsing config file: yaml: unmarshal errors:\n  line 6: cannot unmarshal !!int `256` into uint8",
+		},
+		{
+			input: "testdata/invalid-turn-password.yml",
+			want:  "error parsing config file: password requires username to be set",
+		},
+		{
+			input: "testdata/invalid-radius-auth-method.yml",
+			want:  `error parsing config file: auth_method "MSCHAPv2" must be PAP or CHAP`,
+		},
+		{
+			input: "testdata/invalid-dhcp-hardware-address.yml",
+			want:  "error parsing config file: invalid hardware_address: address 02:00:00:00:00: invalid MAC address",
+		},
+		{
+			input: "testdata/invalid-tftp-filename.yml",
+			want:  "error parsing config file: filename must be set",
+		},
+		{
+			input: "testdata/invalid-syslog-tls.yml",
+			want:  "error parsing config file: tls requires protocol tcp",
+		},
+		{
+			input: "testdata/invalid-nats-auth.yml",
+			want:  "error parsing config file: at most one of token, username and credentials_file must be set",
+		},
+		{
+			input: "testdata/invalid-etcd-basic-auth.yml",
+			want:  "error parsing config file: at most one of basic_auth password, password_file & password_ref must be configured",
+		},
+		{
+			input: "testdata/invalid-elasticsearch-minimum-status.yml",
+			want:  `error parsing config file: minimum_status "orange" must be green, yellow or red`,
+		},
+		{
+			input: "testdata/invalid-git-expect-ref.yml",
+			want:  `error parsing config file: expect_ref "main" must be HEAD or start with refs/`,
+		},
+		{
+			input: "testdata/invalid-registry-repository.yml",
+			want:  `error parsing config file: invalid repository "Library/Alpine"`,
+		},
+		{
+			input: "testdata/invalid-smb-share.yml",
+			want:  `error parsing config file: share requires username`,
+		},
+		{
+			input: "testdata/invalid-nfs-service.yml",
+			want:  `error parsing config file: unknown service "portmap"`,
+		},
+		{
+			input: "testdata/invalid-opcua-node-id.yml",
+			want:  `error parsing config file: invalid node_id "ns=2;Demo.Static.Scalar.Double"`,
+		},
+		{
+			input: "testdata/invalid-webdav-depth.yml",
+			want:  `error parsing config file: invalid depth 2, must be 0 or 1`,
+		},
+		{
+			input: "testdata/invalid-telnet-expect.yml",
+			want:  `error parsing config file: "Could not compile regular expression" regexp="login("`,
+		},
+		{
+			input: "testdata/invalid-irc-nick.yml",
+			want:  `error parsing config file: invalid nick "black box"`,
+		},
+		{
+			input: "testdata/invalid-kubernetes-exclude-checks.yml",
+			want:  `error parsing config file: invalid check name "etcd,log" in exclude_checks`,
+		},
+		{
+			input: "testdata/invalid-include-conflict.yml",
+			want:  `error parsing config file: module "http_2xx" is defined in both testdata/invalid-include-conflict.yml and testdata/include/conf.d/http.yml`,
+		},
+		{
+			input: "testdata/invalid-extends-unknown.yml",
+			want:  `error parsing config file: module "http_api" extends unknown module "http_base"`,
+		},
+		{
+			input: "testdata/invalid-extends-field.yml",
+			want:  "error parsing config file: yaml: unmarshal errors:\n  line 12: field invalid_extra_field not found in type config.plain",
+		},
+		{
+			input: "testdata/invalid-extends-cycle.yml",
+			want:  "error parsing config file: modules a -> b -> a extend each other",
+		},
+		{
+			input: "testdata/invalid-secret-file.yml",
+			want:  "error parsing config file: at most one of password & password_file must be configured",
+		},
+		{
+			input: "testdata/invalid-allowed-overrides.yml",
+			want:  `error parsing config file: override "valid_status_codes" is not supported by the tcp prober`,
+		},
+		{
+			input: "testdata/invalid-label-params.yml",
+			want:  `error parsing config file: invalid label name "__address__" in label_params`,
+		},
+		{
+			input: "testdata/invalid-target-filter-cidr.yml",
+			want:  `error parsing config file: netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`,
+		},
+		{
+			input: "testdata/invalid-max-concurrent.yml",
+			want:  "error parsing config file: max_concurrent must not be negative",
+		},
+		{
+			input: "testdata/invalid-cache-ttl.yml",
+			want:  "error parsing config file: cache_ttl must not be negative",
+		},
+		{
+			input: "testdata/invalid-proxy-password-file.yml",
+			want:  "error parsing config file: at most one of the proxy_url password & proxy_password_file must be configured",
+		},
+		{
+			input: "testdata/invalid-target-filter-proxy.yml",
+			want:  "error parsing config file: target_filter cannot check the addresses of targets resolved by the tcp proxy_url",
+		},
+		{
+			input: "testdata/invalid-global-target-filter-proxy.yml",
+			want:  "error parsing config file: target_filter cannot check the addresses of targets resolved by the tcp proxy_url of module \"tcp_proxy\"",
+		},
+		{
+			input: "testdata/invalid-log-level.yml",
+			want:  "error parsing config file: invalid log_level \"verbose\", must be one of debug, info, warn, error or none",
+		},
+		{
+			input: "testdata/invalid-remote-write-url.yml",
+			want:  "error parsing config file: remote_write url must be an http or https URL",
+		},
+		{
+			input: "testdata/invalid-scheduled-probes-module.yml",
+			want:  "error parsing config file: unknown module \"http_2xx\" in scheduled_probes",
+		},
+		{
+			input: "testdata/invalid-relabel-action.yml",
+			want:  "error parsing config file: unknown action \"labelmap\" in relabel_configs",
+		},
 		{
 			input: "testdata/invalid-http-body-config.yml",
 			want:  `error parsing config file: setting body and body_file both are not allowed`,
@@ -213,3 +558,259 @@ func TestIsEncodingAcceptable(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckModules(t *testing.T) {
+	sc := NewSafeConfig(prometheus.NewRegistry())
+	for _, test := range []struct {
+		input string
+		want  []string
+	}{
+		{
+			input: "testdata/invalid-modules.yml",
+			want: []string{
+				`line 4: module "dns_missing_query": query name must be set for DNS module`,
+				`line 8: module "irc_bad_nick": invalid nick "black box"`,
+			},
+		},
+		{
+			// The lines are those of the file, not of the merged modules.
+			input: "testdata/invalid-extends-modules.yml",
+			want: []string{
+				`line 13: module "dns_missing_query": query name must be set for DNS module`,
+			},
+		},
+	} {
+		errs, err := sc.CheckModules(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != len(test.want) {
+			t.Fatalf("Expected %d module errors in %s, got %v", len(test.want), test.input, errs)
+		}
+		for i, err := range errs {
+			if err.Error() != test.want[i] {
+				t.Errorf("Expected error %q, got %q", test.want[i], err.Error())
+			}
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	sc := NewSafeConfig(prometheus.NewRegistry())
+	sc.ExpandEnv = true
+	if err := sc.ReloadConfig("testdata/blackbox-env.yml", nil); err == nil || err.Error() != `error parsing config file: environment variable "BLACKBOX_TEST_TOKEN" is not set` {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	t.Setenv("BLACKBOX_TEST_TOKEN", "s3cr3t")
+	if err := sc.ReloadConfig("testdata/blackbox-env.yml", nil); err != nil {
+		t.Fatal(err)
+	}
+	module := sc.C.Modules["http_2xx"].HTTP
+	if token := string(module.HTTPClientConfig.Authorization.Credentials); token != "s3cr3t" {
+		t.Errorf("Unexpected bearer token %q", token)
+	}
+	for i, want := range []string{`^ok$`, `costs \$5`} {
+		if got := module.FailIfBodyNotMatchesRegexp[i].String(); got != want {
+			t.Errorf("Unexpected regexp %q, want %q", got, want)
+		}
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	communityFile := filepath.Join(dir, "community")
+	if err := os.WriteFile(communityFile, []byte("private\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var module Module
+	if err := yaml.Unmarshal([]byte("prober: snmp\nsnmp:\n  oid: 1.3.6.1.2.1.1.3.0\n  community_file: "+communityFile+"\n"), &module); err != nil {
+		t.Fatal(err)
+	}
+	if module.SNMP.Community != "" {
+		t.Fatalf("Default community %q was not replaced by the file", module.SNMP.Community)
+	}
+	if err := module.LoadSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if module.SNMP.Community != "private" {
+		t.Fatalf("Unexpected community %q", module.SNMP.Community)
+	}
+
+	// The file is read again for each probe.
+	module.SNMP.CommunityFile = filepath.Join(dir, "missing")
+	if err := module.LoadSecretFiles(); err == nil || !strings.HasPrefix(err.Error(), "unable to read community_file: ") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestLoadProxyPasswordFile(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "proxy-password")
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var module Module
+	if err := yaml.Unmarshal([]byte("prober: tcp\ntcp:\n  proxy_url: http://prober@proxy:3128\n  proxy_password_file: "+passwordFile+"\n"), &module); err != nil {
+		t.Fatal(err)
+	}
+	configured := module
+	if err := module.LoadSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if password, _ := module.TCP.ProxyURL.User.Password(); password != "secret" || module.TCP.ProxyURL.User.Username() != "prober" {
+		t.Fatalf("Unexpected proxy user %v", module.TCP.ProxyURL.User)
+	}
+	if _, ok := configured.TCP.ProxyURL.User.Password(); ok {
+		t.Fatal("The password was stored in the configuration")
+	}
+}
+
+func TestMarshalRedactsCredentials(t *testing.T) {
+	module := Module{
+		Prober: "http",
+		HTTP: HTTPProbe{
+			Headers: map[string]string{
+				"Authorization": "Bearer hunter2",
+				"X-Api-Key":     "hunter2",
+				"Cookie":        "session=hunter2",
+				"Accept":        "text/plain",
+			},
+		},
+	}
+	for _, f := range module.secretFiles() {
+		*f.secret = "hunter2"
+	}
+	out, err := yaml.Marshal(&module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "hunter2") {
+		t.Fatalf("Credentials were not redacted:\n%s", out)
+	}
+	if !strings.Contains(string(out), "Accept: text/plain") {
+		t.Fatalf("Header without credentials was redacted:\n%s", out)
+	}
+	if module.HTTP.Headers["Authorization"] != "Bearer hunter2" {
+		t.Fatal("Marshalling modified the module headers")
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	hash := func(confFile string) float64 {
+		t.Helper()
+		sc := NewSafeConfig(prometheus.NewRegistry())
+		if err := sc.ReloadConfig(confFile, nil); err != nil {
+			t.Fatalf("Error loading config %v: %v", confFile, err)
+		}
+		if success := testutil.ToFloat64(sc.configReloadSuccess); success != 1 {
+			t.Fatalf("Unexpected reload success %v", success)
+		}
+		return testutil.ToFloat64(sc.configHash)
+	}
+
+	good := hash("testdata/blackbox-good.yml")
+	if good == 0 {
+		t.Fatal("Config hash is not set")
+	}
+	if again := hash("testdata/blackbox-good.yml"); again != good {
+		t.Fatalf("Config hash changed from %v to %v for the same config", good, again)
+	}
+	if other := hash("testdata/include/blackbox.yml"); other == good {
+		t.Fatal("Config hash is the same for different configs")
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	module := Module{
+		Prober:           "dns",
+		DNS:              DNSProbe{QueryName: "example.com", QueryType: "ANY"},
+		AllowedOverrides: []string{"query_type"},
+	}
+	for _, tc := range []struct {
+		params url.Values
+		want   string
+	}{
+		{params: url.Values{"query_type": {"MX"}}},
+		{params: url.Values{"query_type": {"BOGUS"}}, want: "query type 'BOGUS' is not valid"},
+		{params: url.Values{"query_name": {"example.org"}}, want: "overriding query_name is not allowed by the module"},
+		{params: url.Values{"valid_status_codes": {"404"}}},
+	} {
+		m := module
+		err := m.ApplyOverrides(tc.params)
+		if tc.want == "" && err != nil {
+			t.Errorf("Unexpected error for %v: %v", tc.params, err)
+		} else if tc.want != "" && (err == nil || err.Error() != tc.want) {
+			t.Errorf("Expected error %q for %v, got %v", tc.want, tc.params, err)
+		}
+	}
+
+	if err := module.ApplyOverrides(url.Values{"query_type": {"MX"}}); err != nil {
+		t.Fatal(err)
+	}
+	if module.DNS.QueryType != "MX" || module.DNS.QueryName != "example.com" {
+		t.Fatalf("Unexpected DNS probe after overrides: %+v", module.DNS)
+	}
+}
+
+func TestTargetFilter(t *testing.T) {
+	var c Config
+	if err := yaml.Unmarshal([]byte(`
+target_filter:
+  allow_hostnames: ['example\.com', '.*\.example\.com']
+  deny_hostnames: ['internal\.example\.com']
+  allow_cidrs: [192.0.2.0/24, '2001:db8::/32']
+  deny_cidrs: [192.0.2.1/32]
+`), &c); err != nil {
+		t.Fatal(err)
+	}
+	f := c.TargetFilter
+	for hostname, allowed := range map[string]bool{
+		"example.com":           true,
+		"www.example.com":       true,
+		"internal.example.com":  false,
+		"example.com.evil.test": false,
+		"example.org":           false,
+	} {
+		if f.AllowsHostname(hostname) != allowed {
+			t.Errorf("Expected host name %q allowed to be %v", hostname, allowed)
+		}
+	}
+	for addr, allowed := range map[string]bool{
+		"192.0.2.2":           true,
+		"::ffff:192.0.2.2":    true,
+		"192.0.2.1":           false,
+		"2001:db8::1":         true,
+		"198.51.100.1":        false,
+		"::ffff:198.51.100.1": false,
+	} {
+		if f.AllowsAddr(netip.MustParseAddr(addr)) != allowed {
+			t.Errorf("Expected address %s allowed to be %v", addr, allowed)
+		}
+	}
+
+	private := TargetFilter{DenyPrivateTargets: true}
+	for addr, allowed := range map[string]bool{
+		"10.1.2.3":           false,
+		"::ffff:192.168.1.1": false,
+		"127.0.0.1":          false,
+		"169.254.169.254":    false,
+		"fe80::1":            false,
+		"0.0.0.0":            false,
+		"fd00::1":            false,
+		"192.0.2.2":          true,
+		"2001:db8::1":        true,
+	} {
+		if private.AllowsAddr(netip.MustParseAddr(addr)) != allowed {
+			t.Errorf("Expected address %s allowed to be %v with deny_private_targets", addr, allowed)
+		}
+	}
+
+	// The regexps are shown as configured.
+	out, err := yaml.Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `- example\.com`) || !strings.Contains(string(out), "- 192.0.2.0/24") {
+		t.Fatalf("Unexpected marshalled target filter:\n%s", out)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxRemoteConfigSize limits the size of a configuration fetched from a URL.
+const maxRemoteConfigSize = 16 << 20
+
+var (
+	errConfigNotModified = errors.New("config not modified")
+
+	configHTTPClient = &http.Client{Timeout: 30 * time.Second}
+	// Metadata services are only reachable on instances of the cloud
+	// provider, elsewhere connecting to them times out.
+	metadataHTTPClient = &http.Client{Timeout: 2 * time.Second}
+)
+
+// configVersion identifies the content of a configuration fetched from a URL
+// by the validators of the response, or from a key-value store by the
+// revision.
+type configVersion struct {
+	etag         string
+	lastModified string
+	// revision is the revision the key was last modified at if the
+	// configuration was read from a key-value store.
+	revision int64
+}
+
+// IsRemote reports whether the configuration file is fetched from a URL or
+// an object storage rather than read from the file system, so it has to be
+// polled for changes.
+func IsRemote(confFile string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://", "gs://"} {
+		if strings.HasPrefix(confFile, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// envReferenceRE matches references to environment variables and escaped
+// dollar signs. Only the ${VAR} form is expanded, as $ is common in regular
+// expressions.
+var envReferenceRE = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references to environment variables in the
+// content of a configuration file, and $$ with $. Variables that are not set
+// are an error, as an empty secret or endpoint would only fail at probe
+// time.
+func expandEnv(content []byte) ([]byte, error) {
+	var err error
+	expanded := envReferenceRE.ReplaceAllFunc(content, func(match []byte) []byte {
+		if string(match) == "$$" {
+			return []byte("$")
+		}
+		name := string(match[2 : len(match)-1])
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %q is not set", name)
+		}
+		return []byte(value)
+	})
+	return expanded, err
+}
+
+// escapeObjectPath escapes all but the unreserved characters of a path as
+// required for signing requests to object storages.
+func escapeObjectPath(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || strings.IndexByte("-._~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// newConfigRequest creates the request to fetch a configuration file from a
+// URL or an object storage.
+func newConfigRequest(confFile string) (*http.Request, error) {
+	switch {
+	case strings.HasPrefix(confFile, "s3://"):
+		return newS3ConfigRequest(confFile)
+	case strings.HasPrefix(confFile, "gs://"):
+		return newGCSConfigRequest(confFile)
+	}
+	return http.NewRequest(http.MethodGet, confFile, nil)
+}
+
+// verifyConfigChecksum verifies the content of an object against the
+// checksums the object storage returned.
+func verifyConfigChecksum(confFile string, header http.Header, content []byte) error {
+	switch {
+	case strings.HasPrefix(confFile, "s3://"):
+		return verifyS3Checksum(header, content)
+	case strings.HasPrefix(confFile, "gs://"):
+		return verifyGCSChecksum(header, content)
+	}
+	return nil
+}
+
+// readConfig reads the configuration file at a path, URL or in an object
+// storage or key-value store. If the file was
+// fetched before with the previous version and did not change since,
+// errConfigNotModified is returned.
+func readConfig(confFile string, previous configVersion) ([]byte, configVersion, error) {
+	if source, ok := parseKVSource(confFile); ok {
+		content, revision, err := source.read(context.Background())
+		if err != nil {
+			return nil, configVersion{}, fmt.Errorf("error reading config file: %s", err)
+		}
+		if revision == previous.revision {
+			return nil, previous, errConfigNotModified
+		}
+		return content, configVersion{revision: revision}, nil
+	}
+	if !IsRemote(confFile) {
+		content, err := os.ReadFile(confFile)
+		if err != nil {
+			return nil, configVersion{}, fmt.Errorf("error reading config file: %s", err)
+		}
+		return content, configVersion{}, nil
+	}
+
+	request, err := newConfigRequest(confFile)
+	if err != nil {
+		return nil, configVersion{}, fmt.Errorf("error reading config file: %s", err)
+	}
+	if previous.etag != "" {
+		request.Header.Set("If-None-Match", previous.etag)
+	}
+	if previous.lastModified != "" {
+		request.Header.Set("If-Modified-Since", previous.lastModified)
+	}
+	resp, err := configHTTPClient.Do(request)
+	if err != nil {
+		return nil, configVersion{}, fmt.Errorf("error reading config file: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, previous, errConfigNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, configVersion{}, fmt.Errorf("error reading config file: unexpected status %s", resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, configVersion{}, fmt.Errorf("error reading config file: %s", err)
+	}
+	if len(content) > maxRemoteConfigSize {
+		return nil, configVersion{}, errors.New("error reading config file: file too large")
+	}
+	if err := verifyConfigChecksum(confFile, resp.Header, content); err != nil {
+		return nil, configVersion{}, fmt.Errorf("error reading config file: %s", err)
+	}
+	return content, configVersion{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}, nil
+}
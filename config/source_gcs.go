@@ -0,0 +1,171 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+// gcsReadOnlyScope is the OAuth 2.0 scope to read objects.
+const gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsTokenSource caches the token source of the service account key in
+// GOOGLE_APPLICATION_CREDENTIALS, so tokens are reused until they expire.
+var gcsTokenSource struct {
+	sync.Mutex
+	path string
+	oauth2.TokenSource
+}
+
+// gcsToken returns an access token for the service account key in
+// GOOGLE_APPLICATION_CREDENTIALS or else for the service account of the
+// Compute Engine instance.
+func gcsToken() (string, error) {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		gcsTokenSource.Lock()
+		defer gcsTokenSource.Unlock()
+		if gcsTokenSource.path != path {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("error reading Google credentials: %s", err)
+			}
+			var key struct {
+				Type         string `json:"type"`
+				ClientEmail  string `json:"client_email"`
+				PrivateKeyID string `json:"private_key_id"`
+				PrivateKey   string `json:"private_key"`
+				TokenURI     string `json:"token_uri"`
+			}
+			if err := json.Unmarshal(content, &key); err != nil {
+				return "", fmt.Errorf("error decoding Google credentials: %s", err)
+			}
+			if key.Type != "service_account" {
+				return "", fmt.Errorf("unsupported Google credentials type %q, must be service_account", key.Type)
+			}
+			conf := &jwt.Config{
+				Email:        key.ClientEmail,
+				PrivateKey:   []byte(key.PrivateKey),
+				PrivateKeyID: key.PrivateKeyID,
+				TokenURL:     key.TokenURI,
+				Scopes:       []string{gcsReadOnlyScope},
+			}
+			gcsTokenSource.path, gcsTokenSource.TokenSource = path, conf.TokenSource(context.Background())
+		}
+		token, err := gcsTokenSource.Token()
+		if err != nil {
+			return "", err
+		}
+		return token.AccessToken, nil
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	request, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token?scopes="+url.QueryEscape(gcsReadOnlyScope), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+	body, err := metadataRequest(request)
+	if err != nil {
+		return "", fmt.Errorf("no Google credentials in the environment and instance metadata unavailable: %s", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("error decoding instance access token: %s", err)
+	}
+	return token.AccessToken, nil
+}
+
+// newGCSConfigRequest creates an authorized request for an object given as
+// gs://<bucket>/<object>. Requests to the emulator in STORAGE_EMULATOR_HOST
+// are not authorized.
+func newGCSConfigRequest(confFile string) (*http.Request, error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(confFile, "gs://"), "/")
+	if !ok || bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid GCS URL %q, must be gs://<bucket>/<object>", confFile)
+	}
+	objectURL := &url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + bucket + "/" + object}
+	emulator := os.Getenv("STORAGE_EMULATOR_HOST")
+	if emulator != "" {
+		if !strings.Contains(emulator, "://") {
+			emulator = "http://" + emulator
+		}
+		u, err := url.Parse(emulator)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GCS emulator: %s", err)
+		}
+		objectURL.Scheme, objectURL.Host = u.Scheme, u.Host
+	}
+	objectURL.RawPath = escapeObjectPath(objectURL.Path)
+
+	request, err := http.NewRequest(http.MethodGet, objectURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if emulator == "" {
+		token, err := gcsToken()
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return request, nil
+}
+
+// verifyGCSChecksum verifies the content of an object against the hashes in
+// the x-goog-hash header. Composite objects only have a CRC32C checksum.
+func verifyGCSChecksum(header http.Header, content []byte) error {
+	for _, value := range header.Values("X-Goog-Hash") {
+		for _, hash := range strings.Split(value, ",") {
+			name, encoded, _ := strings.Cut(strings.TrimSpace(hash), "=")
+			expected, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return fmt.Errorf("invalid %s checksum: %s", name, err)
+			}
+			var actual []byte
+			switch name {
+			case "md5":
+				sum := md5.Sum(content)
+				actual = sum[:]
+			case "crc32c":
+				actual = binary.BigEndian.AppendUint32(nil, crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)))
+			default:
+				continue
+			}
+			if string(actual) != string(expected) {
+				return errors.New(strings.ToUpper(name) + " checksum mismatch")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// kvWatchTimeout limits how long a watch waits for a change before it is
+// renewed.
+const kvWatchTimeout = 5 * time.Minute
+
+// watchHTTPClient has no timeout, as watches are bounded by their context.
+var watchHTTPClient = &http.Client{}
+
+// kvSource is a key in Consul or etcd given as consul://<host>/<key> or
+// etcd://<host>/<key>, with "+https" appended to the scheme to use TLS.
+type kvSource struct {
+	store   string
+	baseURL string
+	key     string
+}
+
+// IsWatched reports whether the configuration file is read from a key-value
+// store, so it has to be watched for changes.
+func IsWatched(confFile string) bool {
+	_, ok := parseKVSource(confFile)
+	return ok
+}
+
+func parseKVSource(confFile string) (kvSource, bool) {
+	for _, store := range []string{"consul", "etcd"} {
+		for _, scheme := range []string{"http", "https"} {
+			prefix := store + "://"
+			if scheme == "https" {
+				prefix = store + "+https://"
+			}
+			if rest, ok := strings.CutPrefix(confFile, prefix); ok {
+				host, key, _ := strings.Cut(rest, "/")
+				return kvSource{store: store, baseURL: scheme + "://" + host, key: key}, true
+			}
+		}
+	}
+	return kvSource{}, false
+}
+
+// do sends a request to the key-value store and returns the response if its
+// status is 200 OK.
+func (s kvSource) do(ctx context.Context, client *http.Client, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	switch s.store {
+	case "consul":
+		if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+			request.Header.Set("X-Consul-Token", token)
+		}
+	case "etcd":
+		if user := os.Getenv("ETCDCTL_USER"); user != "" && path != "/v3/auth/authenticate" {
+			token, err := s.etcdAuthenticate(ctx, user)
+			if err != nil {
+				return nil, err
+			}
+			request.Header.Set("Authorization", token)
+		}
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("key %q not found", s.key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// etcdAuthenticate returns a token for the credentials given as
+// <username>:<password> like to etcdctl.
+func (s kvSource) etcdAuthenticate(ctx context.Context, user string) (string, error) {
+	name, password, _ := strings.Cut(user, ":")
+	resp, err := s.do(ctx, configHTTPClient, http.MethodPost, "/v3/auth/authenticate", map[string]string{"name": name, "password": password})
+	if err != nil {
+		return "", fmt.Errorf("error authenticating with etcd: %s", err)
+	}
+	defer resp.Body.Close()
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return "", fmt.Errorf("error authenticating with etcd: %s", err)
+	}
+	return auth.Token, nil
+}
+
+// etcdKeyValue is a key-value pair in the responses of the gRPC gateway of
+// etcd, which encodes bytes in base64 and 64 bit integers as strings.
+type etcdKeyValue struct {
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+// read reads the value of the key and the revision it was last modified at.
+func (s kvSource) read(ctx context.Context) ([]byte, int64, error) {
+	if s.store == "consul" {
+		resp, err := s.do(ctx, configHTTPClient, http.MethodGet, "/v1/kv/"+s.key+"?raw", nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer resp.Body.Close()
+		index, err := strconv.ParseInt(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid X-Consul-Index: %s", err)
+		}
+		content, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize))
+		return content, index, err
+	}
+
+	resp, err := s.do(ctx, configHTTPClient, http.MethodPost, "/v3/kv/range", map[string][]byte{"key": []byte(s.key)})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		KVs []etcdKeyValue `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	if len(result.KVs) == 0 {
+		return nil, 0, fmt.Errorf("key %q not found", s.key)
+	}
+	return result.KVs[0].Value, result.KVs[0].ModRevision, nil
+}
+
+// wait waits until the key is modified after the revision, or the watch
+// timeout elapses, and returns the revision the key was last modified at.
+func (s kvSource) wait(ctx context.Context, revision int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, kvWatchTimeout)
+	defer cancel()
+
+	if s.store == "consul" {
+		// A blocking query returns once the index exceeds the given one.
+		query := url.Values{"index": {strconv.FormatInt(revision, 10)}, "wait": {"4m"}}
+		resp, err := s.do(ctx, watchHTTPClient, http.MethodGet, "/v1/kv/"+s.key+"?"+query.Encode(), nil)
+		if err != nil {
+			return revision, err
+		}
+		defer resp.Body.Close()
+		index, err := strconv.ParseInt(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if err != nil {
+			return revision, fmt.Errorf("invalid X-Consul-Index: %s", err)
+		}
+		return index, nil
+	}
+
+	// The watch stream sends one response when the watch is created and
+	// then one for each batch of events.
+	request := map[string]interface{}{
+		"create_request": map[string]interface{}{"key": []byte(s.key), "start_revision": strconv.FormatInt(revision+1, 10)},
+	}
+	resp, err := s.do(ctx, watchHTTPClient, http.MethodPost, "/v3/watch", request)
+	if err != nil {
+		return revision, err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var response struct {
+			Result struct {
+				Events []struct {
+					KV etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}
+		if err := decoder.Decode(&response); err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return revision, nil
+			}
+			return revision, err
+		}
+		for _, event := range response.Result.Events {
+			revision = event.KV.ModRevision
+		}
+		if len(response.Result.Events) > 0 {
+			return revision, nil
+		}
+	}
+}
+
+// WatchConfig watches a configuration file in a key-value store and reloads
+// it whenever the key is modified, until the context is canceled.
+func (sc *SafeConfig) WatchConfig(ctx context.Context, confFile string, logger log.Logger) {
+	source, ok := parseKVSource(confFile)
+	if !ok {
+		return
+	}
+	sc.RLock()
+	revision := sc.version.revision
+	sc.RUnlock()
+	for {
+		// The revision is advanced even if the reload fails, so that an
+		// invalid configuration is not reloaded until it is modified again.
+		newRevision, err := source.wait(ctx, revision)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "Error watching config", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Second):
+			}
+			continue
+		}
+		if newRevision == revision {
+			continue
+		}
+		revision = newRevision
+		if err := sc.ReloadConfig(confFile, logger); err != nil {
+			level.Error(logger).Log("msg", "Error reloading config", "err", err)
+			continue
+		}
+		level.Info(logger).Log("msg", "Reloaded config file", "revision", revision)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadSHA256 is the SHA-256 hash of the empty body of a GET request.
+const emptyPayloadSHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ec2MetadataURL is the instance metadata service of EC2.
+var ec2MetadataURL = "http://169.254.169.254"
+
+// s3Credentials are the credentials S3 requests are signed with.
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// s3CredentialsFromEnvironment returns the credentials set in the
+// environment variables of the AWS CLI or else those of the IAM role of the
+// EC2 instance.
+func s3CredentialsFromEnvironment() (s3Credentials, error) {
+	if accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID"); accessKeyID != "" {
+		return s3Credentials{
+			accessKeyID:     accessKeyID,
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	// The instance metadata service is used with a session token (IMDSv2).
+	request, err := http.NewRequest(http.MethodPut, ec2MetadataURL+"/latest/api/token", nil)
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	request.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "60")
+	token, err := metadataRequest(request)
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("no AWS credentials in the environment and instance metadata unavailable: %s", err)
+	}
+	get := func(path string) ([]byte, error) {
+		request, err := http.NewRequest(http.MethodGet, ec2MetadataURL+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		return metadataRequest(request)
+	}
+	role, err := get("")
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("error reading IAM role of instance: %s", err)
+	}
+	body, err := get(strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("error reading credentials of IAM role: %s", err)
+	}
+	var credentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal(body, &credentials); err != nil {
+		return s3Credentials{}, fmt.Errorf("error decoding credentials of IAM role: %s", err)
+	}
+	return s3Credentials{accessKeyID: credentials.AccessKeyID, secretAccessKey: credentials.SecretAccessKey, sessionToken: credentials.Token}, nil
+}
+
+// newS3ConfigRequest creates a signed request for an object given as
+// s3://<bucket>/<key>. The region is taken from AWS_REGION and the endpoint
+// may be overridden with AWS_ENDPOINT_URL_S3, e.g. for MinIO.
+func newS3ConfigRequest(confFile string) (*http.Request, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(confFile, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, must be s3://<bucket>/<key>", confFile)
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	objectURL := &url.URL{Scheme: "https", Path: "/" + bucket + "/" + key}
+	switch {
+	case endpoint != "":
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %s", err)
+		}
+		objectURL.Scheme, objectURL.Host = u.Scheme, u.Host
+	case strings.Contains(bucket, "."):
+		// The wildcard certificate doesn't cover bucket names with dots
+		// in virtual-hosted-style requests.
+		objectURL.Host = "s3." + region + ".amazonaws.com"
+	default:
+		objectURL.Host = bucket + ".s3." + region + ".amazonaws.com"
+		objectURL.Path = "/" + key
+	}
+	objectURL.RawPath = escapeObjectPath(objectURL.Path)
+
+	credentials, err := s3CredentialsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodGet, objectURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The checksum is only returned if the object was uploaded with one.
+	request.Header.Set("X-Amz-Checksum-Mode", "ENABLED")
+	signS3Request(request, credentials, region, time.Now())
+	return request, nil
+}
+
+// signS3Request signs a request with AWS Signature Version 4, covering the
+// host and all headers set on the request so far.
+func signS3Request(r *http.Request, credentials s3Credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", emptyPayloadSHA256)
+	if credentials.sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	headers := map[string]string{"host": r.URL.Host}
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery, canonicalHeaders.String(), signedHeaders, emptyPayloadSHA256}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + credentials.secretAccessKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x", credentials.accessKeyID, scope, signedHeaders, key))
+}
+
+// verifyS3Checksum verifies the content of an object against the SHA-256
+// checksum it was uploaded with or else the MD5 hash in its ETag.
+func verifyS3Checksum(header http.Header, content []byte) error {
+	if checksum := header.Get("X-Amz-Checksum-Sha256"); checksum != "" {
+		hash := sha256.Sum256(content)
+		if base64.StdEncoding.EncodeToString(hash[:]) != checksum {
+			return errors.New("SHA-256 checksum mismatch")
+		}
+		return nil
+	}
+	// The ETag is no MD5 hash for multipart uploads, which contain a dash,
+	// and objects encrypted with KMS keys.
+	etag := strings.Trim(header.Get("ETag"), `"`)
+	if _, err := hex.DecodeString(etag); err != nil || len(etag) != 2*md5.Size || header.Get("X-Amz-Server-Side-Encryption") == "aws:kms" {
+		return nil
+	}
+	hash := md5.Sum(content)
+	if hex.EncodeToString(hash[:]) != etag {
+		return errors.New("MD5 checksum mismatch")
+	}
+	return nil
+}
+
+// metadataRequest sends a request to a metadata service of a cloud provider
+// and returns the body of the response.
+func metadataRequest(request *http.Request) ([]byte, error) {
+	resp, err := metadataHTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
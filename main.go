@@ -14,9 +14,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
+	"math"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
@@ -24,11 +27,15 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
@@ -43,28 +50,41 @@ import (
 
 	"github.com/prometheus/blackbox_exporter/config"
 	"github.com/prometheus/blackbox_exporter/prober"
+	"github.com/prometheus/blackbox_exporter/remotewrite"
+	"github.com/prometheus/blackbox_exporter/scheduler"
 )
 
 var (
 	sc = config.NewSafeConfig(prometheus.DefaultRegisterer)
 
-	configFile     = kingpin.Flag("config.file", "Blackbox exporter configuration file.").Default("blackbox.yml").String()
-	timeoutOffset  = kingpin.Flag("timeout-offset", "Offset to subtract from timeout in seconds.").Default("0.5").Float64()
-	configCheck    = kingpin.Flag("config.check", "If true validate the config file and then exit.").Default().Bool()
-	logLevelProber = kingpin.Flag("log.prober", "Log level from probe requests. One of: [debug, info, warn, error, none]").Default("none").String()
-	historyLimit   = kingpin.Flag("history.limit", "The maximum amount of items to keep in the history.").Default("100").Uint()
-	externalURL    = kingpin.Flag("web.external-url", "The URL under which Blackbox exporter is externally reachable (for example, if Blackbox exporter is served via a reverse proxy). Used for generating relative and absolute links back to Blackbox exporter itself. If the URL has a path portion, it will be used to prefix all HTTP endpoints served by Blackbox exporter. If omitted, relevant URL components will be derived automatically.").PlaceHolder("<url>").String()
-	routePrefix    = kingpin.Flag("web.route-prefix", "Prefix for the internal routes of web endpoints. Defaults to path of --web.external-url.").PlaceHolder("<path>").String()
-	toolkitFlags   = webflag.AddFlags(kingpin.CommandLine, ":9115")
+	configFile       = kingpin.Flag("config.file", "Blackbox exporter configuration file.").Default("blackbox.yml").String()
+	timeoutOffset    = kingpin.Flag("timeout-offset", "Offset to subtract from timeout in seconds.").Default("0.5").Float64()
+	configCheck      = kingpin.Flag("config.check", "If true validate the config file and then exit.").Default().Bool()
+	configExpand     = kingpin.Flag("config.expand-env", "Expand ${VAR} references to environment variables in the config file. $$ is replaced with $.").Default().Bool()
+	configPoll       = kingpin.Flag("config.poll-interval", "Interval to poll a config file fetched from a URL, S3 or GCS for changes. 0 disables polling.").Default("1m").Duration()
+	logLevelProber   = kingpin.Flag("log.prober", "Log level from probe requests. One of: [debug, info, warn, error, none]").Default("none").String()
+	historyLimit     = kingpin.Flag("history.limit", "The maximum amount of items to keep in the history.").Default("100").Uint()
+	probeRate        = kingpin.Flag("probe.rate-limit", "Maximum number of /probe requests per second, further requests are rejected with 429. 0 disables the limit.").Default("0").Float64()
+	probeInFlight    = kingpin.Flag("probe.max-in-flight", "Maximum number of concurrent /probe requests, further requests are rejected with 503. 0 disables the limit.").Default("0").Int()
+	shutdownTimeout  = kingpin.Flag("web.shutdown-timeout", "Maximum time to wait for probes in flight to finish and queued remote write samples to be sent on SIGTERM. A second signal exits immediately.").Default("2m").Duration()
+	batchConcurrency = kingpin.Flag("probe.batch-concurrency", "Maximum number of targets of a /probe_batch request that are probed concurrently.").Default("20").Int()
+	externalURL      = kingpin.Flag("web.external-url", "The URL under which Blackbox exporter is externally reachable (for example, if Blackbox exporter is served via a reverse proxy). Used for generating relative and absolute links back to Blackbox exporter itself. If the URL has a path portion, it will be used to prefix all HTTP endpoints served by Blackbox exporter. If omitted, relevant URL components will be derived automatically.").PlaceHolder("<url>").String()
+	routePrefix      = kingpin.Flag("web.route-prefix", "Prefix for the internal routes of web endpoints. Defaults to path of --web.external-url.").PlaceHolder("<path>").String()
+	toolkitFlags     = webflag.AddFlags(kingpin.CommandLine, ":9115")
 
 	moduleUnknownCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "blackbox_module_unknown_total",
 		Help: "Count of unknown modules requested by probes",
 	})
+	probesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackbox_probes_rejected_total",
+		Help: "Count of probe requests rejected by --probe.rate-limit or --probe.max-in-flight",
+	}, []string{"reason"})
 )
 
 func init() {
 	prometheus.MustRegister(versioncollector.NewCollector("blackbox_exporter"))
+	prometheus.MustRegister(prober.ModuleCollectors()...)
 }
 
 func main() {
@@ -87,8 +107,16 @@ func run() int {
 	level.Info(logger).Log("msg", "Starting blackbox_exporter", "version", version.Info())
 	level.Info(logger).Log("build_context", version.BuildContext())
 
+	sc.ExpandEnv = *configExpand
 	if err := sc.ReloadConfig(*configFile, logger); err != nil {
 		level.Error(logger).Log("msg", "Error loading config", "err", err)
+		if *configCheck {
+			// Report all invalid modules, so they can be fixed at once.
+			moduleErrs, _ := sc.CheckModules(*configFile)
+			for _, moduleErr := range moduleErrs {
+				level.Error(logger).Log("msg", "Invalid module", "module", moduleErr.Module, "line", moduleErr.Line, "err", moduleErr.Err)
+			}
+		}
 		return 1
 	}
 
@@ -131,9 +159,48 @@ func run() int {
 	hup := make(chan os.Signal, 1)
 	reloadCh := make(chan chan error)
 	signal.Notify(hup, syscall.SIGHUP)
+	// A nil channel never delivers, which disables polling.
+	var poll <-chan time.Time
+	if config.IsRemote(*configFile) && *configPoll > 0 {
+		ticker := time.NewTicker(*configPoll)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if config.IsWatched(*configFile) {
+		go sc.WatchConfig(ctx, *configFile, logger)
+	}
+	rwQueue := remotewrite.NewQueue(func() *config.RemoteWriteConfig {
+		sc.RLock()
+		defer sc.RUnlock()
+		return sc.C.RemoteWrite
+	}, log.With(logger, "component", "remote_write"), prometheus.DefaultRegisterer)
+	// The queue is stopped on shutdown to flush it.
+	queueCtx, stopQueue := context.WithCancel(ctx)
+	queueDone := make(chan struct{})
+	go func() {
+		rwQueue.Run(queueCtx)
+		close(queueDone)
+	}()
+	flushQueue := func(ctx context.Context) error {
+		stopQueue()
+		<-queueDone
+		return rwQueue.Flush(ctx)
+	}
+	sched := scheduler.New(func() *config.Config {
+		sc.RLock()
+		defer sc.RUnlock()
+		return sc.C
+	}, log.With(logger, "component", "scheduler"), rh, *timeoutOffset, logLevelProber, rwQueue)
+	go sched.Run(ctx)
 	go func() {
 		for {
 			select {
+			case <-poll:
+				if err := sc.ReloadConfig(*configFile, logger); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+				}
 			case <-hup:
 				if err := sc.ReloadConfig(*configFile, logger); err != nil {
 					level.Error(logger).Log("msg", "Error reloading config", "err", err)
@@ -178,17 +245,45 @@ func run() int {
 				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
 			}
 		})
-	http.Handle(path.Join(*routePrefix, "/metrics"), promhttp.Handler())
+	// The latest results of the scheduled probes are exposed with the metrics
+	// of the exporter.
+	http.Handle(path.Join(*routePrefix, "/metrics"), promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, sched}, promhttp.HandlerOpts{}),
+	))
 	http.HandleFunc(path.Join(*routePrefix, "/-/healthy"), func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Healthy"))
 	})
-	http.HandleFunc(path.Join(*routePrefix, "/probe"), func(w http.ResponseWriter, r *http.Request) {
-		sc.Lock()
-		conf := sc.C
-		sc.Unlock()
-		prober.Handler(w, r, conf, logger, rh, *timeoutOffset, nil, moduleUnknownCounter, logLevelProber)
-	})
+	limiter := newProbeLimiter(*probeRate, *probeInFlight)
+	cache := prober.NewResultCache(prometheus.DefaultRegisterer)
+	// limited rejects requests beyond the rate and in-flight limits. A batch
+	// counts as a single request.
+	limited := func(next func(w http.ResponseWriter, r *http.Request, conf *config.Config)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			release, status := limiter.acquire(time.Now())
+			if status != http.StatusOK {
+				reason := "rate_limit"
+				if status == http.StatusServiceUnavailable {
+					reason = "max_in_flight"
+				}
+				probesRejected.WithLabelValues(reason).Inc()
+				http.Error(w, fmt.Sprintf("Too many probe requests (%s)", reason), status)
+				return
+			}
+			defer release()
+			sc.RLock()
+			conf := sc.C
+			sc.RUnlock()
+			next(w, r, conf)
+		}
+	}
+	http.HandleFunc(path.Join(*routePrefix, "/probe"), limited(func(w http.ResponseWriter, r *http.Request, conf *config.Config) {
+		prober.Handler(w, r, conf, logger, rh, *timeoutOffset, nil, moduleUnknownCounter, logLevelProber, rwQueue, cache)
+	}))
+	http.HandleFunc(path.Join(*routePrefix, "/probe_batch"), limited(func(w http.ResponseWriter, r *http.Request, conf *config.Config) {
+		prober.BatchHandler(w, r, conf, logger, rh, *timeoutOffset, *batchConcurrency, moduleUnknownCounter, logLevelProber, rwQueue)
+	}))
 	http.HandleFunc(*routePrefix, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		w.Write([]byte(`<html>
@@ -199,8 +294,10 @@ func run() int {
     <p><a href="probe?target=prometheus.io&module=http_2xx&debug=true">Debug probe prometheus.io for http_2xx</a></p>
     <p><a href="metrics">Metrics</a></p>
     <p><a href="config">Configuration</a></p>
+    <p><a href="modules">Modules</a></p>
+    <p><a href="history">History</a></p>
     <h2>Recent Probes</h2>
-    <table border='1'><tr><th>Module</th><th>Target</th><th>Result</th><th>Debug</th>`))
+    <table border='1'><tr><th>Module</th><th>Target</th><th>Result</th><th>Duration</th><th>Time</th><th>Debug</th>`))
 
 		results := rh.List()
 
@@ -210,8 +307,9 @@ func run() int {
 			if !r.Success {
 				success = "<strong>Failure</strong>"
 			}
-			fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td><a href='logs?id=%d'>Logs</a></td></td>",
-				html.EscapeString(r.ModuleName), html.EscapeString(r.Target), success, r.Id)
+			fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td><a href='logs?id=%d'>Logs</a></td></tr>",
+				html.EscapeString(r.ModuleName), html.EscapeString(r.Target), success,
+				r.Duration.Round(time.Millisecond), r.Timestamp.UTC().Format(time.RFC3339), r.Id)
 		}
 
 		w.Write([]byte(`</table></body>
@@ -264,13 +362,30 @@ func run() int {
 		w.Write(c)
 	})
 
+	http.HandleFunc(path.Join(*routePrefix, "/history"), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(listHistory(rh.List())); err != nil {
+			level.Warn(logger).Log("msg", "Error encoding history", "err", err)
+		}
+	})
+
+	http.HandleFunc(path.Join(*routePrefix, "/modules"), func(w http.ResponseWriter, r *http.Request) {
+		sc.RLock()
+		modules := listModules(sc.C)
+		sc.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(modules); err != nil {
+			level.Warn(logger).Log("msg", "Error encoding modules", "err", err)
+		}
+	})
+
 	srv := &http.Server{}
 	srvc := make(chan struct{})
 	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		if err := web.ListenAndServe(srv, toolkitFlags, logger); err != nil {
+		if err := web.ListenAndServe(srv, toolkitFlags, logger); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			level.Error(logger).Log("msg", "Error starting HTTP server", "err", err)
 			close(srvc)
 		}
@@ -279,7 +394,10 @@ func run() int {
 	for {
 		select {
 		case <-term:
-			level.Info(logger).Log("msg", "Received SIGTERM, exiting gracefully...")
+			level.Info(logger).Log("msg", "Received SIGTERM, exiting gracefully...", "timeout", *shutdownTimeout)
+			if err := drain(srv, flushQueue, *shutdownTimeout, term); err != nil {
+				level.Warn(logger).Log("msg", "Shutdown did not finish", "err", err)
+			}
 			return 0
 		case <-srvc:
 			return 1
@@ -288,6 +406,131 @@ func run() int {
 
 }
 
+// drain stops the server from accepting requests and waits for the requests
+// in flight to finish, so that probes are not cut off and reported as failed.
+// The results still queued for remote write are then sent with flush. It
+// stops waiting after the timeout or when another signal is received.
+func drain(srv *http.Server, flush func(context.Context) error, timeout time.Duration, term <-chan os.Signal) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-term:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("requests in flight did not finish: %w", err)
+	}
+	if err := flush(ctx); err != nil {
+		return fmt.Errorf("error sending queued remote write samples: %w", err)
+	}
+	return nil
+}
+
+// probeLimiter limits the rate of probe requests with a token bucket that
+// holds one second of requests, and the number of requests in flight.
+type probeLimiter struct {
+	mtx    sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	// inFlight is nil if the number of requests in flight is not limited.
+	inFlight chan struct{}
+}
+
+func newProbeLimiter(rate float64, maxInFlight int) *probeLimiter {
+	l := &probeLimiter{rate: rate, tokens: math.Max(rate, 1)}
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// acquire returns http.StatusOK and a function to call when the request is
+// done if the request is within the limits, or else the status code to reject
+// it with.
+func (l *probeLimiter) acquire(now time.Time) (func(), int) {
+	if l.rate > 0 {
+		l.mtx.Lock()
+		if !l.last.IsZero() {
+			l.tokens = math.Min(l.tokens+now.Sub(l.last).Seconds()*l.rate, math.Max(l.rate, 1))
+		}
+		l.last = now
+		ok := l.tokens >= 1
+		if ok {
+			l.tokens--
+		}
+		l.mtx.Unlock()
+		if !ok {
+			return nil, http.StatusTooManyRequests
+		}
+	}
+	if l.inFlight == nil {
+		return func() {}, http.StatusOK
+	}
+	select {
+	case l.inFlight <- struct{}{}:
+		return func() { <-l.inFlight }, http.StatusOK
+	default:
+		return nil, http.StatusServiceUnavailable
+	}
+}
+
+// historyEntry describes a recent probe on the /history endpoint.
+type historyEntry struct {
+	ID              int64     `json:"id"`
+	Module          string    `json:"module"`
+	Target          string    `json:"target"`
+	Success         bool      `json:"success"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Timestamp       time.Time `json:"timestamp"`
+	// Logs is the path of the page with the debug output of the probe,
+	// relative to the /history endpoint.
+	Logs string `json:"logs"`
+}
+
+// listHistory returns the results of recent probes, newest first.
+func listHistory(results []*prober.Result) []historyEntry {
+	entries := make([]historyEntry, 0, len(results))
+	for i := len(results) - 1; i >= 0; i-- {
+		r := results[i]
+		entries = append(entries, historyEntry{
+			ID:              r.Id,
+			Module:          r.ModuleName,
+			Target:          r.Target,
+			Success:         r.Success,
+			DurationSeconds: r.Duration.Seconds(),
+			Timestamp:       r.Timestamp,
+			Logs:            fmt.Sprintf("logs?id=%d", r.Id),
+		})
+	}
+	return entries
+}
+
+// moduleInfo describes a module on the /modules endpoint.
+type moduleInfo struct {
+	Name   string `json:"name"`
+	Prober string `json:"prober"`
+	// Timeout is empty if the timeout is derived from the scrape timeout.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// listModules returns the modules of the configuration sorted by name.
+func listModules(c *config.Config) []moduleInfo {
+	modules := make([]moduleInfo, 0, len(c.Modules))
+	for name, module := range c.Modules {
+		info := moduleInfo{Name: name, Prober: module.Prober}
+		if module.Timeout > 0 {
+			info.Timeout = module.Timeout.String()
+		}
+		modules = append(modules, info)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Name < modules[j].Name })
+	return modules
+}
+
 func startsOrEndsWithQuote(s string) bool {
 	return strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") ||
 		strings.HasSuffix(s, "\"") || strings.HasSuffix(s, "'")
@@ -13,7 +13,18 @@
 
 package main
 
-import "testing"
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/blackbox_exporter/config"
+	"github.com/prometheus/blackbox_exporter/prober"
+)
 
 func TestComputeExternalURL(t *testing.T) {
 	tests := []struct {
@@ -67,3 +78,129 @@ func TestComputeExternalURL(t *testing.T) {
 		}
 	}
 }
+
+func TestListModules(t *testing.T) {
+	c := &config.Config{Modules: map[string]config.Module{
+		"tcp_connect": {Prober: "tcp"},
+		"http_2xx":    {Prober: "http", Timeout: 5 * time.Second},
+	}}
+	expected := []moduleInfo{
+		{Name: "http_2xx", Prober: "http", Timeout: "5s"},
+		{Name: "tcp_connect", Prober: "tcp"},
+	}
+	if modules := listModules(c); !reflect.DeepEqual(modules, expected) {
+		t.Fatalf("Unexpected modules %+v", modules)
+	}
+}
+
+func TestProbeLimiter(t *testing.T) {
+	now := time.Now()
+	l := newProbeLimiter(2, 0)
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if _, status := l.acquire(now); status != expected {
+			t.Fatalf("Request %d: expected status %d, got %d", i, expected, status)
+		}
+	}
+	// A token is added every half second.
+	if _, status := l.acquire(now.Add(500 * time.Millisecond)); status != http.StatusOK {
+		t.Fatalf("Expected a token after half a second, got status %d", status)
+	}
+
+	l = newProbeLimiter(0, 1)
+	release, status := l.acquire(now)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+	}
+	if _, status := l.acquire(now); status != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, status)
+	}
+	release()
+	if _, status := l.acquire(now); status != http.StatusOK {
+		t.Fatalf("Expected status %d after release, got %d", http.StatusOK, status)
+	}
+}
+
+func TestListHistory(t *testing.T) {
+	rh := &prober.ResultHistory{MaxResults: 2}
+	rh.Add("http_2xx", "https://example.com", "logs", true, 1500*time.Millisecond)
+	rh.Add("tcp_connect", "example.com:22", "logs", false, time.Second)
+	entries := listHistory(rh.List())
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	if e := entries[0]; e.ID != 1 || e.Module != "tcp_connect" || e.Success || e.DurationSeconds != 1 || e.Logs != "logs?id=1" {
+		t.Errorf("Unexpected newest entry %+v", e)
+	}
+	if e := entries[1]; e.ID != 0 || e.Target != "https://example.com" || !e.Success || e.DurationSeconds != 1.5 || e.Timestamp.IsZero() {
+		t.Errorf("Unexpected oldest entry %+v", e)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+	})}
+	go srv.Serve(ln)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Error(err)
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	// The request in flight finishes, new requests are refused.
+	flushed := false
+	flush := func(ctx context.Context) error {
+		flushed = true
+		return nil
+	}
+	if err := drain(srv, flush, time.Minute, make(chan os.Signal)); err != nil {
+		t.Fatal(err)
+	}
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("Expected the request in flight to succeed, got status %d", code)
+	}
+	if !flushed {
+		t.Error("Expected the remote write queue to be flushed")
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("Expected new requests to be refused")
+	}
+}
+
+func TestDrainSignal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	go srv.Serve(ln)
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+
+	// A second signal stops waiting.
+	term := make(chan os.Signal, 1)
+	term <- os.Interrupt
+	flush := func(ctx context.Context) error { return nil }
+	if err := drain(srv, flush, time.Minute, term); err == nil {
+		t.Fatal("Expected an error when a signal interrupts the shutdown")
+	}
+}
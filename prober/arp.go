@@ -0,0 +1,115 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	etherTypeARP = 0x0806
+	etherTypeIP  = 0x0800
+
+	arpOpRequest = 1
+	arpOpReply   = 2
+
+	// arpFrameLen is the length of an Ethernet frame carrying an ARP packet
+	// for IPv4 over Ethernet, without padding.
+	arpFrameLen = 14 + 28
+)
+
+var errARPUnsupported = errors.New("arp probes are only supported on Linux")
+
+// buildARPRequest returns an Ethernet broadcast frame asking for the hardware
+// address of dstIP.
+func buildARPRequest(srcMAC net.HardwareAddr, srcIP, dstIP net.IP) []byte {
+	b := make([]byte, arpFrameLen)
+	copy(b[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(b[6:12], srcMAC)
+	binary.BigEndian.PutUint16(b[12:14], etherTypeARP)
+
+	arp := b[14:]
+	binary.BigEndian.PutUint16(arp[0:2], 1) // Ethernet.
+	binary.BigEndian.PutUint16(arp[2:4], etherTypeIP)
+	arp[4], arp[5] = 6, 4
+	binary.BigEndian.PutUint16(arp[6:8], arpOpRequest)
+	copy(arp[8:14], srcMAC)
+	copy(arp[14:18], srcIP.To4())
+	// The target hardware address is left zero.
+	copy(arp[24:28], dstIP.To4())
+	return b
+}
+
+// parseARPReply returns the sender hardware address of the frame if it is
+// an ARP reply from dstIP.
+func parseARPReply(b []byte, dstIP net.IP) (net.HardwareAddr, bool) {
+	if len(b) < arpFrameLen || binary.BigEndian.Uint16(b[12:14]) != etherTypeARP {
+		return nil, false
+	}
+	arp := b[14:]
+	if binary.BigEndian.Uint16(arp[2:4]) != etherTypeIP || arp[4] != 6 || arp[5] != 4 {
+		return nil, false
+	}
+	if binary.BigEndian.Uint16(arp[6:8]) != arpOpReply || !bytes.Equal(arp[14:18], dstIP.To4()) {
+		return nil, false
+	}
+	return net.HardwareAddr(bytes.Clone(arp[8:14])), true
+}
+
+// ProbeARP sends an ARP request for an on-link target and waits for its
+// reply, which shows that the target is present on the network even if it
+// drops ICMP.
+func ProbeARP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeARPDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_arp_duration_seconds",
+		Help: "Time between sending the ARP request and receiving the reply",
+	})
+	probeARPReplyInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_arp_reply_info",
+		Help: "Contains the hardware address of the target",
+	}, []string{"hardware_address"})
+	registry.MustRegister(probeARPDuration, probeARPReplyInfo)
+
+	dstIPAddr, _, err := chooseProtocol(ctx, "ip4", false, target, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+	iface, srcIP, err := onLinkInterface(module.ARP.SourceInterface, dstIPAddr.IP)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error choosing interface", "err", err)
+		return false
+	}
+	level.Info(logger).Log("msg", "Sending ARP request", "interface", iface.Name, "srcIP", srcIP, "dstIP", dstIPAddr.IP)
+
+	hardwareAddr, rtt, err := arpExchange(ctx, iface, buildARPRequest(iface.HardwareAddr, srcIP, dstIPAddr.IP), dstIPAddr.IP)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error waiting for ARP reply", "err", err)
+		return false
+	}
+	level.Info(logger).Log("msg", "Received ARP reply", "hardware_address", hardwareAddr, "duration_seconds", rtt)
+	probeARPDuration.Set(rtt)
+	probeARPReplyInfo.WithLabelValues(hardwareAddr.String()).Set(1)
+	return true
+}
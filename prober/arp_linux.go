@@ -0,0 +1,82 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// htons converts a short from host to network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// arpExchange sends the request frame on a packet socket bound to iface and
+// returns the hardware address of dstIP and the time its reply took.
+func arpExchange(ctx context.Context, iface *net.Interface, request []byte, dstIP net.IP) (net.HardwareAddr, float64, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, 0, os.NewSyscallError("socket", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return nil, 0, os.NewSyscallError("bind", err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Second)
+	}
+	broadcast := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	start := time.Now()
+	if err := unix.Sendto(fd, request, 0, broadcast); err != nil {
+		return nil, 0, os.NewSyscallError("sendto", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, 0, os.ErrDeadlineExceeded
+		}
+		// A zero timeout would block forever.
+		tv := unix.NsecToTimeval(max(remaining, time.Millisecond).Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return nil, 0, os.NewSyscallError("setsockopt", err)
+		}
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, os.NewSyscallError("recvfrom", err)
+		}
+		if hardwareAddr, ok := parseARPReply(buf[:n], dstIP); ok {
+			return hardwareAddr, time.Since(start).Seconds(), nil
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package prober
+
+import (
+	"context"
+	"net"
+)
+
+// arpExchange sends the request frame on iface and returns the hardware
+// address of dstIP and the time its reply took.
+func arpExchange(ctx context.Context, iface *net.Interface, request []byte, dstIP net.IP) (net.HardwareAddr, float64, error) {
+	return nil, 0, errARPUnsupported
+}
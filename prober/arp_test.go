@@ -0,0 +1,70 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestARPFrames(t *testing.T) {
+	srcMAC := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	dstMAC := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	srcIP, dstIP := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+
+	request := buildARPRequest(srcMAC, srcIP, dstIP)
+	if _, ok := parseARPReply(request, dstIP); ok {
+		t.Fatalf("Request was parsed as a reply")
+	}
+
+	// Turn the request into the reply of the target.
+	reply := append([]byte(nil), request...)
+	copy(reply[0:6], srcMAC)
+	copy(reply[6:12], dstMAC)
+	binary.BigEndian.PutUint16(reply[20:22], arpOpReply)
+	copy(reply[22:28], dstMAC)
+	copy(reply[28:32], dstIP.To4())
+	copy(reply[32:38], srcMAC)
+	copy(reply[38:42], srcIP.To4())
+
+	hardwareAddr, ok := parseARPReply(reply, dstIP)
+	if !ok {
+		t.Fatalf("Reply was not parsed")
+	}
+	if hardwareAddr.String() != dstMAC.String() {
+		t.Fatalf("Expected hardware address %s, got %s", dstMAC, hardwareAddr)
+	}
+	if _, ok := parseARPReply(reply, srcIP); ok {
+		t.Fatalf("Reply of another address was accepted")
+	}
+	if _, ok := parseARPReply(reply[:30], dstIP); ok {
+		t.Fatalf("Truncated reply was accepted")
+	}
+}
+
+func TestARPNotOnLink(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ProbeARP(testCTX, "127.0.0.1", config.Module{}, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("ARP module succeeded for a loopback target, expected failure.")
+	}
+}
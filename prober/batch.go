@@ -0,0 +1,232 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// maxBatchBodySize limits the size of the target list in the body of a
+// /probe_batch request.
+const maxBatchBodySize = 1 << 20
+
+// Probe runs a probe with the parameters like a /probe request and returns
+// its metrics. The request provides the context and the scrape timeout. It
+// returns an error if the probe was rejected, e.g. because a target filter
+// denies the target.
+func Probe(r *http.Request, c *config.Config, logger log.Logger, rh *ResultHistory, timeoutOffset float64, params url.Values,
+	logLevelProber level.Option, pusher ResultPusher) ([]*dto.MetricFamily, error) {
+	pr, _, err := newProbeRequest(r, c, params, timeoutOffset, logger, logLevelProber)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), pr.timeout)
+	defer cancel()
+	return runProbe(ctx, c, pr, logger, rh, pusher).gatherer.Gather()
+}
+
+// LabeledMetrics are the metrics of a probe with the labels that identify it
+// among other probes.
+type LabeledMetrics struct {
+	Labels   map[string]string
+	Families []*dto.MetricFamily
+}
+
+// MergeMetrics merges the metrics of several probes into one family per
+// metric name. The labels of each probe are added to its metrics, unless a
+// metric already has them. Metrics whose type differs from that of the
+// first metric of the same name are dropped.
+func MergeMetrics(results []LabeledMetrics) []*dto.MetricFamily {
+	families := map[string]*dto.MetricFamily{}
+	for _, result := range results {
+		for _, mf := range result.Families {
+			family, ok := families[mf.GetName()]
+			if !ok {
+				family = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+				families[mf.GetName()] = family
+			}
+			if family.GetType() != mf.GetType() {
+				continue
+			}
+			for _, m := range mf.Metric {
+				family.Metric = append(family.Metric, withLabels(m, result.Labels))
+			}
+		}
+	}
+	merged := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		merged = append(merged, family)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].GetName() < merged[j].GetName() })
+	return merged
+}
+
+// withLabels returns a copy of the metric with the labels added. Labels the
+// metric already has are not overwritten.
+func withLabels(m *dto.Metric, labels map[string]string) *dto.Metric {
+	pairs := append([]*dto.LabelPair(nil), m.Label...)
+LABELS:
+	for name, value := range labels {
+		for _, l := range m.Label {
+			if l.GetName() == name {
+				continue LABELS
+			}
+		}
+		name, value := name, value
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+	return &dto.Metric{
+		Label:       pairs,
+		Gauge:       m.Gauge,
+		Counter:     m.Counter,
+		Untyped:     m.Untyped,
+		Histogram:   m.Histogram,
+		Summary:     m.Summary,
+		TimestampMs: m.TimestampMs,
+	}
+}
+
+// batchTargets returns the targets of a /probe_batch request: the target
+// parameters of the query or a form, or one target per line of a plain text
+// body.
+func batchTargets(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	targets := r.URL.Query()["target"]
+	if r.Method != http.MethodPost {
+		return targets, nil
+	}
+	body := http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		form, err := url.ParseQuery(string(content))
+		if err != nil {
+			return nil, err
+		}
+		return append(targets, form["target"]...), nil
+	}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if target := strings.TrimSpace(scanner.Text()); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// BatchHandler probes several targets with one module concurrently and
+// serves their metrics, labeled with the target, in one response. All
+// parameters except the targets apply to every probe. Targets that are
+// rejected, e.g. by a target filter, are omitted.
+func BatchHandler(w http.ResponseWriter, r *http.Request, c *config.Config, logger log.Logger, rh *ResultHistory, timeoutOffset float64,
+	concurrency int, moduleUnknownCounter prometheus.Counter, logLevelProber level.Option, pusher ResultPusher) {
+	params := r.URL.Query()
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = "http_2xx"
+	}
+	module, ok := c.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		level.Debug(logger).Log("msg", "Unknown module", "module", moduleName)
+		if moduleUnknownCounter != nil {
+			moduleUnknownCounter.Add(1)
+		}
+		return
+	}
+	targets, err := batchTargets(w, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading targets: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(targets) == 0 {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	// Probes that wait for others to finish share the timeout of the scrape.
+	timeoutSeconds, err := getTimeout(r, module, timeoutOffset)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse timeout from Prometheus header: %s", err), http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var mtx sync.Mutex
+	var results []LabeledMetrics
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < min(max(concurrency, 1), len(targets)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range queue {
+				targetParams := url.Values{}
+				for name, values := range params {
+					// The output parameters apply to the batch response.
+					if name != "debug" && name != "format" {
+						targetParams[name] = values
+					}
+				}
+				targetParams.Set("module", moduleName)
+				targetParams.Set("target", target)
+				mfs, err := Probe(r, c, logger, rh, timeoutOffset, targetParams, logLevelProber, pusher)
+				if err != nil {
+					level.Warn(logger).Log("msg", "Batch probe was rejected", "module", moduleName, "target", target, "err", err)
+					continue
+				}
+				mtx.Lock()
+				results = append(results, LabeledMetrics{Labels: map[string]string{"target": target}, Families: mfs})
+				mtx.Unlock()
+			}
+		}()
+	}
+	seen := map[string]bool{}
+	for _, target := range targets {
+		// The metrics of a target can only be exposed once.
+		if !seen[target] {
+			seen[target] = true
+			queue <- target
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return MergeMetrics(results), nil
+	})
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
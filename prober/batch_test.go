@@ -0,0 +1,155 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestBatchHandler(t *testing.T) {
+	c := &config.Config{
+		TargetFilter: config.TargetFilter{DenyHostnames: []config.Regexp{config.MustNewRegexp("^(?:denied\\.example\\.com)$")}},
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:  "http",
+				Timeout: 10 * time.Second,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	up, down := ts.URL+"/", ts.URL+"/missing"
+
+	for name, newRequest := range map[string]func() *http.Request{
+		"query": func() *http.Request {
+			params := url.Values{"target": {up, down, up, "http://denied.example.com"}}
+			return httptest.NewRequest("GET", "/probe_batch?"+params.Encode(), nil)
+		},
+		"output parameters": func() *http.Request {
+			// The probes ignore the parameters of the /probe output.
+			params := url.Values{"target": {up, down}, "debug": {"true"}, "format": {"json"}}
+			return httptest.NewRequest("GET", "/probe_batch?"+params.Encode(), nil)
+		},
+		"text body": func() *http.Request {
+			return httptest.NewRequest("POST", "/probe_batch?module=http_2xx", strings.NewReader(up+"\n\n"+down+"\n"))
+		},
+		"form body": func() *http.Request {
+			req := httptest.NewRequest("POST", "/probe_batch", strings.NewReader(url.Values{"target": {up, down}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req
+		},
+	} {
+		rr := httptest.NewRecorder()
+		BatchHandler(rr, newRequest(), c, log.NewNopLogger(), &ResultHistory{MaxResults: 10}, 0.5, 2, nil, level.AllowNone(), nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", name, rr.Code, rr.Body.String())
+		}
+		var parser expfmt.TextParser
+		mfs, err := parser.TextToMetricFamilies(rr.Body)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		success := map[string]float64{}
+		for _, m := range mfs["probe_success"].GetMetric() {
+			success[labelValue(m, "target")] = m.GetGauge().GetValue()
+		}
+		if len(success) != 2 || success[up] != 1 || success[down] != 0 {
+			t.Errorf("%s: unexpected probe_success values %v", name, success)
+		}
+	}
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestBatchHandlerErrors(t *testing.T) {
+	c := &config.Config{
+		Modules: map[string]config.Module{"http_2xx": {Prober: "http"}},
+	}
+	for _, test := range []struct {
+		url  string
+		want string
+	}{
+		{url: "/probe_batch?module=unknown&target=example.com", want: "Unknown module \"unknown\""},
+		{url: "/probe_batch?module=http_2xx", want: "Target parameter is missing"},
+	} {
+		rr := httptest.NewRecorder()
+		BatchHandler(rr, httptest.NewRequest("GET", test.url, nil), c, log.NewNopLogger(), &ResultHistory{}, 0.5, 2, nil, level.AllowNone(), nil)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), test.want) {
+			t.Errorf("Unexpected response for %s: %d %s", test.url, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestMergeMetrics(t *testing.T) {
+	name, help, gauge, counter := "probe_success", "", dto.MetricType_GAUGE, dto.MetricType_COUNTER
+	label, value := "target", "original"
+	one := 1.0
+	results := []LabeledMetrics{
+		{
+			Labels: map[string]string{"target": "a", "module": "http_2xx"},
+			Families: []*dto.MetricFamily{{Name: &name, Help: &help, Type: &gauge, Metric: []*dto.Metric{
+				{Gauge: &dto.Gauge{Value: &one}},
+			}}},
+		},
+		{
+			Labels: map[string]string{"target": "b"},
+			Families: []*dto.MetricFamily{{Name: &name, Help: &help, Type: &gauge, Metric: []*dto.Metric{
+				// Existing labels are not overwritten.
+				{Label: []*dto.LabelPair{{Name: &label, Value: &value}}, Gauge: &dto.Gauge{Value: &one}},
+			}}},
+		},
+		{
+			Labels: map[string]string{"target": "c"},
+			// Metrics of a different type are dropped.
+			Families: []*dto.MetricFamily{{Name: &name, Help: &help, Type: &counter, Metric: []*dto.Metric{
+				{Counter: &dto.Counter{Value: &one}},
+			}}},
+		},
+	}
+	merged := MergeMetrics(results)
+	if len(merged) != 1 || len(merged[0].Metric) != 2 {
+		t.Fatalf("Unexpected merged metrics %v", merged)
+	}
+	first, second := merged[0].Metric[0], merged[0].Metric[1]
+	if len(first.Label) != 2 || first.Label[0].GetName() != "module" || labelValue(first, "target") != "a" {
+		t.Errorf("Unexpected labels %v", first.Label)
+	}
+	if len(second.Label) != 1 || labelValue(second, "target") != "original" {
+		t.Errorf("Unexpected labels %v", second.Label)
+	}
+}
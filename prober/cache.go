@@ -0,0 +1,124 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// probeOutcome is the result of a probe as it is served.
+type probeOutcome struct {
+	gatherer    prometheus.Gatherer
+	success     bool
+	duration    float64
+	debugOutput string
+}
+
+type cacheEntry struct {
+	// done is closed when the probe finished.
+	done    chan struct{}
+	outcome probeOutcome
+	// completed is false if the probe panicked.
+	completed bool
+	// expires is zero while the probe is running.
+	expires time.Time
+}
+
+// ResultCache serves the result of a probe to identical /probe requests,
+// e.g. from several Prometheus servers, for the cache_ttl of the module.
+// Requests that arrive while the probe is running wait for its result.
+type ResultCache struct {
+	mtx     sync.Mutex
+	entries map[string]*cacheEntry
+	hits    *prometheus.CounterVec
+}
+
+// NewResultCache returns an empty cache whose hits are counted by a metric
+// registered with reg.
+func NewResultCache(reg prometheus.Registerer) *ResultCache {
+	return &ResultCache{
+		entries: map[string]*cacheEntry{},
+		hits: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "blackbox_probe_cache_hits_total",
+			Help: "Count of /probe requests served from the result of an identical probe",
+		}, []string{"module"}),
+	}
+}
+
+// do returns the cached outcome of the probe with the key, or runs probe
+// and caches its outcome for ttl. The probe is shared by all requests with
+// the key, so it does not run on the context of the request that started it
+// but is limited by timeout. Requests stop waiting for it when ctx is done.
+// Outcomes of probes that ran until their timeout are not cached.
+func (c *ResultCache) do(ctx context.Context, key, moduleName string, ttl, timeout time.Duration, probe func(context.Context) probeOutcome) (probeOutcome, error) {
+	now := time.Now()
+	c.mtx.Lock()
+	for k, e := range c.entries {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if e, ok := c.entries[key]; ok {
+		c.mtx.Unlock()
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return probeOutcome{}, ctx.Err()
+		}
+		if !e.completed {
+			return probeOutcome{}, errors.New("the identical probe did not complete")
+		}
+		c.hits.WithLabelValues(moduleName).Inc()
+		return e.outcome, nil
+	}
+	e := &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = e
+	c.mtx.Unlock()
+
+	probeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer func() {
+		aborted := probeCtx.Err() != nil
+		cancel()
+		c.mtx.Lock()
+		if e.completed && !aborted {
+			e.expires = time.Now().Add(ttl)
+		} else if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mtx.Unlock()
+		close(e.done)
+	}()
+	e.outcome = probe(probeCtx)
+	e.completed = true
+	return e.outcome, nil
+}
+
+// cacheKey identifies identical probes: the module, the parameters except
+// the output format and the scrape timeout, which limits the probe.
+func cacheKey(moduleName string, params url.Values, scrapeTimeout string) string {
+	key := url.Values{}
+	for name, values := range params {
+		if name != "debug" && name != "format" {
+			key[name] = values
+		}
+	}
+	return moduleName + "\x00" + scrapeTimeout + "\x00" + key.Encode()
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestResultCache(t *testing.T) {
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	c := &config.Config{
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:   "http",
+				Timeout:  10 * time.Second,
+				HTTP:     config.HTTPProbe{IPProtocolFallback: true},
+				CacheTTL: time.Minute,
+			},
+		},
+	}
+	cache := NewResultCache(prometheus.NewRegistry())
+	probe := func(query string) string {
+		req := httptest.NewRequest("GET", "/probe?"+query, nil)
+		rr := httptest.NewRecorder()
+		Handler(rr, req, c, log.NewNopLogger(), &ResultHistory{MaxResults: 10}, 0.5, nil, nil, level.AllowNone(), nil, cache)
+		return rr.Body.String()
+	}
+
+	// Concurrent identical requests share one probe.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body := probe("target=" + url.QueryEscape(ts.URL)); !strings.Contains(body, "probe_success 1") {
+				t.Errorf("Unexpected response %s", body)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("Expected 1 request to the target, got %d", n)
+	}
+
+	// The output format is not part of the key.
+	if body := probe("format=json&target=" + url.QueryEscape(ts.URL)); !strings.Contains(body, `"success":true`) {
+		t.Errorf("Unexpected response %s", body)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("Expected 1 request to the target, got %d", n)
+	}
+	if hits := testutil.ToFloat64(cache.hits.WithLabelValues("http_2xx")); hits != 3 {
+		t.Errorf("Expected 3 cache hits, got %v", hits)
+	}
+
+	// Other parameters, scrape timeouts and debug requests probe again.
+	probe("target=" + url.QueryEscape(ts.URL+"/other"))
+	probe("debug=true&target=" + url.QueryEscape(ts.URL))
+	req := httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(ts.URL), nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "5")
+	Handler(httptest.NewRecorder(), req, c, log.NewNopLogger(), &ResultHistory{MaxResults: 10}, 0.5, nil, nil, level.AllowNone(), nil, cache)
+	if n := requests.Load(); n != 4 {
+		t.Fatalf("Expected 4 requests to the target, got %d", n)
+	}
+}
+
+func TestResultCacheExpiry(t *testing.T) {
+	cache := NewResultCache(prometheus.NewRegistry())
+	probes := 0
+	probe := func(context.Context) probeOutcome {
+		probes++
+		return probeOutcome{success: true}
+	}
+	do := func() {
+		if _, err := cache.do(context.Background(), "key", "http_2xx", 50*time.Millisecond, time.Second, probe); err != nil {
+			t.Fatal(err)
+		}
+	}
+	do()
+	do()
+	if probes != 1 {
+		t.Fatalf("Expected 1 probe, got %d", probes)
+	}
+	time.Sleep(60 * time.Millisecond)
+	do()
+	if probes != 2 {
+		t.Fatalf("Expected a new probe after the TTL, got %d probes", probes)
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("Expected expired entries to be dropped, got %d entries", len(cache.entries))
+	}
+}
+
+func TestResultCacheContext(t *testing.T) {
+	cache := NewResultCache(prometheus.NewRegistry())
+	started, release := make(chan struct{}), make(chan struct{})
+	probe := func(ctx context.Context) probeOutcome {
+		close(started)
+		select {
+		case <-release:
+			return probeOutcome{success: true}
+		case <-ctx.Done():
+			return probeOutcome{}
+		}
+	}
+
+	// The probe outlives the request that started it.
+	first, cancelFirst := context.WithCancel(context.Background())
+	result := make(chan probeOutcome)
+	go func() {
+		outcome, _ := cache.do(first, "key", "http_2xx", time.Minute, time.Minute, probe)
+		result <- outcome
+	}()
+	<-started
+	cancelFirst()
+
+	// Waiters give up when their own context is done.
+	waiting, cancelWaiting := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelWaiting()
+	if _, err := cache.do(waiting, "key", "http_2xx", time.Minute, time.Minute, probe); err == nil {
+		t.Fatal("Expected an error when the context of a waiting request is done")
+	}
+
+	close(release)
+	if outcome := <-result; !outcome.success {
+		t.Fatal("Expected the probe to succeed after the first request was canceled")
+	}
+	outcome, err := cache.do(context.Background(), "key", "http_2xx", time.Minute, time.Minute, probe)
+	if err != nil || !outcome.success {
+		t.Fatalf("Expected the cached outcome, got %v, %v", outcome, err)
+	}
+}
+
+func TestResultCacheTimeout(t *testing.T) {
+	cache := NewResultCache(prometheus.NewRegistry())
+	probes := 0
+	probe := func(ctx context.Context) probeOutcome {
+		probes++
+		<-ctx.Done()
+		return probeOutcome{}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.do(context.Background(), "key", "http_2xx", time.Minute, 10*time.Millisecond, probe); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if probes != 2 {
+		t.Fatalf("Expected outcomes of timed out probes not to be cached, got %d probes", probes)
+	}
+}
+
+func TestResultCachePanic(t *testing.T) {
+	cache := NewResultCache(prometheus.NewRegistry())
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		cache.do(context.Background(), "key", "http_2xx", time.Minute, time.Minute, func(context.Context) probeOutcome {
+			close(started)
+			<-release
+			panic("probe failed")
+		})
+	}()
+	<-started
+
+	waiter := make(chan error)
+	go func() {
+		_, err := cache.do(context.Background(), "key", "http_2xx", time.Minute, time.Minute, nil)
+		waiter <- err
+	}()
+	// Wait for the waiter to block on the probe.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-waiter; err == nil {
+		t.Fatal("Expected an error for a probe that panicked")
+	}
+	outcome, err := cache.do(context.Background(), "key", "http_2xx", time.Minute, time.Minute, func(context.Context) probeOutcome {
+		return probeOutcome{success: true}
+	})
+	if err != nil || !outcome.success {
+		t.Fatalf("Expected a new probe after the panic, got %v, %v", outcome, err)
+	}
+}
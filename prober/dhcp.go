@@ -0,0 +1,244 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	dhcpBootRequest = 1
+	dhcpBootReply   = 2
+
+	dhcpMagicCookie = 0x63825363
+
+	dhcpOptionSubnetMask       = 1
+	dhcpOptionRouter           = 3
+	dhcpOptionDNSServer        = 6
+	dhcpOptionLeaseTime        = 51
+	dhcpOptionMessageType      = 53
+	dhcpOptionServerIdentifier = 54
+	dhcpOptionParameterList    = 55
+	dhcpOptionEnd              = 255
+
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+
+	// dhcpOptionsOffset is the offset of the magic cookie preceding the
+	// options.
+	dhcpOptionsOffset = 236
+
+	// dhcpMinPacketSize is the minimum size of a BOOTP packet.
+	dhcpMinPacketSize = 300
+)
+
+// dhcpDiscoverPacket encodes a DHCPDISCOVER. If giaddr is set, the packet is
+// forwarded as a relay agent and the reply is unicast to giaddr. Otherwise the
+// server is asked to broadcast the reply.
+func dhcpDiscoverPacket(xid uint32, chaddr net.HardwareAddr, giaddr net.IP) []byte {
+	packet := make([]byte, dhcpOptionsOffset, dhcpMinPacketSize)
+	packet[0], packet[1], packet[2] = dhcpBootRequest, 1, byte(len(chaddr))
+	binary.BigEndian.PutUint32(packet[4:], xid)
+	if giaddr != nil {
+		packet[3] = 1
+		copy(packet[24:28], giaddr.To4())
+	} else {
+		packet[10] = 0x80
+	}
+	copy(packet[28:44], chaddr)
+	packet = binary.BigEndian.AppendUint32(packet, dhcpMagicCookie)
+	packet = append(packet,
+		dhcpOptionMessageType, 1, dhcpDiscover,
+		dhcpOptionParameterList, 5, dhcpOptionSubnetMask, dhcpOptionRouter, dhcpOptionDNSServer, dhcpOptionLeaseTime, dhcpOptionServerIdentifier,
+		dhcpOptionEnd,
+	)
+	return packet[:max(len(packet), dhcpMinPacketSize)]
+}
+
+// parseDHCPReply decodes a BOOTREPLY to the transaction, returning the
+// offered address and the options.
+func parseDHCPReply(packet []byte, xid uint32) (net.IP, map[byte][]byte, error) {
+	if len(packet) < dhcpOptionsOffset+4 || packet[0] != dhcpBootReply || binary.BigEndian.Uint32(packet[4:]) != xid {
+		return nil, nil, errors.New("not a reply to the transaction")
+	}
+	if binary.BigEndian.Uint32(packet[dhcpOptionsOffset:]) != dhcpMagicCookie {
+		return nil, nil, errors.New("invalid magic cookie")
+	}
+	options := map[byte][]byte{}
+	for b := packet[dhcpOptionsOffset+4:]; len(b) > 0 && b[0] != dhcpOptionEnd; {
+		if b[0] == 0 {
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil, nil, errors.New("truncated option")
+		}
+		length := int(b[1])
+		options[b[0]] = b[2 : 2+length]
+		b = b[2+length:]
+	}
+	return net.IP(packet[16:20]), options, nil
+}
+
+// ProbeDHCP sends a DHCPDISCOVER to a server, either as broadcast or as relay
+// agent, and waits for a DHCPOFFER.
+func ProbeDHCP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeDHCPDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_dhcp_duration_seconds",
+		Help: "Duration of each phase of the DHCP transaction",
+	}, []string{"phase"})
+	probeDHCPOfferInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_dhcp_offer_info",
+		Help: "Contains the server identifier, subnet and router of the offer",
+	}, []string{"server_identifier", "subnet", "router"})
+	probeDHCPLeaseTime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_dhcp_lease_time_seconds",
+		Help: "Lease time of the offered address",
+	})
+	registry.MustRegister(probeDHCPDuration)
+
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
+		return false
+	}
+	ip, _, err := chooseProtocol(ctx, "ip4", false, targetAddress, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+	serverPort, err := strconv.Atoi(port)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error parsing port", "err", err)
+		return false
+	}
+	server := &net.UDPAddr{IP: ip.IP, Port: serverPort}
+
+	chaddr := make(net.HardwareAddr, 6)
+	if module.DHCP.HardwareAddress != "" {
+		if chaddr, err = net.ParseMAC(module.DHCP.HardwareAddress); err != nil {
+			level.Error(logger).Log("msg", "Error parsing hardware address", "err", err)
+			return false
+		}
+	} else {
+		rand.Read(chaddr)
+		// A random address is a locally administered unicast address.
+		chaddr[0] = chaddr[0]&^0x01 | 0x02
+	}
+
+	clientPort := module.DHCP.ClientPort
+	var giaddr net.IP
+	if module.DHCP.Relay {
+		// Servers send replies to the relay agent port.
+		if clientPort == 0 {
+			clientPort = 67
+		}
+		if giaddr = net.ParseIP(module.DHCP.SourceIPAddress).To4(); giaddr == nil {
+			// The local address towards the server is found by connecting a
+			// UDP socket, which does not send anything.
+			conn, err := net.DialUDP("udp4", nil, server)
+			if err != nil {
+				level.Error(logger).Log("msg", "Error finding local address", "err", err)
+				return false
+			}
+			giaddr = conn.LocalAddr().(*net.UDPAddr).IP.To4()
+			conn.Close()
+		}
+	} else if clientPort == 0 {
+		clientPort = 68
+	}
+
+	listenConfig := net.ListenConfig{}
+	if server.IP.Equal(net.IPv4bcast) {
+		listenConfig.Control = broadcastControl
+	}
+	// Broadcast replies are only received by sockets bound to any address.
+	conn, err := listenConfig.ListenPacket(ctx, "udp4", fmt.Sprintf(":%d", clientPort))
+	if err != nil {
+		level.Error(logger).Log("msg", "Error listening on the client port", "port", clientPort, "err", err)
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+			return false
+		}
+	}
+
+	var xidBytes [4]byte
+	rand.Read(xidBytes[:])
+	xid := binary.BigEndian.Uint32(xidBytes[:])
+	var (
+		yiaddr  net.IP
+		options map[byte][]byte
+	)
+	start := time.Now()
+	err = func() error {
+		if _, err := conn.WriteTo(dhcpDiscoverPacket(xid, chaddr, giaddr), server); err != nil {
+			return err
+		}
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return err
+			}
+			if yiaddr, options, err = parseDHCPReply(buf[:n], xid); err != nil {
+				continue
+			}
+			if messageType := options[dhcpOptionMessageType]; len(messageType) != 1 || messageType[0] != dhcpOffer {
+				return fmt.Errorf("unexpected message type %v", messageType)
+			}
+			return nil
+		}
+	}()
+	probeDHCPDuration.WithLabelValues("discover").Set(time.Since(start).Seconds())
+	if err != nil {
+		level.Error(logger).Log("msg", "No offer received", "err", err)
+		return false
+	}
+
+	var subnet, router, serverIdentifier string
+	if mask := options[dhcpOptionSubnetMask]; len(mask) == net.IPv4len {
+		subnet = (&net.IPNet{IP: yiaddr.Mask(mask), Mask: mask}).String()
+	}
+	if routers := options[dhcpOptionRouter]; len(routers) >= net.IPv4len {
+		router = net.IP(routers[:net.IPv4len]).String()
+	}
+	if id := options[dhcpOptionServerIdentifier]; len(id) == net.IPv4len {
+		serverIdentifier = net.IP(id).String()
+	}
+	level.Info(logger).Log("msg", "Received offer", "address", yiaddr, "server_identifier", serverIdentifier)
+	registry.MustRegister(probeDHCPOfferInfo)
+	probeDHCPOfferInfo.WithLabelValues(serverIdentifier, subnet, router).Set(1)
+	if leaseTime := options[dhcpOptionLeaseTime]; len(leaseTime) == 4 {
+		registry.MustRegister(probeDHCPLeaseTime)
+		probeDHCPLeaseTime.Set(float64(binary.BigEndian.Uint32(leaseTime)))
+	}
+	return true
+}
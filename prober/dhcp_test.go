@@ -0,0 +1,111 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveDHCP runs a minimal DHCP server offering 192.168.1.100/24. Replies
+// are sent back to the source of the request.
+func serveDHCP(t *testing.T, conn net.PacketConn, relay bool) {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request := buf[:n]
+		if n < dhcpMinPacketSize || request[0] != dhcpBootRequest || !bytes.Equal(request[28:34], []byte{0x02, 0, 0, 0, 0, 0x01}) {
+			t.Errorf("Unexpected request %x", request)
+			return
+		}
+		if giaddr := net.IP(request[24:28]); relay != !giaddr.Equal(net.IPv4zero) || relay != (request[3] == 1) {
+			t.Errorf("Unexpected relay agent address %s", giaddr)
+			return
+		}
+
+		reply := make([]byte, dhcpOptionsOffset)
+		copy(reply, request[:dhcpOptionsOffset])
+		reply[0] = dhcpBootReply
+		copy(reply[16:20], net.IPv4(192, 168, 1, 100).To4())
+		reply = binary.BigEndian.AppendUint32(reply, dhcpMagicCookie)
+		reply = append(reply,
+			dhcpOptionMessageType, 1, dhcpOffer,
+			dhcpOptionServerIdentifier, 4, 192, 168, 1, 1,
+			dhcpOptionLeaseTime, 4, 0, 0, 0x0e, 0x10,
+			dhcpOptionSubnetMask, 4, 255, 255, 255, 0,
+			dhcpOptionRouter, 8, 192, 168, 1, 1, 192, 168, 1, 2,
+			0,
+			dhcpOptionEnd,
+		)
+		conn.WriteTo(reply, addr)
+	}
+}
+
+func TestDHCPDiscover(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, relay := range []bool{false, true} {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error listening on socket: %s", err)
+		}
+		defer conn.Close()
+		go serveDHCP(t, conn, relay)
+
+		// Find a free port to receive the reply on.
+		client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error listening on socket: %s", err)
+		}
+		clientPort := client.LocalAddr().(*net.UDPAddr).Port
+		client.Close()
+
+		module := config.Module{DHCP: config.DHCPProbe{
+			SourceIPAddress: "127.0.0.1",
+			Relay:           relay,
+			ClientPort:      clientPort,
+			HardwareAddress: "02:00:00:00:00:01",
+		}}
+		registry := prometheus.NewRegistry()
+		if !ProbeDHCP(testCTX, conn.LocalAddr().String(), module, registry, log.NewNopLogger()) {
+			t.Fatalf("DHCP module failed with relay %v, expected success.", relay)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkMetrics(map[string]map[string]map[string]struct{}{"probe_dhcp_duration_seconds": {"phase": {"discover": {}}}}, mfs, t)
+		checkRegistryLabels(map[string]map[string]string{
+			"probe_dhcp_offer_info": {
+				"server_identifier": "192.168.1.1",
+				"subnet":            "192.168.1.0/24",
+				"router":            "192.168.1.1",
+			},
+		}, mfs, t)
+		checkRegistryResults(map[string]float64{"probe_dhcp_lease_time_seconds": 3600}, mfs, t)
+	}
+}
@@ -295,7 +295,17 @@ func ProbeDNS(ctx context.Context, target string, module config.Module, registry
 		}
 	}
 
-	if !validRcode(response.Rcode, module.DNS.ValidRcodes, logger) {
+	validRcodes := module.DNS.ValidRcodes
+	if module.DNS.ExpectNXDomain {
+		// The name is expected not to exist, so NXDOMAIN is the only
+		// acceptable answer.
+		validRcodes = []string{"NXDOMAIN"}
+	}
+	if !validRcode(response.Rcode, validRcodes, logger) {
+		return false
+	}
+	if module.DNS.ExpectNXDomain && len(response.Answer) > 0 {
+		level.Error(logger).Log("msg", "expect_nxdomain specified but answer RRs returned", "answer_rrs", len(response.Answer))
 		return false
 	}
 	level.Info(logger).Log("msg", "Validating Answer RRs")
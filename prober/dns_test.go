@@ -474,6 +474,72 @@ func TestServfailDNSResponse(t *testing.T) {
 	}
 }
 
+func nxdomainDNSHandler(w dns.ResponseWriter, r *dns.Msg) {
+	if r.Question[0].Name != "example.com." {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		if err := w.WriteMsg(m); err != nil {
+			panic(err)
+		}
+		return
+	}
+	recursiveDNSHandler(w, r)
+}
+
+func TestNXDomainDNSResponse(t *testing.T) {
+	if os.Getenv("CI") == "true" {
+		t.Skip("skipping; CI is failing on ipv6 dns requests")
+	}
+
+	tests := []struct {
+		Probe         config.DNSProbe
+		ShouldSucceed bool
+	}{
+		{
+			config.DNSProbe{
+				IPProtocol:         "ip4",
+				IPProtocolFallback: true,
+				QueryName:          "decommissioned.example.com",
+				Recursion:          true,
+				ExpectNXDomain:     true,
+			}, true,
+		},
+		{
+			config.DNSProbe{
+				IPProtocol:         "ip4",
+				IPProtocolFallback: true,
+				QueryName:          "example.com",
+				Recursion:          true,
+				ExpectNXDomain:     true,
+			}, false,
+		},
+		{
+			config.DNSProbe{
+				IPProtocol:         "ip4",
+				IPProtocolFallback: true,
+				QueryName:          "decommissioned.example.com",
+				Recursion:          true,
+			}, false,
+		},
+	}
+
+	for _, protocol := range PROTOCOLS {
+		server, addr := startDNSServer(protocol, nxdomainDNSHandler)
+		defer server.Shutdown()
+
+		for i, test := range tests {
+			test.Probe.TransportProtocol = protocol
+			registry := prometheus.NewRegistry()
+			testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			result := ProbeDNS(testCTX, addr.String(), config.Module{Timeout: time.Second, DNS: test.Probe}, registry, log.NewNopLogger())
+			if result != test.ShouldSucceed {
+				t.Fatalf("Test %d had unexpected result: %v", i, result)
+			}
+		}
+	}
+}
+
 func TestDNSProtocol(t *testing.T) {
 	if os.Getenv("CI") == "true" {
 		t.Skip("skipping; CI is failing on ipv6 dns requests")
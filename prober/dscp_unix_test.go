@@ -0,0 +1,54 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package prober
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDSCPControl(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+
+	dialer := &net.Dialer{Control: dscpControl(46)}
+	conn, err := dialer.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %s", err)
+	}
+	defer conn.Close()
+
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos int
+	var sockErr error
+	rawConn.Control(func(fd uintptr) {
+		tos, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+	})
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	if tos != 46<<2 {
+		t.Fatalf("Expected TOS %d, got %d", 46<<2, tos)
+	}
+}
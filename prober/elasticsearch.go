@@ -0,0 +1,128 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// elasticsearchStatuses maps the cluster health status to its gauge value.
+// Higher values are worse.
+var elasticsearchStatuses = map[string]float64{
+	"green":  0,
+	"yellow": 1,
+	"red":    2,
+}
+
+// elasticsearchHealth is the part of the cluster health the probe uses.
+type elasticsearchHealth struct {
+	ClusterName      string `json:"cluster_name"`
+	Status           string `json:"status"`
+	NumberOfNodes    int    `json:"number_of_nodes"`
+	UnassignedShards int    `json:"unassigned_shards"`
+}
+
+// ProbeElasticsearch reads the cluster health of an Elasticsearch or
+// OpenSearch cluster and fails if it is worse than the minimum status.
+func ProbeElasticsearch(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeElasticsearchDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_elasticsearch_cluster_health_duration_seconds",
+		Help: "Duration of the cluster health request",
+	})
+	probeElasticsearchStatus := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_elasticsearch_cluster_status",
+		Help: "Cluster health status, 0 for green, 1 for yellow and 2 for red",
+	})
+	probeElasticsearchInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_elasticsearch_cluster_info",
+		Help: "Contains the name of the cluster",
+	}, []string{"cluster_name"})
+	probeElasticsearchNodes := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_elasticsearch_nodes",
+		Help: "Number of nodes in the cluster",
+	})
+	probeElasticsearchUnassignedShards := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_elasticsearch_unassigned_shards",
+		Help: "Number of shards that are not allocated to a node",
+	})
+	registry.MustRegister(probeElasticsearchDuration)
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+	client, err := newHTTPClient(ctx, targetURL, module.Elasticsearch.IPProtocol, module.Elasticsearch.IPProtocolFallback, module.Elasticsearch.SourceIPAddress, module.Elasticsearch.HTTPClientConfig, "elasticsearch_probe", registry, logger)
+	if err != nil {
+		return false
+	}
+
+	var health elasticsearchHealth
+	start := time.Now()
+	err = func() error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(targetURL.String(), "/")+"/_cluster/health", nil)
+		if err != nil {
+			return err
+		}
+		request.Header.Set("User-Agent", userAgentDefaultHeader)
+		request.Header.Set("Accept", "application/json")
+		resp, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&health)
+	}()
+	probeElasticsearchDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		level.Error(logger).Log("msg", "Error reading cluster health", "err", err)
+		return false
+	}
+	status, ok := elasticsearchStatuses[health.Status]
+	if !ok {
+		level.Error(logger).Log("msg", "Unknown cluster status", "status", health.Status)
+		return false
+	}
+
+	registry.MustRegister(probeElasticsearchStatus, probeElasticsearchInfo, probeElasticsearchNodes, probeElasticsearchUnassignedShards)
+	probeElasticsearchStatus.Set(status)
+	probeElasticsearchInfo.WithLabelValues(health.ClusterName).Set(1)
+	probeElasticsearchNodes.Set(float64(health.NumberOfNodes))
+	probeElasticsearchUnassignedShards.Set(float64(health.UnassignedShards))
+	if status > elasticsearchStatuses[module.Elasticsearch.MinimumStatus] {
+		level.Error(logger).Log("msg", "Cluster status is worse than the minimum status", "status", health.Status, "minimum_status", module.Elasticsearch.MinimumStatus)
+		return false
+	}
+	level.Info(logger).Log("msg", "Cluster status", "status", health.Status)
+	return true
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestElasticsearchClusterHealth(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name          string
+		status        string
+		minimumStatus string
+		password      string
+		success       bool
+	}{
+		{name: "green", status: "green", minimumStatus: "green", password: "secret", success: true},
+		{name: "yellow", status: "yellow", minimumStatus: "yellow", password: "secret", success: true},
+		{name: "yellow below minimum", status: "yellow", minimumStatus: "green", password: "secret"},
+		{name: "red", status: "red", minimumStatus: "yellow", password: "secret"},
+		{name: "unauthorized", status: "green", minimumStatus: "red", password: "wrong"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if username, password, _ := r.BasicAuth(); username != "elastic" || password != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if r.URL.Path != "/_cluster/health" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintf(w, `{"cluster_name":"logs","status":%q,"number_of_nodes":3,"unassigned_shards":2}`, tc.status)
+			}))
+			defer ts.Close()
+
+			httpClientConfig := pconfig.DefaultHTTPClientConfig
+			httpClientConfig.BasicAuth = &pconfig.BasicAuth{Username: "elastic", Password: pconfig.Secret(tc.password)}
+			module := config.Module{Elasticsearch: config.ElasticsearchProbe{
+				IPProtocol:       "ip4",
+				HTTPClientConfig: httpClientConfig,
+				MinimumStatus:    tc.minimumStatus,
+			}}
+			registry := prometheus.NewRegistry()
+			if ProbeElasticsearch(testCTX, ts.URL, module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			if tc.password != "secret" {
+				return
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{
+				"probe_elasticsearch_cluster_status":    elasticsearchStatuses[tc.status],
+				"probe_elasticsearch_nodes":             3,
+				"probe_elasticsearch_unassigned_shards": 2,
+			}, mfs, t)
+			checkRegistryLabels(map[string]map[string]string{"probe_elasticsearch_cluster_info": {"cluster_name": "logs"}}, mfs, t)
+		})
+	}
+}
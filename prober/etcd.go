@@ -0,0 +1,193 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// etcdHealth is the response of the /health endpoint.
+type etcdHealth struct {
+	Health string `json:"health"`
+	Reason string `json:"reason"`
+}
+
+// etcdStatus is the part of the maintenance status the probe uses. The gRPC
+// gateway encodes 64 bit integers as strings.
+type etcdStatus struct {
+	Header struct {
+		MemberID string `json:"member_id"`
+	} `json:"header"`
+	Version string `json:"version"`
+	Leader  string `json:"leader"`
+}
+
+// etcdMemberList is the part of the member list the probe uses.
+type etcdMemberList struct {
+	Members []struct {
+		Name       string   `json:"name"`
+		ClientURLs []string `json:"clientURLs"`
+	} `json:"members"`
+}
+
+// etcdRequest sends a request to an etcd endpoint and decodes the JSON
+// response. Calls of the v3 API are sent as POST with an empty request.
+func etcdRequest(ctx context.Context, client *http.Client, method, endpoint string, v interface{}) error {
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader("{}")
+	}
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", userAgentDefaultHeader)
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// An unhealthy member answers /health with 503 and the reason.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// checkEtcdHealth calls the /health endpoint of a member.
+func checkEtcdHealth(ctx context.Context, client *http.Client, baseURL string) error {
+	var health etcdHealth
+	if err := etcdRequest(ctx, client, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/health", &health); err != nil {
+		return err
+	}
+	if health.Health != "true" {
+		return fmt.Errorf("member is unhealthy: %s", health.Reason)
+	}
+	return nil
+}
+
+// ProbeEtcd checks the health and leader of an etcd member and, if
+// configured, the health of all members of its cluster.
+func ProbeEtcd(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeEtcdDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_etcd_duration_seconds",
+		Help: "Duration of each phase of the etcd probe",
+	}, []string{"phase"})
+	probeEtcdHasLeader := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_etcd_has_leader",
+		Help: "Whether the member knows a leader of the cluster",
+	})
+	probeEtcdIsLeader := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_etcd_is_leader",
+		Help: "Whether the member is the leader of the cluster",
+	})
+	probeEtcdVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_etcd_server_info",
+		Help: "Contains the version of the etcd member",
+	}, []string{"version"})
+	probeEtcdMemberHealthy := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_etcd_member_healthy",
+		Help: "Whether each member of the cluster reports to be healthy",
+	}, []string{"member"})
+	probeEtcdMemberDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_etcd_member_health_duration_seconds",
+		Help: "Duration of the health check of each member of the cluster",
+	}, []string{"member"})
+	registry.MustRegister(probeEtcdDuration)
+
+	// step runs and times one phase of the probe.
+	step := newStepTimer(probeEtcdDuration, "phase", "etcd phase", logger, nil)
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+	client, err := newHTTPClient(ctx, targetURL, module.Etcd.IPProtocol, module.Etcd.IPProtocolFallback, module.Etcd.SourceIPAddress, module.Etcd.HTTPClientConfig, "etcd_probe", registry, logger)
+	if err != nil {
+		return false
+	}
+	baseURL := strings.TrimSuffix(targetURL.String(), "/")
+
+	if !step("health", func() error { return checkEtcdHealth(ctx, client, baseURL) }) {
+		return false
+	}
+
+	var status etcdStatus
+	if !step("status", func() error {
+		return etcdRequest(ctx, client, http.MethodPost, baseURL+"/v3/maintenance/status", &status)
+	}) {
+		return false
+	}
+	registry.MustRegister(probeEtcdHasLeader, probeEtcdIsLeader, probeEtcdVersion)
+	probeEtcdVersion.WithLabelValues(status.Version).Set(1)
+	if status.Leader == "" || status.Leader == "0" {
+		level.Error(logger).Log("msg", "Member has no leader")
+		return false
+	}
+	probeEtcdHasLeader.Set(1)
+	if status.Leader == status.Header.MemberID {
+		probeEtcdIsLeader.Set(1)
+	}
+
+	if !module.Etcd.CheckMembers {
+		return true
+	}
+	var members etcdMemberList
+	if !step("members", func() error {
+		if err := etcdRequest(ctx, client, http.MethodPost, baseURL+"/v3/cluster/member/list", &members); err != nil {
+			return err
+		}
+		if len(members.Members) == 0 {
+			return errors.New("member list is empty")
+		}
+		return nil
+	}) {
+		return false
+	}
+	registry.MustRegister(probeEtcdMemberHealthy, probeEtcdMemberDuration)
+	for _, member := range members.Members {
+		// Members that have not started yet have neither name nor URLs.
+		if member.Name == "" || len(member.ClientURLs) == 0 {
+			continue
+		}
+		start := time.Now()
+		err := checkEtcdHealth(ctx, client, member.ClientURLs[0])
+		probeEtcdMemberDuration.WithLabelValues(member.Name).Set(time.Since(start).Seconds())
+		if err != nil {
+			level.Warn(logger).Log("msg", "Member health check failed", "member", member.Name, "err", err)
+			probeEtcdMemberHealthy.WithLabelValues(member.Name).Set(0)
+			continue
+		}
+		probeEtcdMemberHealthy.WithLabelValues(member.Name).Set(1)
+	}
+	return true
+}
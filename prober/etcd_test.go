@@ -0,0 +1,131 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// newEtcdTestMember starts a fake etcd member with the given ID, leader and
+// health. The member list returns the members of peers.
+func newEtcdTestMember(t *testing.T, id, leader string, healthy bool, peers func() map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			if !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, `{"health":"false","reason":"RAFT NO LEADER"}`)
+				return
+			}
+			fmt.Fprint(w, `{"health":"true","reason":""}`)
+		case "/v3/maintenance/status":
+			if r.Method != http.MethodPost {
+				t.Errorf("Unexpected method %s", r.Method)
+			}
+			fmt.Fprintf(w, `{"header":{"cluster_id":"1","member_id":%q},"version":"3.5.12","leader":%q}`, id, leader)
+		case "/v3/cluster/member/list":
+			members := ""
+			for name, clientURL := range peers() {
+				if members != "" {
+					members += ","
+				}
+				members += fmt.Sprintf(`{"name":%q,"clientURLs":[%q]}`, name, clientURL)
+			}
+			fmt.Fprintf(w, `{"members":[%s]}`, members)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEtcdProbe(t *testing.T) {
+	var leader, follower, unhealthy *httptest.Server
+	peers := func() map[string]string {
+		return map[string]string{"etcd-0": leader.URL, "etcd-1": follower.URL, "etcd-2": unhealthy.URL}
+	}
+	leader = newEtcdTestMember(t, "100", "100", true, peers)
+	follower = newEtcdTestMember(t, "101", "100", true, peers)
+	unhealthy = newEtcdTestMember(t, "102", "0", false, peers)
+	noLeader := newEtcdTestMember(t, "103", "0", true, peers)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name         string
+		target       string
+		checkMembers bool
+		success      bool
+		isLeader     float64
+	}{
+		{name: "leader", target: leader.URL, success: true, isLeader: 1},
+		{name: "follower with members", target: follower.URL, checkMembers: true, success: true},
+		{name: "unhealthy", target: unhealthy.URL},
+		{name: "no leader", target: noLeader.URL},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			module := config.Module{Etcd: config.EtcdProbe{
+				IPProtocol:       "ip4",
+				HTTPClientConfig: pconfig.DefaultHTTPClientConfig,
+				CheckMembers:     tc.checkMembers,
+			}}
+			registry := prometheus.NewRegistry()
+			if ProbeEtcd(testCTX, tc.target, module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			if !tc.success {
+				return
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{"probe_etcd_has_leader": 1, "probe_etcd_is_leader": tc.isLeader}, mfs, t)
+			checkRegistryLabels(map[string]map[string]string{"probe_etcd_server_info": {"version": "3.5.12"}}, mfs, t)
+			if tc.checkMembers {
+				checkMetrics(map[string]map[string]map[string]struct{}{
+					"probe_etcd_duration_seconds":               {"phase": {"health": {}, "status": {}, "members": {}}},
+					"probe_etcd_member_health_duration_seconds": {"member": {"etcd-0": {}, "etcd-1": {}, "etcd-2": {}}},
+				}, mfs, t)
+				for _, mf := range mfs {
+					if mf.GetName() != "probe_etcd_member_healthy" {
+						continue
+					}
+					for _, m := range mf.GetMetric() {
+						expected := 1.0
+						if m.GetLabel()[0].GetValue() == "etcd-2" {
+							expected = 0
+						}
+						if m.GetGauge().GetValue() != expected {
+							t.Errorf("Expected %s healthy %v, got %v", m.GetLabel()[0].GetValue(), expected, m.GetGauge().GetValue())
+						}
+					}
+				}
+			}
+		})
+	}
+}
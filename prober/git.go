@@ -0,0 +1,254 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// errGitFlush is returned by readGitPacket for a flush packet.
+var errGitFlush = errors.New("flush packet")
+
+// readGitPacket reads one packet of the pkt-line format.
+func readGitPacket(r *bufio.Reader) (string, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid packet length %q", header)
+	}
+	if length == 0 {
+		return "", errGitFlush
+	}
+	if length < 4 {
+		return "", fmt.Errorf("invalid packet length %d", length)
+	}
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+	line := strings.TrimSuffix(string(payload), "\n")
+	if message, ok := strings.CutPrefix(line, "ERR "); ok {
+		return "", fmt.Errorf("server error: %s", message)
+	}
+	return line, nil
+}
+
+// readGitRefs reads the ref advertisement of git-upload-pack up to the flush
+// packet and returns the advertised ref names.
+func readGitRefs(r *bufio.Reader) ([]string, error) {
+	var refs []string
+	for {
+		line, err := readGitPacket(r)
+		if err == errGitFlush {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The first ref is followed by a NUL and the capabilities.
+		line, _, _ = strings.Cut(line, "\x00")
+		_, ref, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid ref line %q", line)
+		}
+		// An empty repository advertises the capabilities on a
+		// placeholder.
+		if ref != "capabilities^{}" {
+			refs = append(refs, ref)
+		}
+	}
+}
+
+// gitHTTPRefs fetches the ref advertisement over the smart HTTP protocol.
+func gitHTTPRefs(ctx context.Context, repoURL *url.URL, module config.GitProbe, registry *prometheus.Registry, logger log.Logger) ([]string, error) {
+	client, err := newHTTPClient(ctx, repoURL, module.IPProtocol, module.IPProtocolFallback, module.SourceIPAddress, module.HTTPClientConfig, "git_probe", registry, logger)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(repoURL.String(), "/")+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", userAgentDefaultHeader)
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	// Dumb HTTP servers serve a plain list of refs instead.
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-git-upload-pack-advertisement" {
+		return nil, fmt.Errorf("unexpected content type %q", contentType)
+	}
+	r := bufio.NewReader(resp.Body)
+	line, err := readGitPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if line != "# service=git-upload-pack" {
+		return nil, fmt.Errorf("unexpected service line %q", line)
+	}
+	if _, err := readGitPacket(r); err != errGitFlush {
+		return nil, fmt.Errorf("expected flush packet after service line: %v", err)
+	}
+	return readGitRefs(r)
+}
+
+// gitSSHRefs runs git-upload-pack over SSH and reads the ref advertisement.
+func gitSSHRefs(ctx context.Context, repoURL *url.URL, module config.GitProbe, registry *prometheus.Registry, logger log.Logger) ([]string, error) {
+	verify := ssh.InsecureIgnoreHostKey()
+	if module.KnownHostsFile != "" {
+		var err error
+		if verify, err = knownhosts.New(module.KnownHostsFile); err != nil {
+			return nil, fmt.Errorf("error reading known_hosts file: %w", err)
+		}
+	}
+	var auth []ssh.AuthMethod
+	if module.SSHPrivateKeyFile != "" {
+		key, err := os.ReadFile(module.SSHPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	user := "git"
+	if repoURL.User != nil {
+		user = repoURL.User.Username()
+	}
+	port := repoURL.Port()
+	if port == "" {
+		port = "22"
+	}
+	target := net.JoinHostPort(repoURL.Hostname(), port)
+
+	conn, _, err := dialTCPTarget(ctx, target, module.IPProtocol, module.IPProtocolFallback, module.SourceIPAddress, registry, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// The target is given as address so that known_hosts entries match the
+	// name and port of the target rather than the resolved address.
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, target, &ssh.ClientConfig{User: user, Auth: auth, HostKeyCallback: verify})
+	if err != nil {
+		return nil, err
+	}
+	client := ssh.NewClient(sshConn, channels, requests)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	path := strings.ReplaceAll(repoURL.Path, "'", `'\''`)
+	if err := session.Start("git-upload-pack '" + path + "'"); err != nil {
+		return nil, err
+	}
+	refs, err := readGitRefs(bufio.NewReader(stdout))
+	if err != nil {
+		return nil, err
+	}
+	// A flush packet tells the server that nothing is fetched.
+	io.WriteString(stdin, "0000")
+	return refs, nil
+}
+
+// ProbeGit reads the refs a Git repository advertises over smart HTTP or SSH,
+// as git ls-remote does.
+func ProbeGit(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeGitAdvertisementDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_git_advertisement_duration_seconds",
+		Help: "Duration until the ref advertisement was received, including connection setup",
+	})
+	probeGitRefs := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_git_refs",
+		Help: "Number of refs advertised by the repository",
+	})
+	registry.MustRegister(probeGitAdvertisementDuration)
+
+	repoURL, err := url.Parse(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+
+	var refs []string
+	start := time.Now()
+	switch repoURL.Scheme {
+	case "http", "https":
+		refs, err = gitHTTPRefs(ctx, repoURL, module.Git, registry, logger)
+	case "ssh":
+		refs, err = gitSSHRefs(ctx, repoURL, module.Git, registry, logger)
+	default:
+		err = fmt.Errorf("unsupported scheme %q", repoURL.Scheme)
+	}
+	probeGitAdvertisementDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		level.Error(logger).Log("msg", "Error reading ref advertisement", "err", err)
+		return false
+	}
+
+	level.Info(logger).Log("msg", "Received ref advertisement", "refs", len(refs))
+	registry.MustRegister(probeGitRefs)
+	probeGitRefs.Set(float64(len(refs)))
+	if module.Git.ExpectRef != "" {
+		for _, ref := range refs {
+			if ref == module.Git.ExpectRef {
+				return true
+			}
+		}
+		level.Error(logger).Log("msg", "Expected ref not advertised", "ref", module.Git.ExpectRef)
+		return false
+	}
+	if len(refs) == 0 {
+		level.Error(logger).Log("msg", "Repository advertises no refs")
+		return false
+	}
+	return true
+}
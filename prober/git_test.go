@@ -0,0 +1,212 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// gitTestPacket encodes a packet of the pkt-line format.
+func gitTestPacket(line string) string {
+	return fmt.Sprintf("%04x%s", len(line)+4, line)
+}
+
+// gitTestAdvertisement encodes the ref advertisement of a repository with a
+// main branch and a tag.
+func gitTestAdvertisement() string {
+	const oid = "0123456789abcdef0123456789abcdef01234567"
+	return gitTestPacket(oid+" HEAD\x00multi_ack side-band-64k\n") +
+		gitTestPacket(oid+" refs/heads/main\n") +
+		gitTestPacket(oid+" refs/tags/v1.0.0\n") +
+		"0000"
+}
+
+func TestGitSmartHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repo.git/info/refs":
+			if r.URL.Query().Get("service") != "git-upload-pack" {
+				t.Errorf("Unexpected query %s", r.URL.RawQuery)
+			}
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			fmt.Fprint(w, gitTestPacket("# service=git-upload-pack\n")+"0000"+gitTestAdvertisement())
+		case "/empty.git/info/refs":
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			fmt.Fprint(w, gitTestPacket("# service=git-upload-pack\n")+"0000"+
+				gitTestPacket("0000000000000000000000000000000000000000 capabilities^{}\x00multi_ack\n")+"0000")
+		case "/dumb.git/info/refs":
+			fmt.Fprint(w, "0123456789abcdef0123456789abcdef01234567\trefs/heads/main\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name      string
+		repo      string
+		expectRef string
+		success   bool
+		refs      float64
+	}{
+		{name: "repository", repo: "/repo.git", success: true, refs: 3},
+		{name: "expected ref", repo: "/repo.git", expectRef: "refs/heads/main", success: true, refs: 3},
+		{name: "missing ref", repo: "/repo.git", expectRef: "refs/heads/master", refs: 3},
+		{name: "empty repository", repo: "/empty.git"},
+		{name: "dumb server", repo: "/dumb.git"},
+		{name: "missing repository", repo: "/missing.git"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			module := config.Module{Git: config.GitProbe{
+				IPProtocol:       "ip4",
+				HTTPClientConfig: pconfig.DefaultHTTPClientConfig,
+				ExpectRef:        tc.expectRef,
+			}}
+			registry := prometheus.NewRegistry()
+			if ProbeGit(testCTX, ts.URL+tc.repo, module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			if tc.refs == 0 {
+				return
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{"probe_git_refs": tc.refs}, mfs, t)
+		})
+	}
+}
+
+func TestGitSSH(t *testing.T) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublicKey, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(clientPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "git" || !bytes.Equal(key.Marshal(), sshPublicKey.Marshal()) {
+				return nil, fmt.Errorf("unknown key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						return
+					}
+					for request := range requests {
+						var command struct{ Command string }
+						ssh.Unmarshal(request.Payload, &command)
+						if request.Type != "exec" || command.Command != "git-upload-pack '/org/repo.git'" {
+							t.Errorf("Unexpected request %s %q", request.Type, command.Command)
+							request.Reply(false, nil)
+							continue
+						}
+						request.Reply(true, nil)
+						io.WriteString(channel, gitTestAdvertisement())
+						var flush [4]byte
+						if _, err := io.ReadFull(channel, flush[:]); err != nil || string(flush[:]) != "0000" {
+							t.Errorf("Expected flush packet, got %q", flush)
+						}
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+						channel.Close()
+					}
+				}
+			}()
+		}
+	}()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	module := config.Module{Git: config.GitProbe{
+		IPProtocol:        "ip4",
+		SSHPrivateKeyFile: keyFile,
+		ExpectRef:         "refs/tags/v1.0.0",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeGit(testCTX, "ssh://"+ln.Addr().String()+"/org/repo.git", module, registry, log.NewNopLogger()) {
+		t.Fatalf("Git module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_git_refs": 3}, mfs, t)
+}
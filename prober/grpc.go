@@ -22,6 +22,7 @@ import (
 	pconfig "github.com/prometheus/common/config"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -182,15 +183,36 @@ func ProbeGRPC(ctx context.Context, target string, module config.Module, registr
 		}
 	}
 
-	conn, err := grpc.NewClient(target, opts...)
+	// Dial the address checked by chooseProtocol instead of resolving the
+	// target again, while the target remains the authority of the requests.
+	_, port, _ := net.SplitHostPort(target)
+	address := net.JoinHostPort(ip.String(), port)
+	dialer := &net.Dialer{Control: targetFilterControl(ctx, registry)}
+	opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", address)
+	}))
 
+	conn, err := grpc.NewClient("passthrough:///"+target, opts...)
 	if err != nil {
-		level.Error(logger).Log("did not connect: %v", err)
+		level.Error(logger).Log("msg", "Error creating gRPC client", "err", err)
+		return false
+	}
+	defer conn.Close()
+
+	// Establish the connection first to time it separately from the RPC. A
+	// failure is reported by the health check itself.
+	conn.Connect()
+	for state := conn.GetState(); state == connectivity.Idle || state == connectivity.Connecting; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			break
+		}
 	}
+	durationGaugeVec.WithLabelValues("connect").Add(time.Since(checkStart).Seconds())
 
+	rpcStart := time.Now()
 	client := NewGrpcHealthCheckClient(conn)
-	defer conn.Close()
-	ok, statusCode, serverPeer, servingStatus, err := client.Check(context.Background(), module.GRPC.Service)
+	ok, statusCode, serverPeer, servingStatus, err := client.Check(ctx, module.GRPC.Service)
+	durationGaugeVec.WithLabelValues("rpc").Add(time.Since(rpcStart).Seconds())
 	durationGaugeVec.WithLabelValues("check").Add(time.Since(checkStart).Seconds())
 
 	for servingStatusName, _ := range grpc_health_v1.HealthCheckResponse_ServingStatus_value {
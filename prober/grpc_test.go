@@ -29,6 +29,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	pconfig "github.com/prometheus/common/config"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -414,3 +415,68 @@ func TestGRPCHealthCheckUnimplemented(t *testing.T) {
 
 	checkRegistryResults(expectedResults, mfs, t)
 }
+
+func TestGRPCDurationPhases(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+
+	s := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("service", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(s, healthServer)
+	go s.Serve(ln)
+	defer s.GracefulStop()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	result := ProbeGRPC(testCTX, ln.Addr().String(),
+		config.Module{Timeout: time.Second, GRPC: config.GRPCProbe{
+			PreferredIPProtocol: "ip4",
+			Service:             "service",
+		},
+		}, registry, log.NewNopLogger())
+	if !result {
+		t.Fatalf("GRPC probe failed")
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_grpc_duration_seconds": {
+			"phase": {
+				"resolve": {},
+				"connect": {},
+				"rpc":     {},
+				"check":   {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+}
+
+func TestGRPCConnectionRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if ProbeGRPC(testCTX, addr, config.Module{GRPC: config.GRPCProbe{PreferredIPProtocol: "ip4"}}, registry, log.NewNopLogger()) {
+		t.Fatalf("GRPC probe succeeded, expected failure")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_grpc_status_code": float64(codes.Unavailable)}, mfs, t)
+}
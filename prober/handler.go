@@ -16,11 +16,18 @@ package prober
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
@@ -28,6 +35,7 @@ import (
 	"github.com/prometheus/blackbox_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"gopkg.in/yaml.v2"
 )
@@ -39,67 +47,173 @@ var (
 		"icmp": ProbeICMP,
 		"dns":  ProbeDNS,
 		"grpc": ProbeGRPC,
+		"tls":  ProbeTLS,
+		"udp":  ProbeUDP,
+
+		"traceroute":    ProbeTraceroute,
+		"arp":           ProbeARP,
+		"ndp":           ProbeNDP,
+		"smtp":          ProbeSMTP,
+		"imap":          ProbeIMAP,
+		"pop3":          ProbePOP3,
+		"ssh":           ProbeSSH,
+		"ldap":          ProbeLDAP,
+		"mqtt":          ProbeMQTT,
+		"kafka":         ProbeKafka,
+		"memcached":     ProbeMemcached,
+		"postgres":      ProbePostgres,
+		"mysql":         ProbeMySQL,
+		"mongodb":       ProbeMongoDB,
+		"snmp":          ProbeSNMP,
+		"rtsp":          ProbeRTSP,
+		"modbus":        ProbeModbus,
+		"stun":          ProbeSTUN,
+		"turn":          ProbeTURN,
+		"radius":        ProbeRADIUS,
+		"dhcp":          ProbeDHCP,
+		"tftp":          ProbeTFTP,
+		"syslog":        ProbeSyslog,
+		"nats":          ProbeNATS,
+		"etcd":          ProbeEtcd,
+		"elasticsearch": ProbeElasticsearch,
+		"git":           ProbeGit,
+		"registry":      ProbeRegistry,
+		"smb":           ProbeSMB,
+		"nfs":           ProbeNFS,
+		"opcua":         ProbeOPCUA,
+		"webdav":        ProbeWebDAV,
+		"telnet":        ProbeTelnet,
+		"irc":           ProbeIRC,
+		"kubernetes":    ProbeKubernetes,
 	}
 )
 
+// ResultPusher receives the metrics of every probe, e.g. to push them to a
+// remote write endpoint.
+type ResultPusher interface {
+	Push(module, target string, mfs []*dto.MetricFamily)
+}
+
 func Handler(w http.ResponseWriter, r *http.Request, c *config.Config, logger log.Logger, rh *ResultHistory, timeoutOffset float64, params url.Values,
 	moduleUnknownCounter prometheus.Counter,
-	logLevelProber level.Option) {
+	logLevelProber level.Option, pusher ResultPusher, cache *ResultCache) {
 
 	if params == nil {
 		params = r.URL.Query()
 	}
+	pr, status, err := newProbeRequest(r, c, params, timeoutOffset, logger, logLevelProber)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		var unknown unknownModuleError
+		if errors.As(err, &unknown) && moduleUnknownCounter != nil {
+			moduleUnknownCounter.Add(1)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), pr.timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	probe := func(ctx context.Context) probeOutcome {
+		return runProbe(ctx, c, pr, logger, rh, pusher)
+	}
+	var outcome probeOutcome
+	// Debug requests always probe, as they are used to troubleshoot.
+	if cache != nil && pr.module.CacheTTL > 0 && r.URL.Query().Get("debug") != "true" {
+		start := time.Now()
+		key := cacheKey(pr.moduleName, params, r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"))
+		if outcome, err = cache.do(ctx, key, pr.moduleName, pr.module.CacheTTL, pr.timeout, probe); err != nil {
+			level.Error(logger).Log("msg", "Error waiting for the result of an identical probe", "module", pr.moduleName, "target", pr.target, "err", err)
+			outcome = failedOutcome(time.Since(start).Seconds())
+		}
+	} else {
+		outcome = probe(ctx)
+	}
+
+	if r.URL.Query().Get("debug") == "true" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(outcome.debugOutput))
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		result, err := JSONOutput(pr.moduleName, pr.target, outcome.success, outcome.duration, outcome.gatherer)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error encoding probe result: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result)
+		return
+	}
+
+	h := promhttp.HandlerFor(outcome.gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	h.ServeHTTP(w, r)
+}
+
+// unknownModuleError is the error of a probe request for a module that is not
+// configured.
+type unknownModuleError string
+
+func (e unknownModuleError) Error() string {
+	return fmt.Sprintf("Unknown module %q", string(e))
+}
+
+// probeRequest is a validated request for a probe.
+type probeRequest struct {
+	moduleName string
+	// module is a copy of the module with the parameters of the request
+	// applied.
+	module         config.Module
+	target         string
+	params         url.Values
+	prober         ProbeFn
+	timeoutSeconds float64
+	timeout        time.Duration
+	logLevel       level.Option
+	traceparent    string
+}
+
+// newProbeRequest validates the parameters of a probe request. For an
+// invalid request, it returns an error with the HTTP status to respond with.
+func newProbeRequest(r *http.Request, c *config.Config, params url.Values, timeoutOffset float64, logger log.Logger, logLevelProber level.Option) (*probeRequest, int, error) {
 	moduleName := params.Get("module")
 	if moduleName == "" {
 		moduleName = "http_2xx"
 	}
 	module, ok := c.Modules[moduleName]
 	if !ok {
-		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
 		level.Debug(logger).Log("msg", "Unknown module", "module", moduleName)
-		if moduleUnknownCounter != nil {
-			moduleUnknownCounter.Add(1)
-		}
-		return
+		return nil, http.StatusBadRequest, unknownModuleError(moduleName)
 	}
 
 	timeoutSeconds, err := getTimeout(r, module, timeoutOffset)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse timeout from Prometheus header: %s", err), http.StatusInternalServerError)
-		return
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to parse timeout from Prometheus header: %s", err)
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
-	defer cancel()
-	r = r.WithContext(ctx)
-
-	probeSuccessGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_success",
-		Help: "Displays whether or not the probe was a success",
-	})
-	probeDurationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_duration_seconds",
-		Help: "Returns how long the probe took to complete in seconds",
-	})
-
 	target := params.Get("target")
 	if target == "" {
-		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
-		return
+		return nil, http.StatusBadRequest, errors.New("Target parameter is missing")
 	}
 
 	prober, ok := Probers[module.Prober]
 	if !ok {
-		http.Error(w, fmt.Sprintf("Unknown prober %q", module.Prober), http.StatusBadRequest)
-		return
+		return nil, http.StatusBadRequest, fmt.Errorf("Unknown prober %q", module.Prober)
+	}
+
+	// The resolved addresses are checked by the probers.
+	ctx := withTargetFilters(r.Context(), &c.TargetFilter, &module.TargetFilter)
+	if err := checkTargetHostname(ctx, targetHostname(target)); err != nil {
+		level.Debug(logger).Log("msg", "Target is not allowed", "module", moduleName, "target", target)
+		return nil, http.StatusForbidden, err
 	}
 
 	hostname := params.Get("hostname")
 	if module.Prober == "http" && hostname != "" {
-		err = setHTTPHost(hostname, &module)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+		if err := setHTTPHost(hostname, &module); err != nil {
+			return nil, http.StatusBadRequest, err
 		}
 	}
 
@@ -109,15 +223,87 @@ func Handler(w http.ResponseWriter, r *http.Request, c *config.Config, logger lo
 		}
 	}
 
-	sl := newScrapeLogger(logger, moduleName, target, logLevelProber)
-	level.Info(sl).Log("msg", "Beginning probe", "probe", module.Prober, "timeout_seconds", timeoutSeconds)
+	if err := module.ApplyOverrides(params); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	if module.LogLevel != "" {
+		logLevelProber = level.Allow(level.ParseDefault(module.LogLevel, nil))
+	}
+	return &probeRequest{
+		moduleName:     moduleName,
+		module:         module,
+		target:         target,
+		params:         params,
+		prober:         prober,
+		timeoutSeconds: timeoutSeconds,
+		timeout:        time.Duration(timeoutSeconds * float64(time.Second)),
+		logLevel:       logLevelProber,
+		traceparent:    r.Header.Get("traceparent"),
+	}, 0, nil
+}
+
+// newProbeGauges returns the gauges every probe exports.
+func newProbeGauges() (success, duration prometheus.Gauge) {
+	success = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Displays whether or not the probe was a success",
+	})
+	duration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Returns how long the probe took to complete in seconds",
+	})
+	return success, duration
+}
+
+// failedOutcome is the outcome of a probe that did not run.
+func failedOutcome(duration float64) probeOutcome {
+	probeSuccessGauge, probeDurationGauge := newProbeGauges()
+	probeDurationGauge.Set(duration)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(probeSuccessGauge, probeDurationGauge)
+	return probeOutcome{gatherer: registry, duration: duration}
+}
+
+// runProbe runs the probe of the request on ctx and returns its outcome,
+// which is added to the history and pushed to pusher if it is not nil.
+func runProbe(ctx context.Context, c *config.Config, pr *probeRequest, logger log.Logger, rh *ResultHistory, pusher ResultPusher) probeOutcome {
+	module, moduleName, target := pr.module, pr.moduleName, pr.target
+	ctx = withTargetFilters(ctx, &c.TargetFilter, &module.TargetFilter)
+	id := rh.NewId()
+	sl := newScrapeLogger(logger, moduleName, target, id, pr.logLevel)
+	level.Info(sl).Log("msg", "Beginning probe", "probe", module.Prober, "timeout_seconds", pr.timeoutSeconds)
 
+	moduleProbesInFlight.WithLabelValues(moduleName).Inc()
 	start := time.Now()
+	probeSuccessGauge, probeDurationGauge := newProbeGauges()
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(probeSuccessGauge)
 	registry.MustRegister(probeDurationGauge)
-	success := prober(ctx, target, module, registry, sl)
+	success := false
+	release, err := acquireModuleSlot(ctx, moduleName, module.MaxConcurrent)
+	if err != nil {
+		level.Error(sl).Log("msg", "Too many concurrent probes of the module", "max_concurrent", module.MaxConcurrent, "err", err)
+		probeConcurrencyLimited := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_concurrency_limited",
+			Help: "Indicates that the probe was not run because max_concurrent probes of the module were running",
+		})
+		registry.MustRegister(probeConcurrencyLimited)
+		probeConcurrencyLimited.Set(1)
+	} else if err := module.LoadSecretFiles(); err != nil {
+		// The module is a copy, so the secrets are not stored in the configuration.
+		level.Error(sl).Log("msg", "Error loading secrets", "err", err)
+	} else {
+		success = pr.prober(ctx, target, module, registry, sl)
+	}
+	release()
 	duration := time.Since(start).Seconds()
+	moduleProbesInFlight.WithLabelValues(moduleName).Dec()
+	moduleProbes.WithLabelValues(moduleName, strconv.FormatBool(success)).Inc()
+	moduleProbeDuration.WithLabelValues(moduleName).Observe(duration)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		moduleProbeTimeouts.WithLabelValues(moduleName).Inc()
+	}
 	probeDurationGauge.Set(duration)
 	if success {
 		probeSuccessGauge.Set(1)
@@ -126,17 +312,24 @@ func Handler(w http.ResponseWriter, r *http.Request, c *config.Config, logger lo
 		level.Error(sl).Log("msg", "Probe failed", "duration_seconds", duration)
 	}
 
-	debugOutput := DebugOutput(&module, &sl.buffer, registry)
-	rh.Add(moduleName, target, debugOutput, success)
-
-	if r.URL.Query().Get("debug") == "true" {
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte(debugOutput))
-		return
+	var gatherer prometheus.Gatherer = registry
+	if labels := paramLabels(c.LabelParams, pr.params); len(labels) > 0 {
+		gatherer = labelGatherer{gatherer: registry, labels: labels}
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	debugOutput := DebugOutput(&module, &sl.buffer, gatherer)
+	rh.AddWithId(id, moduleName, target, debugOutput, success, time.Duration(duration*float64(time.Second)))
+	if c.Exemplars {
+		observeProbeDuration(registry, duration, id, pr.traceparent)
+	}
+	if pusher != nil {
+		if mfs, err := gatherer.Gather(); err == nil {
+			pusher.Push(moduleName, target, mfs)
+		} else {
+			level.Error(logger).Log("msg", "Error gathering metrics to push", "err", err)
+		}
+	}
+	return probeOutcome{gatherer: gatherer, success: success, duration: duration, debugOutput: debugOutput}
 }
 
 func setHTTPHost(hostname string, module *config.Module) error {
@@ -156,6 +349,111 @@ func setHTTPHost(hostname string, module *config.Module) error {
 	return nil
 }
 
+// paramLabels returns the /probe parameters listed in label_params as label
+// pairs. Parameters that are not set are omitted.
+func paramLabels(names []string, params url.Values) []*dto.LabelPair {
+	var labels []*dto.LabelPair
+	for _, name := range names {
+		name, value := name, params.Get(name)
+		if value != "" {
+			labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+		}
+	}
+	return labels
+}
+
+// labelGatherer adds labels to all metrics gathered from a probe. A label
+// that a metric already has is not overwritten.
+type labelGatherer struct {
+	gatherer prometheus.Gatherer
+	labels   []*dto.LabelPair
+}
+
+func (g labelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.gatherer.Gather()
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+		LABELS:
+			for _, label := range g.labels {
+				for _, l := range m.Label {
+					if l.GetName() == label.GetName() {
+						continue LABELS
+					}
+				}
+				m.Label = append(m.Label, label)
+			}
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return mfs, err
+}
+
+// moduleQueueTimeout is how long a probe waits for one of the max_concurrent
+// probes of its module to finish.
+const moduleQueueTimeout = time.Second
+
+var (
+	moduleSlotsMtx sync.Mutex
+	moduleSlots    = map[string]chan struct{}{}
+)
+
+// The metrics of the probes of each module are exported on the /metrics
+// endpoint of the exporter to plan its capacity. They count every probe that
+// runs, whether for /probe, /probe_batch or scheduled_probes, but not the
+// requests served from the cache.
+var (
+	moduleProbes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackbox_module_probes_total",
+		Help: "Count of probes run per module and outcome",
+	}, []string{"module", "success"})
+	moduleProbesInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "blackbox_module_probes_in_flight",
+		Help: "Number of probes of the module currently running",
+	}, []string{"module"})
+	moduleProbeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "blackbox_module_probe_duration_seconds",
+		Help:    "Duration of the probes of the module",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"module"})
+	moduleProbeTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blackbox_module_probe_timeouts_total",
+		Help: "Count of probes of the module that ran until their timeout",
+	}, []string{"module"})
+)
+
+// ModuleCollectors returns the collectors of the per-module probe metrics,
+// to be registered with the registry of the exporter.
+func ModuleCollectors() []prometheus.Collector {
+	return []prometheus.Collector{moduleProbes, moduleProbesInFlight, moduleProbeDuration, moduleProbeTimeouts}
+}
+
+// acquireModuleSlot waits until fewer than limit probes of the module are
+// running and returns a function that releases the slot. A limit of 0 means
+// no limit.
+func acquireModuleSlot(ctx context.Context, module string, limit int) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	moduleSlotsMtx.Lock()
+	slots, ok := moduleSlots[module]
+	if !ok || cap(slots) != limit {
+		// Probes that are running when the limit changes release their slots
+		// into the previous channel.
+		slots = make(chan struct{}, limit)
+		moduleSlots[module] = slots
+	}
+	moduleSlotsMtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, moduleQueueTimeout)
+	defer cancel()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return func() {}, ctx.Err()
+	}
+}
+
 type scrapeLogger struct {
 	next         log.Logger
 	buffer       bytes.Buffer
@@ -163,15 +461,15 @@ type scrapeLogger struct {
 	logLevel     level.Option
 }
 
-func newScrapeLogger(logger log.Logger, module string, target string, logLevel level.Option) *scrapeLogger {
-	logger = log.With(logger, "module", module, "target", target)
+func newScrapeLogger(logger log.Logger, module string, target string, id int64, logLevel level.Option) *scrapeLogger {
+	logger = log.With(logger, "module", module, "target", target, "probe_id", id)
 	sl := &scrapeLogger{
 		next:     logger,
 		buffer:   bytes.Buffer{},
 		logLevel: logLevel,
 	}
 	bl := log.NewLogfmtLogger(&sl.buffer)
-	sl.bufferLogger = log.With(bl, "ts", log.DefaultTimestampUTC, "caller", log.Caller(6), "module", module, "target", target)
+	sl.bufferLogger = log.With(bl, "ts", log.DefaultTimestampUTC, "caller", log.Caller(6), "module", module, "target", target, "probe_id", id)
 	return sl
 }
 
@@ -182,7 +480,7 @@ func (sl scrapeLogger) Log(keyvals ...interface{}) error {
 }
 
 // DebugOutput returns plaintext debug output for a probe.
-func DebugOutput(module *config.Module, logBuffer *bytes.Buffer, registry *prometheus.Registry) string {
+func DebugOutput(module *config.Module, logBuffer *bytes.Buffer, registry prometheus.Gatherer) string {
 	buf := &bytes.Buffer{}
 	fmt.Fprintf(buf, "Logs for the probe:\n")
 	logBuffer.WriteTo(buf)
@@ -204,6 +502,86 @@ func DebugOutput(module *config.Module, logBuffer *bytes.Buffer, registry *prome
 	return buf.String()
 }
 
+// observeProbeDuration exports the duration of the probe as a histogram with
+// an exemplar that holds the id of the probe in the history and, if the
+// scrape is traced, the W3C trace id of the scrape. Exemplars are only
+// exposed in the OpenMetrics format.
+func observeProbeDuration(registry *prometheus.Registry, duration float64, id int64, traceparent string) {
+	probeDurationHistogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "probe_duration_histogram_seconds",
+		Help:    "Duration of the probe, with the probe id and trace id as exemplar",
+		Buckets: prometheus.DefBuckets,
+	})
+	registry.MustRegister(probeDurationHistogram)
+	exemplar := prometheus.Labels{"probe_id": strconv.FormatInt(id, 10)}
+	// The header has the form version-trace_id-parent_id-flags.
+	if parts := strings.Split(traceparent, "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		if _, err := hex.DecodeString(parts[1]); err == nil {
+			exemplar["trace_id"] = parts[1]
+		}
+	}
+	probeDurationHistogram.(prometheus.ExemplarObserver).ObserveWithExemplar(duration, exemplar)
+}
+
+// probeResult is the result of a probe in the JSON output format.
+type probeResult struct {
+	Module          string         `json:"module"`
+	Target          string         `json:"target"`
+	Success         bool           `json:"success"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Metrics         []metricSample `json:"metrics"`
+}
+
+type metricSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// JSONOutput returns the result of a probe and the values of all its
+// metrics, which include the durations of its phases, as JSON.
+func JSONOutput(module, target string, success bool, duration float64, registry prometheus.Gatherer) ([]byte, error) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	result := probeResult{
+		Module:          module,
+		Target:          target,
+		Success:         success,
+		DurationSeconds: duration,
+		Metrics:         []metricSample{},
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			var value float64
+			switch {
+			case m.Gauge != nil:
+				value = m.Gauge.GetValue()
+			case m.Counter != nil:
+				value = m.Counter.GetValue()
+			case m.Untyped != nil:
+				value = m.Untyped.GetValue()
+			default:
+				continue
+			}
+			// JSON cannot represent NaN and infinite values.
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			sample := metricSample{Name: mf.GetName(), Value: value}
+			if len(m.Label) > 0 {
+				sample.Labels = make(map[string]string, len(m.Label))
+				for _, l := range m.Label {
+					sample.Labels[l.GetName()] = l.GetValue()
+				}
+			}
+			result.Metrics = append(result.Metrics, sample)
+		}
+	}
+	return json.Marshal(result)
+}
+
 func getTimeout(r *http.Request, module config.Module, offset float64) (timeoutSeconds float64, err error) {
 	// If a timeout is configured via the Prometheus header, add it to the request.
 	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
@@ -212,13 +590,21 @@ func getTimeout(r *http.Request, module config.Module, offset float64) (timeoutS
 		if err != nil {
 			return 0, err
 		}
+		if !(timeoutSeconds >= 0) {
+			return 0, fmt.Errorf("invalid scrape timeout %v", timeoutSeconds)
+		}
 	}
 	if timeoutSeconds == 0 {
 		timeoutSeconds = 120
 	}
 
 	var maxTimeoutSeconds = timeoutSeconds - offset
-	if module.Timeout.Seconds() < maxTimeoutSeconds && module.Timeout.Seconds() > 0 || maxTimeoutSeconds < 0 {
+	// An offset that exceeds the scrape timeout would leave no time for the
+	// probe, so the scrape timeout is used in full rather than exceeded.
+	if maxTimeoutSeconds <= 0 {
+		maxTimeoutSeconds = timeoutSeconds
+	}
+	if module.Timeout.Seconds() < maxTimeoutSeconds && module.Timeout.Seconds() > 0 {
 		timeoutSeconds = module.Timeout.Seconds()
 	} else {
 		timeoutSeconds = maxTimeoutSeconds
@@ -15,6 +15,7 @@ package prober
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -27,6 +28,8 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	pconfig "github.com/prometheus/common/config"
 
 	"github.com/prometheus/blackbox_exporter/config"
@@ -60,7 +63,7 @@ func TestPrometheusTimeoutHTTP(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
 	})
 
 	handler.ServeHTTP(rr, req)
@@ -82,7 +85,7 @@ func TestPrometheusConfigSecretsHidden(t *testing.T) {
 	}
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
 	})
 	handler.ServeHTTP(rr, req)
 
@@ -126,6 +129,8 @@ func TestTimeoutIsSetCorrectly(t *testing.T) {
 		{9500 * time.Millisecond, "", 1, 9.5},
 		{0 * time.Second, "", 0.5, 119.5},
 		{0 * time.Second, "", 0, 120},
+		{5 * time.Second, "0.4", 0.5, 0.4},
+		{0 * time.Second, "0.5", 0.5, 0.5},
 	}
 
 	for _, v := range tests {
@@ -142,6 +147,16 @@ func TestTimeoutIsSetCorrectly(t *testing.T) {
 	}
 }
 
+func TestInvalidPrometheusTimeout(t *testing.T) {
+	for _, header := range []string{"-1", "NaN", "15s"} {
+		request, _ := http.NewRequest("GET", "", nil)
+		request.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", header)
+		if _, err := getTimeout(request, config.Module{}, 0.5); err == nil {
+			t.Errorf("Expected error for scrape timeout %q", header)
+		}
+	}
+}
+
 func TestHostnameParam(t *testing.T) {
 	headers := map[string]string{}
 	c := &config.Config{
@@ -178,7 +193,7 @@ func TestHostnameParam(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
 	})
 
 	handler.ServeHTTP(rr, req)
@@ -196,7 +211,7 @@ func TestHostnameParam(t *testing.T) {
 	c.Modules["http_2xx"].HTTP.Headers["Host"] = hostname + ".something"
 
 	handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
 	})
 
 	rr = httptest.NewRecorder()
@@ -243,7 +258,7 @@ func TestTCPHostnameParam(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+		Handler(w, r, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
 	})
 
 	handler.ServeHTTP(rr, req)
@@ -258,3 +273,355 @@ func TestTCPHostnameParam(t *testing.T) {
 	}
 
 }
+
+func TestOverrideParams(t *testing.T) {
+	c := &config.Config{
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:  "http",
+				Timeout: 10 * time.Second,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+			"http_override": {
+				Prober:           "http",
+				Timeout:          10 * time.Second,
+				HTTP:             config.HTTPProbe{IPProtocolFallback: true},
+				AllowedOverrides: []string{"valid_status_codes"},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		params  string
+		status  int
+		success string
+	}{
+		{params: "module=http_override", status: http.StatusOK, success: "probe_success 0"},
+		{params: "module=http_override&valid_status_codes=200,404", status: http.StatusOK, success: "probe_success 1"},
+		{params: "module=http_override&valid_status_codes=ok", status: http.StatusBadRequest},
+		{params: "module=http_2xx&valid_status_codes=404", status: http.StatusBadRequest},
+		// Fields of other probers are not overrides of an HTTP module.
+		{params: "module=http_2xx&query_type=A", status: http.StatusOK, success: "probe_success 0"},
+	} {
+		req, err := http.NewRequest("GET", "?"+tc.params+"&target="+ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		Handler(rr, req, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
+		if rr.Code != tc.status {
+			t.Errorf("%s: probe request handler returned wrong status code: %v, want %v", tc.params, rr.Code, tc.status)
+		}
+		if tc.success != "" && !strings.Contains(rr.Body.String(), tc.success) {
+			t.Errorf("%s: expected %q, response body: %v", tc.params, tc.success, rr.Body.String())
+		}
+	}
+}
+
+func TestLabelParams(t *testing.T) {
+	c := &config.Config{
+		LabelParams: []string{"team", "env"},
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:  "http",
+				Timeout: 10 * time.Second,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", "?team=db&owner=ops&target="+ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	Handler(rr, req, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("probe request handler returned wrong status code: %v, want %v", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	// Parameters that are not set and those not listed are not added.
+	for _, expected := range []string{`probe_success{team="db"} 1`, `probe_http_duration_seconds{phase="connect",team="db"}`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %s, response body: %v", expected, body)
+		}
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	c := &config.Config{
+		Modules: map[string]config.Module{
+			"http_limited": {
+				Prober:        "http",
+				Timeout:       10 * time.Second,
+				HTTP:          config.HTTPProbe{IPProtocolFallback: true},
+				MaxConcurrent: 1,
+			},
+		},
+	}
+
+	received := make(chan struct{})
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-unblock
+	}))
+	defer ts.Close()
+
+	probe := func() string {
+		req, err := http.NewRequest("GET", "?module=http_limited&target="+ts.URL, nil)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		rr := httptest.NewRecorder()
+		Handler(rr, req, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
+		return rr.Body.String()
+	}
+
+	first := make(chan string)
+	go func() { first <- probe() }()
+	<-received
+
+	// The second probe gives up waiting for the first one.
+	if body := probe(); !strings.Contains(body, "probe_concurrency_limited 1") || !strings.Contains(body, "probe_success 0") {
+		t.Fatalf("Expected the probe to be limited, response body: %v", body)
+	}
+	close(unblock)
+	if body := <-first; !strings.Contains(body, "probe_success 1") {
+		t.Fatalf("Expected the first probe to succeed, response body: %v", body)
+	}
+
+	// The slot is free again.
+	go func() { <-received }()
+	if body := probe(); !strings.Contains(body, "probe_success 1") {
+		t.Fatalf("Expected the probe to succeed, response body: %v", body)
+	}
+}
+
+func TestModuleMetrics(t *testing.T) {
+	c := &config.Config{
+		Modules: map[string]config.Module{
+			"http_module_metrics": {
+				Prober:  "http",
+				Timeout: 100 * time.Millisecond,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(time.Second)
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/", "/", "/slow"} {
+		req := httptest.NewRequest("GET", "/probe?module=http_module_metrics&target="+ts.URL+path, nil)
+		Handler(httptest.NewRecorder(), req, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
+	}
+
+	if n := testutil.ToFloat64(moduleProbes.WithLabelValues("http_module_metrics", "true")); n != 2 {
+		t.Errorf("Expected 2 successful probes, got %v", n)
+	}
+	if n := testutil.ToFloat64(moduleProbes.WithLabelValues("http_module_metrics", "false")); n != 1 {
+		t.Errorf("Expected 1 failed probe, got %v", n)
+	}
+	if n := testutil.ToFloat64(moduleProbeTimeouts.WithLabelValues("http_module_metrics")); n != 1 {
+		t.Errorf("Expected 1 probe to time out, got %v", n)
+	}
+	if n := testutil.ToFloat64(moduleProbesInFlight.WithLabelValues("http_module_metrics")); n != 0 {
+		t.Errorf("Expected no probes in flight, got %v", n)
+	}
+	m := &dto.Metric{}
+	if err := moduleProbeDuration.WithLabelValues("http_module_metrics").(prometheus.Histogram).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.GetHistogram().GetSampleCount(); n != 3 {
+		t.Errorf("Expected 3 observed durations, got %v", n)
+	}
+}
+
+func TestDebugOutputIncludesProbeLog(t *testing.T) {
+	c := &config.Config{
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:  "http",
+				Timeout: 10 * time.Second,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", "?debug=true&target="+ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	// Probe logs are not emitted, but still part of the debug output.
+	Handler(rr, req, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
+	for _, expected := range []string{
+		`msg="Resolving target address"`,
+		`msg="Making HTTP request" method=GET`,
+		`msg="Received HTTP response" status_code=404`,
+		`level=debug`,
+		`msg="Read HTTP response body" bytes=5`,
+		`msg="Invalid HTTP response status code, wanted 2xx"`,
+		"probe_success 0",
+		"prober: http",
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected %s in debug output: %v", expected, rr.Body.String())
+		}
+	}
+}
+
+func TestModuleLogLevel(t *testing.T) {
+	c := &config.Config{
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:   "http",
+				Timeout:  10 * time.Second,
+				HTTP:     config.HTTPProbe{IPProtocolFallback: true},
+				LogLevel: "debug",
+			},
+			"http_quiet": {
+				Prober:  "http",
+				Timeout: 10 * time.Second,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	rh := &ResultHistory{MaxResults: 10}
+	var buf bytes.Buffer
+	logger := log.NewJSONLogger(log.NewSyncWriter(&buf))
+	for _, module := range []string{"http_quiet", "http_2xx"} {
+		req := httptest.NewRequest("GET", "/probe?module="+module+"&target="+ts.URL, nil)
+		Handler(httptest.NewRecorder(), req, c, logger, rh, 0.5, nil, nil, level.AllowError(), nil, nil)
+	}
+
+	// Only the module with a log level override logs below --log.prober.
+	var lines int
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Unexpected log line %q: %s", line, err)
+		}
+		// The probe id refers to the probe in the history.
+		if entry["module"] != "http_2xx" || entry["target"] == nil || entry["probe_id"] != float64(1) {
+			t.Errorf("Unexpected log line %q", line)
+		}
+		lines++
+	}
+	if lines == 0 {
+		t.Fatal("Expected debug logs of the module")
+	}
+	if result := rh.GetById(1); result == nil || result.ModuleName != "http_2xx" {
+		t.Errorf("Unexpected result for probe id 1: %v", result)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	c := &config.Config{
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:  "http",
+				Timeout: 10 * time.Second,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", "?format=json&target="+ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	Handler(rr, req, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Unexpected content type %q", ct)
+	}
+	var result probeResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Error decoding %s: %s", rr.Body.String(), err)
+	}
+	if result.Module != "http_2xx" || result.Target != ts.URL || !result.Success || result.DurationSeconds <= 0 {
+		t.Fatalf("Unexpected probe result %+v", result)
+	}
+	values := map[string]float64{}
+	for _, m := range result.Metrics {
+		values[m.Name+m.Labels["phase"]] = m.Value
+	}
+	if values["probe_success"] != 1 || values["probe_http_status_code"] != 200 {
+		t.Errorf("Unexpected metrics %v", values)
+	}
+	if _, ok := values["probe_http_duration_secondsconnect"]; !ok {
+		t.Errorf("Missing connect phase in metrics %v", values)
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	c := &config.Config{
+		Exemplars: true,
+		Modules: map[string]config.Module{
+			"http_2xx": {
+				Prober:  "http",
+				Timeout: 10 * time.Second,
+				HTTP:    config.HTTPProbe{IPProtocolFallback: true},
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	rh := &ResultHistory{MaxResults: 10}
+	for _, tc := range []struct {
+		accept   string
+		exemplar []string
+	}{
+		// The order of the exemplar labels is not defined.
+		{accept: "application/openmetrics-text; version=1.0.0", exemplar: []string{`probe_id="0"`, `trace_id="4bf92f3577b34da6a3ce929d0e0e4736"`}},
+		// Exemplars are not part of the text format.
+		{accept: "text/plain; version=0.0.4"},
+	} {
+		req, err := http.NewRequest("GET", "?target="+ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", tc.accept)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		rr := httptest.NewRecorder()
+		Handler(rr, req, c, log.NewNopLogger(), rh, 0.5, nil, nil, level.AllowNone(), nil, nil)
+		body := rr.Body.String()
+		if !strings.Contains(body, "probe_duration_histogram_seconds_count 1") {
+			t.Fatalf("Expected the duration histogram, response body: %v", body)
+		}
+		for _, label := range tc.exemplar {
+			if !strings.Contains(body, label) {
+				t.Errorf("Expected exemplar label %s, response body: %v", label, body)
+			}
+		}
+		if tc.exemplar == nil && strings.Contains(body, "probe_id") {
+			t.Errorf("Unexpected exemplar in response body: %v", body)
+		}
+	}
+}
@@ -15,6 +15,7 @@ package prober
 
 import (
 	"sync"
+	"time"
 )
 
 // Result contains the result of the execution of a probe
@@ -24,6 +25,9 @@ type Result struct {
 	Target      string
 	DebugOutput string
 	Success     bool
+	// Timestamp is the time the probe finished.
+	Timestamp time.Time
+	Duration  time.Duration
 }
 
 // ResultHistory contains two history slices: `results` contains most recent `maxResults` results.
@@ -37,19 +41,38 @@ type ResultHistory struct {
 	MaxResults             uint
 }
 
-// Add a result to the history.
-func (rh *ResultHistory) Add(moduleName, target, debugOutput string, success bool) {
+// Add a result to the history and return its id.
+func (rh *ResultHistory) Add(moduleName, target, debugOutput string, success bool, duration time.Duration) int64 {
+	id := rh.NewId()
+	rh.AddWithId(id, moduleName, target, debugOutput, success, duration)
+	return id
+}
+
+// NewId reserves the id of a probe before it runs, so that its logs can refer
+// to it. The result is added with AddWithId.
+func (rh *ResultHistory) NewId() int64 {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	id := rh.nextId
+	rh.nextId++
+	return id
+}
+
+// AddWithId adds the result of the probe with an id returned by NewId.
+func (rh *ResultHistory) AddWithId(id int64, moduleName, target, debugOutput string, success bool, duration time.Duration) {
 	rh.mu.Lock()
 	defer rh.mu.Unlock()
 
 	r := &Result{
-		Id:          rh.nextId,
+		Id:          id,
 		ModuleName:  moduleName,
 		Target:      target,
 		DebugOutput: debugOutput,
 		Success:     success,
+		Timestamp:   time.Now(),
+		Duration:    duration,
 	}
-	rh.nextId++
 
 	rh.results = append(rh.results, r)
 	if uint(len(rh.results)) > rh.MaxResults {
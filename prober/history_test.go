@@ -16,12 +16,13 @@ package prober
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestHistoryKeepsLatestResults(t *testing.T) {
 	history := &ResultHistory{MaxResults: 3}
 	for i := 0; i < 4; i++ {
-		history.Add("module", "target", fmt.Sprintf("result %d", i), true)
+		history.Add("module", "target", fmt.Sprintf("result %d", i), true, time.Second)
 	}
 
 	savedResults := history.List()
@@ -34,13 +35,13 @@ func TestHistoryKeepsLatestResults(t *testing.T) {
 
 func FillHistoryWithMaxSuccesses(h *ResultHistory) {
 	for i := uint(0); i < h.MaxResults; i++ {
-		h.Add("module", "target", fmt.Sprintf("result %d", h.nextId), true)
+		h.Add("module", "target", fmt.Sprintf("result %d", h.nextId), true, time.Second)
 	}
 }
 
 func FillHistoryWithMaxPreservedFailures(h *ResultHistory) {
 	for i := uint(0); i < h.MaxResults; i++ {
-		h.Add("module", "target", fmt.Sprintf("result %d", h.nextId), false)
+		h.Add("module", "target", fmt.Sprintf("result %d", h.nextId), false, time.Second)
 	}
 }
 
@@ -83,8 +84,8 @@ func TestHistoryPreservesExpiredFailedResults(t *testing.T) {
 func TestHistoryGetById(t *testing.T) {
 	history := &ResultHistory{MaxResults: 2}
 
-	history.Add("module", "target-0", fmt.Sprintf("result %d", history.nextId), true)
-	history.Add("module", "target-1", fmt.Sprintf("result %d", history.nextId), false)
+	history.Add("module", "target-0", fmt.Sprintf("result %d", history.nextId), true, time.Second)
+	history.Add("module", "target-1", fmt.Sprintf("result %d", history.nextId), false, time.Second)
 
 	// Get a Result object for a target that exists
 	resultTrue := history.GetById(0)
@@ -114,8 +115,8 @@ func TestHistoryGetById(t *testing.T) {
 func TestHistoryGetByTarget(t *testing.T) {
 	history := &ResultHistory{MaxResults: 2}
 
-	history.Add("module", "target-0", fmt.Sprintf("result %d", history.nextId), true)
-	history.Add("module", "target-1", fmt.Sprintf("result %d", history.nextId), false)
+	history.Add("module", "target-0", fmt.Sprintf("result %d", history.nextId), true, time.Second)
+	history.Add("module", "target-1", fmt.Sprintf("result %d", history.nextId), false, time.Second)
 
 	// Get a Result object for a target that exists
 	resultTrue := history.GetByTarget("target-0")
@@ -141,3 +142,16 @@ func TestHistoryGetByTarget(t *testing.T) {
 		t.Errorf("Error finding the result in history by target for target-5")
 	}
 }
+
+func TestHistoryRecordsTiming(t *testing.T) {
+	history := &ResultHistory{MaxResults: 1}
+	before := time.Now()
+	history.Add("module", "target", "result", true, 1500*time.Millisecond)
+	r := history.GetById(0)
+	if r.Duration != 1500*time.Millisecond {
+		t.Errorf("Unexpected duration %v", r.Duration)
+	}
+	if r.Timestamp.Before(before) || r.Timestamp.After(time.Now()) {
+		t.Errorf("Unexpected timestamp %v", r.Timestamp)
+	}
+}
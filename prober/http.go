@@ -151,7 +151,7 @@ func newTransport(rt, noServerName http.RoundTripper, logger log.Logger) *transp
 
 // RoundTrip switches to a new trace, then runs embedded RoundTripper.
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	level.Info(t.logger).Log("msg", "Making HTTP request", "url", req.URL.String(), "host", req.Host)
+	level.Info(t.logger).Log("msg", "Making HTTP request", "method", req.Method, "url", req.URL.String(), "host", req.Host)
 
 	trace := &roundTripTrace{}
 	if req.URL.Scheme == "https" {
@@ -353,14 +353,33 @@ func ProbeHTTP(ctx context.Context, target string, module config.Module, registr
 			}
 		}
 	}
-	client, err := pconfig.NewClientFromConfig(httpClientConfig, "http_probe", pconfig.WithKeepAlivesDisabled())
+	clientOpts := []pconfig.HTTPClientOption{pconfig.WithKeepAlivesDisabled()}
+	var dstIP net.IP
+	if ip != nil {
+		dstIP = ip.IP
+	}
+	srcIP, err := chooseSourceIP(module.HTTP.SourceIPAddress, module.HTTP.SourceInterface, dstIP, logger)
+	if err != nil {
+		return false
+	}
+	if srcIP != nil || hasTargetFilters(ctx) {
+		// Connections to the hosts of redirects are checked as well.
+		dialer := &net.Dialer{Control: targetFilterControl(ctx, registry)}
+		if srcIP != nil {
+			level.Info(logger).Log("msg", "Using local address", "srcIP", srcIP)
+			dialer.LocalAddr = &net.TCPAddr{IP: srcIP}
+		}
+		clientOpts = append(clientOpts, pconfig.WithDialContextFunc(dialer.DialContext))
+	}
+
+	client, err := pconfig.NewClientFromConfig(httpClientConfig, "http_probe", clientOpts...)
 	if err != nil {
 		level.Error(logger).Log("msg", "Error generating HTTP client", "err", err)
 		return false
 	}
 
 	httpClientConfig.TLSConfig.ServerName = ""
-	noServerName, err := pconfig.NewRoundTripperFromConfig(httpClientConfig, "http_probe", pconfig.WithKeepAlivesDisabled())
+	noServerName, err := pconfig.NewRoundTripperFromConfig(httpClientConfig, "http_probe", clientOpts...)
 	if err != nil {
 		level.Error(logger).Log("msg", "Error generating HTTP client without ServerName", "err", err)
 		return false
@@ -385,6 +404,10 @@ func ProbeHTTP(ctx context.Context, target string, module config.Module, registr
 			level.Info(logger).Log("msg", "Not following redirect")
 			return errors.New("don't follow redirects")
 		}
+		if err := checkTargetHostname(ctx, r.URL.Hostname()); err != nil {
+			level.Error(logger).Log("msg", "Not following redirect", "err", err)
+			return err
+		}
 		return nil
 	}
 
@@ -477,7 +500,7 @@ func ProbeHTTP(ctx context.Context, target string, module config.Module, registr
 	} else {
 		requestErrored := (err != nil)
 
-		level.Info(logger).Log("msg", "Received HTTP response", "status_code", resp.StatusCode)
+		level.Info(logger).Log("msg", "Received HTTP response", "status_code", resp.StatusCode, "proto", resp.Proto, "content_length", resp.ContentLength)
 		if len(httpConfig.ValidStatusCodes) != 0 {
 			for _, code := range httpConfig.ValidStatusCodes {
 				if resp.StatusCode == code {
@@ -556,6 +579,7 @@ func ProbeHTTP(ctx context.Context, target string, module config.Module, registr
 			}
 
 			respBodyBytes = byteCounter.n
+			level.Debug(logger).Log("msg", "Read HTTP response body", "bytes", respBodyBytes)
 
 			if err := byteCounter.Close(); err != nil {
 				// We have already read everything we could from the server, maybe even uncompressed the
@@ -29,6 +29,7 @@ import (
 	"net/textproto"
 	"net/url"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -767,6 +768,29 @@ func TestPost(t *testing.T) {
 	}
 }
 
+func TestSourceIPAddress(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skipping; binding to 127.0.0.2 requires the Linux loopback setup")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if host != "127.0.0.2" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result := ProbeHTTP(testCTX, ts.URL,
+		config.Module{Timeout: time.Second, HTTP: config.HTTPProbe{IPProtocolFallback: true, SourceIPAddress: "127.0.0.2"}}, registry, log.NewNopLogger())
+	if !result {
+		t.Fatalf("HTTP probe with source_ip_address failed unexpectedly")
+	}
+}
+
 func TestBasicAuth(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	}))
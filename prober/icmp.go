@@ -16,6 +16,7 @@ package prober
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"math/rand"
 	"net"
 	"os"
@@ -63,6 +64,26 @@ func getICMPSequence() uint16 {
 	return icmpSequence
 }
 
+// addrIP returns the IP address of a peer of an ICMP socket.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// icmpEchoID returns the identifier configured for the module, or the one of
+// the process if none is.
+func icmpEchoID(identifier int) int {
+	if identifier > 0 {
+		return identifier
+	}
+	return icmpID
+}
+
 func ProbeICMP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
 	var (
 		requestType     icmp.Type
@@ -80,13 +101,27 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 			Name: "probe_icmp_reply_hop_limit",
 			Help: "Replied packet hop limit (TTL for ipv4)",
 		})
+
+		addrMismatchGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_icmp_reply_addr_mismatch",
+			Help: "Whether a reply to an echo request came from another address than the target",
+		})
+
+		privilegedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_icmp_privileged_socket",
+			Help: "Whether a raw socket (1) or an unprivileged datagram socket (0) was used",
+		})
 	)
 
+	if module.ICMP.Timestamp {
+		return probeICMPTimestamp(ctx, target, module, registry, logger)
+	}
+
 	for _, lv := range []string{"resolve", "setup", "rtt"} {
 		durationGaugeVec.WithLabelValues(lv)
 	}
 
-	registry.MustRegister(durationGaugeVec)
+	registry.MustRegister(durationGaugeVec, addrMismatchGauge)
 
 	dstIPAddr, lookupTime, err := chooseProtocol(ctx, module.ICMP.IPProtocol, module.ICMP.IPProtocolFallback, target, registry, logger)
 
@@ -96,12 +131,11 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 	}
 	durationGaugeVec.WithLabelValues("resolve").Add(lookupTime)
 
-	var srcIP net.IP
-	if len(module.ICMP.SourceIPAddress) > 0 {
-		if srcIP = net.ParseIP(module.ICMP.SourceIPAddress); srcIP == nil {
-			level.Error(logger).Log("msg", "Error parsing source ip address", "srcIP", module.ICMP.SourceIPAddress)
-			return false
-		}
+	srcIP, err := chooseSourceIP(module.ICMP.SourceIPAddress, module.ICMP.SourceInterface, dstIPAddr.IP, logger)
+	if err != nil {
+		return false
+	}
+	if srcIP != nil {
 		level.Info(logger).Log("msg", "Using source address", "srcIP", srcIP)
 	}
 
@@ -198,40 +232,73 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 		}
 	}
 
+	if module.ICMP.DSCP > 0 && icmpConn != nil {
+		if c4 := icmpConn.IPv4PacketConn(); c4 != nil {
+			err = c4.SetTOS(module.ICMP.DSCP << 2)
+		} else {
+			err = icmpConn.IPv6PacketConn().SetTrafficClass(module.ICMP.DSCP << 2)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "Error setting DSCP", "err", err)
+			return
+		}
+	}
+
+	if privileged {
+		privilegedGauge.Set(1)
+	}
+	registry.MustRegister(privilegedGauge)
+	level.Debug(logger).Log("msg", "Using ICMP socket", "privileged", privileged)
+
 	var dst net.Addr = dstIPAddr
 	if !privileged {
 		dst = &net.UDPAddr{IP: dstIPAddr.IP, Zone: dstIPAddr.Zone}
 	}
 
-	var data []byte
-	if module.ICMP.PayloadSize != 0 {
-		data = make([]byte, module.ICMP.PayloadSize)
-		copy(data, "Prometheus Blackbox Exporter")
-	} else {
-		data = []byte("Prometheus Blackbox Exporter")
-	}
+	data := icmpPayload(module.ICMP.PayloadSize, module.ICMP.PayloadPattern)
 
-	body := &icmp.Echo{
-		ID:   icmpID,
-		Seq:  int(getICMPSequence()),
-		Data: data,
-	}
-	level.Info(logger).Log("msg", "Creating ICMP packet", "seq", body.Seq, "id", body.ID)
-	wm := icmp.Message{
-		Type: requestType,
-		Code: 0,
-		Body: body,
+	// Unprivileged cannot set IDs on Linux.
+	idUnknown := !privileged && runtime.GOOS == "linux"
+	id := icmpEchoID(module.ICMP.Identifier)
+	if idUnknown && module.ICMP.Identifier > 0 {
+		level.Debug(logger).Log("msg", "Ignoring identifier, unprivileged sockets use their own", "identifier", module.ICMP.Identifier)
 	}
 
-	wb, err := wm.Marshal(nil)
-	if err != nil {
-		level.Error(logger).Log("msg", "Error marshalling packet", "err", err)
-		return
-	}
+	// marshalEcho returns the echo request with the sequence number, and the
+	// reply it is expected to be answered with.
+	marshalEcho := func(seq int) (request, reply []byte, err error) {
+		body := &icmp.Echo{
+			ID:   id,
+			Seq:  seq,
+			Data: data,
+		}
+		level.Info(logger).Log("msg", "Creating ICMP packet", "seq", body.Seq, "id", body.ID)
+		wm := icmp.Message{
+			Type: requestType,
+			Code: 0,
+			Body: body,
+		}
+		if request, err = wm.Marshal(nil); err != nil {
+			return nil, nil, err
+		}
 
-	durationGaugeVec.WithLabelValues("setup").Add(time.Since(setupStart).Seconds())
-	level.Info(logger).Log("msg", "Writing out packet")
-	rttStart := time.Now()
+		// Reply should be the same except for the message type and ID if
+		// unprivileged sockets were used and the kernel used its own.
+		wm.Type = replyType
+		if idUnknown {
+			body.ID = 0
+		}
+		if reply, err = wm.Marshal(nil); err != nil {
+			return nil, nil, err
+		}
+		if idUnknown {
+			// If the ID is unknown (due to unprivileged sockets) we also cannot know
+			// the checksum in userspace.
+			reply[2] = 0
+			reply[3] = 0
+		}
+		return request, reply, nil
+	}
 
 	if icmpConn != nil {
 		ttl := module.ICMP.TTL
@@ -245,8 +312,12 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 				c6.SetHopLimit(ttl)
 			}
 		}
-		_, err = icmpConn.WriteTo(wb, dst)
-	} else {
+	}
+	writeEcho := func(wb []byte) error {
+		if icmpConn != nil {
+			_, err := icmpConn.WriteTo(wb, dst)
+			return err
+		}
 		ttl := config.DefaultICMPTTL
 		if module.ICMP.TTL > 0 {
 			level.Debug(logger).Log("msg", "Overriding TTL (raw IPv4)", "ttl", ttl)
@@ -258,6 +329,7 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 			Len:      ipv4.HeaderLen,
 			Protocol: 1,
 			TotalLen: ipv4.HeaderLen + len(wb),
+			TOS:      module.ICMP.DSCP << 2,
 			TTL:      ttl,
 			Dst:      dstIPAddr.IP,
 			Src:      srcIP,
@@ -265,32 +337,84 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 
 		header.Flags |= ipv4.DontFragment
 
-		err = v4RawConn.WriteTo(header, wb, nil)
-	}
-	if err != nil {
-		level.Warn(logger).Log("msg", "Error writing to socket", "err", err)
-		return
+		return v4RawConn.WriteTo(header, wb, nil)
 	}
 
-	// Reply should be the same except for the message type and ID if
-	// unprivileged sockets were used and the kernel used its own.
-	wm.Type = replyType
-	// Unprivileged cannot set IDs on Linux.
-	idUnknown := !privileged && runtime.GOOS == "linux"
-	if idUnknown {
-		body.ID = 0
+	packetCount := module.ICMP.PacketCount
+	if packetCount < 1 {
+		packetCount = 1
 	}
-	wb, err = wm.Marshal(nil)
-	if err != nil {
-		level.Error(logger).Log("msg", "Error marshalling packet", "err", err)
-		return
+	burstCount := module.ICMP.BurstCount
+	// echoes holds the sent echo requests in order, by sequence number.
+	var (
+		echoesMutex sync.Mutex
+		echoes      []*icmpEcho
+		echoesBySeq = map[int]*icmpEcho{}
+	)
+	sendEcho := func(burst bool) error {
+		seq := int(getICMPSequence())
+		request, reply, err := marshalEcho(seq)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error marshalling packet", "err", err)
+			return err
+		}
+		level.Info(logger).Log("msg", "Writing out packet")
+		echo := &icmpEcho{reply: reply, sent: time.Now(), burst: burst}
+		echoesMutex.Lock()
+		echoes = append(echoes, echo)
+		echoesBySeq[seq] = echo
+		echoesMutex.Unlock()
+		if err := writeEcho(request); err != nil {
+			level.Warn(logger).Log("msg", "Error writing to socket", "err", err)
+			return err
+		}
+		return nil
 	}
 
-	if idUnknown {
-		// If the ID is unknown (due to unprivileged sockets) we also cannot know
-		// the checksum in userspace.
-		wb[2] = 0
-		wb[3] = 0
+	durationGaugeVec.WithLabelValues("setup").Add(time.Since(setupStart).Seconds())
+	if err := sendEcho(false); err != nil {
+		return
+	}
+	// The sender is stopped and joined before returning, as it logs to the
+	// scrape logger and writes to the socket.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	defer wg.Wait()
+	defer close(stop)
+	if packetCount > 1 && module.ICMP.PacketInterval == 0 {
+		// Send the whole burst at once, the replies queue up in the socket.
+		for i := 1; i < packetCount; i++ {
+			if err := sendEcho(false); err != nil {
+				return
+			}
+		}
+	} else if packetCount > 1 {
+		interval := module.ICMP.PacketInterval
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for i := 1; i < packetCount; i++ {
+				select {
+				case <-ticker.C:
+				case <-stop:
+					return
+				}
+				sendEcho(false)
+			}
+			if burstCount == 0 {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			for i := 0; i < burstCount; i++ {
+				sendEcho(true)
+			}
+		}()
 	}
 
 	rb := make([]byte, 65536)
@@ -305,7 +429,8 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 		return
 	}
 	level.Info(logger).Log("msg", "Waiting for reply packets")
-	for {
+	received := 0
+	for received < packetCount+burstCount {
 		var n int
 		var peer net.Addr
 		var err error
@@ -344,12 +469,12 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 		if err != nil {
 			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
 				level.Warn(logger).Log("msg", "Timeout reading from socket", "err", err)
-				return
+				break
 			}
 			level.Error(logger).Log("msg", "Error reading from socket", "err", err)
 			continue
 		}
-		if peer.String() != dst.String() {
+		if n < 8 {
 			continue
 		}
 		if idUnknown {
@@ -363,14 +488,158 @@ func ProbeICMP(ctx context.Context, target string, module config.Module, registr
 			rb[2] = 0
 			rb[3] = 0
 		}
-		if bytes.Equal(rb[:n], wb) {
-			durationGaugeVec.WithLabelValues("rtt").Add(time.Since(rttStart).Seconds())
+		echoesMutex.Lock()
+		echo := echoesBySeq[int(binary.BigEndian.Uint16(rb[6:8]))]
+		echoesMutex.Unlock()
+		if echo == nil || echo.received || !bytes.Equal(rb[:n], echo.reply) {
+			continue
+		}
+		if peerIP := addrIP(peer); !peerIP.Equal(dstIPAddr.IP) {
+			// The reply to one of our requests came from another address,
+			// for example because of NAT or anycast.
+			level.Warn(logger).Log("msg", "Reply from a different address than the target", "peer", peerIP, "target", dstIPAddr.IP)
+			addrMismatchGauge.Set(1)
+			continue
+		}
+		echo.received = true
+		echo.rtt = time.Since(echo.sent).Seconds()
+		received++
+		if received == 1 {
+			durationGaugeVec.WithLabelValues("rtt").Add(echo.rtt)
 			if hopLimit >= 0 {
 				hopLimitGauge.Set(hopLimit)
 				registry.MustRegister(hopLimitGauge)
 			}
-			level.Info(logger).Log("msg", "Found matching reply packet")
-			return true
 		}
+		level.Info(logger).Log("msg", "Found matching reply packet")
+	}
+
+	if packetCount > 1 {
+		echoesMutex.Lock()
+		var (
+			sent, burstSent, burstReceived int
+			rtts                           []float64
+		)
+		for _, echo := range echoes {
+			if echo.burst {
+				burstSent++
+				if echo.received {
+					burstReceived++
+				}
+				continue
+			}
+			sent++
+			if echo.received {
+				rtts = append(rtts, echo.rtt)
+			}
+		}
+		echoesMutex.Unlock()
+		reportICMPStatistics(sent, rtts, registry, logger)
+		if burstCount > 0 {
+			reportICMPRateLimit(sent, len(rtts), burstSent, burstReceived, registry, logger)
+		}
+	}
+	return received > 0
+}
+
+// icmpPayload returns the data of echo requests. It is filled with the
+// pattern if one is set, and padded with zeros otherwise.
+func icmpPayload(size int, pattern []byte) []byte {
+	if len(pattern) == 0 {
+		pattern = []byte("Prometheus Blackbox Exporter")
+		if size == 0 {
+			return pattern
+		}
+		data := make([]byte, size)
+		copy(data, pattern)
+		return data
+	}
+	if size == 0 {
+		size = len(pattern)
+	}
+	data := make([]byte, size)
+	for i := 0; i < size; i += len(pattern) {
+		copy(data[i:], pattern)
+	}
+	return data
+}
+
+// icmpEcho is an echo request waiting for its reply.
+type icmpEcho struct {
+	reply    []byte
+	sent     time.Time
+	received bool
+	rtt      float64
+	// burst is set for the requests of the burst of the rate limit
+	// detection.
+	burst bool
+}
+
+// reportICMPStatistics exports the packet loss and round trip time statistics
+// of a probe sending several echo requests.
+func reportICMPStatistics(sent int, rtts []float64, registry *prometheus.Registry, logger log.Logger) {
+	packetsSentGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_icmp_packets_sent",
+		Help: "Number of echo requests sent",
+	})
+	packetsReceivedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_icmp_packets_received",
+		Help: "Number of echo replies received",
+	})
+	packetLossGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_icmp_packet_loss_ratio",
+		Help: "Ratio of echo requests which were not replied to",
+	})
+	rttGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_icmp_rtt_seconds",
+		Help: "Statistics of the round trip times, by stat (min, max, avg, stddev)",
+	}, []string{"stat"})
+	jitterGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_icmp_jitter_seconds",
+		Help: "Mean difference between the round trip times of consecutive echo replies",
+	})
+	registry.MustRegister(packetsSentGauge, packetsReceivedGauge, packetLossGauge)
+
+	packetsSentGauge.Set(float64(sent))
+	packetsReceivedGauge.Set(float64(len(rtts)))
+	if sent > 0 {
+		packetLossGauge.Set(float64(sent-len(rtts)) / float64(sent))
+	}
+	if len(rtts) == 0 {
+		return
+	}
+	stats := computeDurationStats(rtts)
+	level.Info(logger).Log("msg", "Echo replies received", "sent", sent, "received", len(rtts), "min", stats.min, "avg", stats.avg, "max", stats.max, "stddev", stats.stddev, "jitter", stats.jitter)
+	registry.MustRegister(rttGaugeVec, jitterGauge)
+	rttGaugeVec.WithLabelValues("min").Set(stats.min)
+	rttGaugeVec.WithLabelValues("max").Set(stats.max)
+	rttGaugeVec.WithLabelValues("avg").Set(stats.avg)
+	rttGaugeVec.WithLabelValues("stddev").Set(stats.stddev)
+	jitterGauge.Set(stats.jitter)
+}
+
+// reportICMPRateLimit compares the packet loss of the paced echo requests
+// with the one of the burst following them, a higher loss of the burst
+// indicating that the target or the path rate limits ICMP.
+func reportICMPRateLimit(sent, received, burstSent, burstReceived int, registry *prometheus.Registry, logger log.Logger) {
+	burstPacketLossGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_icmp_burst_packet_loss_ratio",
+		Help: "Ratio of the echo requests sent in a burst which were not replied to",
+	})
+	rateLimitedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_icmp_rate_limited",
+		Help: "Whether more echo requests sent in a burst were lost than paced ones",
+	})
+	registry.MustRegister(burstPacketLossGauge, rateLimitedGauge)
+
+	if burstSent == 0 || sent == 0 {
+		return
+	}
+	loss := float64(sent-received) / float64(sent)
+	burstLoss := float64(burstSent-burstReceived) / float64(burstSent)
+	burstPacketLossGauge.Set(burstLoss)
+	if burstLoss > loss {
+		level.Info(logger).Log("msg", "Burst lost more echo requests than paced ones", "loss", loss, "burst_loss", burstLoss)
+		rateLimitedGauge.Set(1)
 	}
 }
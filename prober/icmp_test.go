@@ -0,0 +1,338 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// skipWithoutICMP skips the test if neither privileged nor unprivileged ICMP
+// sockets can be opened.
+func skipWithoutICMP(t *testing.T) {
+	for _, network := range []string{"udp4", "ip4:icmp"} {
+		if conn, err := icmp.ListenPacket(network, "127.0.0.1"); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	t.Skip("ICMP sockets are not available")
+}
+
+func TestICMPSinglePacket(t *testing.T) {
+	skipWithoutICMP(t)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if !ProbeICMP(testCTX, "127.0.0.1", config.Module{ICMP: config.ICMPProbe{IPProtocol: "ip4"}}, registry, log.NewNopLogger()) {
+		t.Fatalf("ICMP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "probe_icmp_reply_hop_limit" {
+			if mf.GetMetric()[0].GetGauge().GetValue() <= 0 {
+				t.Fatalf("Expected a positive reply hop limit")
+			}
+			return
+		}
+	}
+	t.Fatal("probe_icmp_reply_hop_limit not found")
+}
+
+func TestICMPPrivilegedSocket(t *testing.T) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "127.0.0.1")
+	if err != nil {
+		t.Skip("Raw ICMP sockets are not available")
+	}
+	conn.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	// Setting the don't fragment bit requires a raw socket.
+	module := config.Module{ICMP: config.ICMPProbe{IPProtocol: "ip4", DontFragment: true}}
+	if !ProbeICMP(testCTX, "127.0.0.1", module, registry, log.NewNopLogger()) {
+		t.Fatalf("ICMP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_icmp_privileged_socket": 1}, mfs, t)
+}
+
+func TestICMPIdentifier(t *testing.T) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "127.0.0.1")
+	if err != nil {
+		t.Skip("Raw ICMP sockets are not available")
+	}
+	conn.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Only raw sockets honour the identifier.
+	module := config.Module{ICMP: config.ICMPProbe{IPProtocol: "ip4", DontFragment: true, Identifier: icmpID ^ 0xffff}}
+	if !ProbeICMP(testCTX, "127.0.0.1", module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("ICMP module with identifier failed, expected success.")
+	}
+}
+
+func TestICMPMultiplePackets(t *testing.T) {
+	skipWithoutICMP(t)
+
+	module := config.Module{ICMP: config.ICMPProbe{
+		IPProtocol:     "ip4",
+		PacketCount:    3,
+		PacketInterval: 10 * time.Millisecond,
+	}}
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if !ProbeICMP(testCTX, "127.0.0.1", module, registry, log.NewNopLogger()) {
+		t.Fatalf("ICMP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{
+		"probe_icmp_packets_sent":      3,
+		"probe_icmp_packets_received":  3,
+		"probe_icmp_packet_loss_ratio": 0,
+	}, mfs, t)
+	for _, mf := range mfs {
+		if mf.GetName() == "probe_icmp_rtt_seconds" && len(mf.GetMetric()) != 4 {
+			t.Fatalf("Expected min, max, avg and stddev round trip times, got %d", len(mf.GetMetric()))
+		}
+	}
+}
+
+func TestICMPBurst(t *testing.T) {
+	skipWithoutICMP(t)
+
+	module := config.Module{ICMP: config.ICMPProbe{
+		IPProtocol:  "ip4",
+		PacketCount: 5,
+	}}
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if !ProbeICMP(testCTX, "127.0.0.1", module, registry, log.NewNopLogger()) {
+		t.Fatalf("ICMP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := map[string]float64{
+		"probe_icmp_packets_sent":     5,
+		"probe_icmp_packets_received": 5,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func TestICMPRateLimitDetection(t *testing.T) {
+	skipWithoutICMP(t)
+
+	module := config.Module{ICMP: config.ICMPProbe{
+		IPProtocol:     "ip4",
+		PacketCount:    3,
+		PacketInterval: 10 * time.Millisecond,
+		BurstCount:     5,
+	}}
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if !ProbeICMP(testCTX, "127.0.0.1", module, registry, log.NewNopLogger()) {
+		t.Fatalf("ICMP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The loopback interface does not rate limit echo requests.
+	expectedResults := map[string]float64{
+		"probe_icmp_packets_sent":            3,
+		"probe_icmp_packets_received":        3,
+		"probe_icmp_burst_packet_loss_ratio": 0,
+		"probe_icmp_rate_limited":            0,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func TestICMPPayload(t *testing.T) {
+	tests := []struct {
+		size     int
+		pattern  []byte
+		expected []byte
+	}{
+		{expected: []byte("Prometheus Blackbox Exporter")},
+		{size: 32, expected: append([]byte("Prometheus Blackbox Exporter"), 0, 0, 0, 0)},
+		{size: 4, expected: []byte("Prom")},
+		{pattern: []byte{0xff, 0x00}, expected: []byte{0xff, 0x00}},
+		{size: 5, pattern: []byte{0xff, 0x00}, expected: []byte{0xff, 0x00, 0xff, 0x00, 0xff}},
+	}
+	for _, test := range tests {
+		if got := icmpPayload(test.size, test.pattern); !bytes.Equal(got, test.expected) {
+			t.Errorf("icmpPayload(%d, %x) = %x, expected %x", test.size, test.pattern, got, test.expected)
+		}
+	}
+}
+
+func TestICMPTimestamp(t *testing.T) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "127.0.0.1")
+	if err != nil {
+		t.Skip("Raw ICMP sockets are not available")
+	}
+	conn.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	module := config.Module{ICMP: config.ICMPProbe{IPProtocol: "ip4", Timestamp: true}}
+	if !ProbeICMP(testCTX, "127.0.0.1", module, registry, log.NewNopLogger()) {
+		t.Fatalf("ICMP timestamp module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_icmp_timestamp_offset_seconds" {
+			continue
+		}
+		// The target is the local host, so only rounding to milliseconds
+		// contributes to the offset.
+		if offset := mf.GetMetric()[0].GetGauge().GetValue(); offset < -0.01 || offset > 0.01 {
+			t.Fatalf("Expected an offset close to 0, got %v", offset)
+		}
+		return
+	}
+	t.Fatal("probe_icmp_timestamp_offset_seconds not found")
+}
+
+func TestICMPTimestampDiff(t *testing.T) {
+	for _, tc := range []struct {
+		a, b uint32
+		want float64
+	}{
+		{a: 1500, b: 1000, want: 0.5},
+		{a: 1000, b: 1500, want: -0.5},
+		// The target clock already passed midnight.
+		{a: 200, b: msPerDay - 300, want: 0.5},
+		// The local clock already passed midnight.
+		{a: msPerDay - 300, b: 200, want: -0.5},
+	} {
+		if got := icmpTimestampDiff(tc.a, tc.b); got != tc.want {
+			t.Errorf("icmpTimestampDiff(%d, %d) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestICMPSourceIPAddress(t *testing.T) {
+	skipWithoutICMP(t)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	module := config.Module{ICMP: config.ICMPProbe{IPProtocol: "ip4", SourceIPAddress: "127.0.0.1"}}
+	if !ProbeICMP(testCTX, "127.0.0.1", module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("ICMP module with source_ip_address failed, expected success.")
+	}
+
+	// An address which is not assigned to the host cannot be bound.
+	module.ICMP.SourceIPAddress = "192.0.2.1"
+	if ProbeICMP(testCTX, "127.0.0.1", module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("ICMP module with unassigned source_ip_address succeeded, expected failure.")
+	}
+}
+
+func TestICMPReplyAddrMismatch(t *testing.T) {
+	netConn, err := net.ListenPacket("ip4:icmp", "127.0.0.1")
+	if err != nil {
+		t.Skip("Raw ICMP sockets are not available")
+	}
+	defer netConn.Close()
+	rawConn, err := ipv4.NewRawConn(netConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	icmpSequenceMutex.Lock()
+	seq := int(icmpSequence + 1)
+	icmpSequenceMutex.Unlock()
+	// Forge the reply to the request for an unresponsive target, as if it
+	// was answered by another address.
+	wm := icmp.Message{
+		Type: ipv4.ICMPTypeEchoReply,
+		Body: &icmp.Echo{ID: icmpID, Seq: seq, Data: icmpPayload(0, nil)},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		rawConn.WriteTo(&ipv4.Header{
+			Version:  ipv4.Version,
+			Len:      ipv4.HeaderLen,
+			TotalLen: ipv4.HeaderLen + len(wb),
+			TTL:      64,
+			Protocol: 1,
+			Src:      net.ParseIP("127.0.0.3"),
+			Dst:      net.ParseIP("127.0.0.1"),
+		}, wb, nil)
+	}()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	// Only raw sockets receive the forged reply.
+	module := config.Module{ICMP: config.ICMPProbe{IPProtocol: "ip4", DontFragment: true}}
+	if ProbeICMP(testCTX, "192.0.2.123", module, registry, log.NewNopLogger()) {
+		t.Fatalf("ICMP module succeeded with a reply from another address, expected failure.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_icmp_reply_addr_mismatch": 1}, mfs, t)
+}
+
+func TestReportICMPRateLimit(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	reportICMPRateLimit(5, 5, 20, 12, registry, log.NewNopLogger())
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := map[string]float64{
+		"probe_icmp_burst_packet_loss_ratio": 0.4,
+		"probe_icmp_rate_limited":            1,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
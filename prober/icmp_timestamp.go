@@ -0,0 +1,173 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	msPerDay = 24 * 60 * 60 * 1000
+
+	// icmpTimestampNonStandard is set in timestamps which are not
+	// milliseconds since midnight UTC, see RFC 792.
+	icmpTimestampNonStandard = 1 << 31
+)
+
+// icmpTimestamp returns the milliseconds since midnight UTC of t.
+func icmpTimestamp(t time.Time) uint32 {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(t.Sub(midnight).Milliseconds())
+}
+
+// icmpTimestampDiff returns a-b in seconds, taking the wrap around at
+// midnight into account.
+func icmpTimestampDiff(a, b uint32) float64 {
+	d := (int64(a) - int64(b)) % msPerDay
+	if d > msPerDay/2 {
+		d -= msPerDay
+	} else if d <= -msPerDay/2 {
+		d += msPerDay
+	}
+	return float64(d) / 1000
+}
+
+// probeICMPTimestamp sends an ICMP timestamp request and compares the
+// receive and transmit timestamps of the reply with the local clock.
+func probeICMPTimestamp(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	skewGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_icmp_timestamp_skew_seconds",
+		Help: "Difference between the receive or transmit timestamp of the target and the local originate or arrival time",
+	}, []string{"timestamp"})
+	offsetGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_icmp_timestamp_offset_seconds",
+		Help: "Estimated offset of the clock of the target, assuming a symmetric path",
+	})
+	registry.MustRegister(skewGaugeVec, offsetGauge)
+
+	dstIPAddr, _, err := chooseProtocol(ctx, module.ICMP.IPProtocol, module.ICMP.IPProtocolFallback, target, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+	if dstIPAddr.IP.To4() == nil {
+		level.Error(logger).Log("msg", "Timestamp requests are only supported over IPv4")
+		return false
+	}
+	srcIP, err := chooseSourceIP(module.ICMP.SourceIPAddress, module.ICMP.SourceInterface, dstIPAddr.IP, logger)
+	if err != nil {
+		return false
+	}
+	if srcIP == nil {
+		srcIP = net.ParseIP("0.0.0.0")
+	}
+
+	// Unprivileged sockets only allow echo requests.
+	conn, err := icmp.ListenPacket("ip4:icmp", srcIP.String())
+	if err != nil {
+		level.Error(logger).Log("msg", "Error listening to socket", "err", err)
+		return false
+	}
+	defer conn.Close()
+	if module.ICMP.TTL > 0 {
+		if err := conn.IPv4PacketConn().SetTTL(module.ICMP.TTL); err != nil {
+			level.Error(logger).Log("msg", "Error setting TTL", "err", err)
+			return false
+		}
+	}
+	if module.ICMP.DSCP > 0 {
+		if err := conn.IPv4PacketConn().SetTOS(module.ICMP.DSCP << 2); err != nil {
+			level.Error(logger).Log("msg", "Error setting DSCP", "err", err)
+			return false
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		level.Error(logger).Log("msg", "Error setting socket deadline", "err", err)
+		return false
+	}
+
+	id := icmpEchoID(module.ICMP.Identifier)
+	seq := getICMPSequence()
+	body := make([]byte, 16)
+	binary.BigEndian.PutUint16(body[0:2], uint16(id))
+	binary.BigEndian.PutUint16(body[2:4], seq)
+	originate := icmpTimestamp(time.Now())
+	binary.BigEndian.PutUint32(body[4:8], originate)
+	wm := icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Body: &icmp.RawBody{Data: body},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error marshalling packet", "err", err)
+		return false
+	}
+	level.Info(logger).Log("msg", "Writing out timestamp request", "ip", dstIPAddr.String())
+	if _, err := conn.WriteTo(wb, dstIPAddr); err != nil {
+		level.Error(logger).Log("msg", "Error writing to socket", "err", err)
+		return false
+	}
+
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(rb)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reading from socket", "err", err)
+			return false
+		}
+		arrival := icmpTimestamp(time.Now())
+		if !peer.(*net.IPAddr).IP.Equal(dstIPAddr.IP) {
+			continue
+		}
+		msg, err := icmp.ParseMessage(1, rb[:n])
+		if err != nil || msg.Type != ipv4.ICMPTypeTimestampReply {
+			continue
+		}
+		raw, ok := msg.Body.(*icmp.RawBody)
+		if !ok || len(raw.Data) < 16 {
+			continue
+		}
+		data := raw.Data
+		if int(binary.BigEndian.Uint16(data[0:2])) != id || binary.BigEndian.Uint16(data[2:4]) != seq {
+			continue
+		}
+		receive := binary.BigEndian.Uint32(data[8:12])
+		transmit := binary.BigEndian.Uint32(data[12:16])
+		if receive&icmpTimestampNonStandard != 0 || transmit&icmpTimestampNonStandard != 0 {
+			level.Error(logger).Log("msg", "Target replied with non-standard timestamps", "receive", receive, "transmit", transmit)
+			return false
+		}
+
+		receiveSkew := icmpTimestampDiff(receive, originate)
+		transmitSkew := icmpTimestampDiff(transmit, arrival)
+		skewGaugeVec.WithLabelValues("receive").Set(receiveSkew)
+		skewGaugeVec.WithLabelValues("transmit").Set(transmitSkew)
+		offsetGauge.Set((receiveSkew + transmitSkew) / 2)
+		level.Info(logger).Log("msg", "Found matching reply packet", "receive_skew", receiveSkew, "transmit_skew", transmitSkew)
+		return true
+	}
+}
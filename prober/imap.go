@@ -0,0 +1,221 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// imapConn sends tagged IMAP commands and reads their responses.
+type imapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// readLine returns the next response line without its line ending.
+func (c *imapConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// command sends the command and returns its untagged responses, failing
+// unless it completes with OK.
+func (c *imapConn) command(command string) ([]string, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, command); err != nil {
+		return nil, err
+	}
+	var untagged []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, tag+" ") {
+			untagged = append(untagged, line)
+			continue
+		}
+		if status := strings.TrimPrefix(line, tag+" "); !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("command failed: %s", status)
+		}
+		return untagged, nil
+	}
+}
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ProbeIMAP checks the greeting and capabilities of an IMAP server and, as
+// configured, logs in and selects a mailbox.
+func ProbeIMAP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeIMAPCommandDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_imap_command_duration_seconds",
+		Help: "Duration of each step of the IMAP session",
+	}, []string{"command"})
+	probeIMAPMailboxMessages := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_imap_mailbox_messages",
+		Help: "Number of messages in the selected mailbox",
+	})
+	registry.MustRegister(probeIMAPCommandDuration)
+
+	// step runs and times one step of the session.
+	step := newStepTimer(probeIMAPCommandDuration, "command", "IMAP command", logger, nil)
+
+	tlsConfig, err := newTargetTLSConfig(&module.IMAP.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.IMAP.IPProtocol, module.IMAP.IPProtocolFallback, module.IMAP.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	var tlsConn *tls.Conn
+	if module.IMAP.TLS {
+		tlsConn = tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		conn = tlsConn
+	}
+	c := &imapConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if !step("greeting", func() error {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+			return fmt.Errorf("unexpected greeting: %s", line)
+		}
+		return nil
+	}) {
+		return false
+	}
+
+	var capabilities []string
+	capability := func() error {
+		untagged, err := c.command("CAPABILITY")
+		if err != nil {
+			return err
+		}
+		for _, line := range untagged {
+			if strings.HasPrefix(line, "* CAPABILITY ") {
+				capabilities = strings.Fields(strings.TrimPrefix(line, "* CAPABILITY "))
+			}
+		}
+		return nil
+	}
+	if !step("capability", capability) {
+		return false
+	}
+	hasCapability := func(name string) bool {
+		for _, c := range capabilities {
+			if strings.EqualFold(c, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if module.IMAP.StartTLS {
+		if !hasCapability("STARTTLS") {
+			level.Error(logger).Log("msg", "Server does not support STARTTLS")
+			return false
+		}
+		if !step("starttls", func() error {
+			if _, err := c.command("STARTTLS"); err != nil {
+				return err
+			}
+			tlsConn = tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				return err
+			}
+			c.conn, c.reader = tlsConn, bufio.NewReader(tlsConn)
+			// Capabilities must be requested again after STARTTLS.
+			return capability()
+		}) {
+			return false
+		}
+	}
+	if tlsConn != nil {
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+	}
+
+	if module.IMAP.Username != "" {
+		if hasCapability("LOGINDISABLED") {
+			level.Error(logger).Log("msg", "Server does not allow LOGIN on this connection")
+			return false
+		}
+		if !step("login", func() error {
+			_, err := c.command("LOGIN " + imapQuote(module.IMAP.Username) + " " + imapQuote(string(module.IMAP.Password)))
+			return err
+		}) {
+			return false
+		}
+	}
+
+	if module.IMAP.Mailbox != "" {
+		// EXAMINE opens the mailbox read-only, so that the probe does not
+		// change the state of messages.
+		if !step("select", func() error {
+			untagged, err := c.command("EXAMINE " + imapQuote(module.IMAP.Mailbox))
+			if err != nil {
+				return err
+			}
+			for _, line := range untagged {
+				if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "*" && strings.EqualFold(fields[2], "EXISTS") {
+					if exists, err := strconv.Atoi(fields[1]); err == nil {
+						registry.MustRegister(probeIMAPMailboxMessages)
+						probeIMAPMailboxMessages.Set(float64(exists))
+					}
+				}
+			}
+			return nil
+		}) {
+			return false
+		}
+	}
+
+	return step("logout", func() error {
+		_, err := c.command("LOGOUT")
+		return err
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveIMAP runs a minimal IMAP server accepting the password "secret",
+// which upgrades connections to TLS with tlsConfig.
+func serveIMAP(t *testing.T, ln net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			fmt.Fprintf(conn, "* OK IMAP4rev1 ready\r\n")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fields := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 3)
+				if len(fields) < 2 {
+					return
+				}
+				tag := fields[0]
+				switch strings.ToUpper(fields[1]) {
+				case "CAPABILITY":
+					fmt.Fprintf(conn, "* CAPABILITY IMAP4rev1 STARTTLS AUTH=PLAIN\r\n%s OK CAPABILITY completed\r\n", tag)
+				case "STARTTLS":
+					fmt.Fprintf(conn, "%s OK Begin TLS negotiation now\r\n", tag)
+					tlsConn := tls.Server(conn, tlsConfig)
+					if err := tlsConn.Handshake(); err != nil {
+						return
+					}
+					conn, r = tlsConn, bufio.NewReader(tlsConn)
+				case "LOGIN":
+					if fields[2] == `"prober" "secret"` {
+						fmt.Fprintf(conn, "%s OK LOGIN completed\r\n", tag)
+					} else {
+						fmt.Fprintf(conn, "%s NO LOGIN failed\r\n", tag)
+					}
+				case "EXAMINE":
+					fmt.Fprintf(conn, "* 42 EXISTS\r\n* 0 RECENT\r\n%s OK [READ-ONLY] EXAMINE completed\r\n", tag)
+				case "LOGOUT":
+					fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+					return
+				default:
+					t.Errorf("Unexpected IMAP command %q", line)
+					fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestIMAPSession(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	go serveIMAP(t, ln, tlsConfig)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	target := net.JoinHostPort("localhost", port)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	module := config.Module{IMAP: config.IMAPProbe{
+		IPProtocol: "ip4",
+		StartTLS:   true,
+		TLSConfig:  pconfig.TLSConfig{CAFile: caFile},
+		Username:   "prober",
+		Password:   "secret",
+		Mailbox:    "INBOX",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeIMAP(testCTX, target, module, registry, log.NewNopLogger()) {
+		t.Fatalf("IMAP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_imap_command_duration_seconds": {
+			"command": {
+				"connect":    {},
+				"greeting":   {},
+				"capability": {},
+				"starttls":   {},
+				"login":      {},
+				"select":     {},
+				"logout":     {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	expectedResults := map[string]float64{
+		"probe_imap_mailbox_messages": 42,
+		"probe_tls_version_info":      1,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	module.IMAP.Password = "wrong"
+	if ProbeIMAP(testCTX, target, module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("IMAP module succeeded with a wrong password, expected failure.")
+	}
+}
+
+func TestIMAPQuote(t *testing.T) {
+	if got, want := imapQuote(`pa"ss\word`), `"pa\"ss\\word"`; got != want {
+		t.Fatalf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// ircMaxLineSize is the maximum size of a message including IRCv3 tags.
+const ircMaxLineSize = 8191 + 512
+
+// ircError is an error numeric or ERROR message received from the server.
+type ircError struct {
+	code    int
+	message string
+}
+
+func (e *ircError) Error() string {
+	if e.code == 0 {
+		return "server error: " + e.message
+	}
+	return fmt.Sprintf("server replied %03d: %s", e.code, e.message)
+}
+
+// parseIRCMessage splits a message into its prefix, command and parameters,
+// ignoring IRCv3 tags.
+func parseIRCMessage(line string) (string, string, []string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	var prefix string
+	if strings.HasPrefix(line, ":") {
+		prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	params := strings.Fields(line)
+	if len(params) == 0 {
+		return prefix, "", nil
+	}
+	command, params := strings.ToUpper(params[0]), params[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return prefix, command, params
+}
+
+// ProbeIRC registers a client with an IRC server, answering its PING
+// messages, until the server welcomes it.
+func ProbeIRC(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeIRCDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_irc_duration_seconds",
+		Help: "Duration of each phase of the IRC session",
+	}, []string{"phase"})
+	probeIRCServerInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_irc_server_info",
+		Help: "Contains the name of the server that welcomed the client",
+	}, []string{"server"})
+	probeIRCErrorCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_irc_error_code",
+		Help: "Error numeric replied by the server, or 0 for an ERROR message",
+	})
+	registry.MustRegister(probeIRCDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeIRCDuration, "phase", "IRC phase", logger, func(err error) {
+		var ircErr *ircError
+		if errors.As(err, &ircErr) {
+			registry.MustRegister(probeIRCErrorCode)
+			probeIRCErrorCode.Set(float64(ircErr.code))
+		}
+	})
+
+	tlsConfig, err := newTargetTLSConfig(&module.IRC.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.IRC.IPProtocol, module.IRC.IPProtocolFallback, module.IRC.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+	if module.IRC.TLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+		conn = tlsConn
+	}
+
+	return step("registration", func() error {
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, 4096), ircMaxLineSize)
+		send := func(format string, args ...interface{}) error {
+			_, err := fmt.Fprintf(conn, format+"\r\n", args...)
+			return err
+		}
+		if module.IRC.Password != "" {
+			if err := send("PASS %s", string(module.IRC.Password)); err != nil {
+				return err
+			}
+		}
+		nick := module.IRC.Nick
+		if err := send("NICK %s", nick); err != nil {
+			return err
+		}
+		if err := send("USER %s 0 * :blackbox_exporter", nick); err != nil {
+			return err
+		}
+		nickRetried := false
+		for scanner.Scan() {
+			prefix, command, params := parseIRCMessage(scanner.Text())
+			switch command {
+			case "PING":
+				token := ""
+				if len(params) > 0 {
+					token = params[len(params)-1]
+				}
+				level.Debug(logger).Log("msg", "Answering PING", "token", token)
+				if err := send("PONG :%s", token); err != nil {
+					return err
+				}
+			case "001":
+				level.Info(logger).Log("msg", "Registered", "server", prefix, "nick", nick)
+				registry.MustRegister(probeIRCServerInfo)
+				probeIRCServerInfo.WithLabelValues(prefix).Set(1)
+				send("QUIT :blackbox_exporter probe")
+				return nil
+			case "433":
+				// The nick is in use, e.g. by a probe that is still
+				// connected, so one with a random suffix is tried.
+				if nickRetried {
+					return &ircError{code: 433, message: "nickname is already in use"}
+				}
+				nickRetried = true
+				if len(nick) > 5 {
+					nick = nick[:5]
+				}
+				nick += strconv.Itoa(1000 + rand.Intn(9000))
+				if err := send("NICK %s", nick); err != nil {
+					return err
+				}
+			case "ERROR":
+				return &ircError{message: strings.Join(params, " ")}
+			default:
+				// Numerics from 400 to 599 are errors, e.g. 464 for a wrong
+				// password or 465 for a banned client.
+				if code, err := strconv.Atoi(command); err == nil && code >= 400 && code < 600 {
+					return &ircError{code: code, message: strings.Join(params, " ")}
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	})
+}
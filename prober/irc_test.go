@@ -0,0 +1,130 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestParseIRCMessage(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		prefix  string
+		command string
+		params  []string
+	}{
+		{line: "PING :irc.example.com\r\n", command: "PING", params: []string{"irc.example.com"}},
+		{line: ":irc.example.com 001 blackbox :Welcome to the network, blackbox", prefix: "irc.example.com", command: "001", params: []string{"blackbox", "Welcome to the network, blackbox"}},
+		{line: "@time=2024-01-01T00:00:00Z :irc.example.com notice * :*** Looking up your hostname", prefix: "irc.example.com", command: "NOTICE", params: []string{"*", "*** Looking up your hostname"}},
+		{line: "ERROR :Closing link", command: "ERROR", params: []string{"Closing link"}},
+	} {
+		prefix, command, params := parseIRCMessage(tc.line)
+		if prefix != tc.prefix || command != tc.command || !reflect.DeepEqual(params, tc.params) {
+			t.Errorf("Unexpected parse of %q: %q %q %q", tc.line, prefix, command, params)
+		}
+	}
+}
+
+func TestIRCProbe(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				fmt.Fprint(conn, ":irc.example.com NOTICE * :*** Looking up your hostname\r\n")
+				scanner := bufio.NewScanner(conn)
+				var password, nick string
+				for scanner.Scan() {
+					_, command, params := parseIRCMessage(scanner.Text())
+					switch command {
+					case "PASS":
+						password = params[0]
+					case "NICK":
+						nick = params[0]
+						if nick == "taken" {
+							fmt.Fprintf(conn, ":irc.example.com 433 * %s :Nickname is already in use\r\n", nick)
+						}
+					case "USER":
+						if password == "wrong" {
+							fmt.Fprint(conn, ":irc.example.com 464 * :Password incorrect\r\n")
+							return
+						}
+						// Registration completes once the PING is answered.
+						fmt.Fprint(conn, "PING :c0ffee\r\n")
+					case "PONG":
+						// A client whose nick is in use is not welcomed.
+						if params[0] != "c0ffee" || nick == "taken" {
+							fmt.Fprint(conn, "ERROR :Closing link (Incorrect ping reply)\r\n")
+							return
+						}
+						fmt.Fprintf(conn, ":irc.example.com 001 %s :Welcome to the network, %s\r\n", nick, nick)
+					case "QUIT":
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name      string
+		module    config.IRCProbe
+		success   bool
+		errorCode float64
+	}{
+		{name: "registration", module: config.IRCProbe{Nick: "blackbox"}, success: true},
+		{name: "nick in use", module: config.IRCProbe{Nick: "taken"}, success: true},
+		{name: "wrong password", module: config.IRCProbe{Nick: "blackbox", Password: "wrong"}, errorCode: 464},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeIRC(testCTX, ln.Addr().String(), config.Module{IRC: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.success {
+				checkRegistryLabels(map[string]map[string]string{"probe_irc_server_info": {"server": "irc.example.com"}}, mfs, t)
+			} else {
+				checkRegistryResults(map[string]float64{"probe_irc_error_code": tc.errorCode}, mfs, t)
+			}
+		})
+	}
+}
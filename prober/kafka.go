@@ -0,0 +1,340 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// Kafka API keys and the versions used by the prober.
+const (
+	kafkaMetadata                = 3
+	kafkaMetadataVersion         = 4
+	kafkaSASLHandshake           = 17
+	kafkaSASLHandshakeVersion    = 1
+	kafkaSASLAuthenticate        = 36
+	kafkaSASLAuthenticateVersion = 1
+)
+
+// kafkaMaxResponseSize limits the size of the responses read from the broker.
+const kafkaMaxResponseSize = 64 << 20
+
+func appendKafkaString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendKafkaBytes(b []byte, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// kafkaReader decodes Kafka responses. The first error is kept and all
+// further reads return zero values.
+type kafkaReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (k *kafkaReader) read(v interface{}) {
+	if k.err == nil {
+		k.err = binary.Read(k.r, binary.BigEndian, v)
+	}
+}
+
+func (k *kafkaReader) int16() int16 {
+	var v int16
+	k.read(&v)
+	return v
+}
+
+func (k *kafkaReader) int32() int32 {
+	var v int32
+	k.read(&v)
+	return v
+}
+
+func (k *kafkaReader) skip(n int) {
+	if k.err == nil && n > 0 {
+		if n > k.r.Len() {
+			k.err = io.ErrUnexpectedEOF
+			return
+		}
+		k.r.Seek(int64(n), io.SeekCurrent)
+	}
+}
+
+// string reads a string, which is empty if it is null.
+func (k *kafkaReader) string() string {
+	n := int(k.int16())
+	if k.err != nil || n < 0 {
+		return ""
+	}
+	if n > k.r.Len() {
+		k.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := make([]byte, n)
+	k.read(s)
+	return string(s)
+}
+
+func (k *kafkaReader) bytes() []byte {
+	n := int(k.int32())
+	if k.err != nil || n < 0 {
+		return nil
+	}
+	if n > k.r.Len() {
+		k.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := make([]byte, n)
+	k.read(b)
+	return b
+}
+
+// kafkaConn sends requests to a Kafka broker.
+type kafkaConn struct {
+	conn          net.Conn
+	clientID      string
+	correlationID int32
+}
+
+// request sends the request and returns the body of the response.
+func (c *kafkaConn) request(apiKey, apiVersion int16, body []byte) (*kafkaReader, error) {
+	c.correlationID++
+	header := binary.BigEndian.AppendUint16(nil, uint16(apiKey))
+	header = binary.BigEndian.AppendUint16(header, uint16(apiVersion))
+	header = binary.BigEndian.AppendUint32(header, uint32(c.correlationID))
+	header = appendKafkaString(header, c.clientID)
+	request := binary.BigEndian.AppendUint32(nil, uint32(len(header)+len(body)))
+	request = append(append(request, header...), body...)
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(c.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, err
+	}
+	r := &kafkaReader{r: bytes.NewReader(response)}
+	if correlationID := r.int32(); correlationID != c.correlationID {
+		return nil, fmt.Errorf("received response to request %d, expected %d", correlationID, c.correlationID)
+	}
+	return r, nil
+}
+
+// authenticate sends the SASL message and returns the answer of the broker.
+func (c *kafkaConn) authenticate(message []byte) ([]byte, error) {
+	r, err := c.request(kafkaSASLAuthenticate, kafkaSASLAuthenticateVersion, appendKafkaBytes(nil, message))
+	if err != nil {
+		return nil, err
+	}
+	errorCode := r.int16()
+	errorMessage := r.string()
+	answer := r.bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if errorCode != 0 {
+		return nil, fmt.Errorf("authentication failed with error code %d: %s", errorCode, errorMessage)
+	}
+	return answer, nil
+}
+
+// ProbeKafka fetches the metadata of the cluster, or of a topic, from a Kafka
+// broker and checks that the partitions have leaders.
+func ProbeKafka(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeKafkaDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_kafka_duration_seconds",
+		Help: "Duration of each phase of the Kafka session",
+	}, []string{"phase"})
+	probeKafkaBrokers := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_kafka_brokers",
+		Help: "Number of brokers in the cluster",
+	})
+	probeKafkaPartitions := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_kafka_partitions",
+		Help: "Number of partitions of the topics",
+	})
+	probeKafkaPartitionsWithoutLeader := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_kafka_partitions_without_leader",
+		Help: "Number of partitions of the topics without an available leader",
+	})
+	probeKafkaTopicErrorCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_kafka_topic_error_code",
+		Help: "Error code returned for the metadata of the topic",
+	})
+	registry.MustRegister(probeKafkaDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeKafkaDuration, "phase", "Kafka phase", logger, nil)
+
+	tlsConfig, err := newTargetTLSConfig(&module.Kafka.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.Kafka.IPProtocol, module.Kafka.IPProtocolFallback, module.Kafka.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if module.Kafka.TLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+		conn = tlsConn
+	}
+	c := &kafkaConn{conn: conn, clientID: module.Kafka.ClientID}
+
+	if mechanism := module.Kafka.SASLMechanism; mechanism != "" {
+		if !step("sasl_handshake", func() error {
+			r, err := c.request(kafkaSASLHandshake, kafkaSASLHandshakeVersion, appendKafkaString(nil, mechanism))
+			if err != nil {
+				return err
+			}
+			errorCode := r.int16()
+			var mechanisms []string
+			for i, n := int32(0), r.int32(); i < n && r.err == nil; i++ {
+				mechanisms = append(mechanisms, r.string())
+			}
+			if r.err != nil {
+				return r.err
+			}
+			if errorCode != 0 {
+				return fmt.Errorf("mechanism %s refused with error code %d, the broker supports %s", mechanism, errorCode, strings.Join(mechanisms, ", "))
+			}
+			return nil
+		}) {
+			return false
+		}
+
+		if !step("sasl_authenticate", func() error {
+			username, password := module.Kafka.SASLUsername, string(module.Kafka.SASLPassword)
+			if mechanism == "PLAIN" {
+				_, err := c.authenticate([]byte("\x00" + username + "\x00" + password))
+				return err
+			}
+			scram := newSCRAMClient(sha256.New, username, password)
+			if mechanism == "SCRAM-SHA-512" {
+				scram.hash = sha512.New
+			}
+			serverFirst, err := c.authenticate([]byte(scram.clientFirst()))
+			if err != nil {
+				return err
+			}
+			clientFinal, err := scram.clientFinal(string(serverFirst))
+			if err != nil {
+				return err
+			}
+			serverFinal, err := c.authenticate([]byte(clientFinal))
+			if err != nil {
+				return err
+			}
+			return scram.verifyServerFinal(string(serverFinal))
+		}) {
+			return false
+		}
+	}
+
+	return step("metadata", func() error {
+		// A null array requests the metadata of all topics.
+		body := binary.BigEndian.AppendUint32(nil, 0xffffffff)
+		if module.Kafka.Topic != "" {
+			body = appendKafkaString(binary.BigEndian.AppendUint32(nil, 1), module.Kafka.Topic)
+		}
+		body = append(body, 0) // Do not create the topic.
+		r, err := c.request(kafkaMetadata, kafkaMetadataVersion, body)
+		if err != nil {
+			return err
+		}
+
+		r.int32() // Throttle time.
+		brokers := r.int32()
+		for i := int32(0); i < brokers && r.err == nil; i++ {
+			r.int32()  // Node ID.
+			r.string() // Host.
+			r.int32()  // Port.
+			r.string() // Rack.
+		}
+		r.string() // Cluster ID.
+		r.int32()  // Controller ID.
+		var partitions, withoutLeader int
+		topicErrorCode := int16(0)
+		for i, topics := int32(0), r.int32(); i < topics && r.err == nil; i++ {
+			errorCode := r.int16()
+			if name := r.string(); name == module.Kafka.Topic {
+				topicErrorCode = errorCode
+			}
+			r.skip(1) // Internal topic.
+			for j, n := int32(0), r.int32(); j < n && r.err == nil; j++ {
+				r.int16() // Error code.
+				r.int32() // Partition index.
+				if leader := r.int32(); leader < 0 {
+					withoutLeader++
+				}
+				r.skip(4 * int(r.int32())) // Replica nodes.
+				r.skip(4 * int(r.int32())) // In-sync replica nodes.
+				partitions++
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("invalid metadata response: %w", r.err)
+		}
+
+		registry.MustRegister(probeKafkaBrokers, probeKafkaPartitions, probeKafkaPartitionsWithoutLeader)
+		probeKafkaBrokers.Set(float64(brokers))
+		probeKafkaPartitions.Set(float64(partitions))
+		probeKafkaPartitionsWithoutLeader.Set(float64(withoutLeader))
+		if module.Kafka.Topic != "" {
+			registry.MustRegister(probeKafkaTopicErrorCode)
+			probeKafkaTopicErrorCode.Set(float64(topicErrorCode))
+			if topicErrorCode != 0 {
+				return fmt.Errorf("metadata of topic %s failed with error code %d", module.Kafka.Topic, topicErrorCode)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveKafka runs a minimal Kafka broker accepting the password "secret",
+// whose cluster has the topic "orders" with a partition without leader.
+func serveKafka(t *testing.T, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				var size int32
+				if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+					return
+				}
+				request := make([]byte, size)
+				if _, err := io.ReadFull(conn, request); err != nil {
+					return
+				}
+				r := &kafkaReader{r: bytes.NewReader(request)}
+				apiKey, apiVersion, correlationID := r.int16(), r.int16(), r.int32()
+				r.string() // Client ID.
+
+				response := binary.BigEndian.AppendUint32(nil, uint32(correlationID))
+				switch apiKey {
+				case kafkaSASLHandshake:
+					errorCode := uint16(0)
+					if r.string() != "PLAIN" {
+						errorCode = 33
+					}
+					response = binary.BigEndian.AppendUint16(response, errorCode)
+					response = appendKafkaString(binary.BigEndian.AppendUint32(response, 1), "PLAIN")
+				case kafkaSASLAuthenticate:
+					if string(r.bytes()) == "\x00prober\x00secret" {
+						response = binary.BigEndian.AppendUint16(response, 0)
+						response = binary.BigEndian.AppendUint16(response, 0xffff)
+					} else {
+						response = binary.BigEndian.AppendUint16(response, 58)
+						response = appendKafkaString(response, "Authentication failed")
+					}
+					response = appendKafkaBytes(response, nil)
+					response = binary.BigEndian.AppendUint64(response, 0)
+				case kafkaMetadata:
+					topic := "orders"
+					if topics := r.int32(); topics == 1 {
+						topic = r.string()
+					}
+					response = binary.BigEndian.AppendUint32(response, 0)
+					response = binary.BigEndian.AppendUint32(response, 2)
+					for node := uint32(1); node <= 2; node++ {
+						response = binary.BigEndian.AppendUint32(response, node)
+						response = appendKafkaString(response, "localhost")
+						response = binary.BigEndian.AppendUint32(response, 9092)
+						response = binary.BigEndian.AppendUint16(response, 0xffff)
+					}
+					response = appendKafkaString(response, "cluster")
+					response = binary.BigEndian.AppendUint32(response, 1)
+					response = binary.BigEndian.AppendUint32(response, 1)
+					if topic != "orders" {
+						response = binary.BigEndian.AppendUint16(response, 3)
+						response = appendKafkaString(response, topic)
+						response = append(response, 0)
+						response = binary.BigEndian.AppendUint32(response, 0)
+						break
+					}
+					response = binary.BigEndian.AppendUint16(response, 0)
+					response = appendKafkaString(response, topic)
+					response = append(response, 0)
+					response = binary.BigEndian.AppendUint32(response, 2)
+					for partition, leader := range []int32{1, -1} {
+						response = binary.BigEndian.AppendUint16(response, 0)
+						response = binary.BigEndian.AppendUint32(response, uint32(partition))
+						response = binary.BigEndian.AppendUint32(response, uint32(leader))
+						for i := 0; i < 2; i++ {
+							response = binary.BigEndian.AppendUint32(response, 1)
+							response = binary.BigEndian.AppendUint32(response, 1)
+						}
+					}
+				default:
+					t.Errorf("Unexpected Kafka request %d version %d", apiKey, apiVersion)
+					return
+				}
+				conn.Write(appendKafkaBytes(nil, response))
+			}
+		}(conn)
+	}
+}
+
+func TestKafkaMetadata(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go serveKafka(t, ln)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	module := config.Module{Kafka: config.KafkaProbe{
+		IPProtocol:    "ip4",
+		SASLMechanism: "PLAIN",
+		SASLUsername:  "prober",
+		SASLPassword:  "secret",
+		Topic:         "orders",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeKafka(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("Kafka module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_kafka_duration_seconds": {
+			"phase": {
+				"connect":           {},
+				"sasl_handshake":    {},
+				"sasl_authenticate": {},
+				"metadata":          {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	expectedResults := map[string]float64{
+		"probe_kafka_brokers":                   2,
+		"probe_kafka_partitions":                2,
+		"probe_kafka_partitions_without_leader": 1,
+		"probe_kafka_topic_error_code":          0,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	for _, tc := range []struct {
+		name   string
+		module config.KafkaProbe
+	}{
+		{
+			name:   "unknown topic",
+			module: config.KafkaProbe{IPProtocol: "ip4", Topic: "payments"},
+		},
+		{
+			name:   "wrong password",
+			module: config.KafkaProbe{IPProtocol: "ip4", SASLMechanism: "PLAIN", SASLUsername: "prober", SASLPassword: "wrong"},
+		},
+		{
+			name:   "unsupported mechanism",
+			module: config.KafkaProbe{IPProtocol: "ip4", SASLMechanism: "SCRAM-SHA-256", SASLUsername: "prober", SASLPassword: "secret"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if ProbeKafka(testCTX, ln.Addr().String(), config.Module{Kafka: tc.module}, prometheus.NewRegistry(), log.NewNopLogger()) {
+				t.Fatalf("Kafka module succeeded, expected failure.")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// kubernetesVersion is the part of the /version response the probe uses.
+type kubernetesVersion struct {
+	GitVersion string `json:"gitVersion"`
+	Platform   string `json:"platform"`
+}
+
+// kubernetesRequest sends a GET request to an endpoint of the API server and
+// returns the status code and the body.
+func kubernetesRequest(ctx context.Context, client *http.Client, endpoint string) (int, []byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("User-Agent", userAgentDefaultHeader)
+	resp, err := client.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, body, err
+}
+
+// parseKubernetesChecks parses the verbose output of a health endpoint, with
+// lines like "[+]ping ok" and "[-]etcd failed: reason withheld", into the
+// result of each check.
+func parseKubernetesChecks(body []byte) map[string]bool {
+	checks := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 3 || (line[:3] != "[+]" && line[:3] != "[-]") {
+			continue
+		}
+		name, _, _ := strings.Cut(line[3:], " ")
+		checks[name] = line[1] == '+'
+	}
+	return checks
+}
+
+// ProbeKubernetes checks the /livez and /readyz health endpoints of a
+// Kubernetes API server, exporting the result of each individual check, and
+// reads its version.
+func ProbeKubernetes(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeKubernetesDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_kubernetes_duration_seconds",
+		Help: "Duration of the request to each endpoint of the API server",
+	}, []string{"endpoint"})
+	probeKubernetesStatusCode := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_kubernetes_status_code",
+		Help: "Response HTTP status code of each endpoint of the API server",
+	}, []string{"endpoint"})
+	probeKubernetesCheckSuccess := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_kubernetes_check_success",
+		Help: "Whether each individual check of a health endpoint passed",
+	}, []string{"endpoint", "check"})
+	probeKubernetesVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_kubernetes_version_info",
+		Help: "Contains the version and platform of the API server",
+	}, []string{"git_version", "platform"})
+	registry.MustRegister(probeKubernetesDuration, probeKubernetesStatusCode)
+
+	// The API server only serves HTTPS.
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "https://" + target
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+	client, err := newHTTPClient(ctx, targetURL, module.Kubernetes.IPProtocol, module.Kubernetes.IPProtocolFallback, module.Kubernetes.SourceIPAddress, module.Kubernetes.HTTPClientConfig, "kubernetes_probe", registry, logger)
+	if err != nil {
+		return false
+	}
+	baseURL := strings.TrimSuffix(targetURL.String(), "/")
+
+	// request requests an endpoint and records its duration and status code.
+	request := func(endpoint, query string) (int, []byte, error) {
+		start := time.Now()
+		statusCode, body, err := kubernetesRequest(ctx, client, baseURL+"/"+endpoint+query)
+		probeKubernetesDuration.WithLabelValues(endpoint).Set(time.Since(start).Seconds())
+		if statusCode != 0 {
+			probeKubernetesStatusCode.WithLabelValues(endpoint).Set(float64(statusCode))
+		}
+		return statusCode, body, err
+	}
+
+	query := url.Values{"verbose": {""}}
+	for _, check := range module.Kubernetes.ExcludeChecks {
+		query.Add("exclude", check)
+	}
+	success := true
+	registry.MustRegister(probeKubernetesCheckSuccess)
+	// Both health endpoints are checked even if one fails, so that the
+	// failing checks of each are exported.
+	for _, endpoint := range []string{"livez", "readyz"} {
+		statusCode, body, err := request(endpoint, "?"+query.Encode())
+		if err != nil {
+			level.Error(logger).Log("msg", "Error requesting health endpoint", "endpoint", endpoint, "err", err)
+			success = false
+			continue
+		}
+		for check, ok := range parseKubernetesChecks(body) {
+			if !ok {
+				level.Error(logger).Log("msg", "Health check failed", "endpoint", endpoint, "check", check)
+				probeKubernetesCheckSuccess.WithLabelValues(endpoint, check).Set(0)
+				continue
+			}
+			probeKubernetesCheckSuccess.WithLabelValues(endpoint, check).Set(1)
+		}
+		// The status code is authoritative, the checks are absent e.g. if
+		// the request is not authorized.
+		if statusCode != http.StatusOK {
+			level.Error(logger).Log("msg", "Health endpoint failed", "endpoint", endpoint, "status_code", statusCode, "body", strings.TrimSpace(string(body)))
+			success = false
+		}
+	}
+
+	statusCode, body, err := request("version", "")
+	if err == nil && statusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code %d", statusCode)
+	}
+	var version kubernetesVersion
+	if err == nil {
+		err = json.Unmarshal(body, &version)
+	}
+	if err != nil {
+		level.Error(logger).Log("msg", "Error reading version", "err", err)
+		return false
+	}
+	registry.MustRegister(probeKubernetesVersion)
+	probeKubernetesVersion.WithLabelValues(version.GitVersion, version.Platform).Set(1)
+	return success
+}
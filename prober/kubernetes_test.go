@@ -0,0 +1,134 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestKubernetesProbe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"kind":"Status","status":"Failure","reason":"Unauthorized","code":401}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/livez":
+			fmt.Fprint(w, "[+]ping ok\n[+]log ok\n[+]poststarthook/start-apiextensions-informers ok\nlivez check passed\n")
+		case "/readyz":
+			// The etcd check fails unless it is excluded.
+			if r.URL.Query().Get("exclude") == "etcd" {
+				fmt.Fprint(w, "[+]ping ok\n[+]informer-sync ok\nreadyz check passed\n")
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "[+]ping ok\n[-]etcd failed: reason withheld\n[+]informer-sync ok\nreadyz check failed\n")
+		case "/version":
+			fmt.Fprint(w, `{"major":"1","minor":"30","gitVersion":"v1.30.2","platform":"linux/amd64"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name          string
+		token         string
+		excludeChecks []string
+		success       bool
+		checks        map[string]float64
+	}{
+		{
+			name:          "healthy",
+			token:         "s3cr3t",
+			excludeChecks: []string{"etcd"},
+			success:       true,
+			checks: map[string]float64{
+				"livez/ping": 1, "livez/log": 1, "livez/poststarthook/start-apiextensions-informers": 1,
+				"readyz/ping": 1, "readyz/informer-sync": 1,
+			},
+		},
+		{
+			name:  "failing check",
+			token: "s3cr3t",
+			checks: map[string]float64{
+				"livez/ping": 1, "livez/log": 1, "livez/poststarthook/start-apiextensions-informers": 1,
+				"readyz/ping": 1, "readyz/etcd": 0, "readyz/informer-sync": 1,
+			},
+		},
+		{
+			name:   "unauthorized",
+			token:  "wrong",
+			checks: map[string]float64{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			httpClientConfig := pconfig.DefaultHTTPClientConfig
+			httpClientConfig.BearerToken = pconfig.Secret(tc.token)
+			module := config.Module{Kubernetes: config.KubernetesProbe{IPProtocol: "ip4", HTTPClientConfig: httpClientConfig, ExcludeChecks: tc.excludeChecks}}
+			registry := prometheus.NewRegistry()
+			if ProbeKubernetes(testCTX, ts.URL, module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checks := map[string]float64{}
+			for _, mf := range mfs {
+				if mf.GetName() != "probe_kubernetes_check_success" {
+					continue
+				}
+				for _, m := range mf.GetMetric() {
+					labels := map[string]string{}
+					for _, lp := range m.GetLabel() {
+						labels[lp.GetName()] = lp.GetValue()
+					}
+					checks[labels["endpoint"]+"/"+labels["check"]] = m.GetGauge().GetValue()
+				}
+			}
+			if !reflect.DeepEqual(checks, tc.checks) {
+				t.Errorf("Unexpected checks %v, want %v", checks, tc.checks)
+			}
+			if tc.success {
+				checkRegistryLabels(map[string]map[string]string{
+					"probe_kubernetes_version_info": {"git_version": "v1.30.2", "platform": "linux/amd64"},
+				}, mfs, t)
+			}
+		})
+	}
+}
+
+func TestParseKubernetesChecks(t *testing.T) {
+	checks := parseKubernetesChecks([]byte("[+]ping ok\n[-]etcd failed: reason withheld\nreadyz check failed\n"))
+	if len(checks) != 2 || !checks["ping"] || checks["etcd"] {
+		t.Errorf("Unexpected checks %v", checks)
+	}
+}
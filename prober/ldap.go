@@ -0,0 +1,130 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// ldapResultCode returns the LDAP result code of the error of an operation,
+// which is 0 (success) if there is no error.
+func ldapResultCode(err error) float64 {
+	if err == nil {
+		return ldap.LDAPResultSuccess
+	}
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) {
+		return float64(ldapErr.ResultCode)
+	}
+	return ldap.ErrorNetwork
+}
+
+// ProbeLDAP binds to an LDAP server, anonymously or with a DN and password,
+// and, as configured, runs a base object search.
+func ProbeLDAP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeLDAPOperationDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_ldap_operation_duration_seconds",
+		Help: "Duration of each step of the LDAP session",
+	}, []string{"operation"})
+	probeLDAPResultCode := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_ldap_result_code",
+		Help: "Result code of the LDAP operation",
+	}, []string{"operation"})
+	probeLDAPSearchEntries := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_ldap_search_entries",
+		Help: "Number of entries returned by the search",
+	})
+	registry.MustRegister(probeLDAPOperationDuration, probeLDAPResultCode)
+
+	// step runs and times one step of the session.
+	step := newStepTimer(probeLDAPOperationDuration, "operation", "LDAP operation", logger, nil)
+
+	tlsConfig, err := newTargetTLSConfig(&module.LDAP.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.LDAP.IPProtocol, module.LDAP.IPProtocolFallback, module.LDAP.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if module.LDAP.TLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		conn = tlsConn
+	}
+	l := ldap.NewConn(conn, module.LDAP.TLS)
+	l.Start()
+	defer l.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		l.SetTimeout(time.Until(deadline))
+	}
+
+	if module.LDAP.StartTLS {
+		if !step("starttls", func() error { return l.StartTLS(tlsConfig) }) {
+			return false
+		}
+	}
+	if state, ok := l.TLSConnectionState(); ok {
+		reportTLSConnectionState(&state, registry)
+	}
+
+	if !step("bind", func() error {
+		if module.LDAP.BindPassword == "" {
+			err = l.UnauthenticatedBind(module.LDAP.BindDN)
+		} else {
+			err = l.Bind(module.LDAP.BindDN, string(module.LDAP.BindPassword))
+		}
+		probeLDAPResultCode.WithLabelValues("bind").Set(ldapResultCode(err))
+		return err
+	}) {
+		return false
+	}
+
+	if module.LDAP.Search {
+		if !step("search", func() error {
+			result, err := l.Search(ldap.NewSearchRequest(module.LDAP.SearchBaseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, module.LDAP.SearchFilter, nil, nil))
+			probeLDAPResultCode.WithLabelValues("search").Set(ldapResultCode(err))
+			if err != nil {
+				return err
+			}
+			registry.MustRegister(probeLDAPSearchEntries)
+			probeLDAPSearchEntries.Set(float64(len(result.Entries)))
+			return nil
+		}) {
+			return false
+		}
+	}
+
+	return step("unbind", l.Unbind)
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-kit/log"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// ldapResponse encodes an LDAP message with a response of the given
+// application tag and result code.
+func ldapResponse(messageID int64, tag ber.Tag, resultCode int64) []byte {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	packet.AppendChild(response)
+	return packet.Bytes()
+}
+
+// serveLDAP runs a minimal LDAP server accepting anonymous binds and the
+// password "secret", which upgrades connections to TLS with tlsConfig.
+func serveLDAP(t *testing.T, ln net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				packet, err := ber.ReadPacket(conn)
+				if err != nil {
+					return
+				}
+				messageID := packet.Children[0].Value.(int64)
+				request := packet.Children[1]
+				switch request.Tag {
+				case ldap.ApplicationBindRequest:
+					resultCode := int64(ldap.LDAPResultSuccess)
+					if request.Children[1].Value.(string) != "" && request.Children[2].Data.String() != "secret" {
+						resultCode = ldap.LDAPResultInvalidCredentials
+					}
+					conn.Write(ldapResponse(messageID, ldap.ApplicationBindResponse, resultCode))
+				case ldap.ApplicationExtendedRequest:
+					conn.Write(ldapResponse(messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+					tlsConn := tls.Server(conn, tlsConfig)
+					if err := tlsConn.Handshake(); err != nil {
+						return
+					}
+					conn = tlsConn
+				case ldap.ApplicationSearchRequest:
+					entry := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					entry.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+					result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+					result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, request.Children[0].Value.(string), ""))
+					result.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, ""))
+					entry.AppendChild(result)
+					conn.Write(entry.Bytes())
+					conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+				case ldap.ApplicationUnbindRequest:
+					return
+				default:
+					t.Errorf("Unexpected LDAP request %d", request.Tag)
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestLDAPSession(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	go serveLDAP(t, ln, tlsConfig)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	target := net.JoinHostPort("localhost", port)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	module := config.Module{LDAP: config.LDAPProbe{
+		IPProtocol:   "ip4",
+		StartTLS:     true,
+		TLSConfig:    pconfig.TLSConfig{CAFile: caFile},
+		BindDN:       "cn=prober,dc=example,dc=com",
+		BindPassword: "secret",
+		Search:       true,
+		SearchBaseDN: "dc=example,dc=com",
+		SearchFilter: "(objectClass=*)",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeLDAP(testCTX, target, module, registry, log.NewNopLogger()) {
+		t.Fatalf("LDAP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_ldap_operation_duration_seconds": {
+			"operation": {
+				"connect":  {},
+				"starttls": {},
+				"bind":     {},
+				"search":   {},
+				"unbind":   {},
+			},
+		},
+		"probe_ldap_result_code": {
+			"operation": {
+				"bind":   {},
+				"search": {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	expectedResults := map[string]float64{
+		"probe_ldap_result_code":    0,
+		"probe_ldap_search_entries": 1,
+		"probe_tls_version_info":    1,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	// Anonymous bind without TLS.
+	if !ProbeLDAP(testCTX, target, config.Module{LDAP: config.LDAPProbe{IPProtocol: "ip4"}}, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("LDAP module failed with an anonymous bind, expected success.")
+	}
+
+	module.LDAP.BindPassword = "wrong"
+	registry = prometheus.NewRegistry()
+	if ProbeLDAP(testCTX, target, module, registry, log.NewNopLogger()) {
+		t.Fatalf("LDAP module succeeded with a wrong password, expected failure.")
+	}
+	mfs, err = registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_ldap_result_code": ldap.LDAPResultInvalidCredentials}, mfs, t)
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// memcachedConn sends commands of the memcached text protocol.
+type memcachedConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *memcachedConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", fmt.Errorf("command failed: %s", line)
+	}
+	return line, nil
+}
+
+// command sends the command and returns the first line of the response.
+func (c *memcachedConn) command(command string) (string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", command); err != nil {
+		return "", err
+	}
+	return c.readLine()
+}
+
+// ProbeMemcached checks the version and statistics of a memcached server and,
+// if a key is configured, stores a value under it and reads it back.
+func ProbeMemcached(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeMemcachedCommandDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_memcached_command_duration_seconds",
+		Help: "Duration of each command of the memcached session",
+	}, []string{"command"})
+	probeMemcachedVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_memcached_version_info",
+		Help: "Contains the version of the memcached server",
+	}, []string{"version"})
+	probeMemcachedUptime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_memcached_uptime_seconds",
+		Help: "Number of seconds since the memcached server started",
+	})
+	probeMemcachedConnections := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_memcached_current_connections",
+		Help: "Number of open connections of the memcached server",
+	})
+	probeMemcachedHit := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_memcached_hit",
+		Help: "Whether the value stored under the probe key was read back",
+	})
+	registry.MustRegister(probeMemcachedCommandDuration)
+
+	// step runs and times one command of the session.
+	step := newStepTimer(probeMemcachedCommandDuration, "command", "memcached command", logger, nil)
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.Memcached.IPProtocol, module.Memcached.IPProtocolFallback, module.Memcached.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+	c := &memcachedConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if !step("version", func() error {
+		line, err := c.command("version")
+		if err != nil {
+			return err
+		}
+		version, ok := strings.CutPrefix(line, "VERSION ")
+		if !ok {
+			return fmt.Errorf("invalid version response: %s", line)
+		}
+		registry.MustRegister(probeMemcachedVersion)
+		probeMemcachedVersion.WithLabelValues(version).Set(1)
+		return nil
+	}) {
+		return false
+	}
+
+	if !step("stats", func() error {
+		line, err := c.command("stats")
+		for ; err == nil && line != "END"; line, err = c.readLine() {
+			fields := strings.Fields(line)
+			if len(fields) != 3 || fields[0] != "STAT" {
+				return fmt.Errorf("invalid stats response: %s", line)
+			}
+			value, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				continue
+			}
+			switch fields[1] {
+			case "uptime":
+				registry.MustRegister(probeMemcachedUptime)
+				probeMemcachedUptime.Set(value)
+			case "curr_connections":
+				registry.MustRegister(probeMemcachedConnections)
+				probeMemcachedConnections.Set(value)
+			}
+		}
+		return err
+	}) {
+		return false
+	}
+
+	if module.Memcached.Key != "" {
+		// The value is unique, so that a stale value is not mistaken for a
+		// hit. It expires after a minute.
+		value := fmt.Sprintf("blackbox_exporter %d", time.Now().UnixNano())
+		if !step("set", func() error {
+			line, err := c.command(fmt.Sprintf("set %s 0 60 %d\r\n%s", module.Memcached.Key, len(value), value))
+			if err != nil {
+				return err
+			}
+			if line != "STORED" {
+				return fmt.Errorf("value not stored: %s", line)
+			}
+			return nil
+		}) {
+			return false
+		}
+
+		registry.MustRegister(probeMemcachedHit)
+		if !step("get", func() error {
+			line, err := c.command("get " + module.Memcached.Key)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return fmt.Errorf("key %s not found", module.Memcached.Key)
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[0] != "VALUE" {
+				return fmt.Errorf("invalid get response: %s", line)
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil || size < 0 || size > len(value) {
+				return fmt.Errorf("unexpected value of %s bytes", fields[3])
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(c.reader, data); err != nil {
+				return err
+			}
+			if line, err := c.readLine(); err != nil {
+				return err
+			} else if line != "END" {
+				return fmt.Errorf("invalid get response: %s", line)
+			}
+			if string(data[:size]) != value {
+				return fmt.Errorf("unexpected value %q", data[:size])
+			}
+			probeMemcachedHit.Set(1)
+			return nil
+		}) {
+			return false
+		}
+	}
+
+	// The server closes the connection without a response.
+	_, err = fmt.Fprintf(conn, "quit\r\n")
+	return err == nil
+}
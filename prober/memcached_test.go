@@ -0,0 +1,132 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveMemcached runs a minimal memcached server, which forgets values
+// stored under the key "volatile".
+func serveMemcached(t *testing.T, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			values := map[string]string{}
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fields := strings.Fields(line)
+				switch fields[0] {
+				case "version":
+					fmt.Fprintf(conn, "VERSION 1.6.21\r\n")
+				case "stats":
+					fmt.Fprintf(conn, "STAT pid 1\r\nSTAT uptime 3600\r\nSTAT version 1.6.21\r\nSTAT curr_connections 7\r\nEND\r\n")
+				case "set":
+					size, _ := strconv.Atoi(fields[4])
+					data := make([]byte, size+2)
+					if _, err := io.ReadFull(r, data); err != nil {
+						return
+					}
+					if fields[1] != "volatile" {
+						values[fields[1]] = string(data[:size])
+					}
+					fmt.Fprintf(conn, "STORED\r\n")
+				case "get":
+					if value, ok := values[fields[1]]; ok {
+						fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+					}
+					fmt.Fprintf(conn, "END\r\n")
+				case "quit":
+					return
+				default:
+					t.Errorf("Unexpected memcached command %q", line)
+					fmt.Fprintf(conn, "ERROR\r\n")
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestMemcachedRoundTrip(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go serveMemcached(t, ln)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	module := config.Module{Memcached: config.MemcachedProbe{IPProtocol: "ip4", Key: "blackbox"}}
+	registry := prometheus.NewRegistry()
+	if !ProbeMemcached(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("memcached module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_memcached_command_duration_seconds": {
+			"command": {
+				"connect": {},
+				"version": {},
+				"stats":   {},
+				"set":     {},
+				"get":     {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	checkRegistryLabels(map[string]map[string]string{"probe_memcached_version_info": {"version": "1.6.21"}}, mfs, t)
+	expectedResults := map[string]float64{
+		"probe_memcached_uptime_seconds":      3600,
+		"probe_memcached_current_connections": 7,
+		"probe_memcached_hit":                 1,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	module.Memcached.Key = "volatile"
+	registry = prometheus.NewRegistry()
+	if ProbeMemcached(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("memcached module succeeded with a missed key, expected failure.")
+	}
+	mfs, err = registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_memcached_hit": 0}, mfs, t)
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const modbusReadHoldingRegisters = 0x03
+
+// modbusException is an exception response of a Modbus server.
+type modbusException struct {
+	code byte
+}
+
+func (e *modbusException) Error() string {
+	return fmt.Sprintf("exception code %d", e.code)
+}
+
+// modbusReadRegister reads one holding register of the unit over Modbus/TCP.
+func modbusReadRegister(conn net.Conn, unitID uint8, register uint16) (uint16, error) {
+	transactionID := uint16(rand.Intn(1 << 16))
+	request := binary.BigEndian.AppendUint16(nil, transactionID)
+	// The protocol identifier is followed by the length of the unit
+	// identifier and the PDU.
+	request = append(request, 0, 0, 0, 6, unitID, modbusReadHoldingRegisters)
+	request = binary.BigEndian.AppendUint16(request, register)
+	request = binary.BigEndian.AppendUint16(request, 1)
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	var header [7]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return 0, err
+	}
+	length := binary.BigEndian.Uint16(header[4:])
+	if length < 3 || length > 254 {
+		return 0, fmt.Errorf("invalid length %d", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(conn, pdu); err != nil {
+		return 0, err
+	}
+	switch {
+	case binary.BigEndian.Uint16(header[:]) != transactionID || header[6] != unitID:
+		return 0, errors.New("response does not match the request")
+	case pdu[0] == modbusReadHoldingRegisters|0x80:
+		return 0, &modbusException{code: pdu[1]}
+	case pdu[0] != modbusReadHoldingRegisters || pdu[1] != 2 || len(pdu) != 4:
+		return 0, fmt.Errorf("unexpected response %x", pdu)
+	}
+	return binary.BigEndian.Uint16(pdu[2:]), nil
+}
+
+// ProbeModbus reads a holding register of a Modbus/TCP server.
+func ProbeModbus(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeModbusDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_modbus_duration_seconds",
+		Help: "Duration of each phase of the Modbus request",
+	}, []string{"phase"})
+	probeModbusRegisterValue := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_modbus_register_value",
+		Help: "Value of the holding register",
+	})
+	probeModbusExceptionCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_modbus_exception_code",
+		Help: "Code of the exception returned by the server",
+	})
+	registry.MustRegister(probeModbusDuration)
+
+	// step runs and times one phase of the request.
+	step := newStepTimer(probeModbusDuration, "phase", "Modbus phase", logger, func(err error) {
+		var exception *modbusException
+		if errors.As(err, &exception) {
+			registry.MustRegister(probeModbusExceptionCode)
+			probeModbusExceptionCode.Set(float64(exception.code))
+		}
+	})
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.Modbus.IPProtocol, module.Modbus.IPProtocolFallback, module.Modbus.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	return step("read", func() error {
+		value, err := modbusReadRegister(conn, module.Modbus.UnitID, module.Modbus.Register)
+		if err != nil {
+			return err
+		}
+		registry.MustRegister(probeModbusRegisterValue)
+		probeModbusRegisterValue.Set(float64(value))
+		return nil
+	})
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveModbus runs a minimal Modbus/TCP gateway for the unit 1, which holds
+// the value 1234 in the register 40.
+func serveModbus(t *testing.T, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				var request [12]byte
+				if _, err := io.ReadFull(conn, request[:]); err != nil {
+					return
+				}
+				if binary.BigEndian.Uint16(request[4:]) != 6 || request[7] != modbusReadHoldingRegisters || binary.BigEndian.Uint16(request[10:]) != 1 {
+					t.Errorf("Unexpected request %x", request)
+					return
+				}
+				response := append([]byte{}, request[:7]...)
+				switch {
+				case request[6] != 1:
+					// The gateway target device failed to respond.
+					response = append(response, modbusReadHoldingRegisters|0x80, 0x0b)
+				case binary.BigEndian.Uint16(request[8:]) != 40:
+					// Illegal data address.
+					response = append(response, modbusReadHoldingRegisters|0x80, 0x02)
+				default:
+					response = binary.BigEndian.AppendUint16(append(response, modbusReadHoldingRegisters, 2), 1234)
+				}
+				binary.BigEndian.PutUint16(response[4:], uint16(len(response)-6))
+				conn.Write(response)
+			}
+		}(conn)
+	}
+}
+
+func TestModbusRead(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go serveModbus(t, ln)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name          string
+		module        config.ModbusProbe
+		success       bool
+		exceptionCode float64
+	}{
+		{
+			name:    "register",
+			module:  config.ModbusProbe{IPProtocol: "ip4", UnitID: 1, Register: 40},
+			success: true,
+		},
+		{
+			name:          "illegal address",
+			module:        config.ModbusProbe{IPProtocol: "ip4", UnitID: 1, Register: 41},
+			exceptionCode: 2,
+		},
+		{
+			name:          "unknown unit",
+			module:        config.ModbusProbe{IPProtocol: "ip4", UnitID: 2, Register: 40},
+			exceptionCode: 11,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			if ProbeModbus(testCTX, ln.Addr().String(), config.Module{Modbus: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedMetrics := map[string]map[string]map[string]struct{}{
+				"probe_modbus_duration_seconds": {
+					"phase": {
+						"connect": {},
+						"read":    {},
+					},
+				},
+			}
+			checkMetrics(expectedMetrics, mfs, t)
+			if tc.success {
+				checkRegistryResults(map[string]float64{"probe_modbus_register_value": 1234}, mfs, t)
+			} else {
+				checkRegistryResults(map[string]float64{"probe_modbus_exception_code": tc.exceptionCode}, mfs, t)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	mongoOpMsg = 2013
+	// mongoMaxMessageSize limits the size of the messages read from the
+	// server.
+	mongoMaxMessageSize = 48 << 20
+	// mongoCommandNotFound is the error code of an unknown command.
+	mongoCommandNotFound = 59
+)
+
+// bsonElement is an element of a BSON document, whose value is a string,
+// an int32, a bool or binary data.
+type bsonElement struct {
+	key   string
+	value interface{}
+}
+
+func appendBSON(b []byte, elements ...bsonElement) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0)
+	for _, e := range elements {
+		var kind byte
+		switch e.value.(type) {
+		case string:
+			kind = 0x02
+		case []byte:
+			kind = 0x05
+		case bool:
+			kind = 0x08
+		case int32:
+			kind = 0x10
+		default:
+			panic(fmt.Sprintf("unsupported BSON value %T", e.value))
+		}
+		b = append(append(append(b, kind), e.key...), 0)
+		switch v := e.value.(type) {
+		case string:
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(v)+1))
+			b = append(append(b, v...), 0)
+		case []byte:
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(v)))
+			b = append(append(b, 0), v...)
+		case bool:
+			if v {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		case int32:
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		}
+	}
+	b = append(b, 0)
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start))
+	return b
+}
+
+// parseBSON decodes the elements of a BSON document. Embedded documents and
+// arrays are returned undecoded.
+func parseBSON(doc []byte) (map[string]interface{}, error) {
+	if len(doc) < 5 || int(binary.LittleEndian.Uint32(doc)) != len(doc) {
+		return nil, errors.New("invalid BSON document")
+	}
+	r := bytes.NewBuffer(doc[4 : len(doc)-1])
+	next := func(n int) ([]byte, error) {
+		if n < 0 || n > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return r.Next(n), nil
+	}
+	elements := map[string]interface{}{}
+	for r.Len() > 0 {
+		kind, _ := r.ReadByte()
+		key, err := r.ReadString(0)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		key = key[:len(key)-1]
+		var value interface{}
+		switch kind {
+		case 0x01: // Double.
+			b, err := next(8)
+			if err != nil {
+				return nil, err
+			}
+			value = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case 0x02: // String.
+			b, err := next(4)
+			if err != nil {
+				return nil, err
+			}
+			if b, err = next(int(int32(binary.LittleEndian.Uint32(b)))); err != nil || len(b) == 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			value = string(b[:len(b)-1])
+		case 0x03, 0x04: // Document and array.
+			b, err := next(4)
+			if err != nil {
+				return nil, err
+			}
+			rest, err := next(int(int32(binary.LittleEndian.Uint32(b))) - 4)
+			if err != nil {
+				return nil, err
+			}
+			value = append(b, rest...)
+		case 0x05: // Binary data.
+			b, err := next(4)
+			if err != nil {
+				return nil, err
+			}
+			if b, err = next(int(int32(binary.LittleEndian.Uint32(b))) + 1); err != nil {
+				return nil, err
+			}
+			value = b[1:]
+		case 0x07: // ObjectId.
+			value, err = next(12)
+		case 0x08: // Boolean.
+			b, err := next(1)
+			if err != nil {
+				return nil, err
+			}
+			value = b[0] != 0
+		case 0x09, 0x11, 0x12: // UTC datetime, timestamp and int64.
+			b, err := next(8)
+			if err != nil {
+				return nil, err
+			}
+			value = int64(binary.LittleEndian.Uint64(b))
+		case 0x0a: // Null.
+		case 0x10: // Int32.
+			b, err := next(4)
+			if err != nil {
+				return nil, err
+			}
+			value = int32(binary.LittleEndian.Uint32(b))
+		case 0x13: // Decimal128.
+			value, err = next(16)
+		default:
+			return nil, fmt.Errorf("unsupported BSON type 0x%02x", kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements[key] = value
+	}
+	return elements, nil
+}
+
+// bsonNumber returns the value of a numeric BSON element, or 0.
+func bsonNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+// mongoError is an error returned for a command.
+type mongoError struct {
+	code    int32
+	message string
+}
+
+func (e *mongoError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.message, e.code)
+}
+
+// mongoConn runs commands with OP_MSG messages.
+type mongoConn struct {
+	conn      net.Conn
+	requestID int32
+}
+
+// command runs the command against the database and returns the reply.
+func (c *mongoConn) command(database string, elements ...bsonElement) (map[string]interface{}, error) {
+	c.requestID++
+	message := make([]byte, 16, 64)
+	binary.LittleEndian.PutUint32(message[4:], uint32(c.requestID))
+	binary.LittleEndian.PutUint32(message[12:], mongoOpMsg)
+	message = append(message, 0, 0, 0, 0, 0) // Flags and a body section.
+	message = appendBSON(message, append(elements, bsonElement{"$db", database})...)
+	binary.LittleEndian.PutUint32(message, uint32(len(message)))
+	if _, err := c.conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	var header [16]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[:])
+	// The header, the flags and a body section with an empty document.
+	if size < 26 || size > mongoMaxMessageSize {
+		return nil, fmt.Errorf("invalid message size %d", size)
+	}
+	if opCode := binary.LittleEndian.Uint32(header[12:]); opCode != mongoOpMsg {
+		return nil, fmt.Errorf("unexpected reply with op code %d", opCode)
+	}
+	body := make([]byte, size-16)
+	if _, err := io.ReadFull(c.conn, body); err != nil {
+		return nil, err
+	}
+	if body[4] != 0 {
+		return nil, errors.New("reply does not start with a body section")
+	}
+	reply, err := parseBSON(body[5 : 5+min(int(binary.LittleEndian.Uint32(body[5:])), len(body)-5)])
+	if err != nil {
+		return nil, err
+	}
+	if bsonNumber(reply["ok"]) != 1 {
+		message, _ := reply["errmsg"].(string)
+		return nil, &mongoError{code: int32(bsonNumber(reply["code"])), message: message}
+	}
+	return reply, nil
+}
+
+// authenticate runs a SCRAM conversation.
+func (c *mongoConn) authenticate(mechanism, source, username, password string) error {
+	scram := newSCRAMClient(sha256.New, username, password)
+	if mechanism == "SCRAM-SHA-1" {
+		// SCRAM-SHA-1 uses a digest of the password.
+		digest := md5.Sum([]byte(username + ":mongo:" + password))
+		scram = newSCRAMClient(sha1.New, username, hex.EncodeToString(digest[:]))
+	}
+	reply, err := c.command(source,
+		bsonElement{"saslStart", int32(1)},
+		bsonElement{"mechanism", mechanism},
+		bsonElement{"payload", []byte(scram.clientFirst())},
+		bsonElement{"autoAuthorize", int32(1)},
+	)
+	if err != nil {
+		return err
+	}
+	conversationID := int32(bsonNumber(reply["conversationId"]))
+	serverFirst, _ := reply["payload"].([]byte)
+	clientFinal, err := scram.clientFinal(string(serverFirst))
+	if err != nil {
+		return err
+	}
+	reply, err = c.command(source,
+		bsonElement{"saslContinue", int32(1)},
+		bsonElement{"conversationId", conversationID},
+		bsonElement{"payload", []byte(clientFinal)},
+	)
+	if err != nil {
+		return err
+	}
+	serverFinal, _ := reply["payload"].([]byte)
+	if err := scram.verifyServerFinal(string(serverFinal)); err != nil {
+		return err
+	}
+	if done, _ := reply["done"].(bool); !done {
+		_, err = c.command(source,
+			bsonElement{"saslContinue", int32(1)},
+			bsonElement{"conversationId", conversationID},
+			bsonElement{"payload", []byte{}},
+		)
+	}
+	return err
+}
+
+// mongoRole returns the role of the node described by a hello reply.
+func mongoRole(reply map[string]interface{}) string {
+	primary, _ := reply["isWritablePrimary"].(bool)
+	if ismaster, _ := reply["ismaster"].(bool); ismaster {
+		primary = true
+	}
+	secondary, _ := reply["secondary"].(bool)
+	arbiter, _ := reply["arbiterOnly"].(bool)
+	_, replicaSet := reply["setName"]
+	switch {
+	case reply["msg"] == "isdbgrid":
+		return "mongos"
+	case primary && !replicaSet:
+		return "standalone"
+	case primary:
+		return "primary"
+	case secondary:
+		return "secondary"
+	case arbiter:
+		return "arbiter"
+	}
+	return "other"
+}
+
+// ProbeMongoDB runs the hello command against a MongoDB server to find out
+// its role and, if a username is configured, authenticates.
+func ProbeMongoDB(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeMongoDBDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_mongodb_duration_seconds",
+		Help: "Duration of each phase of the MongoDB session",
+	}, []string{"phase"})
+	probeMongoDBRole := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_mongodb_role_info",
+		Help: "Contains the role of the node and the name of its replica set",
+	}, []string{"role", "set_name"})
+	probeMongoDBMaxWireVersion := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_mongodb_max_wire_version",
+		Help: "Latest version of the wire protocol the server supports",
+	})
+	probeMongoDBErrorCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_mongodb_error_code",
+		Help: "Code of the error returned for a command",
+	})
+	registry.MustRegister(probeMongoDBDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeMongoDBDuration, "phase", "MongoDB phase", logger, func(err error) {
+		var commandErr *mongoError
+		if errors.As(err, &commandErr) {
+			registry.MustRegister(probeMongoDBErrorCode)
+			probeMongoDBErrorCode.Set(float64(commandErr.code))
+		}
+	})
+
+	tlsConfig, err := newTargetTLSConfig(&module.MongoDB.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.MongoDB.IPProtocol, module.MongoDB.IPProtocolFallback, module.MongoDB.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if module.MongoDB.TLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+		conn = tlsConn
+	}
+	c := &mongoConn{conn: conn}
+
+	if !step("hello", func() error {
+		reply, err := c.command("admin", bsonElement{"hello", int32(1)})
+		var commandErr *mongoError
+		if errors.As(err, &commandErr) && commandErr.code == mongoCommandNotFound {
+			// Servers before MongoDB 4.4.2 only know the legacy command.
+			reply, err = c.command("admin", bsonElement{"isMaster", int32(1)})
+		}
+		if err != nil {
+			return err
+		}
+		setName, _ := reply["setName"].(string)
+		registry.MustRegister(probeMongoDBRole, probeMongoDBMaxWireVersion)
+		probeMongoDBRole.WithLabelValues(mongoRole(reply), setName).Set(1)
+		probeMongoDBMaxWireVersion.Set(bsonNumber(reply["maxWireVersion"]))
+		return nil
+	}) {
+		return false
+	}
+
+	if module.MongoDB.Username == "" {
+		return true
+	}
+	return step("auth", func() error {
+		return c.authenticate(module.MongoDB.AuthMechanism, module.MongoDB.AuthSource, module.MongoDB.Username, string(module.MongoDB.Password))
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveMongoDB runs a minimal MongoDB secondary accepting the user "prober"
+// with the password "secret". A legacy server does not know the hello
+// command.
+func serveMongoDB(t *testing.T, ln net.Listener, legacy bool) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			var (
+				scram         *scramClient
+				expectedFinal string
+			)
+			for {
+				var header [16]byte
+				if _, err := io.ReadFull(conn, header[:]); err != nil {
+					return
+				}
+				body := make([]byte, binary.LittleEndian.Uint32(header[:])-16)
+				if _, err := io.ReadFull(conn, body); err != nil {
+					return
+				}
+				command, err := parseBSON(body[5:])
+				if err != nil {
+					t.Errorf("Error parsing command: %s", err)
+					return
+				}
+				failed := []bsonElement{{"ok", int32(0)}, {"errmsg", "Authentication failed."}, {"code", int32(18)}}
+				var reply []bsonElement
+				switch {
+				case command["hello"] != nil && legacy:
+					reply = []bsonElement{{"ok", int32(0)}, {"errmsg", "no such command: 'hello'"}, {"code", int32(mongoCommandNotFound)}}
+				case command["hello"] != nil, command["isMaster"] != nil:
+					reply = []bsonElement{{"ok", int32(1)}, {"ismaster", false}, {"secondary", true}, {"setName", "rs0"}, {"maxWireVersion", int32(21)}}
+				case command["saslStart"] != nil:
+					clientFirst := string(command["payload"].([]byte))
+					_, nonce, _ := strings.Cut(clientFirst, ",r=")
+					if command["mechanism"] != "SCRAM-SHA-256" || !strings.HasPrefix(clientFirst, "n,,n=prober,") {
+						reply = failed
+						break
+					}
+					// The server computes the expected messages with a client
+					// using the nonce of the client.
+					scram = newSCRAMClient(sha256.New, "prober", "secret")
+					scram.nonce = nonce
+					serverFirst := "r=" + nonce + "server,s=" + base64.StdEncoding.EncodeToString([]byte("salt")) + ",i=4096"
+					if expectedFinal, err = scram.clientFinal(serverFirst); err != nil {
+						t.Errorf("Error computing client final message: %s", err)
+						return
+					}
+					reply = []bsonElement{{"ok", int32(1)}, {"conversationId", int32(1)}, {"done", false}, {"payload", []byte(serverFirst)}}
+				case command["saslContinue"] != nil:
+					if scram == nil || string(command["payload"].([]byte)) != expectedFinal {
+						reply = failed
+						break
+					}
+					signature := scram.hmac(scram.hmac(scram.saltedPassword, "Server Key"), scram.authMessage)
+					reply = []bsonElement{{"ok", int32(1)}, {"conversationId", int32(1)}, {"done", true}, {"payload", []byte("v=" + base64.StdEncoding.EncodeToString(signature))}}
+				default:
+					t.Errorf("Unexpected command %v", command)
+					return
+				}
+				message := make([]byte, 16)
+				binary.LittleEndian.PutUint32(message[8:], binary.LittleEndian.Uint32(header[4:]))
+				binary.LittleEndian.PutUint32(message[12:], mongoOpMsg)
+				message = appendBSON(append(message, 0, 0, 0, 0, 0), reply...)
+				binary.LittleEndian.PutUint32(message, uint32(len(message)))
+				conn.Write(message)
+			}
+		}(conn)
+	}
+}
+
+func TestMongoDBHello(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, legacy := range []bool{false, true} {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error listening on socket: %s", err)
+		}
+		defer ln.Close()
+		go serveMongoDB(t, ln, legacy)
+
+		module := config.Module{MongoDB: config.MongoDBProbe{
+			IPProtocol:    "ip4",
+			Username:      "prober",
+			Password:      "secret",
+			AuthSource:    "admin",
+			AuthMechanism: "SCRAM-SHA-256",
+		}}
+		registry := prometheus.NewRegistry()
+		if !ProbeMongoDB(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+			t.Fatalf("MongoDB module failed, expected success.")
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedMetrics := map[string]map[string]map[string]struct{}{
+			"probe_mongodb_duration_seconds": {
+				"phase": {
+					"connect": {},
+					"hello":   {},
+					"auth":    {},
+				},
+			},
+		}
+		checkMetrics(expectedMetrics, mfs, t)
+		checkRegistryLabels(map[string]map[string]string{"probe_mongodb_role_info": {"role": "secondary", "set_name": "rs0"}}, mfs, t)
+		checkRegistryResults(map[string]float64{"probe_mongodb_max_wire_version": 21}, mfs, t)
+
+		module.MongoDB.Password = "wrong"
+		registry = prometheus.NewRegistry()
+		if ProbeMongoDB(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+			t.Fatalf("MongoDB module succeeded with a wrong password, expected failure.")
+		}
+		mfs, err = registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRegistryResults(map[string]float64{"probe_mongodb_error_code": 18}, mfs, t)
+	}
+}
+
+func TestMongoRole(t *testing.T) {
+	for _, tc := range []struct {
+		reply map[string]interface{}
+		role  string
+	}{
+		{map[string]interface{}{"isWritablePrimary": true, "setName": "rs0"}, "primary"},
+		{map[string]interface{}{"ismaster": true}, "standalone"},
+		{map[string]interface{}{"ismaster": true, "msg": "isdbgrid"}, "mongos"},
+		{map[string]interface{}{"secondary": true, "setName": "rs0"}, "secondary"},
+		{map[string]interface{}{"arbiterOnly": true, "setName": "rs0"}, "arbiter"},
+		{map[string]interface{}{"setName": "rs0"}, "other"},
+	} {
+		if role := mongoRole(tc.reply); role != tc.role {
+			t.Errorf("Expected role %s for %v, got %s", tc.role, tc.reply, role)
+		}
+	}
+}
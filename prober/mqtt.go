@@ -0,0 +1,338 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// MQTT control packet types.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttDisconnect = 14
+)
+
+// mqttMaxPacketSize limits the size of the packets read from the broker.
+const mqttMaxPacketSize = 1 << 20
+
+// mqttConn writes and reads MQTT control packets.
+type mqttConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// v5 is set if MQTT 5 is used, whose packets contain properties.
+	v5 bool
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendMQTTVarint(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n == 0 {
+			return append(b, digit)
+		}
+		b = append(b, digit|0x80)
+	}
+}
+
+func readMQTTVarint(r io.ByteReader) (int, error) {
+	n, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			return n, nil
+		}
+		multiplier *= 128
+	}
+	return 0, errors.New("malformed variable byte integer")
+}
+
+func readMQTTString(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// skipProperties skips the properties of an MQTT 5 packet.
+func (c *mqttConn) skipProperties(r *bytes.Reader) error {
+	if !c.v5 {
+		return nil
+	}
+	length, err := readMQTTVarint(r)
+	if err != nil {
+		return err
+	}
+	if length > r.Len() {
+		return io.ErrUnexpectedEOF
+	}
+	_, err = r.Seek(int64(length), io.SeekCurrent)
+	return err
+}
+
+// writePacket writes a packet with the fixed header byte and the body.
+func (c *mqttConn) writePacket(header byte, body []byte) error {
+	packet := appendMQTTVarint([]byte{header}, len(body))
+	_, err := c.conn.Write(append(packet, body...))
+	return err
+}
+
+// readPacket reads a packet and returns its type, flags and body.
+func (c *mqttConn) readPacket() (byte, byte, *bytes.Reader, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length, err := readMQTTVarint(c.reader)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if length > mqttMaxPacketSize {
+		return 0, 0, nil, fmt.Errorf("packet of %d bytes exceeds the maximum size", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0f, bytes.NewReader(body), nil
+}
+
+// expectPacket reads a packet and fails unless it has the given type.
+func (c *mqttConn) expectPacket(packetType byte) (*bytes.Reader, error) {
+	t, _, body, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if t != packetType {
+		return nil, fmt.Errorf("received packet of type %d, expected %d", t, packetType)
+	}
+	return body, nil
+}
+
+// readPublish reads packets until a message is published and returns its
+// topic and payload.
+func (c *mqttConn) readPublish() (string, []byte, error) {
+	for {
+		t, flags, body, err := c.readPacket()
+		if err != nil {
+			return "", nil, err
+		}
+		if t != mqttPublish {
+			continue
+		}
+		topic, err := readMQTTString(body)
+		if err != nil {
+			return "", nil, err
+		}
+		if qos := flags >> 1 & 0x03; qos > 0 {
+			if _, err := body.Seek(2, io.SeekCurrent); err != nil {
+				return "", nil, err
+			}
+		}
+		if err := c.skipProperties(body); err != nil {
+			return "", nil, err
+		}
+		payload, err := io.ReadAll(body)
+		return topic, payload, err
+	}
+}
+
+// ProbeMQTT connects to an MQTT broker and, if a topic is configured,
+// measures the delivery of a message published to it.
+func ProbeMQTT(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeMQTTDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_mqtt_duration_seconds",
+		Help: "Duration of each phase of the MQTT session",
+	}, []string{"phase"})
+	probeMQTTConnackReasonCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_mqtt_connack_reason_code",
+		Help: "Return code of MQTT 3.1.1 or reason code of MQTT 5 the broker acknowledged the connection with",
+	})
+	probeMQTTDeliveryLatency := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_mqtt_delivery_latency_seconds",
+		Help: "Time from publishing the test message until it was received on the subscription",
+	})
+	registry.MustRegister(probeMQTTDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeMQTTDuration, "phase", "MQTT phase", logger, nil)
+
+	tlsConfig, err := newTargetTLSConfig(&module.MQTT.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.MQTT.IPProtocol, module.MQTT.IPProtocolFallback, module.MQTT.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if module.MQTT.TLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+		conn = tlsConn
+	}
+	c := &mqttConn{conn: conn, reader: bufio.NewReader(conn), v5: module.MQTT.ProtocolVersion == "5"}
+
+	if !step("connack", func() error {
+		var protocolLevel, flags byte = 4, 0x02 // Clean session.
+		if c.v5 {
+			protocolLevel = 5
+		}
+		if module.MQTT.Username != "" {
+			flags |= 0x80
+		}
+		if module.MQTT.Password != "" {
+			flags |= 0x40
+		}
+		body := appendMQTTString(nil, "MQTT")
+		body = append(body, protocolLevel, flags)
+		body = binary.BigEndian.AppendUint16(body, 60) // Keep alive.
+		if c.v5 {
+			body = appendMQTTVarint(body, 0)
+		}
+		body = appendMQTTString(body, module.MQTT.ClientID)
+		if module.MQTT.Username != "" {
+			body = appendMQTTString(body, module.MQTT.Username)
+		}
+		if module.MQTT.Password != "" {
+			body = appendMQTTString(body, string(module.MQTT.Password))
+		}
+		if err := c.writePacket(mqttConnect<<4, body); err != nil {
+			return err
+		}
+		connack, err := c.expectPacket(mqttConnack)
+		if err != nil {
+			return err
+		}
+		var ack [2]byte
+		if _, err := io.ReadFull(connack, ack[:]); err != nil {
+			return err
+		}
+		registry.MustRegister(probeMQTTConnackReasonCode)
+		probeMQTTConnackReasonCode.Set(float64(ack[1]))
+		if ack[1] != 0 {
+			return fmt.Errorf("connection refused with code %d", ack[1])
+		}
+		return nil
+	}) {
+		return false
+	}
+
+	if module.MQTT.Topic != "" {
+		if !step("subscribe", func() error {
+			body := binary.BigEndian.AppendUint16(nil, 1) // Packet identifier.
+			if c.v5 {
+				body = appendMQTTVarint(body, 0)
+			}
+			body = appendMQTTString(body, module.MQTT.Topic)
+			body = append(body, 0) // At most once delivery.
+			if err := c.writePacket(mqttSubscribe<<4|0x02, body); err != nil {
+				return err
+			}
+			suback, err := c.expectPacket(mqttSuback)
+			if err != nil {
+				return err
+			}
+			if _, err := suback.Seek(2, io.SeekCurrent); err != nil {
+				return err
+			}
+			if err := c.skipProperties(suback); err != nil {
+				return err
+			}
+			code, err := suback.ReadByte()
+			if err != nil {
+				return err
+			}
+			if code >= 0x80 {
+				return fmt.Errorf("subscription refused with code %d", code)
+			}
+			return nil
+		}) {
+			return false
+		}
+
+		// The payload is unique, so that retained or other messages
+		// published to the topic are not mistaken for the test message.
+		payload := fmt.Sprintf("blackbox_exporter %d", time.Now().UnixNano())
+		if !step("delivery", func() error {
+			body := appendMQTTString(nil, module.MQTT.Topic)
+			if c.v5 {
+				body = appendMQTTVarint(body, 0)
+			}
+			start := time.Now()
+			if err := c.writePacket(mqttPublish<<4, append(body, payload...)); err != nil {
+				return err
+			}
+			for {
+				topic, received, err := c.readPublish()
+				if err != nil {
+					return err
+				}
+				if topic == module.MQTT.Topic && string(received) == payload {
+					registry.MustRegister(probeMQTTDeliveryLatency)
+					probeMQTTDeliveryLatency.Set(time.Since(start).Seconds())
+					return nil
+				}
+			}
+		}) {
+			return false
+		}
+	}
+
+	return step("disconnect", func() error {
+		return c.writePacket(mqttDisconnect<<4, nil)
+	})
+}
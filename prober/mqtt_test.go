@@ -0,0 +1,200 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveMQTT runs a minimal MQTT broker accepting the password "secret",
+// which sends messages published by a client back to the client.
+func serveMQTT(t *testing.T, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			c := &mqttConn{conn: conn, reader: bufio.NewReader(conn)}
+			connect, err := c.expectPacket(mqttConnect)
+			if err != nil {
+				t.Errorf("Error reading CONNECT: %s", err)
+				return
+			}
+			if _, err := readMQTTString(connect); err != nil {
+				return
+			}
+			var header [4]byte
+			if _, err := io.ReadFull(connect, header[:]); err != nil {
+				return
+			}
+			c.v5 = header[0] == 5
+			if err := c.skipProperties(connect); err != nil {
+				return
+			}
+			// Read the client identifier, then the username and the
+			// password as flagged, so that the password is read last.
+			var password string
+			for _, flag := range []byte{0, 0x80, 0x40} {
+				if header[1]&flag == flag {
+					if password, err = readMQTTString(connect); err != nil {
+						return
+					}
+				}
+			}
+			code := byte(0)
+			if header[1]&0x40 != 0 && password != "secret" {
+				code = 4
+			}
+			connack := []byte{0, code}
+			if c.v5 {
+				connack = appendMQTTVarint(connack, 0)
+			}
+			c.writePacket(mqttConnack<<4, connack)
+
+			for {
+				packetType, _, body, err := c.readPacket()
+				if err != nil {
+					return
+				}
+				switch packetType {
+				case mqttSubscribe:
+					var packetID [2]byte
+					io.ReadFull(body, packetID[:])
+					suback := packetID[:]
+					if c.v5 {
+						suback = appendMQTTVarint(suback, 0)
+					}
+					c.writePacket(mqttSuback<<4, append(suback, 0))
+					// A retained message, which the prober has to skip.
+					publish := binary.BigEndian.AppendUint16(nil, 4)
+					publish = append(publish, "test"...)
+					if c.v5 {
+						publish = appendMQTTVarint(publish, 0)
+					}
+					c.writePacket(mqttPublish<<4|0x01, append(publish, "retained"...))
+				case mqttPublish:
+					time.Sleep(10 * time.Millisecond)
+					payload, _ := io.ReadAll(body)
+					c.writePacket(mqttPublish<<4, payload)
+				case mqttDisconnect:
+					return
+				default:
+					t.Errorf("Unexpected MQTT packet of type %d", packetType)
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestMQTTSession(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go serveMQTT(t, ln)
+
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	tlsLn, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer tlsLn.Close()
+	go serveMQTT(t, tls.NewListener(tlsLn, tlsConfig))
+	_, tlsPort, _ := net.SplitHostPort(tlsLn.Addr().String())
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, version := range []string{"3.1.1", "5"} {
+		t.Run(version, func(t *testing.T) {
+			module := config.Module{MQTT: config.MQTTProbe{
+				IPProtocol:      "ip4",
+				ProtocolVersion: version,
+				Username:        "prober",
+				Password:        "secret",
+				Topic:           "test",
+			}}
+			registry := prometheus.NewRegistry()
+			if !ProbeMQTT(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+				t.Fatalf("MQTT module failed, expected success.")
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedMetrics := map[string]map[string]map[string]struct{}{
+				"probe_mqtt_duration_seconds": {
+					"phase": {
+						"connect":    {},
+						"connack":    {},
+						"subscribe":  {},
+						"delivery":   {},
+						"disconnect": {},
+					},
+				},
+			}
+			checkMetrics(expectedMetrics, mfs, t)
+			checkRegistryResults(map[string]float64{"probe_mqtt_connack_reason_code": 0}, mfs, t)
+			for _, mf := range mfs {
+				if mf.GetName() == "probe_mqtt_delivery_latency_seconds" && mf.GetMetric()[0].GetGauge().GetValue() < 0.01 {
+					t.Fatalf("Expected a delivery latency of at least 10ms, got %v", mf.GetMetric()[0].GetGauge().GetValue())
+				}
+			}
+
+			module.MQTT.Password = "wrong"
+			registry = prometheus.NewRegistry()
+			if ProbeMQTT(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+				t.Fatalf("MQTT module succeeded with a wrong password, expected failure.")
+			}
+			mfs, err = registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{"probe_mqtt_connack_reason_code": 4}, mfs, t)
+		})
+	}
+
+	module := config.Module{MQTT: config.MQTTProbe{
+		IPProtocol:      "ip4",
+		TLS:             true,
+		TLSConfig:       pconfig.TLSConfig{CAFile: caFile},
+		ProtocolVersion: "3.1.1",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeMQTT(testCTX, net.JoinHostPort("localhost", tlsPort), module, registry, log.NewNopLogger()) {
+		t.Fatalf("MQTT module failed with TLS, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_tls_version_info": 1}, mfs, t)
+}
@@ -0,0 +1,444 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// MySQL capability flags.
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientConnectWithDB    = 0x00000008
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientTransactions     = 0x00002000
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientPluginAuth       = 0x00080000
+)
+
+const (
+	mysqlMaxPacketSize  = 1<<24 - 1
+	mysqlCharsetUTF8MB4 = 45
+
+	mysqlCommandQuit  = 0x01
+	mysqlCommandQuery = 0x03
+
+	mysqlNativePasswordPlugin      = "mysql_native_password"
+	mysqlCachingSHA2PasswordPlugin = "caching_sha2_password"
+	// Authentication data of caching_sha2_password.
+	mysqlCachingSHA2RequestKey = 0x02
+	mysqlCachingSHA2FastAuthOK = 0x03
+	mysqlCachingSHA2FullAuth   = 0x04
+)
+
+// mysqlError is an error reported by the server.
+type mysqlError struct {
+	code    uint16
+	message string
+}
+
+func (e *mysqlError) Error() string {
+	return fmt.Sprintf("error %d: %s", e.code, e.message)
+}
+
+func parseMySQLError(packet []byte) error {
+	if len(packet) < 3 {
+		return errors.New("invalid error packet")
+	}
+	message := packet[3:]
+	if len(message) >= 6 && message[0] == '#' {
+		// Skip the SQL state.
+		message = message[6:]
+	}
+	return &mysqlError{code: binary.LittleEndian.Uint16(packet[1:3]), message: string(message)}
+}
+
+// mysqlHandshake is the initial handshake packet of the server.
+type mysqlHandshake struct {
+	version      string
+	capabilities uint32
+	nonce        []byte
+	plugin       string
+}
+
+func parseMySQLHandshake(packet []byte) (*mysqlHandshake, error) {
+	if len(packet) > 0 && packet[0] == 0xff {
+		return nil, parseMySQLError(packet)
+	}
+	if len(packet) == 0 || packet[0] != 10 {
+		return nil, errors.New("unsupported protocol version")
+	}
+	r := bytes.NewBuffer(packet[1:])
+	version, err := r.ReadString(0)
+	if err != nil {
+		return nil, errors.New("invalid handshake packet")
+	}
+	h := &mysqlHandshake{version: version[:len(version)-1]}
+	// Connection ID, first part of the nonce, filler and lower capabilities.
+	fixed := r.Next(15)
+	if len(fixed) < 15 {
+		return nil, errors.New("invalid handshake packet")
+	}
+	h.nonce = append(h.nonce, fixed[4:12]...)
+	h.capabilities = uint32(binary.LittleEndian.Uint16(fixed[13:]))
+	// Character set, status, upper capabilities, nonce length and reserved.
+	if fixed = r.Next(16); len(fixed) == 16 {
+		h.capabilities |= uint32(binary.LittleEndian.Uint16(fixed[3:])) << 16
+		if h.capabilities&mysqlClientSecureConnection != 0 {
+			n := max(13, int(fixed[5])-8)
+			nonce := r.Next(n)
+			if len(nonce) < n {
+				return nil, errors.New("invalid handshake packet")
+			}
+			// The nonce is terminated by a null byte.
+			h.nonce = append(h.nonce, nonce[:n-1]...)
+		}
+		if h.capabilities&mysqlClientPluginAuth != 0 {
+			h.plugin, _ = r.ReadString(0)
+			h.plugin = string(bytes.TrimRight([]byte(h.plugin), "\x00"))
+		}
+	}
+	return h, nil
+}
+
+// mysqlScramble returns the authentication data of the password for the
+// authentication plugin.
+func mysqlScramble(plugin, password string, nonce []byte) ([]byte, error) {
+	if password == "" {
+		return nil, nil
+	}
+	switch plugin {
+	case mysqlNativePasswordPlugin:
+		// SHA1(password) XOR SHA1(nonce + SHA1(SHA1(password))).
+		h1 := sha1.Sum([]byte(password))
+		h2 := sha1.Sum(h1[:])
+		h3 := sha1.Sum(append(append([]byte{}, nonce...), h2[:]...))
+		for i := range h1 {
+			h1[i] ^= h3[i]
+		}
+		return h1[:], nil
+	case mysqlCachingSHA2PasswordPlugin:
+		// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + nonce).
+		h1 := sha256.Sum256([]byte(password))
+		h2 := sha256.Sum256(h1[:])
+		h3 := sha256.Sum256(append(h2[:], nonce...))
+		for i := range h1 {
+			h1[i] ^= h3[i]
+		}
+		return h1[:], nil
+	default:
+		return nil, fmt.Errorf("unsupported authentication plugin %q", plugin)
+	}
+}
+
+// mysqlConn sends and receives packets of the MySQL client/server protocol.
+type mysqlConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	seq    byte
+}
+
+func (c *mysqlConn) readPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	c.seq = header[3] + 1
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func (c *mysqlConn) writePacket(packet []byte) error {
+	header := []byte{byte(len(packet)), byte(len(packet) >> 8), byte(len(packet) >> 16), c.seq}
+	c.seq++
+	_, err := c.conn.Write(append(header, packet...))
+	return err
+}
+
+// command sends a command, starting a new sequence of packets.
+func (c *mysqlConn) command(command byte, argument string) error {
+	c.seq = 0
+	return c.writePacket(append([]byte{command}, argument...))
+}
+
+// authenticate completes the authentication after the handshake response
+// was sent, switching the plugin or sending the full password if requested.
+func (c *mysqlConn) authenticate(plugin, password string, nonce []byte, secure bool) error {
+	requestedKey := false
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if len(packet) == 0 {
+			return errors.New("empty packet")
+		}
+		switch packet[0] {
+		case 0x00:
+			return nil
+		case 0xff:
+			return parseMySQLError(packet)
+		case 0xfe:
+			// Authentication switch request.
+			data := bytes.SplitN(packet[1:], []byte{0}, 2)
+			plugin = string(data[0])
+			if len(data) == 2 {
+				nonce = bytes.TrimRight(data[1], "\x00")
+			}
+			scramble, err := mysqlScramble(plugin, password, nonce)
+			if err != nil {
+				return err
+			}
+			if err := c.writePacket(scramble); err != nil {
+				return err
+			}
+		case 0x01:
+			switch {
+			case requestedKey:
+				block, _ := pem.Decode(packet[1:])
+				if block == nil || len(nonce) == 0 {
+					return errors.New("invalid public key")
+				}
+				key, err := x509.ParsePKIXPublicKey(block.Bytes)
+				if err != nil {
+					return err
+				}
+				rsaKey, ok := key.(*rsa.PublicKey)
+				if !ok {
+					return errors.New("public key is not an RSA key")
+				}
+				plaintext := append([]byte(password), 0)
+				for i := range plaintext {
+					plaintext[i] ^= nonce[i%len(nonce)]
+				}
+				encrypted, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, rsaKey, plaintext, nil)
+				if err != nil {
+					return err
+				}
+				if err := c.writePacket(encrypted); err != nil {
+					return err
+				}
+			case len(packet) > 1 && packet[1] == mysqlCachingSHA2FastAuthOK:
+			case len(packet) > 1 && packet[1] == mysqlCachingSHA2FullAuth:
+				// The password is sent in clear over TLS, and encrypted with
+				// the public key of the server otherwise.
+				if secure {
+					err = c.writePacket(append([]byte(password), 0))
+				} else {
+					requestedKey = true
+					err = c.writePacket([]byte{mysqlCachingSHA2RequestKey})
+				}
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unexpected authentication data for plugin %s", plugin)
+			}
+		default:
+			return fmt.Errorf("unexpected packet 0x%02x during authentication", packet[0])
+		}
+	}
+}
+
+// query runs the query and reads its result.
+func (c *mysqlConn) query(query string) error {
+	if err := c.command(mysqlCommandQuery, query); err != nil {
+		return err
+	}
+	// A result set consists of the column count, the column definitions and
+	// the rows, each terminated by an EOF packet.
+	for eof := 0; eof < 2; {
+		packet, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if len(packet) == 0 {
+			return errors.New("empty packet")
+		}
+		switch {
+		case packet[0] == 0xff:
+			return parseMySQLError(packet)
+		case packet[0] == 0x00 && eof == 0:
+			return nil
+		case packet[0] == 0xfe && len(packet) < 9:
+			eof++
+		}
+	}
+	return nil
+}
+
+// ProbeMySQL reads the handshake of a MySQL server and, if a username is
+// configured, authenticates and runs a query.
+func ProbeMySQL(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeMySQLDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_mysql_duration_seconds",
+		Help: "Duration of each phase of the MySQL session",
+	}, []string{"phase"})
+	probeMySQLServerVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_mysql_server_version_info",
+		Help: "Contains the version of the MySQL server",
+	}, []string{"version"})
+	probeMySQLErrorCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_mysql_error_code",
+		Help: "Code of the error reported by the server",
+	})
+	registry.MustRegister(probeMySQLDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeMySQLDuration, "phase", "MySQL phase", logger, func(err error) {
+		var serverErr *mysqlError
+		if errors.As(err, &serverErr) {
+			registry.MustRegister(probeMySQLErrorCode)
+			probeMySQLErrorCode.Set(float64(serverErr.code))
+		}
+	})
+
+	tlsConfig, err := newTargetTLSConfig(&module.MySQL.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.MySQL.IPProtocol, module.MySQL.IPProtocolFallback, module.MySQL.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+	c := &mysqlConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	var handshake *mysqlHandshake
+	if !step("handshake", func() error {
+		packet, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if handshake, err = parseMySQLHandshake(packet); err != nil {
+			return err
+		}
+		if handshake.capabilities&mysqlClientProtocol41 == 0 {
+			return errors.New("server does not support protocol 4.1")
+		}
+		registry.MustRegister(probeMySQLServerVersion)
+		probeMySQLServerVersion.WithLabelValues(handshake.version).Set(1)
+		return nil
+	}) {
+		return false
+	}
+
+	capabilities := uint32(mysqlClientLongPassword | mysqlClientProtocol41 | mysqlClientTransactions | mysqlClientSecureConnection | mysqlClientPluginAuth)
+	if module.MySQL.Database != "" {
+		capabilities |= mysqlClientConnectWithDB
+	}
+	// The client settings shared by the TLS request and the handshake
+	// response.
+	clientSettings := func() []byte {
+		settings := binary.LittleEndian.AppendUint32(nil, capabilities)
+		settings = binary.LittleEndian.AppendUint32(settings, mysqlMaxPacketSize)
+		return append(settings, make([]byte, 24)...)
+	}
+
+	if module.MySQL.TLS {
+		if !step("tls", func() error {
+			if handshake.capabilities&mysqlClientSSL == 0 {
+				return errors.New("server does not support TLS")
+			}
+			capabilities |= mysqlClientSSL
+			settings := clientSettings()
+			settings[8] = mysqlCharsetUTF8MB4
+			if err := c.writePacket(settings); err != nil {
+				return err
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				return err
+			}
+			state := tlsConn.ConnectionState()
+			reportTLSConnectionState(&state, registry)
+			c.conn, c.reader = tlsConn, bufio.NewReader(tlsConn)
+			return nil
+		}) {
+			return false
+		}
+	}
+
+	if module.MySQL.Username == "" {
+		return true
+	}
+
+	if !step("auth", func() error {
+		plugin := handshake.plugin
+		if plugin == "" {
+			plugin = mysqlNativePasswordPlugin
+		}
+		password := string(module.MySQL.Password)
+		scramble, err := mysqlScramble(plugin, password, handshake.nonce)
+		if err != nil {
+			// Answer with the native password, the server will request
+			// a supported plugin.
+			plugin = mysqlNativePasswordPlugin
+			scramble, _ = mysqlScramble(plugin, password, handshake.nonce)
+		}
+		response := clientSettings()
+		response[8] = mysqlCharsetUTF8MB4
+		response = append(append(response, module.MySQL.Username...), 0)
+		response = append(append(response, byte(len(scramble))), scramble...)
+		if module.MySQL.Database != "" {
+			response = append(append(response, module.MySQL.Database...), 0)
+		}
+		response = append(append(response, plugin...), 0)
+		if err := c.writePacket(response); err != nil {
+			return err
+		}
+		return c.authenticate(plugin, password, handshake.nonce, module.MySQL.TLS)
+	}) {
+		return false
+	}
+
+	if module.MySQL.Query != "" {
+		if !step("query", func() error { return c.query(module.MySQL.Query) }) {
+			return false
+		}
+	}
+
+	// The server closes the connection without a response.
+	return c.command(mysqlCommandQuit, "") == nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+var mysqlTestNonce = []byte("0123456789abcdefghij")
+
+// mysqlTestHandshake encodes the handshake of a server offering
+// caching_sha2_password.
+func mysqlTestHandshake() []byte {
+	capabilities := uint32(mysqlClientProtocol41 | mysqlClientSSL | mysqlClientSecureConnection | mysqlClientPluginAuth | mysqlClientConnectWithDB)
+	packet := append([]byte{10}, "8.0.39\x00"...)
+	packet = binary.LittleEndian.AppendUint32(packet, 1)
+	packet = append(append(packet, mysqlTestNonce[:8]...), 0)
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(capabilities))
+	packet = append(packet, mysqlCharsetUTF8MB4, 2, 0)
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(capabilities>>16))
+	packet = append(packet, 21)
+	packet = append(packet, make([]byte, 10)...)
+	packet = append(append(packet, mysqlTestNonce[8:]...), 0)
+	return append(packet, mysqlCachingSHA2PasswordPlugin+"\x00"...)
+}
+
+func mysqlTestError(code uint16, message string) []byte {
+	packet := binary.LittleEndian.AppendUint16([]byte{0xff}, code)
+	return append(packet, "#28000"+message...)
+}
+
+// mysqlBufferedConn reads from a reader buffering the connection.
+type mysqlBufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *mysqlBufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// serveMySQL runs a minimal MySQL server accepting the password "secret".
+// The user "native" is switched to mysql_native_password, the user "full"
+// has to send the full password and others use the cached password.
+func serveMySQL(t *testing.T, ln net.Listener, tlsConfig *tls.Config, key *rsa.PrivateKey) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			c := &mysqlConn{conn: conn, reader: bufio.NewReader(conn)}
+			c.writePacket(mysqlTestHandshake())
+			response, err := c.readPacket()
+			if err != nil {
+				return
+			}
+			secure := false
+			if binary.LittleEndian.Uint32(response)&mysqlClientSSL != 0 && len(response) == 32 {
+				// The client hello may have been buffered with the request.
+				tlsConn := tls.Server(&mysqlBufferedConn{Conn: conn, reader: c.reader}, tlsConfig)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				c.conn, c.reader = tlsConn, bufio.NewReader(tlsConn)
+				secure = true
+				if response, err = c.readPacket(); err != nil {
+					return
+				}
+			}
+			fields := bytes.SplitN(response[32:], []byte{0}, 2)
+			user, scramble := string(fields[0]), fields[1][1:1+fields[1][0]]
+
+			authenticated := false
+			switch user {
+			case "native":
+				c.writePacket(append([]byte{0xfe}, mysqlNativePasswordPlugin+"\x00"+string(mysqlTestNonce)+"\x00"...))
+				expected, _ := mysqlScramble(mysqlNativePasswordPlugin, "secret", mysqlTestNonce)
+				scramble, _ = c.readPacket()
+				authenticated = bytes.Equal(scramble, expected)
+			case "full":
+				c.writePacket([]byte{0x01, mysqlCachingSHA2FullAuth})
+				password, _ := c.readPacket()
+				if !secure {
+					publicKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+					c.writePacket(append([]byte{0x01}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})...))
+					encrypted, _ := c.readPacket()
+					password, _ = rsa.DecryptOAEP(sha1.New(), rand.Reader, key, encrypted, nil)
+					for i := range password {
+						password[i] ^= mysqlTestNonce[i%len(mysqlTestNonce)]
+					}
+				}
+				authenticated = string(password) == "secret\x00"
+			default:
+				expected, _ := mysqlScramble(mysqlCachingSHA2PasswordPlugin, "secret", mysqlTestNonce)
+				if authenticated = bytes.Equal(scramble, expected); authenticated {
+					c.writePacket([]byte{0x01, mysqlCachingSHA2FastAuthOK})
+				}
+			}
+			if !authenticated {
+				c.writePacket(mysqlTestError(1045, "Access denied"))
+				return
+			}
+			c.writePacket([]byte{0, 0, 0, 2, 0, 0, 0})
+
+			for {
+				command, err := c.readPacket()
+				if err != nil || command[0] == mysqlCommandQuit {
+					return
+				}
+				if string(command) != "\x03SELECT 1" {
+					c.writePacket(mysqlTestError(1064, "You have an error in your SQL syntax"))
+					continue
+				}
+				for _, packet := range [][]byte{{1}, []byte("\x03def\x00\x00\x00\x011\x00\x0c\x3f\x00\x01\x00\x00\x00\x08\x81\x00\x00\x00\x00"), {0xfe, 0, 0, 2, 0}, {1, '1'}, {0xfe, 0, 0, 2, 0}} {
+					c.writePacket(packet)
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestMySQLQuery(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveMySQL(t, ln, tlsConfig, key)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	target := net.JoinHostPort("localhost", port)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	module := config.Module{MySQL: config.MySQLProbe{
+		IPProtocol: "ip4",
+		TLS:        true,
+		TLSConfig:  pconfig.TLSConfig{CAFile: caFile},
+		Username:   "prober",
+		Password:   "secret",
+		Database:   "mysql",
+		Query:      "SELECT 1",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeMySQL(testCTX, target, module, registry, log.NewNopLogger()) {
+		t.Fatalf("MySQL module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_mysql_duration_seconds": {
+			"phase": {
+				"connect":   {},
+				"handshake": {},
+				"tls":       {},
+				"auth":      {},
+				"query":     {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	checkRegistryLabels(map[string]map[string]string{"probe_mysql_server_version_info": {"version": "8.0.39"}}, mfs, t)
+	checkRegistryResults(map[string]float64{"probe_tls_version_info": 1}, mfs, t)
+
+	for _, tc := range []struct {
+		name      string
+		module    config.MySQLProbe
+		success   bool
+		errorCode float64
+	}{
+		{
+			name:    "handshake only",
+			module:  config.MySQLProbe{IPProtocol: "ip4"},
+			success: true,
+		},
+		{
+			name:    "native password",
+			module:  config.MySQLProbe{IPProtocol: "ip4", Username: "native", Password: "secret", Query: "SELECT 1"},
+			success: true,
+		},
+		{
+			name:    "full authentication with public key",
+			module:  config.MySQLProbe{IPProtocol: "ip4", Username: "full", Password: "secret"},
+			success: true,
+		},
+		{
+			name:    "full authentication over TLS",
+			module:  config.MySQLProbe{IPProtocol: "ip4", TLS: true, TLSConfig: pconfig.TLSConfig{CAFile: caFile}, Username: "full", Password: "secret"},
+			success: true,
+		},
+		{
+			name:      "wrong password",
+			module:    config.MySQLProbe{IPProtocol: "ip4", Username: "native", Password: "wrong"},
+			errorCode: 1045,
+		},
+		{
+			name:      "invalid query",
+			module:    config.MySQLProbe{IPProtocol: "ip4", Username: "prober", Password: "secret", Query: "SELEC 1"},
+			errorCode: 1064,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			if ProbeMySQL(testCTX, target, config.Module{MySQL: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			if tc.success {
+				return
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{"probe_mysql_error_code": tc.errorCode}, mfs, t)
+		})
+	}
+}
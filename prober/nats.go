@@ -0,0 +1,256 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// natsSeedPrefix is the prefix byte of an encoded NKey seed.
+const natsSeedPrefix = 18 << 3
+
+// natsCredentialsBlock matches the JWT and seed blocks of a credentials file.
+var natsCredentialsBlock = regexp.MustCompile(`\s*(?:-{3,}[^\n]*-{3,}\r?\n)([\w\-.=]+)(?:\r?\n-{3,}[^\n]*-{3,}\r?\n?)`)
+
+// natsCRC16 computes the CRC-16/XMODEM checksum of encoded NKeys.
+func natsCRC16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// parseNATSCredentials returns the user JWT and the private key of the NKey
+// seed in a credentials file.
+func parseNATSCredentials(contents []byte) (string, ed25519.PrivateKey, error) {
+	blocks := natsCredentialsBlock.FindAllSubmatch(contents, 2)
+	if len(blocks) != 2 {
+		return "", nil, errors.New("credentials must contain a JWT and an NKey seed")
+	}
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(string(blocks[1][1]))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid NKey seed: %w", err)
+	}
+	if len(raw) != 2+ed25519.SeedSize+2 || raw[0]&0xf8 != natsSeedPrefix {
+		return "", nil, errors.New("invalid NKey seed")
+	}
+	if natsCRC16(raw[:len(raw)-2]) != binary.LittleEndian.Uint16(raw[len(raw)-2:]) {
+		return "", nil, errors.New("invalid NKey seed checksum")
+	}
+	return string(blocks[0][1]), ed25519.NewKeyFromSeed(raw[2 : 2+ed25519.SeedSize]), nil
+}
+
+// natsInfo is the part of the server INFO the probe uses.
+type natsInfo struct {
+	Version     string `json:"version"`
+	TLSRequired bool   `json:"tls_required"`
+	Nonce       string `json:"nonce"`
+}
+
+// natsConn speaks the NATS client protocol.
+type natsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// readOp reads the next protocol line, answering server PINGs and turning
+// -ERR into an error.
+func (c *natsConn) readOp() (string, error) {
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			if _, err := io.WriteString(c.conn, "PONG\r\n"); err != nil {
+				return "", err
+			}
+		case line == "+OK":
+		case strings.HasPrefix(line, "-ERR"):
+			return "", fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		default:
+			return line, nil
+		}
+	}
+}
+
+// ProbeNATS connects to a NATS server, authenticates and checks the
+// connection with a PING. If a subject is configured, a request is sent to it
+// and the probe waits for the reply.
+func ProbeNATS(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeNATSDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_nats_duration_seconds",
+		Help: "Duration of each phase of the NATS session",
+	}, []string{"phase"})
+	probeNATSVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_nats_server_info",
+		Help: "Contains the version of the NATS server",
+	}, []string{"version"})
+	registry.MustRegister(probeNATSDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeNATSDuration, "phase", "NATS phase", logger, nil)
+
+	tlsConfig, err := newTargetTLSConfig(&module.NATS.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var (
+		conn net.Conn
+		info natsInfo
+	)
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.NATS.IPProtocol, module.NATS.IPProtocolFallback, module.NATS.SourceIPAddress, registry, logger)
+		if err != nil {
+			return err
+		}
+		// The server sends INFO in plain text before the TLS handshake.
+		line, err := (&natsConn{conn: conn, reader: bufio.NewReader(conn)}).readOp()
+		if err != nil {
+			return err
+		}
+		payload, ok := strings.CutPrefix(line, "INFO ")
+		if !ok {
+			return fmt.Errorf("expected INFO, got %q", line)
+		}
+		return json.Unmarshal([]byte(payload), &info)
+	}) {
+		return false
+	}
+	defer conn.Close()
+	registry.MustRegister(probeNATSVersion)
+	probeNATSVersion.WithLabelValues(info.Version).Set(1)
+
+	if module.NATS.TLS || info.TLSRequired {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+		conn = tlsConn
+	}
+	c := &natsConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if !step("ping", func() error {
+		options := map[string]interface{}{
+			"verbose":      false,
+			"pedantic":     false,
+			"tls_required": module.NATS.TLS || info.TLSRequired,
+			"name":         "blackbox_exporter",
+			"lang":         "go",
+			"version":      "1.0.0",
+			"protocol":     1,
+		}
+		switch {
+		case module.NATS.Token != "":
+			options["auth_token"] = string(module.NATS.Token)
+		case module.NATS.Username != "":
+			options["user"] = module.NATS.Username
+			options["pass"] = string(module.NATS.Password)
+		case module.NATS.CredentialsFile != "":
+			contents, err := os.ReadFile(module.NATS.CredentialsFile)
+			if err != nil {
+				return err
+			}
+			jwt, key, err := parseNATSCredentials(contents)
+			if err != nil {
+				return err
+			}
+			options["jwt"] = jwt
+			options["sig"] = base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(info.Nonce)))
+		}
+		connect, err := json.Marshal(options)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(c.conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+			return err
+		}
+		line, err := c.readOp()
+		if err != nil {
+			return err
+		}
+		if line != "PONG" {
+			return fmt.Errorf("expected PONG, got %q", line)
+		}
+		return nil
+	}) {
+		return false
+	}
+
+	if module.NATS.Subject == "" {
+		return true
+	}
+	return step("request", func() error {
+		var id [8]byte
+		rand.Read(id[:])
+		inbox := "_INBOX." + hex.EncodeToString(id[:])
+		// The subscription is removed after the first message.
+		if _, err := fmt.Fprintf(c.conn, "SUB %s 1\r\nUNSUB 1 1\r\nPUB %s %s %d\r\n%s\r\n",
+			inbox, module.NATS.Subject, inbox, len(module.NATS.Payload), module.NATS.Payload); err != nil {
+			return err
+		}
+		line, err := c.readOp()
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "MSG" || fields[1] != inbox {
+			return fmt.Errorf("expected reply, got %q", line)
+		}
+		size, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return fmt.Errorf("invalid reply size: %w", err)
+		}
+		// The reply payload is followed by CRLF.
+		_, err = io.CopyN(io.Discard, c.reader, int64(size)+2)
+		return err
+	})
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const natsTestNonce = "c2VjcmV0IG5vbmNl"
+
+// natsTestSeed encodes the seed of a user NKey.
+func natsTestSeed(seed []byte) string {
+	const userPrefix = 20 << 3
+	raw := append([]byte{natsSeedPrefix | userPrefix>>5, userPrefix & 31 << 3}, seed...)
+	raw = binary.LittleEndian.AppendUint16(raw, natsCRC16(raw))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+}
+
+// serveNATS runs a minimal NATS server. Clients authenticate with the token
+// "s3cr3t" or with the NKey of publicKey, and requests to the subject "echo"
+// are answered with their payload.
+func serveNATS(t *testing.T, ln net.Listener, tlsConfig *tls.Config, publicKey ed25519.PublicKey) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			fmt.Fprintf(conn, "INFO {\"version\":\"2.10.7\",\"tls_required\":%v,\"nonce\":%q}\r\n", tlsConfig != nil, natsTestNonce)
+			if tlsConfig != nil {
+				conn = tls.Server(conn, tlsConfig)
+			}
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				verb, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+				switch verb {
+				case "CONNECT":
+					var options struct {
+						AuthToken string `json:"auth_token"`
+						JWT       string `json:"jwt"`
+						Sig       string `json:"sig"`
+					}
+					if err := json.Unmarshal([]byte(args), &options); err != nil {
+						t.Errorf("Invalid CONNECT: %s", err)
+						return
+					}
+					sig, _ := base64.RawURLEncoding.DecodeString(options.Sig)
+					if options.AuthToken != "s3cr3t" && (options.JWT != "eyJ0ZXN0In0" || !ed25519.Verify(publicKey, []byte(natsTestNonce), sig)) {
+						io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+						return
+					}
+				case "PING":
+					io.WriteString(conn, "PONG\r\n")
+				case "PUB":
+					fields := strings.Fields(args)
+					size, _ := strconv.Atoi(fields[len(fields)-1])
+					payload := make([]byte, size+2)
+					if _, err := io.ReadFull(reader, payload); err != nil {
+						return
+					}
+					if fields[0] == "echo" {
+						fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s", fields[1], size, payload)
+					}
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestNATSSession(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsFile := filepath.Join(t.TempDir(), "user.creds")
+	credentials := fmt.Sprintf(`-----BEGIN NATS USER JWT-----
+eyJ0ZXN0In0
+------END NATS USER JWT------
+
+************************* IMPORTANT *************************
+NKEY Seed printed below can be used to sign and prove identity.
+
+-----BEGIN USER NKEY SEED-----
+%s
+------END USER NKEY SEED------
+`, natsTestSeed(privateKey.Seed()))
+	if err := os.WriteFile(credentialsFile, []byte(credentials), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	for _, tc := range []struct {
+		name    string
+		module  config.NATSProbe
+		tls     bool
+		success bool
+		phases  map[string]struct{}
+	}{
+		{
+			name:    "token",
+			module:  config.NATSProbe{Token: "s3cr3t"},
+			success: true,
+			phases:  map[string]struct{}{"connect": {}, "ping": {}},
+		},
+		{
+			name:   "wrong token",
+			module: config.NATSProbe{Token: "wrong"},
+			phases: map[string]struct{}{"connect": {}, "ping": {}},
+		},
+		{
+			name:    "credentials with tls",
+			module:  config.NATSProbe{CredentialsFile: credentialsFile, TLSConfig: pconfig.TLSConfig{CAFile: caFile}},
+			tls:     true,
+			success: true,
+			phases:  map[string]struct{}{"connect": {}, "tls": {}, "ping": {}},
+		},
+		{
+			name:    "request",
+			module:  config.NATSProbe{Token: "s3cr3t", Subject: "echo", Payload: "ping"},
+			success: true,
+			phases:  map[string]struct{}{"connect": {}, "ping": {}, "request": {}},
+		},
+		{
+			name:   "request without responder",
+			module: config.NATSProbe{Token: "s3cr3t", Subject: "nobody"},
+			phases: map[string]struct{}{"connect": {}, "ping": {}, "request": {}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer ln.Close()
+			serverTLSConfig := tlsConfig
+			if !tc.tls {
+				serverTLSConfig = nil
+			}
+			go serveNATS(t, ln, serverTLSConfig, publicKey)
+
+			ctx := testCTX
+			if !tc.success {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(testCTX, time.Second)
+				defer cancel()
+			}
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			_, port, _ := net.SplitHostPort(ln.Addr().String())
+			if ProbeNATS(ctx, net.JoinHostPort("localhost", port), config.Module{NATS: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_nats_duration_seconds": {"phase": tc.phases}}, mfs, t)
+			checkRegistryLabels(map[string]map[string]string{"probe_nats_server_info": {"version": "2.10.7"}}, mfs, t)
+		})
+	}
+}
+
+func TestParseNATSCredentials(t *testing.T) {
+	seed := natsTestSeed(make([]byte, ed25519.SeedSize))
+	corrupted := []byte(seed)
+	if corrupted[10] == 'A' {
+		corrupted[10] = 'B'
+	} else {
+		corrupted[10] = 'A'
+	}
+	for _, tc := range []struct {
+		seed string
+		err  string
+	}{
+		{seed: seed},
+		{seed: string(corrupted), err: "invalid NKey seed checksum"},
+	} {
+		contents := fmt.Sprintf("-----BEGIN NATS USER JWT-----\njwt\n------END NATS USER JWT------\n\n-----BEGIN USER NKEY SEED-----\n%s\n------END USER NKEY SEED------\n", tc.seed)
+		jwt, _, err := parseNATSCredentials([]byte(contents))
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("Expected error %q, got %v", tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwt != "jwt" {
+			t.Errorf("Unexpected JWT %q", jwt)
+		}
+	}
+}
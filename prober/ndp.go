@@ -0,0 +1,186 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	ndpOptionSourceLinkLayerAddr = 1
+	ndpOptionTargetLinkLayerAddr = 2
+
+	ndpFlagRouter    = 0x80
+	ndpFlagSolicited = 0x40
+)
+
+// ndpAdvertisement holds the fields of a neighbor advertisement the probe
+// reports.
+type ndpAdvertisement struct {
+	hardwareAddr net.HardwareAddr
+	router       bool
+}
+
+// solicitedNodeAddr returns the solicited-node multicast address of ip.
+func solicitedNodeAddr(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+	return addr
+}
+
+// buildNeighborSolicitation returns the body of a neighbor solicitation for
+// target, advertising the hardware address of the sender.
+func buildNeighborSolicitation(target net.IP, srcMAC net.HardwareAddr) []byte {
+	// Reserved field, target address and source link-layer address option.
+	b := make([]byte, 4+16+8)
+	copy(b[4:20], target.To16())
+	b[20], b[21] = ndpOptionSourceLinkLayerAddr, 1
+	copy(b[22:28], srcMAC)
+	return b
+}
+
+// parseNeighborAdvertisement returns the advertisement if body is a
+// solicited neighbor advertisement for target.
+func parseNeighborAdvertisement(body []byte, target net.IP) (*ndpAdvertisement, bool) {
+	if len(body) < 20 || body[0]&ndpFlagSolicited == 0 || !net.IP(body[4:20]).Equal(target) {
+		return nil, false
+	}
+	adv := &ndpAdvertisement{router: body[0]&ndpFlagRouter != 0}
+	for options := body[20:]; len(options) >= 8; {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			break
+		}
+		if options[0] == ndpOptionTargetLinkLayerAddr && length >= 8 {
+			adv.hardwareAddr = net.HardwareAddr(bytes.Clone(options[2:8]))
+		}
+		options = options[length:]
+	}
+	return adv, true
+}
+
+// ProbeNDP sends an IPv6 neighbor solicitation for an on-link target and
+// waits for its advertisement, which shows that the target is present on the
+// network even if it filters echo requests.
+func ProbeNDP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeNDPDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_ndp_duration_seconds",
+		Help: "Time between sending the neighbor solicitation and receiving the advertisement",
+	})
+	probeNDPReplyInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_ndp_reply_info",
+		Help: "Contains the hardware address of the target and whether it is a router",
+	}, []string{"hardware_address", "router"})
+	registry.MustRegister(probeNDPDuration, probeNDPReplyInfo)
+
+	// The zone of a link-local target names the interface.
+	host, zone, _ := strings.Cut(target, "%")
+	dstIPAddr, _, err := chooseProtocol(ctx, "ip6", false, host, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+	ifaceName := module.NDP.SourceInterface
+	if ifaceName == "" {
+		ifaceName = zone
+	}
+	iface, srcIP, err := onLinkInterface(ifaceName, dstIPAddr.IP)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error choosing interface", "err", err)
+		return false
+	}
+
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", srcIP.String()+"%"+iface.Name)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error listening to socket", "err", err)
+		return false
+	}
+	defer conn.Close()
+	// Neighbor discovery messages must be sent with a hop limit of 255 so
+	// that receivers know they were not forwarded.
+	pc := conn.IPv6PacketConn()
+	if err := pc.SetMulticastHopLimit(255); err != nil {
+		level.Error(logger).Log("msg", "Error setting hop limit", "err", err)
+		return false
+	}
+	if err := pc.SetMulticastInterface(iface); err != nil {
+		level.Error(logger).Log("msg", "Error setting multicast interface", "err", err)
+		return false
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		level.Error(logger).Log("msg", "Error setting socket deadline", "err", err)
+		return false
+	}
+
+	wm := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborSolicitation,
+		Body: &icmp.RawBody{Data: buildNeighborSolicitation(dstIPAddr.IP, iface.HardwareAddr)},
+	}
+	// The kernel computes the checksum of ICMPv6 messages.
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error marshalling packet", "err", err)
+		return false
+	}
+	dst := &net.IPAddr{IP: solicitedNodeAddr(dstIPAddr.IP), Zone: iface.Name}
+	level.Info(logger).Log("msg", "Sending neighbor solicitation", "interface", iface.Name, "srcIP", srcIP, "dst", dst)
+	start := time.Now()
+	if _, err := conn.WriteTo(wb, dst); err != nil {
+		level.Error(logger).Log("msg", "Error writing to socket", "err", err)
+		return false
+	}
+
+	rb := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(rb)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error waiting for neighbor advertisement", "err", err)
+			return false
+		}
+		msg, err := icmp.ParseMessage(58, rb[:n])
+		if err != nil || msg.Type != ipv6.ICMPTypeNeighborAdvertisement {
+			continue
+		}
+		raw, ok := msg.Body.(*icmp.RawBody)
+		if !ok {
+			continue
+		}
+		adv, ok := parseNeighborAdvertisement(raw.Data, dstIPAddr.IP)
+		if !ok {
+			continue
+		}
+		rtt := time.Since(start).Seconds()
+		level.Info(logger).Log("msg", "Received neighbor advertisement", "hardware_address", adv.hardwareAddr, "router", adv.router, "duration_seconds", rtt)
+		probeNDPDuration.Set(rtt)
+		router := "false"
+		if adv.router {
+			router = "true"
+		}
+		probeNDPReplyInfo.WithLabelValues(adv.hardwareAddr.String(), router).Set(1)
+		return true
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestSolicitedNodeAddr(t *testing.T) {
+	got := solicitedNodeAddr(net.ParseIP("fe80::2aa:ff:fe28:9c5a"))
+	if want := net.ParseIP("ff02::1:ff28:9c5a"); !got.Equal(want) {
+		t.Fatalf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestNDPMessages(t *testing.T) {
+	target := net.ParseIP("fe80::1")
+	mac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+
+	solicitation := buildNeighborSolicitation(target, mac)
+	if _, ok := parseNeighborAdvertisement(solicitation, target); ok {
+		t.Fatalf("Solicitation was parsed as a solicited advertisement")
+	}
+
+	// Turn the solicitation into the advertisement of a router.
+	advertisement := append([]byte(nil), solicitation...)
+	advertisement[0] = ndpFlagRouter | ndpFlagSolicited
+	advertisement[20] = ndpOptionTargetLinkLayerAddr
+
+	adv, ok := parseNeighborAdvertisement(advertisement, target)
+	if !ok {
+		t.Fatalf("Advertisement was not parsed")
+	}
+	if adv.hardwareAddr.String() != mac.String() || !adv.router {
+		t.Fatalf("Unexpected advertisement %+v", adv)
+	}
+	if _, ok := parseNeighborAdvertisement(advertisement, net.ParseIP("fe80::2")); ok {
+		t.Fatalf("Advertisement of another address was accepted")
+	}
+}
+
+func TestNDPLinkLocalWithoutInterface(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ProbeNDP(testCTX, "fe80::1", config.Module{}, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("NDP module succeeded for a link-local target without interface, expected failure.")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	rpcPortmapProgram = 100000
+	rpcPortmapVersion = 2
+	rpcPortmapGetPort = 3
+	rpcProtocolTCP    = 6
+)
+
+// rpcPrograms maps the services that can be probed to their RPC program and
+// version.
+var rpcPrograms = map[string][2]uint32{
+	"nfs":      {100003, 3},
+	"mountd":   {100005, 3},
+	"nlockmgr": {100021, 4},
+	"status":   {100024, 1},
+}
+
+// rpcProgram returns the program and version of a service, using the
+// configured version for NFS itself.
+func rpcProgram(service string, nfsVersion int) [2]uint32 {
+	program := rpcPrograms[service]
+	if service == "nfs" {
+		program[1] = uint32(nfsVersion)
+	}
+	return program
+}
+
+// rpcError is a call that was not executed successfully by the server.
+type rpcError struct {
+	rejected bool
+	status   uint32
+}
+
+func (e *rpcError) Error() string {
+	if e.rejected {
+		return fmt.Sprintf("call rejected with status %d", e.status)
+	}
+	return fmt.Sprintf("call not accepted with status %d", e.status)
+}
+
+// rpcCall sends an ONC RPC call with AUTH_NONE over a TCP connection and
+// returns the results of the reply.
+func rpcCall(conn net.Conn, program, version, procedure uint32, args []byte) ([]byte, error) {
+	xid := rand.Uint32()
+	call := binary.BigEndian.AppendUint32(nil, xid)
+	for _, v := range []uint32{0, 2, program, version, procedure, 0, 0, 0, 0} {
+		call = binary.BigEndian.AppendUint32(call, v)
+	}
+	call = append(call, args...)
+	// The call is sent as a single, last fragment of the record.
+	record := binary.BigEndian.AppendUint32(nil, 0x80000000|uint32(len(call)))
+	if _, err := conn.Write(append(record, call...)); err != nil {
+		return nil, err
+	}
+
+	var reply []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return nil, err
+		}
+		marker := binary.BigEndian.Uint32(header[:])
+		if length := marker & 0x7fffffff; len(reply)+int(length) > 1<<20 {
+			return nil, errors.New("reply too large")
+		}
+		fragment := make([]byte, marker&0x7fffffff)
+		if _, err := io.ReadFull(conn, fragment); err != nil {
+			return nil, err
+		}
+		reply = append(reply, fragment...)
+		if marker&0x80000000 != 0 {
+			break
+		}
+	}
+
+	if len(reply) < 12 || binary.BigEndian.Uint32(reply) != xid || binary.BigEndian.Uint32(reply[4:]) != 1 {
+		return nil, errors.New("invalid RPC reply")
+	}
+	if replyStatus := binary.BigEndian.Uint32(reply[8:]); replyStatus != 0 {
+		var status uint32
+		if len(reply) >= 16 {
+			status = binary.BigEndian.Uint32(reply[12:])
+		}
+		return nil, &rpcError{rejected: true, status: status}
+	}
+	// The reply is accepted and carries a verifier before the status.
+	if len(reply) < 20 {
+		return nil, errors.New("truncated RPC reply")
+	}
+	verifierLength := int(binary.BigEndian.Uint32(reply[16:]))
+	offset := 20 + (verifierLength+3)&^3
+	if verifierLength > len(reply) || len(reply) < offset+4 {
+		return nil, errors.New("truncated RPC reply")
+	}
+	if acceptStatus := binary.BigEndian.Uint32(reply[offset:]); acceptStatus != 0 {
+		return nil, &rpcError{status: acceptStatus}
+	}
+	return reply[offset+4:], nil
+}
+
+// ProbeNFS asks the portmapper at the target for the TCP ports of the NFS
+// services and optionally performs a NULL call to each of them.
+func ProbeNFS(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeNFSDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_nfs_duration_seconds",
+		Help: "Duration of each phase of the NFS probe",
+	}, []string{"phase"})
+	probeNFSServiceUp := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_nfs_service_up",
+		Help: "Whether the RPC service is registered and, if checked, answers a NULL call",
+	}, []string{"service"})
+	probeNFSServicePort := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_nfs_service_port",
+		Help: "TCP port registered for the RPC service",
+	}, []string{"service"})
+	registry.MustRegister(probeNFSDuration)
+
+	start := time.Now()
+	conn, _, err := dialTCPTarget(ctx, target, module.NFS.IPProtocol, module.NFS.IPProtocolFallback, module.NFS.SourceIPAddress, registry, logger)
+	probeNFSDuration.WithLabelValues("connect").Set(time.Since(start).Seconds())
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	registry.MustRegister(probeNFSServiceUp, probeNFSServicePort)
+	ports := map[string]uint32{}
+	start = time.Now()
+	for _, service := range module.NFS.Services {
+		program := rpcProgram(service, module.NFS.NFSVersion)
+		args := binary.BigEndian.AppendUint32(nil, program[0])
+		args = binary.BigEndian.AppendUint32(args, program[1])
+		args = binary.BigEndian.AppendUint32(args, rpcProtocolTCP)
+		args = binary.BigEndian.AppendUint32(args, 0)
+		result, err := rpcCall(conn, rpcPortmapProgram, rpcPortmapVersion, rpcPortmapGetPort, args)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error querying portmapper", "service", service, "err", err)
+			probeNFSDuration.WithLabelValues("portmap").Set(time.Since(start).Seconds())
+			return false
+		}
+		if len(result) < 4 {
+			level.Error(logger).Log("msg", "Truncated portmapper reply", "service", service)
+			probeNFSDuration.WithLabelValues("portmap").Set(time.Since(start).Seconds())
+			return false
+		}
+		port := binary.BigEndian.Uint32(result)
+		probeNFSServicePort.WithLabelValues(service).Set(float64(port))
+		// The portmapper answers port 0 for unregistered programs.
+		if port == 0 {
+			level.Error(logger).Log("msg", "Service is not registered", "service", service, "program", program[0], "version", program[1])
+			probeNFSServiceUp.WithLabelValues(service).Set(0)
+			continue
+		}
+		level.Info(logger).Log("msg", "Service is registered", "service", service, "port", port)
+		ports[service] = port
+		probeNFSServiceUp.WithLabelValues(service).Set(1)
+	}
+	probeNFSDuration.WithLabelValues("portmap").Set(time.Since(start).Seconds())
+
+	if module.NFS.NullCall {
+		start = time.Now()
+		for _, service := range module.NFS.Services {
+			port, ok := ports[service]
+			if !ok {
+				continue
+			}
+			program := rpcProgram(service, module.NFS.NFSVersion)
+			err := func() error {
+				// The service is dialed from and to the addresses of the
+				// portmapper connection, which were chosen already.
+				dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: conn.LocalAddr().(*net.TCPAddr).IP}}
+				address := net.JoinHostPort(conn.RemoteAddr().(*net.TCPAddr).IP.String(), strconv.Itoa(int(port)))
+				serviceConn, err := dialer.DialContext(ctx, "tcp", address)
+				if err != nil {
+					return err
+				}
+				defer serviceConn.Close()
+				if deadline, ok := ctx.Deadline(); ok {
+					if err := serviceConn.SetDeadline(deadline); err != nil {
+						return err
+					}
+				}
+				_, err = rpcCall(serviceConn, program[0], program[1], 0, nil)
+				return err
+			}()
+			if err != nil {
+				level.Error(logger).Log("msg", "NULL call failed", "service", service, "err", err)
+				probeNFSServiceUp.WithLabelValues(service).Set(0)
+				delete(ports, service)
+			}
+		}
+		probeNFSDuration.WithLabelValues("null").Set(time.Since(start).Seconds())
+	}
+	return len(ports) == len(module.NFS.Services)
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveRPC answers the RPC calls on a listener with the results of handle,
+// which returns the accept status and the results of a call.
+func serveRPC(t *testing.T, ln net.Listener, handle func(program, version, procedure uint32, args []byte) (uint32, []byte)) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				var header [4]byte
+				if _, err := io.ReadFull(conn, header[:]); err != nil {
+					return
+				}
+				marker := binary.BigEndian.Uint32(header[:])
+				if marker&0x80000000 == 0 {
+					t.Errorf("Expected a single fragment")
+					return
+				}
+				call := make([]byte, marker&0x7fffffff)
+				if _, err := io.ReadFull(conn, call); err != nil {
+					return
+				}
+				if len(call) < 40 || binary.BigEndian.Uint32(call[4:]) != 0 || binary.BigEndian.Uint32(call[8:]) != 2 {
+					t.Errorf("Invalid call %x", call)
+					return
+				}
+				status, results := handle(binary.BigEndian.Uint32(call[12:]), binary.BigEndian.Uint32(call[16:]), binary.BigEndian.Uint32(call[20:]), call[40:])
+				reply := append([]byte(nil), call[:4]...)
+				for _, v := range []uint32{1, 0, 0, 0, status} {
+					reply = binary.BigEndian.AppendUint32(reply, v)
+				}
+				reply = append(reply, results...)
+				if _, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, 0x80000000|uint32(len(reply))), reply...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestNFSProbe(t *testing.T) {
+	nfsLn, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer nfsLn.Close()
+	go serveRPC(t, nfsLn, func(program, version, procedure uint32, args []byte) (uint32, []byte) {
+		if program != 100003 || procedure != 0 {
+			return 1, nil // Program unavailable.
+		}
+		if version != 3 {
+			return 2, binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 3), 3) // Program mismatch.
+		}
+		return 0, nil
+	})
+
+	// mountd is registered, but not listening anymore.
+	mountdLn, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	mountdLn.Close()
+
+	portmapLn, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer portmapLn.Close()
+	go serveRPC(t, portmapLn, func(program, version, procedure uint32, args []byte) (uint32, []byte) {
+		if program != rpcPortmapProgram || version != rpcPortmapVersion || procedure != rpcPortmapGetPort || len(args) != 16 {
+			t.Errorf("Unexpected call of program %d version %d procedure %d", program, version, procedure)
+			return 1, nil
+		}
+		var port int
+		switch [2]uint32{binary.BigEndian.Uint32(args), binary.BigEndian.Uint32(args[4:])} {
+		case [2]uint32{100003, 3}, [2]uint32{100003, 4}:
+			port = nfsLn.Addr().(*net.TCPAddr).Port
+		case [2]uint32{100005, 3}:
+			port = mountdLn.Addr().(*net.TCPAddr).Port
+		}
+		return 0, binary.BigEndian.AppendUint32(nil, uint32(port))
+	})
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name     string
+		module   config.NFSProbe
+		success  bool
+		services map[string]float64
+	}{
+		{
+			name:     "registered",
+			module:   config.NFSProbe{Services: []string{"nfs", "mountd"}, NFSVersion: 3},
+			success:  true,
+			services: map[string]float64{"nfs": 1, "mountd": 1},
+		},
+		{
+			name:     "null call",
+			module:   config.NFSProbe{Services: []string{"nfs"}, NFSVersion: 3, NullCall: true},
+			success:  true,
+			services: map[string]float64{"nfs": 1},
+		},
+		{
+			name:     "version mismatch",
+			module:   config.NFSProbe{Services: []string{"nfs"}, NFSVersion: 4, NullCall: true},
+			services: map[string]float64{"nfs": 0},
+		},
+		{
+			name:     "service down",
+			module:   config.NFSProbe{Services: []string{"nfs", "mountd"}, NFSVersion: 3, NullCall: true},
+			services: map[string]float64{"nfs": 1, "mountd": 0},
+		},
+		{
+			name:     "not registered",
+			module:   config.NFSProbe{Services: []string{"nlockmgr"}, NFSVersion: 3},
+			services: map[string]float64{"nlockmgr": 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeNFS(testCTX, portmapLn.Addr().String(), config.Module{NFS: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mf := range mfs {
+				if mf.GetName() != "probe_nfs_service_up" {
+					continue
+				}
+				if len(mf.GetMetric()) != len(tc.services) {
+					t.Errorf("Expected %d services, got %d", len(tc.services), len(mf.GetMetric()))
+				}
+				for _, m := range mf.GetMetric() {
+					service := m.GetLabel()[0].GetValue()
+					if up := m.GetGauge().GetValue(); up != tc.services[service] {
+						t.Errorf("Expected service %s up %v, got %v", service, tc.services[service], up)
+					}
+				}
+			}
+		})
+	}
+}
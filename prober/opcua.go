@@ -0,0 +1,790 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	opcuaSecurityPolicyNone = "http://opcfoundation.org/UA/SecurityPolicy#None"
+
+	// Binary encoding ids of the services.
+	opcuaOpenSecureChannelRequest  = 446
+	opcuaOpenSecureChannelResponse = 449
+	opcuaCloseSecureChannelRequest = 452
+	opcuaCreateSessionRequest      = 461
+	opcuaCreateSessionResponse     = 464
+	opcuaActivateSessionRequest    = 467
+	opcuaActivateSessionResponse   = 470
+	opcuaCloseSessionRequest       = 473
+	opcuaCloseSessionResponse      = 476
+	opcuaReadRequest               = 631
+	opcuaReadResponse              = 634
+	opcuaAnonymousIdentityToken    = 321
+	opcuaMessageSecurityModeNone   = 1
+	opcuaUserTokenTypeAnonymous    = 0
+	opcuaAttributeValue            = 13
+	opcuaTimestampsToReturnNeither = 3
+	opcuaStatusSeverityBad         = 0x80000000
+	opcuaMaxMessageSize            = 1 << 24
+	opcuaDefaultPort               = "4840"
+)
+
+// opcuaSecurityModes are the names of the message security modes.
+var opcuaSecurityModes = map[uint32]string{1: "None", 2: "Sign", 3: "SignAndEncrypt"}
+
+// opcuaError is a bad status code returned by the server.
+type opcuaError struct {
+	status uint32
+	reason string
+}
+
+func (e *opcuaError) Error() string {
+	if e.reason != "" {
+		return fmt.Sprintf("bad status 0x%08x: %s", e.status, e.reason)
+	}
+	return fmt.Sprintf("bad status 0x%08x", e.status)
+}
+
+// opcuaEncoder appends values in the OPC UA binary encoding.
+type opcuaEncoder struct {
+	b []byte
+}
+
+func (e *opcuaEncoder) byte(v byte) {
+	e.b = append(e.b, v)
+}
+
+func (e *opcuaEncoder) uint16(v uint16) {
+	e.b = binary.LittleEndian.AppendUint16(e.b, v)
+}
+
+func (e *opcuaEncoder) uint32(v uint32) {
+	e.b = binary.LittleEndian.AppendUint32(e.b, v)
+}
+
+func (e *opcuaEncoder) int64(v int64) {
+	e.b = binary.LittleEndian.AppendUint64(e.b, uint64(v))
+}
+
+func (e *opcuaEncoder) float64(v float64) {
+	e.b = binary.LittleEndian.AppendUint64(e.b, math.Float64bits(v))
+}
+
+// string encodes a string, where the empty string is encoded as null.
+func (e *opcuaEncoder) string(s string) {
+	if s == "" {
+		e.uint32(math.MaxUint32)
+		return
+	}
+	e.uint32(uint32(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// byteString encodes a byte string, where nil is encoded as null.
+func (e *opcuaEncoder) byteString(b []byte) {
+	if b == nil {
+		e.uint32(math.MaxUint32)
+		return
+	}
+	e.uint32(uint32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// typeID encodes the numeric node id of a type in namespace 0.
+func (e *opcuaEncoder) typeID(id uint16) {
+	e.b = append(e.b, 0x01, 0x00)
+	e.uint16(id)
+}
+
+// dateTime encodes a time as 100ns intervals since 1601.
+func (e *opcuaEncoder) dateTime(t time.Time) {
+	e.int64(t.UnixNano()/100 + 116444736000000000)
+}
+
+// opcuaDecoder reads values in the OPC UA binary encoding. After the first
+// error, all values read are zero.
+type opcuaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *opcuaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = errors.New("truncated message")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *opcuaDecoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *opcuaDecoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *opcuaDecoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *opcuaDecoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// byteString decodes a byte string or string, returning nil for null.
+func (d *opcuaDecoder) byteString() []byte {
+	n := int32(d.uint32())
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *opcuaDecoder) string() string {
+	return string(d.byteString())
+}
+
+// array decodes the length of an array and calls f for each element.
+func (d *opcuaDecoder) array(f func()) {
+	n := int32(d.uint32())
+	for i := int32(0); i < n && d.err == nil; i++ {
+		f()
+	}
+}
+
+// nodeID decodes a node id and returns its encoding.
+func (d *opcuaDecoder) nodeID() []byte {
+	start := d.b
+	switch encoding := d.byte(); encoding & 0x3f {
+	case 0x00:
+		d.next(1)
+	case 0x01:
+		d.next(3)
+	case 0x02:
+		d.next(6)
+	case 0x03, 0x05:
+		d.next(2)
+		d.byteString()
+	case 0x04:
+		d.next(18)
+	default:
+		d.err = fmt.Errorf("invalid node id encoding 0x%02x", encoding)
+	}
+	if d.err != nil {
+		return nil
+	}
+	return start[:len(start)-len(d.b)]
+}
+
+func (d *opcuaDecoder) localizedText() string {
+	mask := d.byte()
+	if mask&0x01 != 0 {
+		d.string()
+	}
+	if mask&0x02 != 0 {
+		return d.string()
+	}
+	return ""
+}
+
+func (d *opcuaDecoder) extensionObject() {
+	d.nodeID()
+	if encoding := d.byte(); encoding != 0 {
+		d.byteString()
+	}
+}
+
+func (d *opcuaDecoder) diagnosticInfo() {
+	mask := d.byte()
+	for bit := byte(0x01); bit <= 0x08; bit <<= 1 {
+		if mask&bit != 0 {
+			d.next(4)
+		}
+	}
+	if mask&0x10 != 0 {
+		d.string()
+	}
+	if mask&0x20 != 0 {
+		d.next(4)
+	}
+	if mask&0x40 != 0 {
+		d.diagnosticInfo()
+	}
+}
+
+// responseHeader decodes a response header and returns the service result.
+func (d *opcuaDecoder) responseHeader() uint32 {
+	d.next(12) // Timestamp and request handle.
+	result := d.uint32()
+	d.diagnosticInfo()
+	d.array(func() { d.string() })
+	d.extensionObject()
+	return result
+}
+
+// variant decodes a variant and returns its value if it is a number. It
+// returns false for other values and for values it can not skip.
+func (d *opcuaDecoder) variant() (float64, bool, bool) {
+	encoding := d.byte()
+	if encoding&0xc0 != 0 {
+		// Arrays are not decoded.
+		return 0, false, false
+	}
+	switch encoding {
+	case 1:
+		return float64(d.byte()), true, true
+	case 2:
+		return float64(int8(d.byte())), true, true
+	case 3:
+		return float64(d.byte()), true, true
+	case 4:
+		return float64(int16(d.uint16())), true, true
+	case 5:
+		return float64(d.uint16()), true, true
+	case 6:
+		return float64(int32(d.uint32())), true, true
+	case 7:
+		return float64(d.uint32()), true, true
+	case 8:
+		return float64(int64(d.uint64())), true, true
+	case 9:
+		return float64(d.uint64()), true, true
+	case 10:
+		return float64(math.Float32frombits(d.uint32())), true, true
+	case 11:
+		return math.Float64frombits(d.uint64()), true, true
+	case 12, 15, 16:
+		d.byteString()
+	case 13:
+		d.next(8)
+	case 14:
+		d.next(16)
+	case 17:
+		d.nodeID()
+	case 19:
+		d.next(4)
+	case 20:
+		d.next(2)
+		d.string()
+	case 21:
+		d.localizedText()
+	default:
+		return 0, false, false
+	}
+	return 0, false, true
+}
+
+// parseOPCUANodeID encodes a node id given in the string notation, e.g.
+// ns=2;s=Demo.Static.Scalar.Double.
+func parseOPCUANodeID(s string) ([]byte, error) {
+	var namespace uint64
+	if ns, rest, ok := strings.Cut(s, ";"); ok && strings.HasPrefix(ns, "ns=") {
+		var err error
+		if namespace, err = strconv.ParseUint(ns[3:], 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid namespace %q", ns)
+		}
+		s = rest
+	}
+	e := &opcuaEncoder{}
+	switch {
+	case strings.HasPrefix(s, "i="):
+		id, err := strconv.ParseUint(s[2:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numeric identifier %q", s)
+		}
+		e.byte(0x02)
+		e.uint16(uint16(namespace))
+		e.uint32(uint32(id))
+	case strings.HasPrefix(s, "s="):
+		e.byte(0x03)
+		e.uint16(uint16(namespace))
+		e.string(s[2:])
+	case strings.HasPrefix(s, "b="):
+		id, err := base64.StdEncoding.DecodeString(s[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid opaque identifier %q", s)
+		}
+		e.byte(0x05)
+		e.uint16(uint16(namespace))
+		e.byteString(id)
+	default:
+		return nil, fmt.Errorf("unsupported node id %q", s)
+	}
+	return e.b, nil
+}
+
+// opcuaEndpoint is an endpoint announced by the server.
+type opcuaEndpoint struct {
+	securityMode      uint32
+	securityPolicyURI string
+	anonymousPolicyID string
+	hasAnonymous      bool
+}
+
+// opcuaConn speaks the UA TCP protocol with security policy None.
+type opcuaConn struct {
+	conn           net.Conn
+	endpointURL    string
+	channelID      uint32
+	tokenID        uint32
+	sequenceNumber uint32
+	requestID      uint32
+	authToken      []byte
+}
+
+// send sends a message of a single chunk.
+func (c *opcuaConn) send(messageType string, body []byte) error {
+	message := append([]byte(messageType), 'F')
+	message = binary.LittleEndian.AppendUint32(message, uint32(8+len(body)))
+	_, err := c.conn.Write(append(message, body...))
+	return err
+}
+
+// receive reads a message, joining its chunks, and returns its type and the
+// body following the security and sequence headers.
+func (c *opcuaConn) receive() (string, []byte, error) {
+	var body []byte
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+			return "", nil, err
+		}
+		messageType, size := string(header[:3]), binary.LittleEndian.Uint32(header[4:])
+		if size < 8 || int(size)+len(body) > opcuaMaxMessageSize {
+			return "", nil, fmt.Errorf("invalid message size %d", size)
+		}
+		chunk := make([]byte, size-8)
+		if _, err := io.ReadFull(c.conn, chunk); err != nil {
+			return "", nil, err
+		}
+		d := &opcuaDecoder{b: chunk}
+		switch messageType {
+		case "ACK":
+			return messageType, chunk, nil
+		case "ERR":
+			status := d.uint32()
+			return "", nil, &opcuaError{status: status, reason: d.string()}
+		case "OPN":
+			d.uint32()
+			d.string()
+			d.byteString()
+			d.byteString()
+		case "MSG":
+			d.next(8) // Channel and token id.
+		default:
+			return "", nil, fmt.Errorf("unexpected message type %q", messageType)
+		}
+		d.next(8) // Sequence header.
+		if d.err != nil {
+			return "", nil, d.err
+		}
+		switch header[3] {
+		case 'A':
+			status := d.uint32()
+			return "", nil, &opcuaError{status: status, reason: d.string()}
+		case 'C':
+			body = append(body, d.b...)
+		default:
+			return messageType, append(body, d.b...), nil
+		}
+	}
+}
+
+// requestHeader encodes a request header with the authentication token of
+// the session.
+func (c *opcuaConn) requestHeader(e *opcuaEncoder) {
+	c.requestID++
+	if c.authToken == nil {
+		e.b = append(e.b, 0x00, 0x00)
+	} else {
+		e.b = append(e.b, c.authToken...)
+	}
+	e.dateTime(time.Now())
+	e.uint32(c.requestID) // Request handle.
+	e.uint32(0)           // Return diagnostics.
+	e.string("")          // Audit entry id.
+	e.uint32(10000)       // Timeout hint.
+	e.b = append(e.b, 0x00, 0x00, 0x00)
+}
+
+// sequenceHeader encodes the sequence header of the next chunk.
+func (c *opcuaConn) sequenceHeader(e *opcuaEncoder) {
+	c.sequenceNumber++
+	e.uint32(c.sequenceNumber)
+	e.uint32(c.requestID)
+}
+
+// response decodes the type and header of a response, returning the decoder
+// positioned at its parameters.
+func opcuaResponse(body []byte, expected uint16) (*opcuaDecoder, error) {
+	d := &opcuaDecoder{b: body}
+	typeID := d.nodeID()
+	result := d.responseHeader()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if result&opcuaStatusSeverityBad != 0 {
+		return nil, &opcuaError{status: result}
+	}
+	if len(typeID) != 4 || binary.LittleEndian.Uint16(typeID[2:]) != expected {
+		return nil, fmt.Errorf("unexpected response type %x", typeID)
+	}
+	return d, nil
+}
+
+// hello exchanges the HEL and ACK messages.
+func (c *opcuaConn) hello() error {
+	e := &opcuaEncoder{}
+	e.uint32(0)     // Protocol version.
+	e.uint32(65536) // Receive buffer size.
+	e.uint32(65536) // Send buffer size.
+	e.uint32(0)     // Maximum message size.
+	e.uint32(0)     // Maximum chunk count.
+	e.string(c.endpointURL)
+	if err := c.send("HEL", e.b); err != nil {
+		return err
+	}
+	_, _, err := c.receive()
+	return err
+}
+
+// openSecureChannel opens a secure channel with security policy None.
+func (c *opcuaConn) openSecureChannel() error {
+	e := &opcuaEncoder{}
+	e.uint32(0) // Secure channel id.
+	e.string(opcuaSecurityPolicyNone)
+	e.byteString(nil) // Sender certificate.
+	e.byteString(nil) // Receiver certificate thumbprint.
+	body := &opcuaEncoder{}
+	body.typeID(opcuaOpenSecureChannelRequest)
+	c.requestHeader(body)
+	body.uint32(0) // Client protocol version.
+	body.uint32(0) // Issue.
+	body.uint32(opcuaMessageSecurityModeNone)
+	body.byteString([]byte{}) // Client nonce.
+	body.uint32(3600000)      // Requested lifetime.
+	c.sequenceHeader(e)
+	if err := c.send("OPN", append(e.b, body.b...)); err != nil {
+		return err
+	}
+	_, response, err := c.receive()
+	if err != nil {
+		return err
+	}
+	d, err := opcuaResponse(response, opcuaOpenSecureChannelResponse)
+	if err != nil {
+		return err
+	}
+	d.uint32() // Server protocol version.
+	c.channelID = d.uint32()
+	c.tokenID = d.uint32()
+	return d.err
+}
+
+// call sends a service request and returns the decoder positioned at the
+// parameters of the response.
+func (c *opcuaConn) call(request, response uint16, params []byte) (*opcuaDecoder, error) {
+	body := &opcuaEncoder{}
+	body.typeID(request)
+	c.requestHeader(body)
+	e := &opcuaEncoder{}
+	e.uint32(c.channelID)
+	e.uint32(c.tokenID)
+	c.sequenceHeader(e)
+	if err := c.send("MSG", append(append(e.b, body.b...), params...)); err != nil {
+		return nil, err
+	}
+	_, message, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	return opcuaResponse(message, response)
+}
+
+// createSession creates a session and returns the endpoints of the server.
+func (c *opcuaConn) createSession() ([]opcuaEndpoint, error) {
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+	e := &opcuaEncoder{}
+	e.string("urn:blackbox_exporter") // Application URI.
+	e.string("urn:blackbox_exporter") // Product URI.
+	e.byte(0x02)
+	e.string("blackbox_exporter")
+	e.uint32(1)  // Client.
+	e.string("") // Gateway server URI.
+	e.string("") // Discovery profile URI.
+	e.uint32(math.MaxUint32)
+	e.string("") // Server URI.
+	e.string(c.endpointURL)
+	e.string("blackbox_exporter probe")
+	e.byteString(nonce)
+	e.byteString(nil) // Client certificate.
+	e.float64(60000)  // Requested session timeout in milliseconds.
+	e.uint32(0)       // Maximum response message size.
+	d, err := c.call(opcuaCreateSessionRequest, opcuaCreateSessionResponse, e.b)
+	if err != nil {
+		return nil, err
+	}
+	d.nodeID() // Session id.
+	c.authToken = append([]byte(nil), d.nodeID()...)
+	d.next(8)      // Revised session timeout.
+	d.byteString() // Server nonce.
+	d.byteString() // Server certificate.
+	var endpoints []opcuaEndpoint
+	d.array(func() {
+		var endpoint opcuaEndpoint
+		d.string() // Endpoint URL.
+		d.string() // Application URI.
+		d.string() // Product URI.
+		d.localizedText()
+		d.uint32()
+		d.string()
+		d.string()
+		d.array(func() { d.string() })
+		d.byteString() // Server certificate.
+		endpoint.securityMode = d.uint32()
+		endpoint.securityPolicyURI = d.string()
+		d.array(func() {
+			policyID := d.string()
+			tokenType := d.uint32()
+			d.string()
+			d.string()
+			d.string()
+			if tokenType == opcuaUserTokenTypeAnonymous && !endpoint.hasAnonymous {
+				endpoint.anonymousPolicyID, endpoint.hasAnonymous = policyID, true
+			}
+		})
+		d.string() // Transport profile URI.
+		d.byte()   // Security level.
+		endpoints = append(endpoints, endpoint)
+	})
+	return endpoints, d.err
+}
+
+// activateSession activates the session with an anonymous identity token.
+func (c *opcuaConn) activateSession(policyID string) error {
+	token := &opcuaEncoder{}
+	token.string(policyID)
+	e := &opcuaEncoder{}
+	e.string("")      // Client signature algorithm.
+	e.byteString(nil) // Client signature.
+	e.uint32(math.MaxUint32)
+	e.uint32(math.MaxUint32)
+	e.typeID(opcuaAnonymousIdentityToken)
+	e.byte(0x01)
+	e.byteString(token.b)
+	e.string("")
+	e.byteString(nil)
+	_, err := c.call(opcuaActivateSessionRequest, opcuaActivateSessionResponse, e.b)
+	return err
+}
+
+// read reads the value attribute of a node, returning its value if it is a
+// number.
+func (c *opcuaConn) read(nodeID []byte) (float64, bool, error) {
+	e := &opcuaEncoder{}
+	e.float64(0) // Maximum age.
+	e.uint32(opcuaTimestampsToReturnNeither)
+	e.uint32(1)
+	e.b = append(e.b, nodeID...)
+	e.uint32(opcuaAttributeValue)
+	e.string("") // Index range.
+	e.uint16(0)  // Data encoding.
+	e.string("")
+	d, err := c.call(opcuaReadRequest, opcuaReadResponse, e.b)
+	if err != nil {
+		return 0, false, err
+	}
+	if n := int32(d.uint32()); n != 1 {
+		return 0, false, fmt.Errorf("expected one result, got %d", n)
+	}
+	var (
+		value     float64
+		isNumber  bool
+		decodable = true
+	)
+	mask := d.byte()
+	if mask&0x01 != 0 {
+		value, isNumber, decodable = d.variant()
+	}
+	if d.err != nil {
+		return 0, false, d.err
+	}
+	// The status code follows the value and is only read if the value could
+	// be skipped. An omitted status code means good.
+	if decodable && mask&0x02 != 0 {
+		if status := d.uint32(); status&opcuaStatusSeverityBad != 0 {
+			return 0, false, &opcuaError{status: status}
+		}
+	}
+	return value, isNumber, d.err
+}
+
+// close closes the session and the secure channel.
+func (c *opcuaConn) close() {
+	c.call(opcuaCloseSessionRequest, opcuaCloseSessionResponse, []byte{1}) // Delete subscriptions.
+	body := &opcuaEncoder{}
+	body.typeID(opcuaCloseSecureChannelRequest)
+	c.requestHeader(body)
+	e := &opcuaEncoder{}
+	e.uint32(c.channelID)
+	e.uint32(c.tokenID)
+	c.sequenceHeader(e)
+	c.send("CLO", append(e.b, body.b...))
+}
+
+// ProbeOPCUA opens a secure channel without security to an OPC UA server,
+// creates an anonymous session and reads the value of a node.
+func ProbeOPCUA(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeOPCUADuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_opcua_duration_seconds",
+		Help: "Duration of each phase of the OPC UA session",
+	}, []string{"phase"})
+	probeOPCUASecurityPolicy := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_opcua_security_policy_info",
+		Help: "Contains the security policies and modes of the endpoints offered by the server",
+	}, []string{"security_policy", "security_mode"})
+	probeOPCUAStatus := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_opcua_status_code",
+		Help: "Bad status code returned by the server",
+	})
+	probeOPCUAValue := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_opcua_value",
+		Help: "Numeric value of the node read",
+	})
+	registry.MustRegister(probeOPCUADuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeOPCUADuration, "phase", "OPC UA phase", logger, func(err error) {
+		var opcuaErr *opcuaError
+		if errors.As(err, &opcuaErr) {
+			registry.MustRegister(probeOPCUAStatus)
+			probeOPCUAStatus.Set(float64(opcuaErr.status))
+		}
+	})
+
+	// The target is either an endpoint URL or an address.
+	endpointURL := target
+	if !strings.HasPrefix(target, "opc.tcp://") {
+		endpointURL = "opc.tcp://" + target
+	}
+	u, err := url.Parse(endpointURL)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = opcuaDefaultPort
+	}
+	nodeID, err := parseOPCUANodeID(module.OPCUA.NodeID)
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid node id", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, net.JoinHostPort(u.Hostname(), port), module.OPCUA.IPProtocol, module.OPCUA.IPProtocolFallback, module.OPCUA.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+	c := &opcuaConn{conn: conn, endpointURL: endpointURL}
+
+	if !step("hello", c.hello) || !step("open_secure_channel", c.openSecureChannel) {
+		return false
+	}
+
+	var policyID string
+	if !step("create_session", func() error {
+		endpoints, err := c.createSession()
+		if err != nil {
+			return err
+		}
+		registry.MustRegister(probeOPCUASecurityPolicy)
+		found := false
+		for _, endpoint := range endpoints {
+			policy := endpoint.securityPolicyURI
+			if i := strings.LastIndex(policy, "#"); i >= 0 {
+				policy = policy[i+1:]
+			}
+			probeOPCUASecurityPolicy.WithLabelValues(policy, opcuaSecurityModes[endpoint.securityMode]).Set(1)
+			if endpoint.securityMode == opcuaMessageSecurityModeNone && endpoint.securityPolicyURI == opcuaSecurityPolicyNone && endpoint.hasAnonymous && !found {
+				policyID, found = endpoint.anonymousPolicyID, true
+			}
+		}
+		if !found {
+			return errors.New("server offers no endpoint for anonymous sessions without security")
+		}
+		return nil
+	}) {
+		return false
+	}
+	defer c.close()
+
+	if !step("activate_session", func() error { return c.activateSession(policyID) }) {
+		return false
+	}
+	return step("read", func() error {
+		value, isNumber, err := c.read(nodeID)
+		if err != nil {
+			return err
+		}
+		if isNumber {
+			registry.MustRegister(probeOPCUAValue)
+			probeOPCUAValue.Set(value)
+		}
+		return nil
+	})
+}
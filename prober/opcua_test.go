@@ -0,0 +1,308 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestParseOPCUANodeID(t *testing.T) {
+	for _, tc := range []struct {
+		nodeID   string
+		expected []byte
+	}{
+		{nodeID: "i=2259", expected: []byte{0x02, 0, 0, 0xd3, 0x08, 0, 0}},
+		{nodeID: "ns=2;s=PLC", expected: []byte{0x03, 2, 0, 3, 0, 0, 0, 'P', 'L', 'C'}},
+		{nodeID: "ns=1;b=AQI=", expected: []byte{0x05, 1, 0, 2, 0, 0, 0, 1, 2}},
+		{nodeID: "ns=1;g=09087e75-8e5e-499b-954f-f2a9603db28a"},
+		{nodeID: "ns=70000;i=1"},
+	} {
+		nodeID, err := parseOPCUANodeID(tc.nodeID)
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tc.nodeID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", tc.nodeID, err)
+		} else if !bytes.Equal(nodeID, tc.expected) {
+			t.Errorf("Unexpected encoding %x of %q", nodeID, tc.nodeID)
+		}
+	}
+}
+
+// serveOPCUA answers the requests of the OPC UA probe. The server offers an
+// endpoint without security and, if anonymous is set, anonymous access to it.
+func serveOPCUA(t *testing.T, conn net.Conn, anonymous bool) {
+	defer conn.Close()
+	authToken := []byte{0x05, 0, 0, 4, 0, 0, 0, 't', 'o', 'k', 'n'}
+	responseHeader := func(e *opcuaEncoder, typeID uint16, status uint32) {
+		e.typeID(typeID)
+		e.dateTime(time.Now())
+		e.uint32(1)
+		e.uint32(status)
+		e.byte(0)
+		e.uint32(math.MaxUint32)
+		e.b = append(e.b, 0, 0, 0)
+	}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		chunk := make([]byte, binary.LittleEndian.Uint32(header[4:])-8)
+		if _, err := io.ReadFull(conn, chunk); err != nil {
+			return
+		}
+		d := &opcuaDecoder{b: chunk}
+		e := &opcuaEncoder{}
+		messageType := string(header[:3])
+		switch messageType {
+		case "HEL":
+			if d.next(20); d.string() != "opc.tcp://"+conn.LocalAddr().String() {
+				t.Errorf("Unexpected endpoint URL in %x", chunk)
+			}
+			for _, v := range []uint32{0, 65536, 65536, 0, 0} {
+				e.uint32(v)
+			}
+			messageType = "ACK"
+		case "OPN":
+			d.uint32()
+			if policy := d.string(); policy != opcuaSecurityPolicyNone {
+				t.Errorf("Unexpected security policy %q", policy)
+			}
+			d.byteString()
+			d.byteString()
+			e.uint32(7)
+			e.string(opcuaSecurityPolicyNone)
+			e.byteString(nil)
+			e.byteString(nil)
+			e.b = append(e.b, d.next(8)...)
+			responseHeader(e, opcuaOpenSecureChannelResponse, 0)
+			e.uint32(0)
+			e.uint32(7) // Channel id.
+			e.uint32(9) // Token id.
+			e.dateTime(time.Now())
+			e.uint32(3600000)
+			e.byteString([]byte{})
+		case "MSG":
+			if channel, token := d.uint32(), d.uint32(); channel != 7 || token != 9 {
+				t.Errorf("Unexpected channel %d and token %d", channel, token)
+			}
+			e.uint32(7)
+			e.uint32(9)
+			e.b = append(e.b, d.next(8)...)
+			typeID := d.nodeID()
+			token := d.nodeID()
+			d.next(16)
+			d.string()
+			d.next(4)
+			d.extensionObject()
+			if request := binary.LittleEndian.Uint16(typeID[2:]); request != opcuaCreateSessionRequest && !bytes.Equal(token, authToken) {
+				t.Errorf("Unexpected authentication token %x", token)
+			}
+			switch binary.LittleEndian.Uint16(typeID[2:]) {
+			case opcuaCreateSessionRequest:
+				responseHeader(e, opcuaCreateSessionResponse, 0)
+				e.b = append(e.b, 0x02, 1, 0, 1, 0, 0, 0)
+				e.b = append(e.b, authToken...)
+				e.float64(60000)
+				e.byteString(make([]byte, 32))
+				e.byteString(nil)
+				// Without anonymous access, only user names are accepted.
+				tokenType := uint32(1)
+				if anonymous {
+					tokenType = opcuaUserTokenTypeAnonymous
+				}
+				e.uint32(2)
+				for _, endpoint := range []struct {
+					mode      uint32
+					policy    string
+					tokenType uint32
+				}{
+					{mode: 1, policy: opcuaSecurityPolicyNone, tokenType: tokenType},
+					{mode: 3, policy: "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256", tokenType: 1},
+				} {
+					e.string("opc.tcp://" + conn.LocalAddr().String())
+					e.string("urn:test")
+					e.string("urn:test")
+					e.byte(0x02)
+					e.string("Test Server")
+					e.uint32(0)
+					e.string("")
+					e.string("")
+					e.uint32(math.MaxUint32)
+					e.byteString(nil)
+					e.uint32(endpoint.mode)
+					e.string(endpoint.policy)
+					e.uint32(1)
+					e.string("policy")
+					e.uint32(endpoint.tokenType)
+					e.string("")
+					e.string("")
+					e.string("")
+					e.string("http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary")
+					e.byte(0)
+				}
+				e.uint32(math.MaxUint32)
+				e.string("")
+				e.byteString(nil)
+				e.uint32(0)
+			case opcuaActivateSessionRequest:
+				d.string()
+				d.byteString()
+				d.array(func() {})
+				d.array(func() {})
+				d.nodeID()
+				d.byte()
+				identity := &opcuaDecoder{b: d.byteString()}
+				if policy := identity.string(); policy != "policy" {
+					t.Errorf("Unexpected identity token policy %q", policy)
+				}
+				responseHeader(e, opcuaActivateSessionResponse, 0)
+				e.byteString(make([]byte, 32))
+				e.uint32(math.MaxUint32)
+				e.uint32(math.MaxUint32)
+			case opcuaReadRequest:
+				d.next(12)
+				if n := d.uint32(); n != 1 {
+					t.Errorf("Unexpected number of nodes %d", n)
+				}
+				nodeID := d.nodeID()
+				responseHeader(e, opcuaReadResponse, 0)
+				e.uint32(1)
+				if expected, _ := parseOPCUANodeID("i=2259"); bytes.Equal(nodeID, expected) {
+					e.byte(0x01)
+					e.byte(6)
+					e.uint32(0)
+				} else if expected, _ := parseOPCUANodeID("ns=2;s=Temperature"); bytes.Equal(nodeID, expected) {
+					e.byte(0x03)
+					e.byte(11)
+					e.float64(21.5)
+					e.uint32(0)
+				} else {
+					e.byte(0x02)
+					e.uint32(0x80340000) // Bad node id unknown.
+				}
+				e.uint32(math.MaxUint32)
+			case opcuaCloseSessionRequest:
+				responseHeader(e, opcuaCloseSessionResponse, 0)
+			default:
+				t.Errorf("Unexpected request %x", typeID)
+				return
+			}
+		case "CLO":
+			return
+		default:
+			t.Errorf("Unexpected message type %q", messageType)
+			return
+		}
+		if d.err != nil {
+			t.Errorf("Error decoding %s message: %s", messageType, d.err)
+			return
+		}
+		message := append([]byte(messageType), 'F')
+		message = binary.LittleEndian.AppendUint32(message, uint32(8+len(e.b)))
+		if _, err := conn.Write(append(message, e.b...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestOPCUAProbe(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name      string
+		nodeID    string
+		anonymous bool
+		success   bool
+		phases    map[string]struct{}
+		results   map[string]float64
+	}{
+		{
+			name:      "server state",
+			nodeID:    "i=2259",
+			anonymous: true,
+			success:   true,
+			phases:    map[string]struct{}{"connect": {}, "hello": {}, "open_secure_channel": {}, "create_session": {}, "activate_session": {}, "read": {}},
+			results:   map[string]float64{"probe_opcua_value": 0},
+		},
+		{
+			name:      "string node",
+			nodeID:    "ns=2;s=Temperature",
+			anonymous: true,
+			success:   true,
+			phases:    map[string]struct{}{"connect": {}, "hello": {}, "open_secure_channel": {}, "create_session": {}, "activate_session": {}, "read": {}},
+			results:   map[string]float64{"probe_opcua_value": 21.5},
+		},
+		{
+			name:      "unknown node",
+			nodeID:    "ns=2;s=Missing",
+			anonymous: true,
+			phases:    map[string]struct{}{"connect": {}, "hello": {}, "open_secure_channel": {}, "create_session": {}, "activate_session": {}, "read": {}},
+			results:   map[string]float64{"probe_opcua_status_code": 0x80340000},
+		},
+		{
+			name:    "no anonymous access",
+			nodeID:  "i=2259",
+			phases:  map[string]struct{}{"connect": {}, "hello": {}, "open_secure_channel": {}, "create_session": {}},
+			results: map[string]float64{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				serveOPCUA(t, conn, tc.anonymous)
+			}()
+
+			module := config.Module{OPCUA: config.OPCUAProbe{IPProtocol: "ip4", NodeID: tc.nodeID}}
+			registry := prometheus.NewRegistry()
+			if ProbeOPCUA(testCTX, "opc.tcp://"+ln.Addr().String(), module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{
+				"probe_opcua_duration_seconds":     {"phase": tc.phases},
+				"probe_opcua_security_policy_info": {"security_policy": {"None": {}, "Basic256Sha256": {}}, "security_mode": {"None": {}, "SignAndEncrypt": {}}},
+			}, mfs, t)
+			checkRegistryResults(tc.results, mfs, t)
+		})
+	}
+}
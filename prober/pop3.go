@@ -0,0 +1,174 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// pop3Conn sends POP3 commands and reads their status lines.
+type pop3Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// readStatus reads a status line and returns the text following "+OK".
+func (c *pop3Conn) readStatus() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "+OK") {
+		return "", fmt.Errorf("command failed: %s", line)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+}
+
+// command sends the command and returns the text of its status line.
+func (c *pop3Conn) command(command string) (string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", command); err != nil {
+		return "", err
+	}
+	return c.readStatus()
+}
+
+// ProbePOP3 checks the greeting of a POP3 server and, as configured, logs in
+// and reads the size of the maildrop.
+func ProbePOP3(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probePOP3CommandDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_pop3_command_duration_seconds",
+		Help: "Duration of each step of the POP3 session",
+	}, []string{"command"})
+	probePOP3Messages := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_pop3_messages",
+		Help: "Number of messages in the maildrop",
+	})
+	probePOP3MaildropSize := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_pop3_maildrop_size_bytes",
+		Help: "Size of the maildrop",
+	})
+	registry.MustRegister(probePOP3CommandDuration)
+
+	// step runs and times one step of the session.
+	step := newStepTimer(probePOP3CommandDuration, "command", "POP3 command", logger, nil)
+
+	tlsConfig, err := newTargetTLSConfig(&module.POP3.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.POP3.IPProtocol, module.POP3.IPProtocolFallback, module.POP3.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	var tlsConn *tls.Conn
+	if module.POP3.TLS {
+		tlsConn = tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		conn = tlsConn
+	}
+	c := &pop3Conn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if !step("greeting", func() error {
+		_, err := c.readStatus()
+		return err
+	}) {
+		return false
+	}
+
+	if module.POP3.StartTLS {
+		if !step("stls", func() error {
+			if _, err := c.command("STLS"); err != nil {
+				return err
+			}
+			tlsConn = tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				return err
+			}
+			c.conn, c.reader = tlsConn, bufio.NewReader(tlsConn)
+			return nil
+		}) {
+			return false
+		}
+	}
+	if tlsConn != nil {
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+	}
+
+	if module.POP3.Username != "" {
+		if !step("user", func() error {
+			_, err := c.command("USER " + module.POP3.Username)
+			return err
+		}) {
+			return false
+		}
+		if !step("pass", func() error {
+			_, err := c.command("PASS " + string(module.POP3.Password))
+			return err
+		}) {
+			return false
+		}
+		if !step("stat", func() error {
+			status, err := c.command("STAT")
+			if err != nil {
+				return err
+			}
+			fields := strings.Fields(status)
+			if len(fields) < 2 {
+				return fmt.Errorf("invalid STAT response: %s", status)
+			}
+			messages, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return fmt.Errorf("invalid STAT response: %s", status)
+			}
+			size, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return fmt.Errorf("invalid STAT response: %s", status)
+			}
+			registry.MustRegister(probePOP3Messages, probePOP3MaildropSize)
+			probePOP3Messages.Set(float64(messages))
+			probePOP3MaildropSize.Set(float64(size))
+			return nil
+		}) {
+			return false
+		}
+	}
+
+	return step("quit", func() error {
+		_, err := c.command("QUIT")
+		return err
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// servePOP3 runs a minimal POP3 server accepting the password "secret",
+// which upgrades connections to TLS with tlsConfig.
+func servePOP3(t *testing.T, ln net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			fmt.Fprintf(conn, "+OK POP3 server ready\r\n")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\r\n")
+				switch fields := strings.SplitN(line, " ", 2); strings.ToUpper(fields[0]) {
+				case "STLS":
+					fmt.Fprintf(conn, "+OK Begin TLS negotiation\r\n")
+					tlsConn := tls.Server(conn, tlsConfig)
+					if err := tlsConn.Handshake(); err != nil {
+						return
+					}
+					conn, r = tlsConn, bufio.NewReader(tlsConn)
+				case "USER":
+					fmt.Fprintf(conn, "+OK\r\n")
+				case "PASS":
+					if line == "PASS secret" {
+						fmt.Fprintf(conn, "+OK Logged in\r\n")
+					} else {
+						fmt.Fprintf(conn, "-ERR Authentication failed\r\n")
+					}
+				case "STAT":
+					fmt.Fprintf(conn, "+OK 3 1024\r\n")
+				case "QUIT":
+					fmt.Fprintf(conn, "+OK Bye\r\n")
+					return
+				default:
+					t.Errorf("Unexpected POP3 command %q", line)
+					fmt.Fprintf(conn, "-ERR Unknown command\r\n")
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestPOP3Session(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	go servePOP3(t, ln, tlsConfig)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	target := net.JoinHostPort("localhost", port)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	module := config.Module{POP3: config.POP3Probe{
+		IPProtocol: "ip4",
+		StartTLS:   true,
+		TLSConfig:  pconfig.TLSConfig{CAFile: caFile},
+		Username:   "prober",
+		Password:   "secret",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbePOP3(testCTX, target, module, registry, log.NewNopLogger()) {
+		t.Fatalf("POP3 module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_pop3_command_duration_seconds": {
+			"command": {
+				"connect":  {},
+				"greeting": {},
+				"stls":     {},
+				"user":     {},
+				"pass":     {},
+				"stat":     {},
+				"quit":     {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	expectedResults := map[string]float64{
+		"probe_pop3_messages":            3,
+		"probe_pop3_maildrop_size_bytes": 1024,
+		"probe_tls_version_info":         1,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	module.POP3.Password = "wrong"
+	if ProbePOP3(testCTX, target, module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("POP3 module succeeded with a wrong password, expected failure.")
+	}
+}
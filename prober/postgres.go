@@ -0,0 +1,302 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	postgresProtocolVersion = 3 << 16
+	postgresSSLRequestCode  = 80877103
+	// postgresMaxMessageSize limits the size of the messages read from the
+	// server.
+	postgresMaxMessageSize = 1 << 20
+)
+
+// postgresError is an error reported by the server.
+type postgresError struct {
+	sqlstate string
+	message  string
+}
+
+func (e *postgresError) Error() string {
+	return fmt.Sprintf("%s (SQLSTATE %s)", e.message, e.sqlstate)
+}
+
+// parsePostgresError parses the fields of an ErrorResponse message.
+func parsePostgresError(body []byte) *postgresError {
+	e := &postgresError{}
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) == 0 {
+			continue
+		}
+		switch field[0] {
+		case 'C':
+			e.sqlstate = string(field[1:])
+		case 'M':
+			e.message = string(field[1:])
+		}
+	}
+	return e
+}
+
+// postgresConn sends and receives messages of the PostgreSQL frontend/backend
+// protocol.
+type postgresConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// send sends a message, which has no type if it is 0.
+func (c *postgresConn) send(messageType byte, body []byte) error {
+	var message []byte
+	if messageType != 0 {
+		message = append(message, messageType)
+	}
+	message = binary.BigEndian.AppendUint32(message, uint32(len(body)+4))
+	_, err := c.conn.Write(append(message, body...))
+	return err
+}
+
+// receive returns the type and the body of the next message. An
+// ErrorResponse is returned as error.
+func (c *postgresConn) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size > postgresMaxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message size %d", size)
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	if header[0] == 'E' {
+		return 0, nil, parsePostgresError(body)
+	}
+	return header[0], body, nil
+}
+
+// authenticate answers an authentication request of the server.
+func (c *postgresConn) authenticate(request []byte, username, password string) error {
+	if len(request) < 4 {
+		return errors.New("invalid authentication request")
+	}
+	switch method := binary.BigEndian.Uint32(request); method {
+	case 0: // AuthenticationOk.
+		return nil
+	case 3: // AuthenticationCleartextPassword.
+		return c.send('p', append([]byte(password), 0))
+	case 5: // AuthenticationMD5Password.
+		if len(request) < 8 {
+			return errors.New("invalid authentication request")
+		}
+		inner := md5.Sum([]byte(password + username))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), request[4:8]...))
+		return c.send('p', append([]byte("md5"+hex.EncodeToString(outer[:])), 0))
+	case 10: // AuthenticationSASL.
+		mechanisms := strings.Split(strings.TrimRight(string(request[4:]), "\x00"), "\x00")
+		found := false
+		for _, mechanism := range mechanisms {
+			found = found || mechanism == "SCRAM-SHA-256"
+		}
+		if !found {
+			return fmt.Errorf("unsupported SASL mechanisms %s", strings.Join(mechanisms, ", "))
+		}
+		// The server uses the user name of the startup message.
+		scram := newSCRAMClient(sha256.New, "", password)
+		clientFirst := scram.clientFirst()
+		body := append([]byte("SCRAM-SHA-256"), 0)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(clientFirst)))
+		if err := c.send('p', append(body, clientFirst...)); err != nil {
+			return err
+		}
+		serverFirst, err := c.receiveSASL(11)
+		if err != nil {
+			return err
+		}
+		clientFinal, err := scram.clientFinal(serverFirst)
+		if err != nil {
+			return err
+		}
+		if err := c.send('p', []byte(clientFinal)); err != nil {
+			return err
+		}
+		serverFinal, err := c.receiveSASL(12)
+		if err != nil {
+			return err
+		}
+		return scram.verifyServerFinal(serverFinal)
+	default:
+		return fmt.Errorf("unsupported authentication method %d", method)
+	}
+}
+
+// receiveSASL returns the data of the next SASL authentication message,
+// which has to be of the given kind.
+func (c *postgresConn) receiveSASL(kind uint32) (string, error) {
+	messageType, body, err := c.receive()
+	if err != nil {
+		return "", err
+	}
+	if messageType != 'R' || len(body) < 4 || binary.BigEndian.Uint32(body) != kind {
+		return "", fmt.Errorf("unexpected message %q during SASL authentication", messageType)
+	}
+	return string(body[4:]), nil
+}
+
+// ProbePostgres connects to a PostgreSQL server, authenticates and runs a
+// query.
+func ProbePostgres(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probePostgresDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_postgres_duration_seconds",
+		Help: "Duration of each phase of the PostgreSQL session",
+	}, []string{"phase"})
+	probePostgresServerVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_postgres_server_version_info",
+		Help: "Contains the version of the PostgreSQL server",
+	}, []string{"version"})
+	probePostgresError := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_postgres_error_info",
+		Help: "Contains the SQLSTATE code of the error reported by the server",
+	}, []string{"sqlstate"})
+	registry.MustRegister(probePostgresDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probePostgresDuration, "phase", "PostgreSQL phase", logger, func(err error) {
+		var serverErr *postgresError
+		if errors.As(err, &serverErr) {
+			registry.MustRegister(probePostgresError)
+			probePostgresError.WithLabelValues(serverErr.sqlstate).Set(1)
+		}
+	})
+
+	tlsConfig, err := newTargetTLSConfig(&module.Postgres.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, target, module.Postgres.IPProtocol, module.Postgres.IPProtocolFallback, module.Postgres.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if module.Postgres.TLS {
+		if !step("tls", func() error {
+			c := &postgresConn{conn: conn}
+			if err := c.send(0, binary.BigEndian.AppendUint32(nil, postgresSSLRequestCode)); err != nil {
+				return err
+			}
+			var answer [1]byte
+			if _, err := io.ReadFull(conn, answer[:]); err != nil {
+				return err
+			}
+			if answer[0] != 'S' {
+				return errors.New("server does not support TLS")
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				return err
+			}
+			state := tlsConn.ConnectionState()
+			reportTLSConnectionState(&state, registry)
+			conn = tlsConn
+			return nil
+		}) {
+			return false
+		}
+	}
+	c := &postgresConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if !step("startup", func() error {
+		database := module.Postgres.Database
+		if database == "" {
+			database = module.Postgres.Username
+		}
+		startup := binary.BigEndian.AppendUint32(nil, postgresProtocolVersion)
+		for _, parameter := range []string{"user", module.Postgres.Username, "database", database, "application_name", "blackbox_exporter"} {
+			startup = append(append(startup, parameter...), 0)
+		}
+		if err := c.send(0, append(startup, 0)); err != nil {
+			return err
+		}
+		for {
+			messageType, body, err := c.receive()
+			if err != nil {
+				return err
+			}
+			switch messageType {
+			case 'R':
+				if err := c.authenticate(body, module.Postgres.Username, string(module.Postgres.Password)); err != nil {
+					return err
+				}
+			case 'S':
+				if name, value, _ := strings.Cut(strings.TrimRight(string(body), "\x00"), "\x00"); name == "server_version" {
+					registry.MustRegister(probePostgresServerVersion)
+					probePostgresServerVersion.WithLabelValues(value).Set(1)
+				}
+			case 'Z':
+				return nil
+			}
+		}
+	}) {
+		return false
+	}
+
+	if !step("query", func() error {
+		if err := c.send('Q', append([]byte(module.Postgres.Query), 0)); err != nil {
+			return err
+		}
+		for {
+			messageType, _, err := c.receive()
+			if err != nil {
+				return err
+			}
+			if messageType == 'Z' {
+				return nil
+			}
+		}
+	}) {
+		return false
+	}
+
+	// The server closes the connection without a response.
+	return c.send('X', nil) == nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// postgresAuthRequest encodes an authentication request of the method.
+func postgresAuthRequest(method uint32, data string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, method), data...)
+}
+
+// postgresServerAuth authenticates the user with the password "secret",
+// using MD5 for "md5", SCRAM-SHA-256 for "scram" and no password otherwise.
+func postgresServerAuth(c *postgresConn, user string) bool {
+	receivePassword := func() string {
+		messageType, body, err := c.receive()
+		if err != nil || messageType != 'p' {
+			return ""
+		}
+		return string(body)
+	}
+	switch user {
+	case "md5":
+		c.send('R', postgresAuthRequest(5, "salt"))
+		inner := md5.Sum([]byte("secret" + user))
+		outer := md5.Sum([]byte(hex.EncodeToString(inner[:]) + "salt"))
+		return receivePassword() == "md5"+hex.EncodeToString(outer[:])+"\x00"
+	case "scram":
+		c.send('R', postgresAuthRequest(10, "SCRAM-SHA-256\x00\x00"))
+		mechanism, clientFirst, _ := strings.Cut(receivePassword(), "\x00")
+		if mechanism != "SCRAM-SHA-256" || len(clientFirst) < 4 {
+			return false
+		}
+		// The server computes the expected messages with a client using
+		// the nonce of the client.
+		_, nonce, _ := strings.Cut(clientFirst[4:], ",r=")
+		expected := newSCRAMClient(sha256.New, "", "secret")
+		expected.nonce = nonce
+		serverFirst := "r=" + nonce + "server,s=" + base64.StdEncoding.EncodeToString([]byte("salt")) + ",i=4096"
+		c.send('R', postgresAuthRequest(11, serverFirst))
+		clientFinal, err := expected.clientFinal(serverFirst)
+		if err != nil || receivePassword() != clientFinal {
+			return false
+		}
+		signature := expected.hmac(expected.hmac(expected.saltedPassword, "Server Key"), expected.authMessage)
+		c.send('R', postgresAuthRequest(12, "v="+base64.StdEncoding.EncodeToString(signature)))
+	}
+	return true
+}
+
+// postgresErrorResponse encodes an ErrorResponse message body.
+func postgresErrorResponse(sqlstate, message string) []byte {
+	return []byte("SERROR\x00C" + sqlstate + "\x00M" + message + "\x00\x00")
+}
+
+// servePostgres runs a minimal PostgreSQL server, which upgrades connections
+// to TLS with tlsConfig and answers only the query "SELECT 1".
+func servePostgres(t *testing.T, ln net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			c := &postgresConn{conn: conn, reader: bufio.NewReader(conn)}
+			readStartup := func() []byte {
+				var size uint32
+				if err := binary.Read(c.reader, binary.BigEndian, &size); err != nil {
+					return nil
+				}
+				body := make([]byte, size-4)
+				if _, err := io.ReadFull(c.reader, body); err != nil {
+					return nil
+				}
+				return body
+			}
+			startup := readStartup()
+			if binary.BigEndian.Uint32(startup) == postgresSSLRequestCode {
+				conn.Write([]byte{'S'})
+				tlsConn := tls.Server(conn, tlsConfig)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				c = &postgresConn{conn: tlsConn, reader: bufio.NewReader(tlsConn)}
+				startup = readStartup()
+			}
+			parameters := bytes.Split(startup[4:], []byte{0})
+			if binary.BigEndian.Uint32(startup) != postgresProtocolVersion || string(parameters[0]) != "user" {
+				t.Errorf("Unexpected startup message %q", startup)
+				return
+			}
+			if !postgresServerAuth(c, string(parameters[1])) {
+				c.send('E', postgresErrorResponse("28P01", "password authentication failed"))
+				return
+			}
+			c.send('R', postgresAuthRequest(0, ""))
+			c.send('S', []byte("server_version\x0016.4\x00"))
+			c.send('K', make([]byte, 8))
+			c.send('Z', []byte{'I'})
+
+			for {
+				messageType, body, err := c.receive()
+				if err != nil || messageType == 'X' {
+					return
+				}
+				if string(body) == "SELECT 1\x00" {
+					c.send('T', []byte("\x00\x01?column?\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x17\x00\x04\xff\xff\xff\xff\x00\x00"))
+					c.send('D', []byte("\x00\x01\x00\x00\x00\x011"))
+					c.send('C', []byte("SELECT 1\x00"))
+				} else {
+					c.send('E', postgresErrorResponse("42601", "syntax error"))
+				}
+				c.send('Z', []byte{'I'})
+			}
+		}(conn)
+	}
+}
+
+func TestPostgresQuery(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	go servePostgres(t, ln, tlsConfig)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	target := net.JoinHostPort("localhost", port)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	module := config.Module{Postgres: config.PostgresProbe{
+		IPProtocol: "ip4",
+		TLS:        true,
+		TLSConfig:  pconfig.TLSConfig{CAFile: caFile},
+		Username:   "scram",
+		Password:   "secret",
+		Query:      "SELECT 1",
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbePostgres(testCTX, target, module, registry, log.NewNopLogger()) {
+		t.Fatalf("PostgreSQL module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_postgres_duration_seconds": {
+			"phase": {
+				"connect": {},
+				"tls":     {},
+				"startup": {},
+				"query":   {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	checkRegistryLabels(map[string]map[string]string{"probe_postgres_server_version_info": {"version": "16.4"}}, mfs, t)
+	checkRegistryResults(map[string]float64{"probe_tls_version_info": 1}, mfs, t)
+
+	for _, tc := range []struct {
+		name     string
+		module   config.PostgresProbe
+		success  bool
+		sqlstate string
+	}{
+		{
+			name:    "md5",
+			module:  config.PostgresProbe{IPProtocol: "ip4", Username: "md5", Password: "secret", Query: "SELECT 1"},
+			success: true,
+		},
+		{
+			name:    "trust",
+			module:  config.PostgresProbe{IPProtocol: "ip4", Username: "postgres", Query: "SELECT 1"},
+			success: true,
+		},
+		{
+			name:     "wrong password",
+			module:   config.PostgresProbe{IPProtocol: "ip4", Username: "md5", Password: "wrong", Query: "SELECT 1"},
+			sqlstate: "28P01",
+		},
+		{
+			name:     "wrong scram password",
+			module:   config.PostgresProbe{IPProtocol: "ip4", Username: "scram", Password: "wrong", Query: "SELECT 1"},
+			sqlstate: "28P01",
+		},
+		{
+			name:     "invalid query",
+			module:   config.PostgresProbe{IPProtocol: "ip4", Username: "postgres", Query: "SELEC 1"},
+			sqlstate: "42601",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			if ProbePostgres(testCTX, target, config.Module{Postgres: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			if tc.sqlstate == "" {
+				return
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryLabels(map[string]map[string]string{"probe_postgres_error_info": {"sqlstate": tc.sqlstate}}, mfs, t)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	radiusAccessRequest   = 1
+	radiusAccessAccept    = 2
+	radiusAccessReject    = 3
+	radiusAccessChallenge = 11
+
+	radiusAttrUserName             = 1
+	radiusAttrUserPassword         = 2
+	radiusAttrCHAPPassword         = 3
+	radiusAttrNASIdentifier        = 32
+	radiusAttrMessageAuthenticator = 80
+
+	radiusHeaderSize = 20
+)
+
+type radiusAttribute struct {
+	typ   byte
+	value []byte
+}
+
+// radiusPAPPassword hides the password as described in RFC 2865, section
+// 5.2.
+func radiusPAPPassword(password, secret string, authenticator []byte) []byte {
+	hidden := make([]byte, max(md5.Size, (len(password)+15)&^15))
+	copy(hidden, password)
+	previous := authenticator
+	for i := 0; i < len(hidden); i += md5.Size {
+		mask := md5.Sum(append([]byte(secret), previous...))
+		for j := range mask {
+			hidden[i+j] ^= mask[j]
+		}
+		previous = hidden[i : i+md5.Size]
+	}
+	return hidden
+}
+
+// radiusCHAPPassword computes the CHAP response to the request authenticator
+// used as challenge.
+func radiusCHAPPassword(password string, authenticator []byte) []byte {
+	var ident [1]byte
+	rand.Read(ident[:])
+	response := md5.Sum(append(append(ident[:], password...), authenticator...))
+	return append(ident[:], response[:]...)
+}
+
+// radiusAccessRequestPacket encodes an Access-Request. The packet is signed
+// with a Message-Authenticator attribute.
+func radiusAccessRequestPacket(id byte, authenticator []byte, secret string, attributes []radiusAttribute) []byte {
+	packet := append([]byte{radiusAccessRequest, id, 0, 0}, authenticator...)
+	attributes = append(attributes, radiusAttribute{typ: radiusAttrMessageAuthenticator, value: make([]byte, md5.Size)})
+	for _, attribute := range attributes {
+		packet = append(packet, attribute.typ, byte(2+len(attribute.value)))
+		packet = append(packet, attribute.value...)
+	}
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(packet)
+	copy(packet[len(packet)-md5.Size:], mac.Sum(nil))
+	return packet
+}
+
+// radiusCheckResponse verifies the response authenticator of a response to
+// the request with the authenticator.
+func radiusCheckResponse(response, authenticator []byte, secret string) error {
+	if len(response) < radiusHeaderSize || int(binary.BigEndian.Uint16(response[2:])) > len(response) {
+		return errors.New("truncated response")
+	}
+	response = response[:binary.BigEndian.Uint16(response[2:])]
+	digest := md5.New()
+	digest.Write(response[:4])
+	digest.Write(authenticator)
+	digest.Write(response[radiusHeaderSize:])
+	digest.Write([]byte(secret))
+	if !bytes.Equal(digest.Sum(nil), response[4:radiusHeaderSize]) {
+		return errors.New("invalid response authenticator, the shared secret may be wrong")
+	}
+	return nil
+}
+
+// ProbeRADIUS sends an Access-Request with test credentials to a RADIUS
+// server and checks whether access is accepted.
+func ProbeRADIUS(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeRADIUSDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_radius_duration_seconds",
+		Help: "Duration of each phase of the RADIUS request",
+	}, []string{"phase"})
+	probeRADIUSResponseCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_radius_response_code",
+		Help: "Code of the response packet, 2 for Access-Accept and 3 for Access-Reject",
+	})
+	registry.MustRegister(probeRADIUSDuration)
+
+	conn, err := dialUDPTarget(ctx, target, module.RADIUS.IPProtocol, module.RADIUS.IPProtocolFallback, module.RADIUS.SourceIPAddress, registry, logger)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	secret, password := string(module.RADIUS.Secret), string(module.RADIUS.Password)
+	authenticator := make([]byte, 16)
+	rand.Read(authenticator)
+	attributes := []radiusAttribute{
+		{typ: radiusAttrUserName, value: []byte(module.RADIUS.Username)},
+		{typ: radiusAttrNASIdentifier, value: []byte(module.RADIUS.NASIdentifier)},
+	}
+	if module.RADIUS.AuthMethod == "CHAP" {
+		attributes = append(attributes, radiusAttribute{typ: radiusAttrCHAPPassword, value: radiusCHAPPassword(password, authenticator)})
+	} else {
+		attributes = append(attributes, radiusAttribute{typ: radiusAttrUserPassword, value: radiusPAPPassword(password, secret, authenticator)})
+	}
+	id := authenticator[0]
+	request := radiusAccessRequestPacket(id, authenticator, secret, attributes)
+
+	start := time.Now()
+	var response []byte
+	err = func() error {
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return err
+			}
+			if n >= radiusHeaderSize && buf[1] == id {
+				response = buf[:n]
+				return radiusCheckResponse(response, authenticator, secret)
+			}
+		}
+	}()
+	probeRADIUSDuration.WithLabelValues("access").Set(time.Since(start).Seconds())
+	if err != nil {
+		level.Error(logger).Log("msg", "Access-Request failed", "err", err)
+		return false
+	}
+
+	registry.MustRegister(probeRADIUSResponseCode)
+	probeRADIUSResponseCode.Set(float64(response[0]))
+	expected := byte(radiusAccessAccept)
+	if module.RADIUS.ExpectReject {
+		expected = radiusAccessReject
+	}
+	switch response[0] {
+	case expected:
+		return true
+	case radiusAccessAccept:
+		level.Error(logger).Log("msg", "Access was accepted, expected a reject")
+	case radiusAccessReject:
+		level.Error(logger).Log("msg", "Access was rejected")
+	case radiusAccessChallenge:
+		level.Error(logger).Log("msg", "Server sent an Access-Challenge, which is not supported")
+	default:
+		level.Error(logger).Log("msg", "Unexpected response code", "code", response[0])
+	}
+	return false
+}
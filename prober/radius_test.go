@@ -0,0 +1,167 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+var radiusTestUsers = map[string]string{
+	"prober": "secret",
+	"long":   "a password longer than sixteen bytes",
+}
+
+// serveRADIUS runs a minimal RADIUS server with the shared secret
+// "testing123", accepting the radiusTestUsers.
+func serveRADIUS(t *testing.T, conn net.PacketConn) {
+	const secret = "testing123"
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request := buf[:n]
+		if request[0] != radiusAccessRequest || int(binary.BigEndian.Uint16(request[2:])) != n {
+			t.Errorf("Unexpected request %x", request)
+			return
+		}
+		authenticator := request[4:radiusHeaderSize]
+		attributes := map[byte][]byte{}
+		for b := request[radiusHeaderSize:]; len(b) >= 2; b = b[b[1]:] {
+			attributes[b[0]] = b[2:b[1]]
+		}
+
+		signature := bytes.Clone(attributes[radiusAttrMessageAuthenticator])
+		copy(attributes[radiusAttrMessageAuthenticator], make([]byte, md5.Size))
+		mac := hmac.New(md5.New, []byte(secret))
+		mac.Write(request)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			continue
+		}
+
+		password, accepted := radiusTestUsers[string(attributes[radiusAttrUserName])]
+		if chap, ok := attributes[radiusAttrCHAPPassword]; ok {
+			expected := md5.Sum(append(append([]byte{chap[0]}, password...), authenticator...))
+			accepted = accepted && bytes.Equal(chap[1:], expected[:])
+		} else {
+			// Hiding the password again reproduces the attribute.
+			accepted = accepted && bytes.Equal(attributes[radiusAttrUserPassword], radiusPAPPassword(password, secret, authenticator))
+		}
+
+		response := append([]byte{radiusAccessReject, request[1], 0, radiusHeaderSize}, make([]byte, 16)...)
+		if accepted {
+			response[0] = radiusAccessAccept
+		}
+		digest := md5.Sum(append(append(append(response[:4:4], authenticator...), response[radiusHeaderSize:]...), secret...))
+		copy(response[4:], digest[:])
+		conn.WriteTo(response, addr)
+	}
+}
+
+func TestRADIUSAccessRequest(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer conn.Close()
+	go serveRADIUS(t, conn)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name         string
+		module       config.RADIUSProbe
+		success      bool
+		responseCode float64
+	}{
+		{
+			name:         "pap",
+			module:       config.RADIUSProbe{Secret: "testing123", Username: "prober", Password: "secret", AuthMethod: "PAP"},
+			success:      true,
+			responseCode: radiusAccessAccept,
+		},
+		{
+			name:         "pap with long password",
+			module:       config.RADIUSProbe{Secret: "testing123", Username: "long", Password: "a password longer than sixteen bytes", AuthMethod: "PAP"},
+			success:      true,
+			responseCode: radiusAccessAccept,
+		},
+		{
+			name:         "chap",
+			module:       config.RADIUSProbe{Secret: "testing123", Username: "prober", Password: "secret", AuthMethod: "CHAP"},
+			success:      true,
+			responseCode: radiusAccessAccept,
+		},
+		{
+			name:         "wrong password",
+			module:       config.RADIUSProbe{Secret: "testing123", Username: "prober", Password: "wrong", AuthMethod: "CHAP"},
+			responseCode: radiusAccessReject,
+		},
+		{
+			name:         "expected reject",
+			module:       config.RADIUSProbe{Secret: "testing123", Username: "prober", Password: "wrong", AuthMethod: "PAP", ExpectReject: true},
+			success:      true,
+			responseCode: radiusAccessReject,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeRADIUS(testCTX, conn.LocalAddr().String(), config.Module{RADIUS: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_radius_duration_seconds": {"phase": {"access": {}}}}, mfs, t)
+			checkRegistryResults(map[string]float64{"probe_radius_response_code": tc.responseCode}, mfs, t)
+		})
+	}
+}
+
+func TestRADIUSPAPPassword(t *testing.T) {
+	// The password is recovered by XORing the hidden password with the same
+	// masks.
+	authenticator := []byte("0123456789abcdef")
+	hidden := radiusPAPPassword("a password longer than sixteen bytes", "testing123", authenticator)
+	if len(hidden) != 48 {
+		t.Fatalf("Expected 48 bytes, got %d", len(hidden))
+	}
+	previous, recovered := authenticator, make([]byte, len(hidden))
+	for i := 0; i < len(hidden); i += md5.Size {
+		mask := md5.Sum(append([]byte("testing123"), previous...))
+		for j := range mask {
+			recovered[i+j] = hidden[i+j] ^ mask[j]
+		}
+		previous = hidden[i : i+md5.Size]
+	}
+	if string(bytes.TrimRight(recovered, "\x00")) != "a password longer than sixteen bytes" {
+		t.Errorf("Unexpected recovered password %q", recovered)
+	}
+}
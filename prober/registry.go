@@ -0,0 +1,245 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// registryManifestTypes are the manifest media types accepted from the
+// registry.
+var registryManifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// parseRegistryChallenge parses a WWW-Authenticate header into the scheme and
+// its parameters. Parameter values may be quoted and contain commas.
+func parseRegistryChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return strings.ToLower(scheme), params
+}
+
+// registryClient sends requests to a registry, authorizing them as requested
+// by the challenge of the registry.
+type registryClient struct {
+	client        *http.Client
+	username      string
+	password      string
+	authorization string
+}
+
+func (c *registryClient) do(ctx context.Context, method, requestURL string, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("User-Agent", userAgentDefaultHeader)
+	if c.authorization != "" {
+		request.Header.Set("Authorization", c.authorization)
+	}
+	return c.client.Do(request)
+}
+
+// authorize answers the challenge of a 401 response, either with basic
+// authentication or with a bearer token from the token service.
+func (c *registryClient) authorize(ctx context.Context, challenge, scope string) error {
+	scheme, params := parseRegistryChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if c.username == "" {
+			return errors.New("registry requires basic authentication but no username is configured")
+		}
+		request := &http.Request{Header: http.Header{}}
+		request.SetBasicAuth(c.username, c.password)
+		c.authorization = request.Header.Get("Authorization")
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || tokenURL.Host == "" {
+		return fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	tokenURL.RawQuery = query.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", userAgentDefaultHeader)
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request failed with status %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return err
+	}
+	// Both fields are defined, access_token for OAuth 2.0 compatibility.
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return errors.New("token response contains no token")
+	}
+	c.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// ProbeRegistry checks the API version endpoint of a container registry,
+// authenticating as requested, and optionally the manifest of an image.
+func ProbeRegistry(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeRegistryDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_registry_duration_seconds",
+		Help: "Duration of each phase of the registry probe",
+	}, []string{"phase"})
+	registry.MustRegister(probeRegistryDuration)
+
+	// step runs and times one phase of the probe.
+	step := newStepTimer(probeRegistryDuration, "phase", "Registry phase", logger, nil)
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "https://" + target
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+	httpClientConfig := pconfig.DefaultHTTPClientConfig
+	httpClientConfig.TLSConfig = module.Registry.TLSConfig
+	client, err := newHTTPClient(ctx, targetURL, module.Registry.IPProtocol, module.Registry.IPProtocolFallback, module.Registry.SourceIPAddress, httpClientConfig, "registry_probe", registry, logger)
+	if err != nil {
+		return false
+	}
+	c := &registryClient{client: client, username: module.Registry.Username, password: string(module.Registry.Password)}
+	baseURL := strings.TrimSuffix(targetURL.String(), "/") + "/v2/"
+
+	var challenge string
+	if !step("ping", func() error {
+		resp, err := c.do(ctx, http.MethodGet, baseURL, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusUnauthorized:
+			challenge = resp.Header.Get("WWW-Authenticate")
+		default:
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}) {
+		return false
+	}
+
+	if challenge != "" {
+		var scope string
+		if module.Registry.Repository != "" {
+			scope = "repository:" + module.Registry.Repository + ":pull"
+		}
+		if !step("auth", func() error {
+			if err := c.authorize(ctx, challenge, scope); err != nil {
+				return err
+			}
+			// The credentials have to be accepted by the registry itself.
+			resp, err := c.do(ctx, http.MethodGet, baseURL, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unexpected status %s after authentication", resp.Status)
+			}
+			return nil
+		}) {
+			return false
+		}
+	}
+
+	if module.Registry.Repository == "" {
+		return true
+	}
+	return step("manifest", func() error {
+		resp, err := c.do(ctx, http.MethodHead, baseURL+module.Registry.Repository+"/manifests/"+module.Registry.Reference,
+			http.Header{"Accept": {strings.Join(registryManifestTypes, ", ")}})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		level.Info(logger).Log("msg", "Manifest found", "digest", resp.Header.Get("Docker-Content-Digest"), "media_type", resp.Header.Get("Content-Type"))
+		return nil
+	})
+}
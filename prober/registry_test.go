@@ -0,0 +1,123 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestParseRegistryChallenge(t *testing.T) {
+	scheme, params := parseRegistryChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:library/alpine:pull,push"`)
+	if scheme != "bearer" {
+		t.Errorf("Unexpected scheme %q", scheme)
+	}
+	expected := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:library/alpine:pull,push",
+	}
+	for key, value := range expected {
+		if params[key] != value {
+			t.Errorf("Expected %s %q, got %q", key, value, params[key])
+		}
+	}
+}
+
+func TestRegistryProbe(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			username, password, _ := r.BasicAuth()
+			if username != "prober" || password != "secret" || r.URL.Query().Get("service") != "test-registry" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `{"token":"t0k3n-%s"}`, r.URL.Query().Get("scope"))
+			return
+		case "/v2/":
+		case "/v2/library/alpine/manifests/3.20":
+			if r.Method != http.MethodHead {
+				t.Errorf("Unexpected method %s", r.Method)
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		authorization := r.Header.Get("Authorization")
+		if authorization != "Bearer t0k3n-" && authorization != "Bearer t0k3n-repository:library/alpine:pull" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, ts.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:0123")
+	}))
+	defer ts.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name    string
+		module  config.RegistryProbe
+		success bool
+		phases  map[string]struct{}
+	}{
+		{
+			name:    "api version",
+			module:  config.RegistryProbe{Username: "prober", Password: "secret"},
+			success: true,
+			phases:  map[string]struct{}{"ping": {}, "auth": {}},
+		},
+		{
+			name:    "manifest",
+			module:  config.RegistryProbe{Username: "prober", Password: "secret", Repository: "library/alpine", Reference: "3.20"},
+			success: true,
+			phases:  map[string]struct{}{"ping": {}, "auth": {}, "manifest": {}},
+		},
+		{
+			name:   "missing manifest",
+			module: config.RegistryProbe{Username: "prober", Password: "secret", Repository: "library/alpine", Reference: "2.0"},
+			phases: map[string]struct{}{"ping": {}, "auth": {}, "manifest": {}},
+		},
+		{
+			name:   "wrong password",
+			module: config.RegistryProbe{Username: "prober", Password: "wrong"},
+			phases: map[string]struct{}{"ping": {}, "auth": {}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeRegistry(testCTX, ts.URL, config.Module{Registry: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_registry_duration_seconds": {"phase": tc.phases}}, mfs, t)
+		})
+	}
+}
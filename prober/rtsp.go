@@ -0,0 +1,297 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// rtspMaxBodySize limits the size of the SDP description read.
+const rtspMaxBodySize = 1 << 20
+
+var rtspAuthParamRE = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+
+type rtspResponse struct {
+	statusCode int
+	header     textproto.MIMEHeader
+	body       []byte
+}
+
+// rtspConn sends requests over an RTSP connection.
+type rtspConn struct {
+	conn   net.Conn
+	reader *textproto.Reader
+	cseq   int
+
+	// authorize returns the Authorization header of a request, once the
+	// server asked for credentials.
+	authorize func(method, uri string) string
+}
+
+func (c *rtspConn) request(method, uri string, header map[string]string) (*rtspResponse, error) {
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: %s\r\n", method, uri, c.cseq, userAgentDefaultHeader)
+	for name, value := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	if c.authorize != nil {
+		fmt.Fprintf(&b, "Authorization: %s\r\n", c.authorize(method, uri))
+	}
+	b.WriteString("\r\n")
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	line, err := c.reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	version, status, _ := strings.Cut(line, " ")
+	code, _, _ := strings.Cut(status, " ")
+	if !strings.HasPrefix(version, "RTSP/") {
+		return nil, fmt.Errorf("invalid status line %q", line)
+	}
+	response := &rtspResponse{}
+	if response.statusCode, err = strconv.Atoi(code); err != nil {
+		return nil, fmt.Errorf("invalid status line %q", line)
+	}
+	if response.header, err = c.reader.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	if cseq := response.header.Get("CSeq"); cseq != strconv.Itoa(c.cseq) {
+		return nil, fmt.Errorf("response has CSeq %q, expected %d", cseq, c.cseq)
+	}
+	if length := response.header.Get("Content-Length"); length != "" {
+		size, err := strconv.Atoi(length)
+		if err != nil || size < 0 || size > rtspMaxBodySize {
+			return nil, fmt.Errorf("invalid Content-Length %q", length)
+		}
+		response.body = make([]byte, size)
+		if _, err := io.ReadFull(c.reader.R, response.body); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// rtspAuthorization returns a function computing the Authorization header
+// for the challenges of the server, preferring digest authentication.
+func rtspAuthorization(challenges []string, username, password string) (func(method, uri string) string, error) {
+	for _, challenge := range challenges {
+		scheme, params, _ := strings.Cut(challenge, " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		values := map[string]string{}
+		for _, match := range rtspAuthParamRE.FindAllStringSubmatch(params, -1) {
+			values[strings.ToLower(match[1])] = match[2] + match[3]
+		}
+		if algorithm := values["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+			return nil, fmt.Errorf("unsupported digest algorithm %q", algorithm)
+		}
+		md5Hex := func(s string) string {
+			sum := md5.Sum([]byte(s))
+			return hex.EncodeToString(sum[:])
+		}
+		realm, nonce := values["realm"], values["nonce"]
+		ha1 := md5Hex(username + ":" + realm + ":" + password)
+		qopAuth := false
+		for _, qop := range strings.Split(values["qop"], ",") {
+			qopAuth = qopAuth || strings.TrimSpace(qop) == "auth"
+		}
+		nc := 0
+		return func(method, uri string) string {
+			ha2 := md5Hex(method + ":" + uri)
+			header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, username, realm, nonce, uri)
+			if !qopAuth {
+				return header + fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+nonce+":"+ha2))
+			}
+			nc++
+			cnonce := make([]byte, 8)
+			rand.Read(cnonce)
+			response := md5Hex(fmt.Sprintf("%s:%s:%08x:%x:auth:%s", ha1, nonce, nc, cnonce, ha2))
+			return header + fmt.Sprintf(`, qop=auth, nc=%08x, cnonce="%x", response="%s"`, nc, cnonce, response)
+		}, nil
+	}
+	for _, challenge := range challenges {
+		if scheme, _, _ := strings.Cut(challenge, " "); strings.EqualFold(scheme, "Basic") {
+			credentials := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+			return func(string, string) string { return credentials }, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported authentication challenges %q", challenges)
+}
+
+// ProbeRTSP sends OPTIONS and DESCRIBE requests for the URL of a stream and
+// validates the SDP description of the stream.
+func ProbeRTSP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeRTSPDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_rtsp_duration_seconds",
+		Help: "Duration of each phase of the RTSP session",
+	}, []string{"phase"})
+	probeRTSPStatusCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_rtsp_status_code",
+		Help: "Status code of the last RTSP response",
+	})
+	probeRTSPMediaStreams := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_rtsp_media_streams",
+		Help: "Number of media streams of each type in the SDP description",
+	}, []string{"media"})
+	registry.MustRegister(probeRTSPDuration, probeRTSPStatusCode)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeRTSPDuration, "phase", "RTSP phase", logger, nil)
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+	port := targetURL.Port()
+	switch targetURL.Scheme {
+	case "rtsp":
+		if port == "" {
+			port = "554"
+		}
+	case "rtsps":
+		if port == "" {
+			port = "322"
+		}
+	default:
+		level.Error(logger).Log("msg", "Target URL must use the rtsp or rtsps scheme", "target", target)
+		return false
+	}
+	targetURL.User = nil
+	uri := targetURL.String()
+
+	address := net.JoinHostPort(targetURL.Hostname(), port)
+	tlsConfig, err := newTargetTLSConfig(&module.RTSP.TLSConfig, address)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var conn net.Conn
+	if !step("connect", func() error {
+		conn, _, err = dialTCPTarget(ctx, address, module.RTSP.IPProtocol, module.RTSP.IPProtocolFallback, module.RTSP.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if targetURL.Scheme == "rtsps" {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		state := tlsConn.ConnectionState()
+		reportTLSConnectionState(&state, registry)
+		conn = tlsConn
+	}
+	c := &rtspConn{conn: conn, reader: textproto.NewReader(bufio.NewReader(conn))}
+
+	// do sends a request, authenticating it if the server asks for
+	// credentials, and requires a successful response.
+	do := func(method string, header map[string]string) (*rtspResponse, error) {
+		response, err := c.request(method, uri, header)
+		if err == nil && response.statusCode == 401 && c.authorize == nil && module.RTSP.Username != "" {
+			c.authorize, err = rtspAuthorization(response.header.Values("WWW-Authenticate"), module.RTSP.Username, string(module.RTSP.Password))
+			if err != nil {
+				return nil, err
+			}
+			response, err = c.request(method, uri, header)
+		}
+		if err != nil {
+			return nil, err
+		}
+		probeRTSPStatusCode.Set(float64(response.statusCode))
+		if response.statusCode != 200 {
+			return nil, fmt.Errorf("%s returned status code %d", method, response.statusCode)
+		}
+		return response, nil
+	}
+
+	if !step("options", func() error {
+		response, err := do("OPTIONS", nil)
+		if err != nil {
+			return err
+		}
+		level.Debug(logger).Log("msg", "Server supports methods", "public", response.header.Get("Public"))
+		return nil
+	}) {
+		return false
+	}
+
+	var description *rtspResponse
+	if !step("describe", func() error {
+		description, err = do("DESCRIBE", map[string]string{"Accept": "application/sdp"})
+		return err
+	}) {
+		return false
+	}
+
+	if contentType := description.header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/sdp") {
+		level.Error(logger).Log("msg", "Description is not SDP", "content_type", contentType)
+		return false
+	}
+	lines := strings.Split(string(description.body), "\n")
+	if strings.TrimSpace(lines[0]) != "v=0" {
+		level.Error(logger).Log("msg", "Description does not start with the SDP version")
+		return false
+	}
+	media := map[string]int{}
+	for _, line := range lines {
+		if fields, ok := strings.CutPrefix(strings.TrimSpace(line), "m="); ok {
+			mediaType, _, _ := strings.Cut(fields, " ")
+			media[mediaType]++
+		}
+	}
+	registry.MustRegister(probeRTSPMediaStreams)
+	for mediaType, streams := range media {
+		probeRTSPMediaStreams.WithLabelValues(mediaType).Set(float64(streams))
+	}
+	if len(media) == 0 {
+		level.Error(logger).Log("msg", "Description does not contain any media stream")
+		return false
+	}
+	for _, mediaType := range module.RTSP.ExpectMedia {
+		if media[mediaType] == 0 {
+			level.Error(logger).Log("msg", "Description does not contain an expected media stream", "media", mediaType)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const rtspTestSDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=Camera\r\nt=0 0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\nm=audio 0 RTP/AVP 0\r\n"
+
+// rtspTestDigestValid checks the digest response of the user "prober" with
+// the password "secret".
+func rtspTestDigestValid(method, authorization string) bool {
+	values := map[string]string{}
+	for _, match := range rtspAuthParamRE.FindAllStringSubmatch(strings.TrimPrefix(authorization, "Digest "), -1) {
+		values[match[1]] = match[2] + match[3]
+	}
+	md5Hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := md5Hex(values["username"] + ":camera:secret")
+	ha2 := md5Hex(method + ":" + values["uri"])
+	expected := md5Hex(strings.Join([]string{ha1, "4f1d", values["nc"], values["cnonce"], "auth", ha2}, ":"))
+	return values["username"] == "prober" && values["qop"] == "auth" && values["response"] == expected
+}
+
+// serveRTSP runs a minimal RTSP server. The stream "/secure" requires digest
+// authentication and "/audio" only has an audio stream.
+func serveRTSP(t *testing.T, ln net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if tlsConfig != nil {
+				conn = tls.Server(conn, tlsConfig)
+			}
+			reader := textproto.NewReader(bufio.NewReader(conn))
+			for {
+				line, err := reader.ReadLine()
+				if err != nil {
+					return
+				}
+				header, err := reader.ReadMIMEHeader()
+				if err != nil {
+					return
+				}
+				fields := strings.Fields(line)
+				if len(fields) != 3 || fields[2] != "RTSP/1.0" {
+					t.Errorf("Unexpected request line %q", line)
+					return
+				}
+				method, path := fields[0], fields[1][strings.Index(fields[1][len("rtsp://"):], "/")+len("rtsp://"):]
+				status, extra, body := "200 OK", "", ""
+				switch {
+				case path == "/secure" && !rtspTestDigestValid(method, header.Get("Authorization")):
+					status, extra = "401 Unauthorized", "WWW-Authenticate: Basic realm=\"camera\"\r\nWWW-Authenticate: Digest realm=\"camera\", nonce=\"4f1d\", qop=\"auth\"\r\n"
+				case method == "OPTIONS":
+					extra = "Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN\r\n"
+				case method == "DESCRIBE" && path == "/missing":
+					status = "404 Not Found"
+				case method == "DESCRIBE" && path == "/audio":
+					extra, body = "Content-Type: application/sdp\r\n", "v=0\r\nm=audio 0 RTP/AVP 0\r\n"
+				case method == "DESCRIBE":
+					extra, body = "Content-Type: application/sdp\r\n", rtspTestSDP
+				}
+				fmt.Fprintf(conn, "RTSP/1.0 %s\r\nCSeq: %s\r\n%sContent-Length: %d\r\n\r\n%s", status, header.Get("CSeq"), extra, len(body), body)
+			}
+		}(conn)
+	}
+}
+
+func TestRTSPDescribe(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go serveRTSP(t, ln, nil)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		module  config.RTSPProbe
+		success bool
+	}{
+		{
+			name:    "stream",
+			path:    "/stream",
+			module:  config.RTSPProbe{ExpectMedia: []string{"video", "audio"}},
+			success: true,
+		},
+		{
+			name:    "digest authentication",
+			path:    "/secure",
+			module:  config.RTSPProbe{Username: "prober", Password: "secret"},
+			success: true,
+		},
+		{
+			name:   "wrong password",
+			path:   "/secure",
+			module: config.RTSPProbe{Username: "prober", Password: "wrong"},
+		},
+		{
+			name:   "missing video",
+			path:   "/audio",
+			module: config.RTSPProbe{ExpectMedia: []string{"video"}},
+		},
+		{
+			name: "missing stream",
+			path: "/missing",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeRTSP(testCTX, "rtsp://"+ln.Addr().String()+tc.path, config.Module{RTSP: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			if !tc.success {
+				return
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedMetrics := map[string]map[string]map[string]struct{}{
+				"probe_rtsp_duration_seconds": {
+					"phase": {
+						"connect":  {},
+						"options":  {},
+						"describe": {},
+					},
+				},
+				"probe_rtsp_media_streams": {
+					"media": {
+						"video": {},
+						"audio": {},
+					},
+				},
+			}
+			checkMetrics(expectedMetrics, mfs, t)
+			checkRegistryResults(map[string]float64{"probe_rtsp_status_code": 200}, mfs, t)
+		})
+	}
+}
+
+func TestRTSPSDescribe(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	go serveRTSP(t, ln, tlsConfig)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	module := config.Module{RTSP: config.RTSPProbe{
+		IPProtocol: "ip4",
+		TLSConfig:  pconfig.TLSConfig{CAFile: caFile},
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeRTSP(testCTX, "rtsps://"+net.JoinHostPort("localhost", port)+"/stream", module, registry, log.NewNopLogger()) {
+		t.Fatalf("RTSP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_tls_version_info": 1}, mfs, t)
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scramClient is the client side of a SCRAM authentication exchange as
+// specified in RFC 5802, without channel binding.
+type scramClient struct {
+	hash     func() hash.Hash
+	username string
+	password string
+	nonce    string
+
+	authMessage    string
+	saltedPassword []byte
+}
+
+func newSCRAMClient(hash func() hash.Hash, username, password string) *scramClient {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	return &scramClient{
+		hash:     hash,
+		username: username,
+		password: password,
+		nonce:    base64.RawStdEncoding.EncodeToString(nonce),
+	}
+}
+
+func (c *scramClient) hmac(key []byte, message string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func (c *scramClient) clientFirstBare() string {
+	username := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(c.username)
+	return "n=" + username + ",r=" + c.nonce
+}
+
+// clientFirst returns the first message of the client.
+func (c *scramClient) clientFirst() string {
+	return "n,," + c.clientFirstBare()
+}
+
+// clientFinal returns the final message of the client, which answers the
+// first message of the server.
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	var (
+		nonce      string
+		salt       []byte
+		iterations int
+		err        error
+	)
+	for _, attribute := range strings.Split(serverFirst, ",") {
+		key, value, _ := strings.Cut(attribute, "=")
+		switch key {
+		case "r":
+			nonce = value
+		case "s":
+			if salt, err = base64.StdEncoding.DecodeString(value); err != nil {
+				return "", fmt.Errorf("invalid salt: %w", err)
+			}
+		case "i":
+			if iterations, err = strconv.Atoi(value); err != nil {
+				return "", fmt.Errorf("invalid iteration count: %w", err)
+			}
+		}
+	}
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("server nonce does not extend the client nonce")
+	}
+	if len(salt) == 0 || iterations <= 0 {
+		return "", fmt.Errorf("invalid server message: %s", serverFirst)
+	}
+
+	c.saltedPassword = pbkdf2.Key([]byte(c.password), salt, iterations, c.hash().Size(), c.hash)
+	clientKey := c.hmac(c.saltedPassword, "Client Key")
+	storedKey := c.hash()
+	storedKey.Write(clientKey)
+	withoutProof := "c=biws,r=" + nonce
+	c.authMessage = c.clientFirstBare() + "," + serverFirst + "," + withoutProof
+	proof := c.hmac(storedKey.Sum(nil), c.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verifyServerFinal checks that the final message of the server proves that
+// the server knows the password.
+func (c *scramClient) verifyServerFinal(serverFinal string) error {
+	if e, ok := strings.CutPrefix(serverFinal, "e="); ok {
+		return fmt.Errorf("authentication failed: %s", e)
+	}
+	verifier, ok := strings.CutPrefix(serverFinal, "v=")
+	if !ok {
+		return fmt.Errorf("invalid server message: %s", serverFinal)
+	}
+	signature, err := base64.StdEncoding.DecodeString(verifier)
+	if err != nil {
+		return fmt.Errorf("invalid server signature: %w", err)
+	}
+	serverKey := c.hmac(c.saltedPassword, "Server Key")
+	if !hmac.Equal(signature, c.hmac(serverKey, c.authMessage)) {
+		return errors.New("invalid server signature")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestSCRAMClient(t *testing.T) {
+	// The example exchange of RFC 7677.
+	c := newSCRAMClient(sha256.New, "user", "pencil")
+	c.nonce = "rOprNGfwEbeRWgbNEkqO"
+
+	if got, want := c.clientFirst(), "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"; got != want {
+		t.Fatalf("Unexpected client first message %q, want %q", got, want)
+	}
+	got, err := c.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; got != want {
+		t.Fatalf("Unexpected client final message %q, want %q", got, want)
+	}
+	if err := c.verifyServerFinal("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.verifyServerFinal("v=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="); err == nil {
+		t.Fatal("Expected an invalid server signature to fail")
+	}
+
+	if _, err := c.clientFinal("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"); err == nil {
+		t.Fatal("Expected a server nonce not extending the client nonce to fail")
+	}
+}
@@ -0,0 +1,664 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/md4" //nolint:staticcheck // NTLM requires MD4.
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	smbHeaderSize = 64
+
+	smbNegotiate      = 0x0
+	smbSessionSetup   = 0x1
+	smbTreeConnect    = 0x3
+	smbCreate         = 0x5
+	smbClose          = 0x6
+	smbQueryDirectory = 0xe
+
+	smbFlagsResponse = 0x1
+	smbFlagsAsync    = 0x2
+	smbFlagsSigned   = 0x8
+
+	smbStatusSuccess                = 0x00000000
+	smbStatusPending                = 0x00000103
+	smbStatusNoMoreFiles            = 0x80000006
+	smbStatusMoreProcessingRequired = 0xc0000016
+
+	smbSessionFlagIsGuest = 0x1
+	smbSessionFlagIsNull  = 0x2
+
+	smbShareFlagEncryptData = 0x8000
+
+	smbSigningRequired = 0x2
+)
+
+// smbDialects are the dialects offered by the probe, by dialect revision.
+var smbDialects = map[uint16]string{
+	0x0202: "2.0.2",
+	0x0210: "2.1",
+	0x0300: "3.0",
+	0x0302: "3.0.2",
+	0x0311: "3.1.1",
+}
+
+// smbError is an unsuccessful NT status returned by the server.
+type smbError struct {
+	command uint16
+	status  uint32
+}
+
+func (e *smbError) Error() string {
+	return fmt.Sprintf("command %d failed with status 0x%08x", e.command, e.status)
+}
+
+// smbUTF16 encodes a string as UTF-16LE.
+func smbUTF16(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// aesCMAC computes the AES-CMAC of RFC 4493.
+func aesCMAC(key, message []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	subkey := func(b []byte) []byte {
+		k := make([]byte, aes.BlockSize)
+		for i := range b {
+			k[i] = b[i] << 1
+			if i+1 < len(b) {
+				k[i] |= b[i+1] >> 7
+			}
+		}
+		if b[0]&0x80 != 0 {
+			k[aes.BlockSize-1] ^= 0x87
+		}
+		return k
+	}
+	l := make([]byte, aes.BlockSize)
+	block.Encrypt(l, l)
+	k1 := subkey(l)
+	k2 := subkey(k1)
+
+	n := (len(message) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n > 0 && len(message)%aes.BlockSize == 0 {
+		copy(last, message[(n-1)*aes.BlockSize:])
+		for i := range last {
+			last[i] ^= k1[i]
+		}
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := message[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		for i := range last {
+			last[i] ^= k2[i]
+		}
+	}
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n; i++ {
+		b := last
+		if i < n-1 {
+			b = message[i*aes.BlockSize : (i+1)*aes.BlockSize]
+		}
+		for j := range x {
+			x[j] ^= b[j]
+		}
+		block.Encrypt(x, x)
+	}
+	return x
+}
+
+// smbKDF derives a 128 bit key with the counter mode KDF of SP800-108 and
+// HMAC-SHA256.
+func smbKDF(key, label, context []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte{0, 0, 0, 1})
+	mac.Write(label)
+	mac.Write([]byte{0})
+	mac.Write(context)
+	mac.Write([]byte{0, 0, 0, 128})
+	return mac.Sum(nil)[:16]
+}
+
+// ntlmV2Response computes the NTLMv2 response to a server challenge and the
+// session base key as described in MS-NLMP 3.3.2.
+func ntlmV2Response(username, domain, password string, serverChallenge, clientChallenge, timestamp, targetInfo []byte) ([]byte, []byte) {
+	nt := md4.New()
+	nt.Write(smbUTF16(password))
+	mac := hmac.New(md5.New, nt.Sum(nil))
+	mac.Write(smbUTF16(strings.ToUpper(username) + domain))
+	responseKey := mac.Sum(nil)
+
+	blob := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	blob = append(blob, timestamp...)
+	blob = append(blob, clientChallenge...)
+	blob = append(blob, 0, 0, 0, 0)
+	blob = append(blob, targetInfo...)
+	blob = append(blob, 0, 0, 0, 0)
+
+	mac = hmac.New(md5.New, responseKey)
+	mac.Write(serverChallenge)
+	mac.Write(blob)
+	proof := mac.Sum(nil)
+	mac = hmac.New(md5.New, responseKey)
+	mac.Write(proof)
+	return append(proof, blob...), mac.Sum(nil)
+}
+
+const (
+	ntlmNegotiateFlags = 0x00000001 | // Unicode.
+		0x00000004 | // Request target.
+		0x00000010 | // Sign.
+		0x00000200 | // NTLM.
+		0x00008000 | // Always sign.
+		0x00080000 | // Extended session security.
+		0x00800000 | // Target info.
+		0x20000000 | // 128 bit.
+		0x80000000 // 56 bit.
+)
+
+// ntlmNegotiateMessage returns the NEGOTIATE_MESSAGE starting NTLM
+// authentication.
+func ntlmNegotiateMessage() []byte {
+	b := append([]byte("NTLMSSP\x00"), 1, 0, 0, 0)
+	b = binary.LittleEndian.AppendUint32(b, ntlmNegotiateFlags)
+	return append(b, make([]byte, 16)...)
+}
+
+// ntlmAuthenticateMessage answers a CHALLENGE_MESSAGE, returning the
+// AUTHENTICATE_MESSAGE and the session key.
+func ntlmAuthenticateMessage(challenge []byte, username, domain, password string) ([]byte, []byte, error) {
+	if len(challenge) < 48 || !bytes.HasPrefix(challenge, []byte("NTLMSSP\x00\x02\x00\x00\x00")) {
+		return nil, nil, errors.New("invalid NTLM challenge")
+	}
+	length, offset := binary.LittleEndian.Uint16(challenge[40:]), binary.LittleEndian.Uint32(challenge[44:])
+	if int(offset)+int(length) > len(challenge) {
+		return nil, nil, errors.New("invalid NTLM target info")
+	}
+	targetInfo := challenge[offset : offset+uint32(length)]
+
+	// The timestamp of the server is used if present, so that the clocks do
+	// not have to be synchronized.
+	var timestamp []byte
+	for b := targetInfo; len(b) >= 4; {
+		id, n := binary.LittleEndian.Uint16(b), int(binary.LittleEndian.Uint16(b[2:]))
+		if id == 0 || len(b) < 4+n {
+			break
+		}
+		if id == 7 && n == 8 {
+			timestamp = b[4:12]
+		}
+		b = b[4+n:]
+	}
+	if timestamp == nil {
+		// Windows file times count 100ns intervals since 1601.
+		timestamp = binary.LittleEndian.AppendUint64(nil, uint64(time.Now().UnixNano()/100+116444736000000000))
+	}
+	clientChallenge := make([]byte, 8)
+	rand.Read(clientChallenge)
+	ntResponse, sessionKey := ntlmV2Response(username, domain, password, challenge[24:32], clientChallenge, timestamp, targetInfo)
+
+	fields := [][]byte{make([]byte, 24), ntResponse, smbUTF16(domain), smbUTF16(username), nil, nil}
+	b := append([]byte("NTLMSSP\x00"), 3, 0, 0, 0)
+	payloadOffset := 12 + 8*len(fields) + 4
+	var payload []byte
+	for _, field := range fields {
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(field)))
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(field)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(payloadOffset+len(payload)))
+		payload = append(payload, field...)
+	}
+	b = binary.LittleEndian.AppendUint32(b, ntlmNegotiateFlags)
+	return append(b, payload...), sessionKey, nil
+}
+
+// spnegoWrap encodes a DER element with the tag.
+func spnegoWrap(tag byte, content []byte) []byte {
+	b := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+// spnegoInit wraps the NTLM NEGOTIATE_MESSAGE in a SPNEGO NegTokenInit.
+func spnegoInit(token []byte) []byte {
+	spnegoOID := []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	ntlmOID := []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+	negTokenInit := spnegoWrap(0x30, append(spnegoWrap(0xa0, spnegoWrap(0x30, ntlmOID)), spnegoWrap(0xa2, spnegoWrap(0x04, token))...))
+	return spnegoWrap(0x60, append(spnegoOID, spnegoWrap(0xa0, negTokenInit)...))
+}
+
+// spnegoResponse wraps the NTLM AUTHENTICATE_MESSAGE in a SPNEGO
+// NegTokenResp.
+func spnegoResponse(token []byte) []byte {
+	return spnegoWrap(0xa1, spnegoWrap(0x30, spnegoWrap(0xa2, spnegoWrap(0x04, token))))
+}
+
+// smbConn speaks SMB2 over direct TCP.
+type smbConn struct {
+	conn       net.Conn
+	messageID  uint64
+	sessionID  uint64
+	treeID     uint32
+	dialect    uint16
+	signingKey []byte
+	// preauthHash is the SMB 3.1.1 preauthentication integrity hash.
+	preauthHash []byte
+}
+
+// sign sets the signature of a request.
+func (c *smbConn) sign(message []byte) {
+	binary.LittleEndian.PutUint32(message[16:], binary.LittleEndian.Uint32(message[16:])|smbFlagsSigned)
+	var signature []byte
+	if c.dialect >= 0x0300 {
+		signature = aesCMAC(c.signingKey, message)
+	} else {
+		mac := hmac.New(sha256.New, c.signingKey)
+		mac.Write(message)
+		signature = mac.Sum(nil)
+	}
+	copy(message[48:64], signature)
+}
+
+// request sends a command and returns the header and body of the response.
+// Unless the status is one of the accepted ones, an smbError is returned.
+func (c *smbConn) request(command uint16, body []byte, accepted ...uint32) ([]byte, []byte, error) {
+	message := make([]byte, smbHeaderSize, smbHeaderSize+len(body))
+	copy(message, "\xfeSMB")
+	binary.LittleEndian.PutUint16(message[4:], smbHeaderSize)
+	binary.LittleEndian.PutUint16(message[6:], 1) // Credit charge.
+	binary.LittleEndian.PutUint16(message[12:], command)
+	binary.LittleEndian.PutUint16(message[14:], 64) // Credits requested.
+	binary.LittleEndian.PutUint64(message[24:], c.messageID)
+	binary.LittleEndian.PutUint32(message[36:], c.treeID)
+	binary.LittleEndian.PutUint64(message[40:], c.sessionID)
+	message = append(message, body...)
+	c.messageID++
+	if c.signingKey != nil {
+		c.sign(message)
+	}
+	if command == smbNegotiate || command == smbSessionSetup {
+		c.updatePreauthHash(message)
+	}
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(message)))
+	if _, err := c.conn.Write(append(frame, message...)); err != nil {
+		return nil, nil, err
+	}
+	for {
+		var frameHeader [4]byte
+		if _, err := io.ReadFull(c.conn, frameHeader[:]); err != nil {
+			return nil, nil, err
+		}
+		response := make([]byte, binary.BigEndian.Uint32(frameHeader[:])&0xffffff)
+		if _, err := io.ReadFull(c.conn, response); err != nil {
+			return nil, nil, err
+		}
+		if len(response) < smbHeaderSize || !bytes.HasPrefix(response, []byte("\xfeSMB")) {
+			return nil, nil, errors.New("invalid SMB2 response")
+		}
+		header, status := response[:smbHeaderSize], binary.LittleEndian.Uint32(response[8:])
+		// Interim responses announce that the result follows later.
+		if status == smbStatusPending && binary.LittleEndian.Uint32(header[16:])&smbFlagsAsync != 0 {
+			continue
+		}
+		// The final session setup response is not part of the preauthentication hash.
+		if command == smbNegotiate || (command == smbSessionSetup && status != smbStatusSuccess) {
+			c.updatePreauthHash(response)
+		}
+		if status != smbStatusSuccess {
+			isAccepted := false
+			for _, s := range accepted {
+				isAccepted = isAccepted || s == status
+			}
+			if !isAccepted {
+				return nil, nil, &smbError{command: command, status: status}
+			}
+		}
+		return header, response[smbHeaderSize:], nil
+	}
+}
+
+func (c *smbConn) updatePreauthHash(message []byte) {
+	if c.preauthHash == nil {
+		return
+	}
+	h := sha512.New()
+	h.Write(c.preauthHash)
+	h.Write(message)
+	c.preauthHash = h.Sum(nil)
+}
+
+// negotiate offers all supported dialects, returning the chosen dialect and
+// the security mode of the server.
+func (c *smbConn) negotiate() (uint16, uint16, error) {
+	c.preauthHash = make([]byte, sha512.Size)
+	dialects := []uint16{0x0202, 0x0210, 0x0300, 0x0302, 0x0311}
+	body := binary.LittleEndian.AppendUint16(nil, 36)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(dialects)))
+	body = binary.LittleEndian.AppendUint16(body, 1) // Signing enabled.
+	body = append(body, 0, 0, 0, 0, 0, 0)            // Reserved and capabilities.
+	clientGUID := make([]byte, 16)
+	rand.Read(clientGUID)
+	body = append(body, clientGUID...)
+	contextOffset := smbHeaderSize + len(body) + 8 + 2*len(dialects)
+	contextOffset += (8 - contextOffset%8) % 8
+	body = binary.LittleEndian.AppendUint32(body, uint32(contextOffset))
+	body = binary.LittleEndian.AppendUint16(body, 1)
+	body = append(body, 0, 0)
+	for _, dialect := range dialects {
+		body = binary.LittleEndian.AppendUint16(body, dialect)
+	}
+	body = append(body, make([]byte, contextOffset-smbHeaderSize-len(body))...)
+	// The preauthentication integrity capabilities with SHA-512.
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	body = append(body, 1, 0, byte(6+len(salt)), 0, 0, 0, 0, 0, 1, 0, byte(len(salt)), 0, 1, 0)
+	body = append(body, salt...)
+
+	_, response, err := c.request(smbNegotiate, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(response) < 64 {
+		return 0, 0, errors.New("truncated negotiate response")
+	}
+	c.dialect = binary.LittleEndian.Uint16(response[4:])
+	if _, ok := smbDialects[c.dialect]; !ok {
+		return 0, 0, fmt.Errorf("server chose unknown dialect 0x%04x", c.dialect)
+	}
+	if c.dialect != 0x0311 {
+		c.preauthHash = nil
+	}
+	return c.dialect, binary.LittleEndian.Uint16(response[2:]), nil
+}
+
+// sessionSetup authenticates with NTLMv2 and derives the signing key.
+func (c *smbConn) sessionSetup(username, domain, password string) error {
+	setup := func(token []byte) ([]byte, []byte, error) {
+		body := binary.LittleEndian.AppendUint16(nil, 25)
+		body = append(body, 0, 1)                   // Flags and signing enabled.
+		body = append(body, 0, 0, 0, 0, 0, 0, 0, 0) // Capabilities and channel.
+		body = binary.LittleEndian.AppendUint16(body, smbHeaderSize+24)
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(token)))
+		body = append(body, make([]byte, 8)...) // Previous session ID.
+		body = append(body, token...)
+		header, response, err := c.request(smbSessionSetup, body, smbStatusMoreProcessingRequired)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(response) < 8 {
+			return nil, nil, errors.New("truncated session setup response")
+		}
+		offset, length := int(binary.LittleEndian.Uint16(response[4:])), int(binary.LittleEndian.Uint16(response[6:]))
+		if length > 0 && (offset < smbHeaderSize || offset-smbHeaderSize+length > len(response)) {
+			return nil, nil, errors.New("invalid security buffer")
+		}
+		c.sessionID = binary.LittleEndian.Uint64(header[40:])
+		if length == 0 {
+			return header, response, nil
+		}
+		return header, response[offset-smbHeaderSize : offset-smbHeaderSize+length], nil
+	}
+
+	_, token, err := setup(spnegoInit(ntlmNegotiateMessage()))
+	if err != nil {
+		return err
+	}
+	// The challenge is found inside the SPNEGO NegTokenResp.
+	start := bytes.Index(token, []byte("NTLMSSP\x00\x02\x00\x00\x00"))
+	if start < 0 {
+		return errors.New("no NTLM challenge received")
+	}
+	authenticate, sessionKey, err := ntlmAuthenticateMessage(token[start:], username, domain, password)
+	if err != nil {
+		return err
+	}
+	_, response, err := setup(spnegoResponse(authenticate))
+	if err != nil {
+		return err
+	}
+	if flags := binary.LittleEndian.Uint16(response[2:]); flags&(smbSessionFlagIsGuest|smbSessionFlagIsNull) != 0 {
+		return errors.New("server authenticated the probe as guest")
+	}
+	switch {
+	case c.dialect == 0x0311:
+		c.signingKey = smbKDF(sessionKey, []byte("SMBSigningKey\x00"), c.preauthHash)
+	case c.dialect >= 0x0300:
+		c.signingKey = smbKDF(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+	default:
+		c.signingKey = sessionKey
+	}
+	return nil
+}
+
+// treeConnect connects to a share, returning its share flags.
+func (c *smbConn) treeConnect(path string) (uint32, error) {
+	name := smbUTF16(path)
+	body := binary.LittleEndian.AppendUint16(nil, 9)
+	body = append(body, 0, 0)
+	body = binary.LittleEndian.AppendUint16(body, smbHeaderSize+8)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(name)))
+	body = append(body, name...)
+	header, response, err := c.request(smbTreeConnect, body)
+	if err != nil {
+		return 0, err
+	}
+	if len(response) < 16 {
+		return 0, errors.New("truncated tree connect response")
+	}
+	c.treeID = binary.LittleEndian.Uint32(header[36:])
+	return binary.LittleEndian.Uint32(response[4:]), nil
+}
+
+// listRoot counts the entries in the root directory of the connected share.
+func (c *smbConn) listRoot() (int, error) {
+	body := binary.LittleEndian.AppendUint16(nil, 57)
+	body = append(body, 0, 0)                                       // Security flags and oplock level.
+	body = binary.LittleEndian.AppendUint32(body, 2)                // Impersonation.
+	body = append(body, make([]byte, 16)...)                        // Create flags and reserved.
+	body = binary.LittleEndian.AppendUint32(body, 0x00100081)       // List directory, read attributes and synchronize.
+	body = binary.LittleEndian.AppendUint32(body, 0)                // File attributes.
+	body = binary.LittleEndian.AppendUint32(body, 7)                // Share read, write and delete.
+	body = binary.LittleEndian.AppendUint32(body, 1)                // Open existing file.
+	body = binary.LittleEndian.AppendUint32(body, 1)                // Directory.
+	body = binary.LittleEndian.AppendUint16(body, smbHeaderSize+56) // Name offset.
+	body = append(body, make([]byte, 10)...)                        // Empty name and no create contexts.
+	body = append(body, 0)
+	_, response, err := c.request(smbCreate, body)
+	if err != nil {
+		return 0, err
+	}
+	if len(response) < 80 {
+		return 0, errors.New("truncated create response")
+	}
+	fileID := response[64:80]
+	defer func() {
+		body := binary.LittleEndian.AppendUint16(nil, 24)
+		body = append(body, make([]byte, 6)...)
+		c.request(smbClose, append(body, fileID...))
+	}()
+
+	entries := 0
+	pattern := smbUTF16("*")
+	for flags := byte(0x01); ; flags = 0 {
+		body := binary.LittleEndian.AppendUint16(nil, 33)
+		body = append(body, 0x0c, flags, 0, 0, 0, 0) // File names information.
+		body = append(body, fileID...)
+		body = binary.LittleEndian.AppendUint16(body, smbHeaderSize+32)
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(pattern)))
+		body = binary.LittleEndian.AppendUint32(body, 65536)
+		body = append(body, pattern...)
+		header, response, err := c.request(smbQueryDirectory, body, smbStatusNoMoreFiles)
+		if err != nil {
+			return 0, err
+		}
+		if binary.LittleEndian.Uint32(header[8:]) == smbStatusNoMoreFiles {
+			return entries, nil
+		}
+		if len(response) < 8 {
+			return 0, errors.New("truncated query directory response")
+		}
+		offset, length := int(binary.LittleEndian.Uint16(response[2:])), int(binary.LittleEndian.Uint32(response[4:]))
+		if offset < smbHeaderSize || offset-smbHeaderSize+length > len(response) {
+			return 0, errors.New("invalid query directory output buffer")
+		}
+		for b := response[offset-smbHeaderSize : offset-smbHeaderSize+length]; len(b) >= 12; {
+			next, nameLength := binary.LittleEndian.Uint32(b), int(binary.LittleEndian.Uint32(b[8:]))
+			if len(b) < 12+nameLength {
+				return 0, errors.New("truncated directory entry")
+			}
+			if name := string(b[12 : 12+nameLength]); name != ".\x00" && name != ".\x00.\x00" {
+				entries++
+			}
+			if next == 0 || int(next) > len(b) {
+				break
+			}
+			b = b[next:]
+		}
+	}
+}
+
+// ProbeSMB negotiates an SMB2 or SMB3 dialect and, if credentials are
+// configured, authenticates and lists the root directory of a share.
+func ProbeSMB(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeSMBDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_smb_duration_seconds",
+		Help: "Duration of each phase of the SMB session",
+	}, []string{"phase"})
+	probeSMBDialect := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_smb_dialect_info",
+		Help: "Contains the SMB dialect chosen by the server",
+	}, []string{"dialect"})
+	probeSMBSigningRequired := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_smb_signing_required",
+		Help: "Whether the server requires signed messages",
+	})
+	probeSMBStatus := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_smb_status_code",
+		Help: "NT status code of the failed SMB command",
+	})
+	probeSMBShareEntries := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_smb_share_entries",
+		Help: "Number of entries in the root directory of the share",
+	})
+	registry.MustRegister(probeSMBDuration)
+
+	// step runs and times one phase of the session.
+	step := newStepTimer(probeSMBDuration, "phase", "SMB phase", logger, func(err error) {
+		var smbErr *smbError
+		if errors.As(err, &smbErr) {
+			registry.MustRegister(probeSMBStatus)
+			probeSMBStatus.Set(float64(smbErr.status))
+		}
+	})
+
+	var (
+		conn net.Conn
+		host string
+		err  error
+	)
+	if !step("connect", func() error {
+		conn, host, err = dialTCPTarget(ctx, target, module.SMB.IPProtocol, module.SMB.IPProtocolFallback, module.SMB.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+	c := &smbConn{conn: conn}
+
+	if !step("negotiate", func() error {
+		dialect, securityMode, err := c.negotiate()
+		if err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "Negotiated dialect", "dialect", smbDialects[dialect])
+		registry.MustRegister(probeSMBDialect, probeSMBSigningRequired)
+		probeSMBDialect.WithLabelValues(smbDialects[dialect]).Set(1)
+		if securityMode&smbSigningRequired != 0 {
+			probeSMBSigningRequired.Set(1)
+		}
+		return nil
+	}) {
+		return false
+	}
+
+	if module.SMB.Username == "" {
+		return true
+	}
+	if !step("session_setup", func() error {
+		return c.sessionSetup(module.SMB.Username, module.SMB.Domain, string(module.SMB.Password))
+	}) {
+		return false
+	}
+
+	if module.SMB.Share == "" {
+		return true
+	}
+	if !step("tree_connect", func() error {
+		flags, err := c.treeConnect(`\\` + host + `\` + module.SMB.Share)
+		if err != nil {
+			return err
+		}
+		if flags&smbShareFlagEncryptData != 0 {
+			return errors.New("share requires encryption, which is not supported")
+		}
+		return nil
+	}) {
+		return false
+	}
+	return step("list", func() error {
+		entries, err := c.listRoot()
+		if err != nil {
+			return err
+		}
+		registry.MustRegister(probeSMBShareEntries)
+		probeSMBShareEntries.Set(float64(entries))
+		return nil
+	})
+}
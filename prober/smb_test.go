@@ -0,0 +1,255 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestNTLMV2Response(t *testing.T) {
+	// The example of MS-NLMP 4.2.4.
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge := bytes.Repeat([]byte{0xaa}, 8)
+	targetInfo := append(append([]byte{2, 0, 12, 0}, smbUTF16("Domain")...), append([]byte{1, 0, 12, 0}, smbUTF16("Server")...)...)
+	targetInfo = append(targetInfo, 0, 0, 0, 0)
+	response, sessionKey := ntlmV2Response("User", "Domain", "Password", serverChallenge, clientChallenge, make([]byte, 8), targetInfo)
+	if proof := hex.EncodeToString(response[:16]); proof != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Errorf("Unexpected NTProofStr %s", proof)
+	}
+	if key := hex.EncodeToString(sessionKey); key != "8de40ccadbc14a82f15cb0ad0de95ca3" {
+		t.Errorf("Unexpected session base key %s", key)
+	}
+}
+
+func TestAESCMAC(t *testing.T) {
+	// The examples of RFC 4493.
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	for message, expected := range map[string]string{
+		"":                                 "bb1d6929e95937287fa37d129b756746",
+		"6bc1bee22e409f96e93d7e117393172a": "070a16b46b4d4144f79bdd9dd04a287c",
+		"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411": "dfa66747de9ae63030ca32611497c827",
+	} {
+		m, _ := hex.DecodeString(message)
+		if mac := hex.EncodeToString(aesCMAC(key, m)); mac != expected {
+			t.Errorf("Unexpected CMAC %s of %q, expected %s", mac, message, expected)
+		}
+	}
+}
+
+// serveSMB answers the commands of the SMB probe with dialect 2.1, checking
+// the NTLMv2 response and the signatures of signed requests.
+func serveSMB(t *testing.T, conn net.Conn, password string) {
+	defer conn.Close()
+	serverChallenge := []byte("chalenge")
+	var sessionKey []byte
+	for {
+		var frameHeader [4]byte
+		if _, err := io.ReadFull(conn, frameHeader[:]); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(frameHeader[:]))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		command, body := binary.LittleEndian.Uint16(request[12:]), request[smbHeaderSize:]
+		if binary.LittleEndian.Uint32(request[16:])&smbFlagsSigned != 0 {
+			signature := append([]byte(nil), request[48:64]...)
+			copy(request[48:64], make([]byte, 16))
+			mac := hmac.New(sha256.New, sessionKey)
+			mac.Write(request)
+			if !bytes.Equal(mac.Sum(nil)[:16], signature) {
+				t.Errorf("Invalid signature of command %d", command)
+				return
+			}
+		} else if command > smbSessionSetup {
+			t.Errorf("Command %d is not signed", command)
+			return
+		}
+
+		status := uint32(smbStatusSuccess)
+		var response []byte
+		switch command {
+		case smbNegotiate:
+			response = binary.LittleEndian.AppendUint16(nil, 65)
+			response = binary.LittleEndian.AppendUint16(response, 3) // Signing enabled and required.
+			response = binary.LittleEndian.AppendUint16(response, 0x0210)
+			response = append(response, make([]byte, 58)...)
+		case smbSessionSetup:
+			offset, length := binary.LittleEndian.Uint16(body[12:]), binary.LittleEndian.Uint16(body[14:])
+			token := request[offset : offset+length]
+			var out []byte
+			if start := bytes.Index(token, []byte("NTLMSSP\x00\x01")); start >= 0 {
+				status = smbStatusMoreProcessingRequired
+				targetInfo := append([]byte{2, 0, 8, 0}, smbUTF16("TEST")...)
+				targetInfo = append(targetInfo, 0, 0, 0, 0)
+				out = append([]byte("NTLMSSP\x00\x02\x00\x00\x00"), make([]byte, 8)...)
+				out = binary.LittleEndian.AppendUint32(out, ntlmNegotiateFlags)
+				out = append(out, serverChallenge...)
+				out = append(out, make([]byte, 8)...)
+				out = binary.LittleEndian.AppendUint16(out, uint16(len(targetInfo)))
+				out = binary.LittleEndian.AppendUint16(out, uint16(len(targetInfo)))
+				out = binary.LittleEndian.AppendUint32(out, 48)
+				out = append(out, targetInfo...)
+				out = spnegoResponse(out)
+			} else if start := bytes.Index(token, []byte("NTLMSSP\x00\x03")); start >= 0 {
+				authenticate := token[start:]
+				field := func(i int) []byte {
+					length, offset := binary.LittleEndian.Uint16(authenticate[12+8*i:]), binary.LittleEndian.Uint32(authenticate[16+8*i:])
+					return authenticate[offset : offset+uint32(length)]
+				}
+				ntResponse := field(1)
+				blob := ntResponse[16:]
+				expected, key := ntlmV2Response("prober", "TEST", password, serverChallenge, blob[16:24], blob[8:16], blob[28:len(blob)-4])
+				if !bytes.Equal(field(3), smbUTF16("prober")) || !bytes.Equal(expected, ntResponse) {
+					status = 0xc000006d // Logon failure.
+				}
+				sessionKey = key
+			} else {
+				t.Errorf("Unexpected security token %x", token)
+				return
+			}
+			response = binary.LittleEndian.AppendUint16(nil, 9)
+			response = append(response, 0, 0)
+			response = binary.LittleEndian.AppendUint16(response, smbHeaderSize+8)
+			response = binary.LittleEndian.AppendUint16(response, uint16(len(out)))
+			response = append(response, out...)
+		case smbTreeConnect:
+			offset, length := binary.LittleEndian.Uint16(body[4:]), binary.LittleEndian.Uint16(body[6:])
+			if path := request[offset : offset+length]; !bytes.Equal(path, smbUTF16(`\\127.0.0.1\public`)) {
+				status = 0xc00000cc // Bad network name.
+			}
+			response = append(binary.LittleEndian.AppendUint16(nil, 16), make([]byte, 14)...)
+		case smbCreate:
+			response = append(binary.LittleEndian.AppendUint16(nil, 89), make([]byte, 87)...)
+		case smbQueryDirectory:
+			if body[3]&0x01 == 0 {
+				status = smbStatusNoMoreFiles
+				response = append(binary.LittleEndian.AppendUint16(nil, 9), make([]byte, 7)...)
+				break
+			}
+			var entries []byte
+			for i, name := range []string{".", "..", "docs", "readme.txt"} {
+				entry := make([]byte, 12)
+				if i < 3 {
+					binary.LittleEndian.PutUint32(entry, uint32(12+2*len(name)))
+				}
+				binary.LittleEndian.PutUint32(entry[8:], uint32(2*len(name)))
+				entries = append(entries, append(entry, smbUTF16(name)...)...)
+			}
+			response = binary.LittleEndian.AppendUint16(nil, 9)
+			response = binary.LittleEndian.AppendUint16(response, smbHeaderSize+8)
+			response = binary.LittleEndian.AppendUint32(response, uint32(len(entries)))
+			response = append(response, entries...)
+		case smbClose:
+			response = append(binary.LittleEndian.AppendUint16(nil, 60), make([]byte, 58)...)
+		default:
+			t.Errorf("Unexpected command %d", command)
+			return
+		}
+
+		header := append([]byte(nil), request[:smbHeaderSize]...)
+		binary.LittleEndian.PutUint32(header[8:], status)
+		binary.LittleEndian.PutUint32(header[16:], smbFlagsResponse)
+		binary.LittleEndian.PutUint64(header[40:], 1)
+		binary.LittleEndian.PutUint32(header[36:], 1)
+		copy(header[48:64], make([]byte, 16))
+		message := append(header, response...)
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(message))), message...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestSMBProbe(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMB(t, conn, "secret")
+		}
+	}()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name    string
+		module  config.SMBProbe
+		success bool
+		phases  map[string]struct{}
+		results map[string]float64
+	}{
+		{
+			name:    "negotiate",
+			success: true,
+			phases:  map[string]struct{}{"connect": {}, "negotiate": {}},
+			results: map[string]float64{"probe_smb_signing_required": 1},
+		},
+		{
+			name:    "list share",
+			module:  config.SMBProbe{Username: "prober", Password: "secret", Domain: "TEST", Share: "public"},
+			success: true,
+			phases:  map[string]struct{}{"connect": {}, "negotiate": {}, "session_setup": {}, "tree_connect": {}, "list": {}},
+			results: map[string]float64{"probe_smb_share_entries": 2},
+		},
+		{
+			name:    "missing share",
+			module:  config.SMBProbe{Username: "prober", Password: "secret", Domain: "TEST", Share: "private"},
+			phases:  map[string]struct{}{"connect": {}, "negotiate": {}, "session_setup": {}, "tree_connect": {}},
+			results: map[string]float64{"probe_smb_status_code": 0xc00000cc},
+		},
+		{
+			name:    "wrong password",
+			module:  config.SMBProbe{Username: "prober", Password: "wrong", Domain: "TEST"},
+			phases:  map[string]struct{}{"connect": {}, "negotiate": {}, "session_setup": {}},
+			results: map[string]float64{"probe_smb_status_code": 0xc000006d},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeSMB(testCTX, ln.Addr().String(), config.Module{SMB: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_smb_duration_seconds": {"phase": tc.phases}}, mfs, t)
+			checkRegistryResults(tc.results, mfs, t)
+			checkRegistryLabels(map[string]map[string]string{"probe_smb_dialect_info": {"dialect": "2.1"}}, mfs, t)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// ProbeSMTP runs an SMTP transaction up to RCPT TO, and DATA if a message is
+// configured, timing each command.
+func ProbeSMTP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeSMTPCommandDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_smtp_command_duration_seconds",
+		Help: "Duration of each step of the SMTP transaction",
+	}, []string{"command"})
+	probeSMTPReplyCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_smtp_reply_code",
+		Help: "Reply code of the SMTP command which failed",
+	})
+	registry.MustRegister(probeSMTPCommandDuration)
+
+	// step runs and times one step of the transaction.
+	step := newStepTimer(probeSMTPCommandDuration, "command", "SMTP command", logger, func(err error) {
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			registry.MustRegister(probeSMTPReplyCode)
+			probeSMTPReplyCode.Set(float64(protoErr.Code))
+		}
+	})
+
+	tlsConfig, err := newTargetTLSConfig(&module.SMTP.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+
+	var (
+		conn net.Conn
+		host string
+	)
+	if !step("connect", func() error {
+		conn, host, err = dialTCPTarget(ctx, target, module.SMTP.IPProtocol, module.SMTP.IPProtocolFallback, module.SMTP.SourceIPAddress, registry, logger)
+		return err
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if module.SMTP.TLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+			return false
+		}
+		conn = tlsConn
+	}
+
+	var client *smtp.Client
+	if !step("greeting", func() error {
+		client, err = smtp.NewClient(conn, host)
+		return err
+	}) {
+		return false
+	}
+	// EHLO is sent explicitly, as the client otherwise defers it to the
+	// first command needing it and returns its error from that command.
+	if !step("ehlo", func() error { return client.Hello(module.SMTP.EHLODomain) }) {
+		return false
+	}
+	startTLS, _ := client.Extension("STARTTLS")
+
+	if module.SMTP.StartTLS {
+		if !startTLS {
+			level.Error(logger).Log("msg", "Server does not support STARTTLS")
+			return false
+		}
+		if !step("starttls", func() error { return client.StartTLS(tlsConfig) }) {
+			return false
+		}
+	}
+	if state, ok := client.TLSConnectionState(); ok {
+		reportTLSConnectionState(&state, registry)
+	}
+
+	if module.SMTP.AuthUsername != "" {
+		// PLAIN authentication is refused on unencrypted connections to
+		// hosts other than localhost.
+		auth := smtp.PlainAuth("", module.SMTP.AuthUsername, string(module.SMTP.AuthPassword), host)
+		if !step("auth", func() error { return client.Auth(auth) }) {
+			return false
+		}
+	}
+
+	if module.SMTP.MailFrom != "" {
+		if !step("mail", func() error { return client.Mail(module.SMTP.MailFrom) }) {
+			return false
+		}
+		if !step("rcpt", func() error {
+			for _, rcpt := range module.SMTP.RcptTo {
+				if err := client.Rcpt(rcpt); err != nil {
+					return fmt.Errorf("recipient %s: %w", rcpt, err)
+				}
+			}
+			return nil
+		}) {
+			return false
+		}
+	}
+
+	if module.SMTP.Data != "" {
+		if !step("data", func() error {
+			w, err := client.Data()
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(module.SMTP.Data)); err != nil {
+				return err
+			}
+			return w.Close()
+		}) {
+			return false
+		}
+	}
+
+	return step("quit", client.Quit)
+}
@@ -0,0 +1,217 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveSMTP runs a minimal SMTP server rejecting recipients at
+// example.org, which upgrades connections to TLS with tlsConfig.
+func serveSMTP(t *testing.T, ln net.Listener, tlsConfig *tls.Config) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			fmt.Fprintf(conn, "220 localhost ESMTP\r\n")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\r\n")
+				switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+				case "EHLO", "HELO":
+					if strings.Contains(line, "rejected.example.com") {
+						fmt.Fprintf(conn, "550 Access denied\r\n")
+					} else {
+						fmt.Fprintf(conn, "250-localhost\r\n250-STARTTLS\r\n250 AUTH PLAIN\r\n")
+					}
+				case "STARTTLS":
+					fmt.Fprintf(conn, "220 Ready to start TLS\r\n")
+					tlsConn := tls.Server(conn, tlsConfig)
+					if err := tlsConn.Handshake(); err != nil {
+						return
+					}
+					conn, r = tlsConn, bufio.NewReader(tlsConn)
+				case "AUTH":
+					fmt.Fprintf(conn, "235 Authentication successful\r\n")
+				case "MAIL":
+					fmt.Fprintf(conn, "250 OK\r\n")
+				case "RCPT":
+					if strings.Contains(line, "@example.org") {
+						fmt.Fprintf(conn, "550 No such user\r\n")
+					} else {
+						fmt.Fprintf(conn, "250 OK\r\n")
+					}
+				case "DATA":
+					fmt.Fprintf(conn, "354 Go ahead\r\n")
+					for {
+						line, err := r.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if line == ".\r\n" {
+							break
+						}
+					}
+					fmt.Fprintf(conn, "250 Queued\r\n")
+				case "QUIT":
+					fmt.Fprintf(conn, "221 Bye\r\n")
+					return
+				default:
+					t.Errorf("Unexpected SMTP command %q", line)
+					fmt.Fprintf(conn, "502 Not implemented\r\n")
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestSMTPTransaction(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go serveSMTP(t, ln, nil)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	module := config.Module{SMTP: config.SMTPProbe{
+		IPProtocol: "ip4",
+		EHLODomain: "prober.example.com",
+		MailFrom:   "prober@example.com",
+		RcptTo:     []string{"postmaster@example.com"},
+	}}
+	registry := prometheus.NewRegistry()
+	if !ProbeSMTP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("SMTP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_smtp_command_duration_seconds": {
+			"command": {
+				"connect":  {},
+				"greeting": {},
+				"ehlo":     {},
+				"mail":     {},
+				"rcpt":     {},
+				"quit":     {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+
+	// The recipient is rejected.
+	module.SMTP.RcptTo = []string{"postmaster@example.org"}
+	registry = prometheus.NewRegistry()
+	if ProbeSMTP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("SMTP module succeeded, expected failure.")
+	}
+	mfs, err = registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_smtp_reply_code": 550}, mfs, t)
+
+	// The EHLO is rejected.
+	module.SMTP.EHLODomain = "rejected.example.com"
+	registry = prometheus.NewRegistry()
+	if ProbeSMTP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("SMTP module succeeded, expected failure.")
+	}
+	mfs, err = registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_smtp_reply_code": 550}, mfs, t)
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			if command := labelValue(m, "command"); mf.GetName() == "probe_smtp_command_duration_seconds" && command != "connect" && command != "greeting" && command != "ehlo" {
+				t.Errorf("Unexpected command %q after the rejected EHLO", command)
+			}
+		}
+	}
+}
+
+func TestSMTPStartTLS(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+	go serveSMTP(t, ln, tlsConfig)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	module := config.Module{SMTP: config.SMTPProbe{
+		IPProtocol:   "ip4",
+		StartTLS:     true,
+		TLSConfig:    pconfig.TLSConfig{CAFile: caFile},
+		EHLODomain:   "localhost",
+		AuthUsername: "prober",
+		AuthPassword: "secret",
+		MailFrom:     "prober@example.com",
+		RcptTo:       []string{"postmaster@example.com"},
+		Data:         "Subject: probe\r\n\r\nHello.\r\n",
+	}}
+	registry := prometheus.NewRegistry()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	if !ProbeSMTP(testCTX, net.JoinHostPort("localhost", port), module, registry, log.NewNopLogger()) {
+		t.Fatalf("SMTP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_smtp_command_duration_seconds": {
+			"command": {
+				"connect":  {},
+				"greeting": {},
+				"ehlo":     {},
+				"starttls": {},
+				"auth":     {},
+				"mail":     {},
+				"rcpt":     {},
+				"data":     {},
+				"quit":     {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	checkRegistryResults(map[string]float64{"probe_tls_version_info": 1}, mfs, t)
+}
@@ -0,0 +1,434 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	snmpVersion2c = 1
+	snmpVersion3  = 3
+
+	// PDU types, encoded as context-specific tags.
+	snmpGetRequest = 0
+	snmpResponse   = 2
+	snmpReport     = 8
+
+	// snmpUSM is the user-based security model of RFC 3414.
+	snmpUSM = 3
+
+	snmpFlagAuth       = 0x01
+	snmpFlagReportable = 0x04
+
+	snmpMaxMessageSize = 65507
+
+	// snmpDigestSize is the size of the truncated HMAC of HMAC-MD5-96 and
+	// HMAC-SHA-96.
+	snmpDigestSize = 12
+)
+
+// snmpUSMStatsOID prefixes the counters of the user-based security model,
+// which agents return in reports.
+var snmpUSMStatsOID = asn1.ObjectIdentifier{1, 3, 6, 1, 6, 3, 15, 1, 1}
+
+var snmpUSMStats = map[int]string{
+	1: "unsupportedSecLevels",
+	2: "notInTimeWindows",
+	3: "unknownUserNames",
+	4: "unknownEngineIDs",
+	5: "wrongDigests",
+	6: "decryptionErrors",
+}
+
+type snmpVarBind struct {
+	Name  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type snmpPDU struct {
+	RequestID   int32
+	ErrorStatus int
+	ErrorIndex  int
+	VarBinds    []snmpVarBind
+}
+
+type snmpCommunityMessage struct {
+	Version   int
+	Community []byte
+	PDU       asn1.RawValue
+}
+
+type snmpGlobalData struct {
+	MessageID     int32
+	MaxSize       int32
+	Flags         []byte
+	SecurityModel int
+}
+
+type snmpUSMParameters struct {
+	EngineID       []byte
+	EngineBoots    int32
+	EngineTime     int32
+	UserName       []byte
+	AuthParameters []byte
+	PrivParameters []byte
+}
+
+type snmpScopedPDU struct {
+	ContextEngineID []byte
+	ContextName     []byte
+	PDU             asn1.RawValue
+}
+
+type snmpV3Message struct {
+	Version            int
+	GlobalData         snmpGlobalData
+	SecurityParameters []byte
+	ScopedPDU          snmpScopedPDU
+}
+
+// parseSNMPOID parses a numeric object identifier like "1.3.6.1.2.1.1.3.0".
+func parseSNMPOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, component := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.ParseUint(component, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object identifier %q", s)
+		}
+		oid = append(oid, int(n))
+	}
+	return oid, nil
+}
+
+// parseSNMPPDU decodes a PDU, returning its type.
+func parseSNMPPDU(raw asn1.RawValue) (int, snmpPDU, error) {
+	var pdu snmpPDU
+	if raw.Class != asn1.ClassContextSpecific {
+		return 0, pdu, fmt.Errorf("unexpected PDU class %d", raw.Class)
+	}
+	if _, err := asn1.UnmarshalWithParams(raw.FullBytes, &pdu, fmt.Sprintf("tag:%d", raw.Tag)); err != nil {
+		return 0, pdu, err
+	}
+	return raw.Tag, pdu, nil
+}
+
+// snmpLocalizedKey derives the key of the password localized to the engine as
+// described in RFC 3414, appendix A.2.
+func snmpLocalizedKey(h func() hash.Hash, password string, engineID []byte) []byte {
+	digest := h()
+	chunk := make([]byte, 64)
+	for i := 0; i < 1<<20; i += len(chunk) {
+		for j := range chunk {
+			chunk[j] = password[(i+j)%len(password)]
+		}
+		digest.Write(chunk)
+	}
+	key := digest.Sum(nil)
+	digest.Reset()
+	digest.Write(key)
+	digest.Write(engineID)
+	digest.Write(key)
+	return digest.Sum(nil)
+}
+
+// snmpValue converts the value of a variable binding to a number.
+func snmpValue(value asn1.RawValue) (float64, error) {
+	switch {
+	case value.Class == asn1.ClassContextSpecific && value.Tag <= 2:
+		return 0, errors.New([]string{"noSuchObject", "noSuchInstance", "endOfMibView"}[value.Tag])
+	case len(value.Bytes) > 9:
+		return 0, fmt.Errorf("value of %d bytes is too large", len(value.Bytes))
+	case value.Class == asn1.ClassUniversal && value.Tag == asn1.TagInteger:
+		var n int64
+		for i, b := range value.Bytes {
+			if i == 0 && b&0x80 != 0 {
+				n = -1
+			}
+			n = n<<8 | int64(b)
+		}
+		return float64(n), nil
+	case value.Class == asn1.ClassApplication && (value.Tag == 1 || value.Tag == 2 || value.Tag == 3 || value.Tag == 6):
+		// Counter32, Gauge32, TimeTicks and Counter64 are unsigned.
+		var n uint64
+		for _, b := range value.Bytes {
+			n = n<<8 | uint64(b)
+		}
+		return float64(n), nil
+	case value.Class == asn1.ClassUniversal && value.Tag == asn1.TagOctetString:
+		return strconv.ParseFloat(strings.TrimSpace(string(value.Bytes)), 64)
+	}
+	return 0, fmt.Errorf("value of class %d and tag %d is not numeric", value.Class, value.Tag)
+}
+
+// snmpReportName returns the name of the counter reported by the agent.
+func snmpReportName(pdu snmpPDU) string {
+	if len(pdu.VarBinds) == 0 {
+		return "empty report"
+	}
+	name := pdu.VarBinds[0].Name
+	if len(name) == len(snmpUSMStatsOID)+2 && name[:len(snmpUSMStatsOID)].Equal(snmpUSMStatsOID) {
+		if stat, ok := snmpUSMStats[name[len(snmpUSMStatsOID)]]; ok {
+			return stat
+		}
+	}
+	return name.String()
+}
+
+// snmpSession exchanges messages with an agent. Version 3 sessions use the
+// user-based security model without privacy.
+type snmpSession struct {
+	conn      net.Conn
+	version   string
+	community []byte
+	username  []byte
+	authHash  func() hash.Hash
+	authKey   []byte
+	requestID int32
+
+	// The parameters of the authoritative engine of the agent.
+	engineID    []byte
+	engineBoots int32
+	engineTime  int32
+}
+
+// encode encodes a message with the PDU. In version 3, the message is
+// authenticated if authenticate is set and an authentication key is known.
+func (s *snmpSession) encode(pduType int, pdu snmpPDU, authenticate bool) ([]byte, error) {
+	pduBytes, err := asn1.MarshalWithParams(pdu, fmt.Sprintf("tag:%d", pduType))
+	if err != nil {
+		return nil, err
+	}
+	if s.version == "2c" {
+		return asn1.Marshal(snmpCommunityMessage{Version: snmpVersion2c, Community: s.community, PDU: asn1.RawValue{FullBytes: pduBytes}})
+	}
+
+	flags := byte(snmpFlagReportable)
+	usm := snmpUSMParameters{EngineID: s.engineID, EngineBoots: s.engineBoots, EngineTime: s.engineTime}
+	if authenticate {
+		usm.UserName = s.username
+		if s.authKey != nil {
+			flags |= snmpFlagAuth
+			usm.AuthParameters = make([]byte, snmpDigestSize)
+		}
+	}
+	securityParameters, err := asn1.Marshal(usm)
+	if err != nil {
+		return nil, err
+	}
+	message, err := asn1.Marshal(snmpV3Message{
+		Version:            snmpVersion3,
+		GlobalData:         snmpGlobalData{MessageID: pdu.RequestID, MaxSize: snmpMaxMessageSize, Flags: []byte{flags}, SecurityModel: snmpUSM},
+		SecurityParameters: securityParameters,
+		ScopedPDU:          snmpScopedPDU{ContextEngineID: s.engineID, PDU: asn1.RawValue{FullBytes: pduBytes}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if flags&snmpFlagAuth != 0 {
+		// The digest is computed over the message with zeroed authentication
+		// parameters, which precede the empty privacy parameters.
+		offset := bytes.Index(message, securityParameters) + len(securityParameters) - snmpDigestSize - 2
+		mac := hmac.New(s.authHash, s.authKey)
+		mac.Write(message)
+		copy(message[offset:], mac.Sum(nil)[:snmpDigestSize])
+	}
+	return message, nil
+}
+
+// decode decodes a message, returning the ID of the request it answers. In
+// version 3, the engine parameters of the agent are updated from the message.
+func (s *snmpSession) decode(message []byte) (int32, int, snmpPDU, error) {
+	if s.version == "2c" {
+		var m snmpCommunityMessage
+		if _, err := asn1.Unmarshal(message, &m); err != nil {
+			return 0, 0, snmpPDU{}, err
+		}
+		pduType, pdu, err := parseSNMPPDU(m.PDU)
+		return pdu.RequestID, pduType, pdu, err
+	}
+
+	var m snmpV3Message
+	if _, err := asn1.Unmarshal(message, &m); err != nil {
+		return 0, 0, snmpPDU{}, err
+	}
+	var usm snmpUSMParameters
+	if _, err := asn1.Unmarshal(m.SecurityParameters, &usm); err != nil {
+		return 0, 0, snmpPDU{}, err
+	}
+	s.engineID, s.engineBoots, s.engineTime = usm.EngineID, usm.EngineBoots, usm.EngineTime
+	pduType, pdu, err := parseSNMPPDU(m.ScopedPDU.PDU)
+	return m.GlobalData.MessageID, pduType, pdu, err
+}
+
+// exchange sends a GetRequest and waits for the message answering it.
+func (s *snmpSession) exchange(pdu snmpPDU, authenticate bool) (int, snmpPDU, error) {
+	s.requestID++
+	pdu.RequestID = s.requestID
+	request, err := s.encode(snmpGetRequest, pdu, authenticate)
+	if err != nil {
+		return 0, snmpPDU{}, err
+	}
+	if _, err := s.conn.Write(request); err != nil {
+		return 0, snmpPDU{}, err
+	}
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			return 0, snmpPDU{}, err
+		}
+		id, pduType, response, err := s.decode(buf[:n])
+		if err != nil {
+			return 0, snmpPDU{}, fmt.Errorf("error decoding message: %w", err)
+		}
+		if id == pdu.RequestID {
+			return pduType, response, nil
+		}
+	}
+}
+
+// discover learns the engine parameters of the agent from the report to an
+// unauthenticated request.
+func (s *snmpSession) discover() error {
+	pduType, _, err := s.exchange(snmpPDU{}, false)
+	if err != nil {
+		return err
+	}
+	if pduType != snmpReport || len(s.engineID) == 0 {
+		return errors.New("agent did not report its engine ID")
+	}
+	return nil
+}
+
+// get requests the value of the object.
+func (s *snmpSession) get(oid asn1.ObjectIdentifier) (snmpPDU, error) {
+	request := snmpPDU{VarBinds: []snmpVarBind{{Name: oid, Value: asn1.NullRawValue}}}
+	for retried := false; ; retried = true {
+		pduType, response, err := s.exchange(request, true)
+		if err != nil {
+			return snmpPDU{}, err
+		}
+		switch pduType {
+		case snmpResponse:
+			return response, nil
+		case snmpReport:
+			report := snmpReportName(response)
+			// The report carries the current engine time, so the request
+			// is repeated once if the clocks were not synchronized.
+			if report == "notInTimeWindows" && !retried {
+				continue
+			}
+			return snmpPDU{}, fmt.Errorf("agent reported %s", report)
+		default:
+			return snmpPDU{}, fmt.Errorf("unexpected PDU type %d", pduType)
+		}
+	}
+}
+
+// ProbeSNMP gets the value of one object from an SNMP agent.
+func ProbeSNMP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeSNMPDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_snmp_duration_seconds",
+		Help: "Duration of each phase of the SNMP request",
+	}, []string{"phase"})
+	probeSNMPValue := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_snmp_value",
+		Help: "Numeric value of the requested object",
+	})
+	probeSNMPErrorStatus := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_snmp_error_status",
+		Help: "Error status of the response of the agent",
+	})
+	registry.MustRegister(probeSNMPDuration)
+
+	// step runs and times one phase of the request.
+	step := newStepTimer(probeSNMPDuration, "phase", "SNMP phase", logger, nil)
+
+	oid, err := parseSNMPOID(module.SNMP.OID)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error parsing OID", "err", err)
+		return false
+	}
+
+	conn, err := dialUDPTarget(ctx, target, module.SNMP.IPProtocol, module.SNMP.IPProtocolFallback, module.SNMP.SourceIPAddress, registry, logger)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	s := &snmpSession{
+		conn:      conn,
+		version:   module.SNMP.Version,
+		community: []byte(module.SNMP.Community),
+		username:  []byte(module.SNMP.Username),
+		requestID: rand.Int31n(1 << 30),
+	}
+	if s.version == "3" {
+		if !step("discovery", s.discover) {
+			return false
+		}
+		if module.SNMP.AuthPassword != "" {
+			s.authHash = sha1.New
+			if module.SNMP.AuthProtocol == "MD5" {
+				s.authHash = md5.New
+			}
+			s.authKey = snmpLocalizedKey(s.authHash, string(module.SNMP.AuthPassword), s.engineID)
+		}
+	}
+
+	var response snmpPDU
+	if !step("get", func() error {
+		response, err = s.get(oid)
+		return err
+	}) {
+		return false
+	}
+	if response.ErrorStatus != 0 {
+		registry.MustRegister(probeSNMPErrorStatus)
+		probeSNMPErrorStatus.Set(float64(response.ErrorStatus))
+		level.Error(logger).Log("msg", "Agent returned an error", "error_status", response.ErrorStatus, "error_index", response.ErrorIndex)
+		return false
+	}
+	if len(response.VarBinds) != 1 || !response.VarBinds[0].Name.Equal(oid) {
+		level.Error(logger).Log("msg", "Response does not contain the requested object", "oid", oid)
+		return false
+	}
+	value, err := snmpValue(response.VarBinds[0].Value)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error reading value", "oid", oid, "err", err)
+		return false
+	}
+	registry.MustRegister(probeSNMPValue)
+	probeSNMPValue.Set(value)
+	return true
+}
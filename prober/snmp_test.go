@@ -0,0 +1,215 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+var snmpTestEngineID = []byte("\x80\x00\x1f\x88\x04blackbox")
+
+// serveSNMP runs a minimal agent with the community "public" and the user
+// "prober", authenticated by HMAC-SHA-96 with the password "maplesyrup". The
+// only object it knows is 1.3.6.1.2.1.2.2.1.10.1, a Counter32 of 42.
+func serveSNMP(t *testing.T, conn net.PacketConn) {
+	key := snmpLocalizedKey(sha1.New, "maplesyrup", snmpTestEngineID)
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		message := buf[:n]
+		agent := &snmpSession{version: "2c", community: []byte("public"), engineID: snmpTestEngineID, engineBoots: 1, engineTime: 100}
+		var (
+			community snmpCommunityMessage
+			v3        snmpV3Message
+			raw       asn1.RawValue
+			id        int32
+			report    int
+		)
+		if _, err := asn1.Unmarshal(message, &community); err == nil {
+			if string(community.Community) != "public" {
+				continue
+			}
+			raw = community.PDU
+		} else if _, err := asn1.Unmarshal(message, &v3); err == nil {
+			agent.version = "3"
+			raw, id = v3.ScopedPDU.PDU, v3.GlobalData.MessageID
+			var usm snmpUSMParameters
+			if _, err := asn1.Unmarshal(v3.SecurityParameters, &usm); err != nil {
+				t.Errorf("Error decoding security parameters: %s", err)
+				return
+			}
+			switch {
+			case len(usm.EngineID) == 0:
+				report = 4
+			case string(usm.UserName) != "prober":
+				report = 3
+			case v3.GlobalData.Flags[0]&snmpFlagAuth == 0:
+				report = 1
+			default:
+				offset := bytes.Index(message, v3.SecurityParameters) + len(v3.SecurityParameters) - snmpDigestSize - 2
+				digest := append([]byte(nil), message[offset:offset+snmpDigestSize]...)
+				copy(message[offset:], make([]byte, snmpDigestSize))
+				mac := hmac.New(sha1.New, key)
+				mac.Write(message)
+				if !hmac.Equal(digest, mac.Sum(nil)[:snmpDigestSize]) {
+					report = 5
+				}
+			}
+		} else {
+			t.Errorf("Error decoding message: %s", err)
+			return
+		}
+		_, pdu, err := parseSNMPPDU(raw)
+		if err != nil {
+			t.Errorf("Error decoding PDU: %s", err)
+			return
+		}
+		if agent.version == "2c" {
+			id = pdu.RequestID
+		}
+
+		pduType, reply := snmpResponse, snmpPDU{RequestID: id}
+		switch {
+		case report != 0:
+			pduType = snmpReport
+			name := append(append(asn1.ObjectIdentifier{}, snmpUSMStatsOID...), report, 0)
+			reply.VarBinds = []snmpVarBind{{Name: name, Value: asn1.RawValue{Class: asn1.ClassApplication, Tag: 1, Bytes: []byte{1}}}}
+		case pdu.VarBinds[0].Name.String() == "1.3.6.1.2.1.2.2.1.10.1":
+			reply.VarBinds = []snmpVarBind{{Name: pdu.VarBinds[0].Name, Value: asn1.RawValue{Class: asn1.ClassApplication, Tag: 1, Bytes: []byte{42}}}}
+		default:
+			reply.VarBinds = []snmpVarBind{{Name: pdu.VarBinds[0].Name, Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1}}}
+		}
+		response, err := agent.encode(pduType, reply, false)
+		if err != nil {
+			t.Errorf("Error encoding response: %s", err)
+			return
+		}
+		conn.WriteTo(response, addr)
+	}
+}
+
+func TestSNMPGet(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer conn.Close()
+	go serveSNMP(t, conn)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name    string
+		module  config.SNMPProbe
+		success bool
+		phases  map[string]struct{}
+	}{
+		{
+			name:    "community",
+			module:  config.SNMPProbe{Version: "2c", Community: "public", OID: "1.3.6.1.2.1.2.2.1.10.1"},
+			success: true,
+			phases:  map[string]struct{}{"get": {}},
+		},
+		{
+			name:    "user",
+			module:  config.SNMPProbe{Version: "3", Username: "prober", AuthProtocol: "SHA", AuthPassword: "maplesyrup", OID: ".1.3.6.1.2.1.2.2.1.10.1"},
+			success: true,
+			phases:  map[string]struct{}{"discovery": {}, "get": {}},
+		},
+		{
+			name:   "wrong password",
+			module: config.SNMPProbe{Version: "3", Username: "prober", AuthProtocol: "SHA", AuthPassword: "pancakes", OID: "1.3.6.1.2.1.2.2.1.10.1"},
+			phases: map[string]struct{}{"discovery": {}, "get": {}},
+		},
+		{
+			name:   "unknown user",
+			module: config.SNMPProbe{Version: "3", Username: "nobody", AuthProtocol: "MD5", AuthPassword: "maplesyrup", OID: "1.3.6.1.2.1.2.2.1.10.1"},
+			phases: map[string]struct{}{"discovery": {}, "get": {}},
+		},
+		{
+			name:   "unknown object",
+			module: config.SNMPProbe{Version: "2c", Community: "public", OID: "1.3.6.1.2.1.1.3.0"},
+			phases: map[string]struct{}{"get": {}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeSNMP(testCTX, conn.LocalAddr().String(), config.Module{SNMP: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_snmp_duration_seconds": {"phase": tc.phases}}, mfs, t)
+			if tc.success {
+				checkRegistryResults(map[string]float64{"probe_snmp_value": 42}, mfs, t)
+			}
+		})
+	}
+}
+
+func TestSNMPLocalizedKey(t *testing.T) {
+	// Test vectors from RFC 3414, appendix A.3.
+	engineID := []byte("\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+	if key := hex.EncodeToString(snmpLocalizedKey(md5.New, "maplesyrup", engineID)); key != "526f5eed9fcce26f8964c2930787d82b" {
+		t.Errorf("Unexpected MD5 key %s", key)
+	}
+	if key := hex.EncodeToString(snmpLocalizedKey(sha1.New, "maplesyrup", engineID)); key != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Errorf("Unexpected SHA key %s", key)
+	}
+}
+
+func TestSNMPValue(t *testing.T) {
+	for _, tc := range []struct {
+		value    asn1.RawValue
+		expected float64
+		err      bool
+	}{
+		{value: asn1.RawValue{Tag: asn1.TagInteger, Bytes: []byte{0xff, 0x38}}, expected: -200},
+		{value: asn1.RawValue{Tag: asn1.TagInteger, Bytes: []byte{0x00, 0xc8}}, expected: 200},
+		{value: asn1.RawValue{Class: asn1.ClassApplication, Tag: 2, Bytes: []byte{0xff, 0xff, 0xff, 0xff}}, expected: 4294967295},
+		{value: asn1.RawValue{Class: asn1.ClassApplication, Tag: 6, Bytes: []byte{0x00, 0xff, 0, 0, 0, 0, 0, 0, 0}}, expected: 18374686479671623680},
+		{value: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: []byte("21.5\n")}, expected: 21.5},
+		{value: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: []byte("eth0")}, err: true},
+		{value: asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, Bytes: []byte{127, 0, 0, 1}}, err: true},
+		{value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}, err: true},
+	} {
+		value, err := snmpValue(tc.value)
+		if (err != nil) != tc.err {
+			t.Errorf("Unexpected error for %v: %v", tc.value, err)
+		} else if value != tc.expected {
+			t.Errorf("Expected %v for %v, got %v", tc.expected, tc.value, value)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// errSSHHostKeyReceived aborts the SSH handshake once the host key of the
+// server was received, as the probe does not authenticate.
+var errSSHHostKeyReceived = errors.New("host key received")
+
+// sshVersionConn records the beginning of what the server sends, which
+// contains its version string.
+type sshVersionConn struct {
+	net.Conn
+	received []byte
+}
+
+func (c *sshVersionConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if len(c.received) < 1024 {
+		c.received = append(c.received, b[:n]...)
+	}
+	return n, err
+}
+
+// serverVersion returns the version string of the server, which may be
+// preceded by other lines.
+func (c *sshVersionConn) serverVersion() string {
+	for _, line := range strings.Split(string(c.received), "\n") {
+		if strings.HasPrefix(line, "SSH-") {
+			return strings.TrimRight(line, "\r")
+		}
+	}
+	return ""
+}
+
+// sshHostKeyHash returns a hash of the fingerprint of a host key, which changes
+// when the server presents another host key.
+func sshHostKeyHash(fingerprint string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(fingerprint))
+	return float64(h.Sum32())
+}
+
+// ProbeSSH performs the key exchange of the SSH protocol and verifies the
+// host key of the server, without authenticating.
+func ProbeSSH(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeSSHHandshakeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_ssh_handshake_duration_seconds",
+		Help: "Duration of the SSH key exchange",
+	})
+	probeSSHHostKeyInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_ssh_host_key_info",
+		Help: "Contains the algorithm and the SHA256 fingerprint of the host key",
+	}, []string{"algorithm", "fingerprint_sha256"})
+	probeSSHHostKeyHash := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_ssh_host_key_hash",
+		Help: "Specifies the hash of the host key fingerprint. It's useful to detect if the host key changes.",
+	})
+	probeSSHHostKeyMatch := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_ssh_host_key_match",
+		Help: "Whether the host key matches the known_hosts file or the pinned fingerprint",
+	})
+	probeSSHServerVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_ssh_server_version_info",
+		Help: "Contains the version string of the server",
+	}, []string{"version"})
+	registry.MustRegister(probeSSHHandshakeDuration)
+
+	var verify ssh.HostKeyCallback
+	switch {
+	case module.SSH.KnownHostsFile != "":
+		var err error
+		if verify, err = knownhosts.New(module.SSH.KnownHostsFile); err != nil {
+			level.Error(logger).Log("msg", "Error reading known_hosts file", "err", err)
+			return false
+		}
+	case module.SSH.HostKeyFingerprint != "":
+		verify = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fingerprint := ssh.FingerprintSHA256(key); fingerprint != module.SSH.HostKeyFingerprint {
+				return fmt.Errorf("host key fingerprint %s does not match %s", fingerprint, module.SSH.HostKeyFingerprint)
+			}
+			return nil
+		}
+	}
+
+	conn, _, err := dialTCPTarget(ctx, target, module.SSH.IPProtocol, module.SSH.IPProtocolFallback, module.SSH.SourceIPAddress, registry, logger)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	var (
+		hostKey   ssh.PublicKey
+		verifyErr error
+	)
+	clientConfig := &ssh.ClientConfig{
+		User: "prober",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			if verify != nil {
+				verifyErr = verify(hostname, remote, key)
+			}
+			return errSSHHostKeyReceived
+		},
+	}
+	start := time.Now()
+	// The target is given as address so that known_hosts entries match the
+	// name and port of the target rather than the resolved address.
+	versionConn := &sshVersionConn{Conn: conn}
+	_, _, _, err = ssh.NewClientConn(versionConn, target, clientConfig)
+	probeSSHHandshakeDuration.Set(time.Since(start).Seconds())
+	if hostKey == nil {
+		level.Error(logger).Log("msg", "SSH handshake failed", "err", err)
+		return false
+	}
+
+	fingerprint := ssh.FingerprintSHA256(hostKey)
+	level.Info(logger).Log("msg", "Received host key", "algorithm", hostKey.Type(), "fingerprint", fingerprint)
+	registry.MustRegister(probeSSHHostKeyInfo, probeSSHHostKeyHash)
+	probeSSHHostKeyInfo.WithLabelValues(hostKey.Type(), fingerprint).Set(1)
+	probeSSHHostKeyHash.Set(sshHostKeyHash(fingerprint))
+	if serverVersion := versionConn.serverVersion(); serverVersion != "" {
+		registry.MustRegister(probeSSHServerVersion)
+		probeSSHServerVersion.WithLabelValues(serverVersion).Set(1)
+	}
+
+	if verify == nil {
+		return true
+	}
+	registry.MustRegister(probeSSHHostKeyMatch)
+	if verifyErr != nil {
+		level.Error(logger).Log("msg", "Host key verification failed", "err", verifyErr)
+		return false
+	}
+	probeSSHHostKeyMatch.Set(1)
+	return true
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestSSHHostKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-TestServer", NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, serverConfig)
+			}()
+		}
+	}()
+	target := ln.Addr().String()
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	knownHosts, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(knownHosts.Name())
+	knownHosts.WriteString(knownhosts.Line([]string{target}, signer.PublicKey()) + "\n")
+	knownHosts.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	if !ProbeSSH(testCTX, target, config.Module{SSH: config.SSHProbe{IPProtocol: "ip4"}}, registry, log.NewNopLogger()) {
+		t.Fatalf("SSH module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := map[string]map[string]string{
+		"probe_ssh_host_key_info": {
+			"algorithm":          "ssh-ed25519",
+			"fingerprint_sha256": fingerprint,
+		},
+		"probe_ssh_server_version_info": {
+			"version": "SSH-2.0-TestServer",
+		},
+	}
+	checkRegistryLabels(expectedLabels, mfs, t)
+	checkRegistryResults(map[string]float64{"probe_ssh_host_key_hash": sshHostKeyHash(fingerprint)}, mfs, t)
+
+	for _, tc := range []struct {
+		name    string
+		module  config.SSHProbe
+		success bool
+	}{
+		{
+			name:    "pinned fingerprint",
+			module:  config.SSHProbe{IPProtocol: "ip4", HostKeyFingerprint: fingerprint},
+			success: true,
+		},
+		{
+			name:    "other fingerprint",
+			module:  config.SSHProbe{IPProtocol: "ip4", HostKeyFingerprint: "SHA256:AAAA"},
+			success: false,
+		},
+		{
+			name:    "known_hosts",
+			module:  config.SSHProbe{IPProtocol: "ip4", KnownHostsFile: knownHosts.Name()},
+			success: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			if ProbeSSH(testCTX, target, config.Module{SSH: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			match := 0.0
+			if tc.success {
+				match = 1
+			}
+			checkRegistryResults(map[string]float64{"probe_ssh_host_key_match": match}, mfs, t)
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	stunMagicCookie = 0x2112a442
+	stunHeaderSize  = 20
+
+	stunBinding = 0x0001
+
+	// Classes of messages, combined with the method into the message type.
+	stunClassSuccess = 0x0100
+	stunClassError   = 0x0110
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrMessageIntegrity = 0x0008
+	stunAttrErrorCode        = 0x0009
+	stunAttrXORMappedAddress = 0x0020
+)
+
+type stunAttribute struct {
+	typ   uint16
+	value []byte
+}
+
+type stunMessage struct {
+	typ           uint16
+	transactionID [12]byte
+	attributes    []stunAttribute
+}
+
+// stunError is an error response of a STUN server.
+type stunError struct {
+	code   int
+	reason string
+}
+
+func (e *stunError) Error() string {
+	return fmt.Sprintf("error response %d %s", e.code, e.reason)
+}
+
+func newSTUNRequest(method uint16, attributes ...stunAttribute) *stunMessage {
+	m := &stunMessage{typ: method, attributes: attributes}
+	rand.Read(m.transactionID[:])
+	return m
+}
+
+func (m *stunMessage) get(typ uint16) ([]byte, bool) {
+	for _, attribute := range m.attributes {
+		if attribute.typ == typ {
+			return attribute.value, true
+		}
+	}
+	return nil, false
+}
+
+// encode encodes the message. A MESSAGE-INTEGRITY attribute is appended if
+// a key is given.
+func (m *stunMessage) encode(key []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, m.typ)
+	b = append(b, 0, 0)
+	b = binary.BigEndian.AppendUint32(b, stunMagicCookie)
+	b = append(b, m.transactionID[:]...)
+	for _, attribute := range m.attributes {
+		b = binary.BigEndian.AppendUint16(b, attribute.typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(attribute.value)))
+		b = append(b, attribute.value...)
+		// Attributes are padded to a multiple of four bytes.
+		b = append(b, make([]byte, -len(attribute.value)&3)...)
+	}
+	if key != nil {
+		// The length covers the integrity attribute, which is computed over
+		// the message preceding it.
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)-stunHeaderSize+4+sha1.Size))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(b)
+		b = binary.BigEndian.AppendUint16(b, stunAttrMessageIntegrity)
+		b = binary.BigEndian.AppendUint16(b, sha1.Size)
+		b = mac.Sum(b)
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-stunHeaderSize))
+	return b
+}
+
+func parseSTUNMessage(b []byte) (*stunMessage, error) {
+	if len(b) < stunHeaderSize || binary.BigEndian.Uint32(b[4:]) != stunMagicCookie {
+		return nil, errors.New("not a STUN message")
+	}
+	if length := int(binary.BigEndian.Uint16(b[2:])); length != len(b)-stunHeaderSize {
+		return nil, fmt.Errorf("length %d does not match message of %d bytes", length, len(b))
+	}
+	m := &stunMessage{typ: binary.BigEndian.Uint16(b)}
+	copy(m.transactionID[:], b[8:stunHeaderSize])
+	for b = b[stunHeaderSize:]; len(b) > 0; {
+		if len(b) < 4 {
+			return nil, errors.New("truncated attribute")
+		}
+		typ, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+length {
+			return nil, errors.New("truncated attribute")
+		}
+		m.attributes = append(m.attributes, stunAttribute{typ: typ, value: b[4 : 4+length]})
+		b = b[min(len(b), 4+(length+3)&^3):]
+	}
+	return m, nil
+}
+
+// address decodes an address attribute, which may be XORed with the magic
+// cookie and transaction ID.
+func (m *stunMessage) address(typ uint16, xor bool) (*net.UDPAddr, error) {
+	value, ok := m.get(typ)
+	if !ok {
+		return nil, fmt.Errorf("attribute 0x%04x missing", typ)
+	}
+	var size int
+	switch {
+	case len(value) == 8 && value[1] == 0x01:
+		size = net.IPv4len
+	case len(value) == 20 && value[1] == 0x02:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("invalid address attribute 0x%04x", typ)
+	}
+	port, ip := binary.BigEndian.Uint16(value[2:]), net.IP(bytes.Clone(value[4:4+size]))
+	if xor {
+		mask := binary.BigEndian.AppendUint32(nil, stunMagicCookie)
+		mask = append(mask, m.transactionID[:]...)
+		port ^= stunMagicCookie >> 16
+		for i := range ip {
+			ip[i] ^= mask[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// stunRoundTrip sends a request and waits for the response with the same
+// transaction ID. Error responses are returned together with a *stunError.
+func stunRoundTrip(conn net.Conn, request *stunMessage, key []byte) (*stunMessage, error) {
+	if _, err := conn.Write(request.encode(key)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		response, err := parseSTUNMessage(buf[:n])
+		if err != nil || response.transactionID != request.transactionID {
+			continue
+		}
+		if response.typ == request.typ|stunClassError {
+			value, _ := response.get(stunAttrErrorCode)
+			if len(value) < 4 {
+				return response, &stunError{}
+			}
+			return response, &stunError{code: int(value[2]&0x07)*100 + int(value[3]), reason: string(value[4:])}
+		}
+		if response.typ != request.typ|stunClassSuccess {
+			return nil, fmt.Errorf("unexpected message type 0x%04x", response.typ)
+		}
+		return response, nil
+	}
+}
+
+// ProbeSTUN sends a binding request to a STUN server and checks the server
+// reflexive address of the response.
+func ProbeSTUN(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeSTUNDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_stun_duration_seconds",
+		Help: "Duration of each phase of the STUN request",
+	}, []string{"phase"})
+	probeSTUNMappedAddressIPProtocol := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_stun_mapped_address_ip_protocol",
+		Help: "Specifies whether the server reflexive address is IPv4 or IPv6",
+	})
+	probeSTUNErrorCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_stun_error_code",
+		Help: "Code of the error response of the server",
+	})
+	registry.MustRegister(probeSTUNDuration)
+
+	conn, err := dialUDPTarget(ctx, target, module.STUN.IPProtocol, module.STUN.IPProtocolFallback, module.STUN.SourceIPAddress, registry, logger)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	response, err := stunRoundTrip(conn, newSTUNRequest(stunBinding), nil)
+	probeSTUNDuration.WithLabelValues("binding").Set(time.Since(start).Seconds())
+	if err != nil {
+		var responseErr *stunError
+		if errors.As(err, &responseErr) {
+			registry.MustRegister(probeSTUNErrorCode)
+			probeSTUNErrorCode.Set(float64(responseErr.code))
+		}
+		level.Error(logger).Log("msg", "Binding request failed", "err", err)
+		return false
+	}
+
+	address, err := response.address(stunAttrXORMappedAddress, true)
+	if err != nil {
+		// Servers implementing only RFC 3489 do not XOR the address.
+		address, err = response.address(stunAttrMappedAddress, false)
+	}
+	if err != nil {
+		level.Error(logger).Log("msg", "Response does not contain the server reflexive address", "err", err)
+		return false
+	}
+	level.Info(logger).Log("msg", "Received server reflexive address", "address", address)
+	registry.MustRegister(probeSTUNMappedAddressIPProtocol)
+	if address.IP.To4() != nil {
+		probeSTUNMappedAddressIPProtocol.Set(4)
+	} else {
+		probeSTUNMappedAddressIPProtocol.Set(6)
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// stunTestAddress encodes the address as an attribute of the response m.
+func stunTestAddress(m *stunMessage, typ uint16, addr *net.UDPAddr, xor bool) stunAttribute {
+	ip, family := addr.IP.To4(), byte(0x01)
+	if ip == nil {
+		ip, family = addr.IP.To16(), 0x02
+	}
+	port, ip := uint16(addr.Port), append(net.IP{}, ip...)
+	if xor {
+		mask := append(binary.BigEndian.AppendUint32(nil, stunMagicCookie), m.transactionID[:]...)
+		port ^= stunMagicCookie >> 16
+		for i := range ip {
+			ip[i] ^= mask[i]
+		}
+	}
+	return stunAttribute{typ: typ, value: append(binary.BigEndian.AppendUint16([]byte{0, family}, port), ip...)}
+}
+
+// serveSTUN answers each request with the response returned by handle.
+func serveSTUN(t *testing.T, conn net.PacketConn, handle func(request *stunMessage, addr *net.UDPAddr) (*stunMessage, []byte)) {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := parseSTUNMessage(buf[:n])
+		if err != nil {
+			t.Errorf("Error parsing request: %s", err)
+			return
+		}
+		response, key := handle(request, addr.(*net.UDPAddr))
+		response.transactionID = request.transactionID
+		conn.WriteTo(response.encode(key), addr)
+	}
+}
+
+func TestSTUNBinding(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name      string
+		handle    func(request *stunMessage, addr *net.UDPAddr) *stunMessage
+		success   bool
+		errorCode float64
+	}{
+		{
+			name: "xor mapped address",
+			handle: func(request *stunMessage, addr *net.UDPAddr) *stunMessage {
+				response := &stunMessage{typ: stunBinding | stunClassSuccess, transactionID: request.transactionID}
+				response.attributes = []stunAttribute{
+					stunTestAddress(response, stunAttrMappedAddress, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}, false),
+					stunTestAddress(response, stunAttrXORMappedAddress, addr, true),
+				}
+				return response
+			},
+			success: true,
+		},
+		{
+			name: "mapped address",
+			handle: func(request *stunMessage, addr *net.UDPAddr) *stunMessage {
+				response := &stunMessage{typ: stunBinding | stunClassSuccess}
+				response.attributes = []stunAttribute{stunTestAddress(response, stunAttrMappedAddress, addr, false)}
+				return response
+			},
+			success: true,
+		},
+		{
+			name: "error response",
+			handle: func(request *stunMessage, addr *net.UDPAddr) *stunMessage {
+				return &stunMessage{typ: stunBinding | stunClassError, attributes: []stunAttribute{{typ: stunAttrErrorCode, value: []byte("\x00\x00\x05\x00Server Error")}}}
+			},
+			errorCode: 500,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer conn.Close()
+			go serveSTUN(t, conn, func(request *stunMessage, addr *net.UDPAddr) (*stunMessage, []byte) {
+				if request.typ != stunBinding || len(request.attributes) != 0 {
+					t.Errorf("Unexpected request %+v", request)
+				}
+				return tc.handle(request, addr), nil
+			})
+
+			registry := prometheus.NewRegistry()
+			module := config.Module{STUN: config.STUNProbe{IPProtocol: "ip4"}}
+			if ProbeSTUN(testCTX, conn.LocalAddr().String(), module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_stun_duration_seconds": {"phase": {"binding": {}}}}, mfs, t)
+			if tc.success {
+				checkRegistryResults(map[string]float64{"probe_stun_mapped_address_ip_protocol": 4}, mfs, t)
+			} else {
+				checkRegistryResults(map[string]float64{"probe_stun_error_code": tc.errorCode}, mfs, t)
+			}
+		})
+	}
+}
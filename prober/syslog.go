@@ -0,0 +1,124 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// syslogPriority is the PRI of the test message, facility user and severity
+// informational.
+const syslogPriority = 1<<3 | 6
+
+// syslogMessage formats an RFC 5424 message carrying the probe ID as
+// structured data. Fields that are not available are replaced by the NILVALUE.
+func syslogMessage(now time.Time, hostname, appName, id, msg string) string {
+	field := func(s string, n int) string {
+		var b strings.Builder
+		for _, r := range s {
+			// Header fields are printable US-ASCII without spaces.
+			if r > ' ' && r < 127 && b.Len() < n {
+				b.WriteRune(r)
+			}
+		}
+		if b.Len() == 0 {
+			return "-"
+		}
+		return b.String()
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d probe [probe@32473 id=\"%s\"] %s",
+		syslogPriority, now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		field(hostname, 255), field(appName, 48), os.Getpid(), id, msg)
+}
+
+// ProbeSyslog sends a test message to a syslog receiver over UDP, TCP or TLS.
+func ProbeSyslog(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeSyslogDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_syslog_duration_seconds",
+		Help: "Duration of each phase of the syslog delivery",
+	}, []string{"phase"})
+	registry.MustRegister(probeSyslogDuration)
+
+	// step runs and times one phase of the delivery.
+	step := newStepTimer(probeSyslogDuration, "phase", "Syslog phase", logger, nil)
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if module.Syslog.Protocol == "udp" {
+		if conn, err = dialUDPTarget(ctx, target, module.Syslog.IPProtocol, module.Syslog.IPProtocolFallback, module.Syslog.SourceIPAddress, registry, logger); err != nil {
+			return false
+		}
+		defer conn.Close()
+	} else {
+		tlsConfig, err := newTargetTLSConfig(&module.Syslog.TLSConfig, target)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+			return false
+		}
+		if !step("connect", func() error {
+			conn, _, err = dialTCPTarget(ctx, target, module.Syslog.IPProtocol, module.Syslog.IPProtocolFallback, module.Syslog.SourceIPAddress, registry, logger)
+			return err
+		}) {
+			return false
+		}
+		defer conn.Close()
+
+		if module.Syslog.TLS {
+			tlsConn := tls.Client(conn, tlsConfig)
+			if !step("tls", func() error { return tlsConn.HandshakeContext(ctx) }) {
+				return false
+			}
+			state := tlsConn.ConnectionState()
+			reportTLSConnectionState(&state, registry)
+			conn = tlsConn
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	var id [8]byte
+	rand.Read(id[:])
+	message := syslogMessage(time.Now(), hostname, module.Syslog.AppName, hex.EncodeToString(id[:]), module.Syslog.Message)
+	switch {
+	case module.Syslog.Protocol == "udp":
+		// Every datagram carries exactly one message.
+	case module.Syslog.Framing == "non-transparent":
+		// Messages are delimited by a trailing LF, so it must not appear
+		// inside the message.
+		message = strings.ReplaceAll(message, "\n", " ") + "\n"
+	default:
+		message = strconv.Itoa(len(message)) + " " + message
+	}
+	level.Info(logger).Log("msg", "Sending message", "id", hex.EncodeToString(id[:]))
+	return step("send", func() error {
+		_, err := conn.Write([]byte(message))
+		return err
+	})
+}
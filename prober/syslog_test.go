@@ -0,0 +1,153 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+var syslogTestMessage = regexp.MustCompile(`^<14>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ blackbox_exporter \d+ probe \[probe@32473 id="[0-9a-f]{16}"\] test message$`)
+
+func TestSyslogMessage(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	message := syslogMessage(now, "", "app name", "0123456789abcdef", "hello")
+	expected := `<14>1 2024-01-02T03:04:05.000006Z - appname `
+	if !strings.HasPrefix(message, expected) || !strings.HasSuffix(message, ` probe [probe@32473 id="0123456789abcdef"] hello`) {
+		t.Errorf("Unexpected message %q", message)
+	}
+}
+
+func TestSyslogDelivery(t *testing.T) {
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tlsConfig, caFile := newTestTLSServerConfig(t)
+
+	for _, tc := range []struct {
+		name   string
+		module config.SyslogProbe
+		phases map[string]struct{}
+	}{
+		{
+			name:   "udp",
+			module: config.SyslogProbe{Protocol: "udp", Framing: "octet-counting"},
+			phases: map[string]struct{}{"send": {}},
+		},
+		{
+			name:   "tcp octet-counting",
+			module: config.SyslogProbe{Protocol: "tcp", Framing: "octet-counting"},
+			phases: map[string]struct{}{"connect": {}, "send": {}},
+		},
+		{
+			name:   "tcp non-transparent",
+			module: config.SyslogProbe{Protocol: "tcp", Framing: "non-transparent"},
+			phases: map[string]struct{}{"connect": {}, "send": {}},
+		},
+		{
+			name: "tls",
+			module: config.SyslogProbe{
+				Protocol:  "tcp",
+				TLS:       true,
+				TLSConfig: pconfig.TLSConfig{CAFile: caFile},
+				Framing:   "octet-counting",
+			},
+			phases: map[string]struct{}{"connect": {}, "tls": {}, "send": {}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			messages := make(chan string, 1)
+			var address string
+			if tc.module.Protocol == "udp" {
+				conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Error listening on socket: %s", err)
+				}
+				defer conn.Close()
+				address = conn.LocalAddr().String()
+				go func() {
+					buf := make([]byte, maxUDPPacketSize)
+					n, _, err := conn.ReadFrom(buf)
+					if err == nil {
+						messages <- string(buf[:n])
+					}
+				}()
+			} else {
+				ln, err := net.Listen("tcp4", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Error listening on socket: %s", err)
+				}
+				defer ln.Close()
+				if tc.module.TLS {
+					ln = tls.NewListener(ln, tlsConfig)
+				}
+				address = ln.Addr().String()
+				go func() {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					b, _ := io.ReadAll(conn)
+					messages <- string(b)
+				}()
+			}
+
+			tc.module.IPProtocol = "ip4"
+			tc.module.AppName = "blackbox_exporter"
+			tc.module.Message = "test message"
+			registry := prometheus.NewRegistry()
+			if !ProbeSyslog(testCTX, "localhost"+address[strings.LastIndex(address, ":"):], config.Module{Syslog: tc.module}, registry, log.NewNopLogger()) {
+				t.Fatalf("Syslog module failed, expected success.")
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_syslog_duration_seconds": {"phase": tc.phases}}, mfs, t)
+
+			message := <-messages
+			switch {
+			case tc.module.Protocol == "udp":
+			case tc.module.Framing == "octet-counting":
+				length, rest, _ := strings.Cut(message, " ")
+				if length != strconv.Itoa(len(rest)) {
+					t.Fatalf("Unexpected octet-counting frame %q", message)
+				}
+				message = rest
+			case tc.module.Framing == "non-transparent":
+				if !strings.HasSuffix(message, "\n") {
+					t.Fatalf("Unexpected non-transparent frame %q", message)
+				}
+				message = strings.TrimSuffix(message, "\n")
+			}
+			if !syslogTestMessage.MatchString(message) {
+				t.Errorf("Unexpected message %q", message)
+			}
+		})
+	}
+}
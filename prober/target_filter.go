@@ -0,0 +1,133 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+type targetFiltersKey struct{}
+
+// withTargetFilters returns a context carrying the filters that the
+// addresses a probe connects to must pass. Empty filters are dropped.
+func withTargetFilters(ctx context.Context, filters ...*config.TargetFilter) context.Context {
+	var active []*config.TargetFilter
+	for _, f := range filters {
+		if len(f.AllowHostnames)+len(f.DenyHostnames)+len(f.AllowCIDRs)+len(f.DenyCIDRs) > 0 || f.DenyPrivateTargets {
+			active = append(active, f)
+		}
+	}
+	if len(active) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, targetFiltersKey{}, active)
+}
+
+// hasTargetFilters reports whether the context carries any target filters.
+func hasTargetFilters(ctx context.Context) bool {
+	return ctx.Value(targetFiltersKey{}) != nil
+}
+
+// targetHostname returns the host name of a target, which is either a URL or
+// a host with an optional port.
+func targetHostname(target string) string {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return strings.Trim(target, "[]")
+}
+
+// checkTargetHostname returns an error if a filter of the context denies
+// probing the host name.
+func checkTargetHostname(ctx context.Context, hostname string) error {
+	filters, _ := ctx.Value(targetFiltersKey{}).([]*config.TargetFilter)
+	for _, f := range filters {
+		if !f.AllowsHostname(hostname) {
+			return fmt.Errorf("target %q is not allowed", hostname)
+		}
+	}
+	return nil
+}
+
+// checkTargetAddr returns an error if a filter of the context denies
+// probing the IP address.
+func checkTargetAddr(ctx context.Context, ip net.IP) error {
+	filters, _ := ctx.Value(targetFiltersKey{}).([]*config.TargetFilter)
+	addr, ok := netip.AddrFromSlice(ip)
+	for _, f := range filters {
+		if !ok || !f.AllowsAddr(addr) {
+			return fmt.Errorf("target address %s is not allowed", ip)
+		}
+	}
+	return nil
+}
+
+// checkProxiedTarget returns an error if a filter of the context denies
+// probing the target host through a proxy that resolves it. Only IP address
+// targets can be checked against filters of addresses then.
+func checkProxiedTarget(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return checkTargetAddr(ctx, ip)
+	}
+	filters, _ := ctx.Value(targetFiltersKey{}).([]*config.TargetFilter)
+	for _, f := range filters {
+		if f.FiltersAddrs() {
+			return fmt.Errorf("the address of target %q cannot be checked, as the proxy resolves it", host)
+		}
+	}
+	return nil
+}
+
+// reportTargetDenied exports that the probe failed because a target filter
+// denied an address.
+func reportTargetDenied(registry *prometheus.Registry) {
+	probeTargetDenied := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_target_denied",
+		Help: "Indicates that a target filter denied an address the probe resolved or connected to",
+	})
+	// The gauge is already registered if another address was denied.
+	if err := registry.Register(probeTargetDenied); err == nil {
+		probeTargetDenied.Set(1)
+	}
+}
+
+// targetFilterControl returns a net.Dialer control function that checks the
+// address of every connection, e.g. those of HTTP redirects, against the
+// filters of the context.
+func targetFilterControl(ctx context.Context, registry *prometheus.Registry) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if err := checkTargetAddr(ctx, net.ParseIP(host)); err != nil {
+			reportTargetDenied(registry)
+			return err
+		}
+		return nil
+	}
+}
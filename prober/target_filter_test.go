@@ -0,0 +1,121 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestTargetHostname(t *testing.T) {
+	for target, expected := range map[string]string{
+		"https://example.com:8443/health": "example.com",
+		"example.com:443":                 "example.com",
+		"[2001:db8::1]:53":                "2001:db8::1",
+		"2001:db8::1":                     "2001:db8::1",
+		"example.com":                     "example.com",
+	} {
+		if hostname := targetHostname(target); hostname != expected {
+			t.Errorf("Expected host name %q for %q, got %q", expected, target, hostname)
+		}
+	}
+}
+
+func TestTargetFilter(t *testing.T) {
+	var localhost string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, localhost, http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+	localhost = "http://localhost:" + ts.URL[strings.LastIndex(ts.URL, ":")+1:]
+
+	loopback := netip.MustParsePrefix("127.0.0.0/8")
+	module := config.Module{
+		Prober:  "http",
+		Timeout: 10 * time.Second,
+		HTTP:    config.HTTPProbe{IPProtocol: "ip4", HTTPClientConfig: config.DefaultHTTPProbe.HTTPClientConfig},
+	}
+	for _, tc := range []struct {
+		name   string
+		global config.TargetFilter
+		module config.TargetFilter
+		target string
+		status int
+		output string
+	}{
+		{
+			name:   "allowed",
+			global: config.TargetFilter{AllowCIDRs: []netip.Prefix{loopback}},
+			target: ts.URL,
+			status: http.StatusOK,
+			output: "probe_success 1",
+		},
+		{
+			name:   "denied host name",
+			global: config.TargetFilter{DenyHostnames: []config.Regexp{config.MustNewRegexp("^(?:localhost)$")}},
+			target: localhost,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "denied address",
+			module: config.TargetFilter{DenyCIDRs: []netip.Prefix{loopback}},
+			target: localhost,
+			status: http.StatusOK,
+			output: "target address 127.0.0.1 is not allowed",
+		},
+		{
+			name:   "denied private address",
+			global: config.TargetFilter{DenyPrivateTargets: true},
+			target: ts.URL,
+			status: http.StatusOK,
+			output: "probe_target_denied 1",
+		},
+		{
+			name:   "denied redirect",
+			global: config.TargetFilter{DenyHostnames: []config.Regexp{config.MustNewRegexp("^(?:localhost)$")}},
+			target: ts.URL + "/redirect",
+			status: http.StatusOK,
+			output: "Not following redirect",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := module
+			m.TargetFilter = tc.module
+			c := &config.Config{TargetFilter: tc.global, Modules: map[string]config.Module{"http_2xx": m}}
+			req, err := http.NewRequest("GET", "?debug=true&target="+tc.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			Handler(rr, req, c, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone(), nil, nil)
+			if rr.Code != tc.status {
+				t.Fatalf("probe request handler returned wrong status code: %v, want %v", rr.Code, tc.status)
+			}
+			if !strings.Contains(rr.Body.String(), tc.output) {
+				t.Fatalf("Expected %q, response body: %v", tc.output, rr.Body.String())
+			}
+		})
+	}
+}
@@ -15,63 +15,152 @@ package prober
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	pconfig "github.com/prometheus/common/config"
+	"golang.org/x/net/proxy"
 
 	"github.com/prometheus/blackbox_exporter/config"
 )
 
-func dialTCP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) (net.Conn, error) {
-	var dialProtocol, dialTarget string
-	dialer := &net.Dialer{}
-	targetAddress, port, err := net.SplitHostPort(target)
-	if err != nil {
-		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
-		return nil, err
+// probeTCPExpectFailure succeeds only if the connection to the target is
+// refused or times out.
+func probeTCPExpectFailure(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	conn, err := dialTCP(ctx, target, module, registry, logger)
+	if err == nil {
+		conn.Close()
+		level.Error(logger).Log("msg", "Connection succeeded, expected failure")
+		return false
 	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		level.Error(logger).Log("msg", "Error resolving target", "err", err)
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		level.Info(logger).Log("msg", "Connection refused as expected", "err", err)
+		return true
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		level.Info(logger).Log("msg", "Connection timed out as expected", "err", err)
+		return true
+	}
+	level.Error(logger).Log("msg", "Connection failed for an unexpected reason", "err", err)
+	return false
+}
+
+// tcpDialer connects to a resolved target, so that the target can be dialed
+// several times without resolving it again.
+type tcpDialer struct {
+	dialer    proxy.ContextDialer
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	logger    log.Logger
+
+	// happyEyeballs holds the addresses raced against each other instead
+	// of dialing address, and the registry their results are exported to.
+	happyEyeballs []happyEyeballsAttempt
+	registry      *prometheus.Registry
+}
 
-	ip, _, err := chooseProtocol(ctx, module.TCP.IPProtocol, module.TCP.IPProtocolFallback, targetAddress, registry, logger)
+func newTCPDialer(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) (*tcpDialer, error) {
+	d := &tcpDialer{logger: logger}
+	dialer := &net.Dialer{KeepAlive: module.TCP.KeepAliveInterval}
+	if module.TCP.DSCP > 0 {
+		dialer.Control = dscpControl(module.TCP.DSCP)
+	}
+	targetAddress, port, err := net.SplitHostPort(target)
 	if err != nil {
-		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
 		return nil, err
 	}
 
-	if ip.IP.To4() == nil {
-		dialProtocol = "tcp6"
+	proxyURL := module.TCP.ProxyURL.URL
+	var dstIP net.IP
+	if module.TCP.ProxyResolvesTarget() {
+		// The proxy resolves the target, which might not be resolvable
+		// from here.
+		if err := checkProxiedTarget(ctx, targetAddress); err != nil {
+			level.Error(logger).Log("msg", "Target is not allowed", "err", err)
+			reportTargetDenied(registry)
+			return nil, err
+		}
+		d.network = "tcp"
+		d.address = target
+	} else if module.TCP.HappyEyeballs {
+		d.happyEyeballs, err = resolveHappyEyeballs(ctx, targetAddress, port, registry, logger)
+		if err != nil {
+			return nil, err
+		}
+		d.registry = registry
 	} else {
-		dialProtocol = "tcp4"
-	}
+		ip, _, err := chooseProtocol(ctx, module.TCP.IPProtocol, module.TCP.IPProtocolFallback, targetAddress, registry, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error resolving address", "err", err)
+			return nil, err
+		}
 
-	if len(module.TCP.SourceIPAddress) > 0 {
-		srcIP := net.ParseIP(module.TCP.SourceIPAddress)
-		if srcIP == nil {
-			level.Error(logger).Log("msg", "Error parsing source ip address", "srcIP", module.TCP.SourceIPAddress)
-			return nil, fmt.Errorf("error parsing source ip address: %s", module.TCP.SourceIPAddress)
+		if ip.IP.To4() == nil {
+			d.network = "tcp6"
+		} else {
+			d.network = "tcp4"
 		}
+		dstIP = ip.IP
+		d.address = net.JoinHostPort(ip.String(), port)
+	}
+
+	srcIP, err := chooseSourceIP(module.TCP.SourceIPAddress, module.TCP.SourceInterface, dstIP, logger)
+	if err != nil {
+		return nil, err
+	}
+	if srcIP != nil {
 		level.Info(logger).Log("msg", "Using local address", "srcIP", srcIP)
 		dialer.LocalAddr = &net.TCPAddr{IP: srcIP}
 	}
 
-	dialTarget = net.JoinHostPort(ip.String(), port)
+	d.dialer = dialer
+	switch {
+	case proxyURL == nil:
+	case proxyURL.Scheme == "http" || proxyURL.Scheme == "https":
+		d.dialer = &httpConnectDialer{proxyURL: proxyURL, forward: dialer}
+		level.Info(logger).Log("msg", "Dialing through proxy", "proxy", module.TCP.ProxyURL.Redacted())
+	default:
+		proxyDialer, err := proxy.FromURL(proxyURL, dialer)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error creating proxy dialer", "err", err)
+			return nil, err
+		}
+		d.dialer = proxyDialer.(proxy.ContextDialer)
+		level.Info(logger).Log("msg", "Dialing through proxy", "proxy", module.TCP.ProxyURL.Redacted())
+	}
 
 	if !module.TCP.TLS {
-		level.Info(logger).Log("msg", "Dialing TCP without TLS")
-		return dialer.DialContext(ctx, dialProtocol, dialTarget)
+		return d, nil
 	}
-	tlsConfig, err := pconfig.NewTLSConfig(&module.TCP.TLSConfig)
+	d.tlsConfig, err = pconfig.NewTLSConfig(&module.TCP.TLSConfig)
 	if err != nil {
 		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
 		return nil, err
 	}
 
-	if len(tlsConfig.ServerName) == 0 {
+	if len(d.tlsConfig.ServerName) == 0 {
 		// If there is no `server_name` in tls_config, use
 		// targetAddress as TLS-servername. Normally tls.DialWithDialer
 		// would do this for us, but we pre-resolved the name by
@@ -79,13 +168,81 @@ func dialTCP(ctx context.Context, target string, module config.Module, registry
 		// resolving twice).
 		// For this reason we need to specify the original targetAddress
 		// via tlsConfig to enable hostname verification.
-		tlsConfig.ServerName = targetAddress
+		d.tlsConfig.ServerName = targetAddress
 	}
-	timeoutDeadline, _ := ctx.Deadline()
-	dialer.Deadline = timeoutDeadline
+	return d, nil
+}
 
-	level.Info(logger).Log("msg", "Dialing TCP with TLS")
-	return tls.DialWithDialer(dialer, dialProtocol, dialTarget, tlsConfig)
+// dial connects to the target, and performs the TLS handshake if TLS is
+// enabled.
+func (d *tcpDialer) dial(ctx context.Context) (net.Conn, error) {
+	if d.tlsConfig == nil {
+		level.Info(d.logger).Log("msg", "Dialing TCP without TLS")
+	} else {
+		level.Info(d.logger).Log("msg", "Dialing TCP with TLS")
+	}
+	var conn net.Conn
+	var err error
+	if len(d.happyEyeballs) > 0 {
+		conn, err = d.dialHappyEyeballs(ctx)
+	} else {
+		conn, err = d.dialer.DialContext(ctx, d.network, d.address)
+	}
+	if err != nil || d.tlsConfig == nil {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, d.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connectTCP dials the target count times in sequence and returns the last
+// connection. If the target is dialed more than once, statistics about the
+// connect times are exported.
+func connectTCP(ctx context.Context, d *tcpDialer, count int, registry *prometheus.Registry, logger log.Logger) (net.Conn, error) {
+	if count <= 1 {
+		return d.dial(ctx)
+	}
+	probeConnectDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_tcp_connect_duration_seconds",
+		Help: "Statistics of the time taken to connect, by stat (min, max, avg, stddev)",
+	}, []string{"stat"})
+	registry.MustRegister(probeConnectDuration)
+
+	durations := make([]float64, 0, count)
+	var conn net.Conn
+	for i := 0; i < count; i++ {
+		if conn != nil {
+			conn.Close()
+		}
+		start := time.Now()
+		var err error
+		conn, err = d.dial(ctx)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error connecting", "attempt", i+1, "err", err)
+			return nil, err
+		}
+		durations = append(durations, time.Since(start).Seconds())
+	}
+
+	stats := computeDurationStats(durations)
+	level.Info(logger).Log("msg", "Connected repeatedly", "count", count, "min", stats.min, "avg", stats.avg, "max", stats.max, "stddev", stats.stddev)
+	probeConnectDuration.WithLabelValues("min").Set(stats.min)
+	probeConnectDuration.WithLabelValues("max").Set(stats.max)
+	probeConnectDuration.WithLabelValues("avg").Set(stats.avg)
+	probeConnectDuration.WithLabelValues("stddev").Set(stats.stddev)
+	return conn, nil
+}
+
+func dialTCP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) (net.Conn, error) {
+	d, err := newTCPDialer(ctx, target, module, registry, logger)
+	if err != nil {
+		return nil, err
+	}
+	return d.dial(ctx)
 }
 
 func probeExpectInfo(registry *prometheus.Registry, qr *config.QueryResponse, bytes []byte, match []int) {
@@ -106,7 +263,36 @@ func probeExpectInfo(registry *prometheus.Registry, qr *config.QueryResponse, by
 	metric.WithLabelValues(values...).Set(1)
 }
 
+// maxBannerLength is the maximum number of characters of a banner exported
+// on probe_tcp_banner_info, to keep the label value bounded.
+const maxBannerLength = 128
+
+// sanitizeBanner strips non-printable characters from a banner line and
+// truncates it to maxBannerLength characters.
+func sanitizeBanner(line string) string {
+	banner := strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(line, ""))
+	banner = strings.TrimSpace(banner)
+	if runes := []rune(banner); len(runes) > maxBannerLength {
+		banner = string(runes[:maxBannerLength])
+	}
+	return banner
+}
+
 func ProbeTCP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	if module.TCP.HalfOpen {
+		return probeTCPHalfOpen(ctx, target, module, registry, logger)
+	}
+	if len(module.TCP.Ports) > 0 {
+		return probeTCPPorts(ctx, target, module, registry, logger)
+	}
+	if module.TCP.ExpectFailure {
+		return probeTCPExpectFailure(ctx, target, module, registry, logger)
+	}
 	probeSSLEarliestCertExpiry := prometheus.NewGauge(sslEarliestCertExpiryGaugeOpts)
 	probeSSLLastChainExpiryTimestampSeconds := prometheus.NewGauge(sslChainExpiryInTimeStampGaugeOpts)
 	probeSSLLastInformation := prometheus.NewGaugeVec(
@@ -120,20 +306,37 @@ func ProbeTCP(ctx context.Context, target string, module config.Module, registry
 		probeTLSInfoGaugeOpts,
 		[]string{"version"},
 	)
+	probeTLSCipher := prometheus.NewGaugeVec(
+		probeTLSCipherGaugeOpts,
+		[]string{"cipher"},
+	)
 	probeFailedDueToRegex := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "probe_failed_due_to_regex",
 		Help: "Indicates if probe failed due to regex",
 	})
+	probeQueryResponseDurationGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_tcp_query_response_duration_seconds",
+		Help: "Duration of each query_response step",
+	}, []string{"step"})
 	registry.MustRegister(probeFailedDueToRegex)
+	if len(module.TCP.QueryResponse) > 0 {
+		registry.MustRegister(probeQueryResponseDurationGaugeVec)
+	}
 	deadline, _ := ctx.Deadline()
 
-	conn, err := dialTCP(ctx, target, module, registry, logger)
+	dialer, err := newTCPDialer(ctx, target, module, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error dialing TCP", "err", err)
+		return false
+	}
+	conn, err := connectTCP(ctx, dialer, module.TCP.ConnectCount, registry, logger)
 	if err != nil {
 		level.Error(logger).Log("msg", "Error dialing TCP", "err", err)
 		return false
 	}
 	defer conn.Close()
 	level.Info(logger).Log("msg", "Successfully dialed")
+	reportTCPInfo(conn, registry, logger)
 
 	// Set a deadline to prevent the following code from blocking forever.
 	// If a deadline cannot be set, better fail the probe by returning an error
@@ -142,22 +345,125 @@ func ProbeTCP(ctx context.Context, target string, module config.Module, registry
 		level.Error(logger).Log("msg", "Error setting deadline", "err", err)
 		return false
 	}
-	if module.TCP.TLS {
-		state := conn.(*tls.Conn).ConnectionState()
-		registry.MustRegister(probeSSLEarliestCertExpiry, probeTLSVersion, probeSSLLastChainExpiryTimestampSeconds, probeSSLLastInformation)
+	// reportTLSState exports the certificate metrics of an established TLS
+	// session, either from the initial dial or from a STARTTLS upgrade.
+	reportTLSState := func(state tls.ConnectionState) {
+		registry.MustRegister(probeSSLEarliestCertExpiry, probeTLSVersion, probeTLSCipher, probeSSLLastChainExpiryTimestampSeconds, probeSSLLastInformation)
 		probeSSLEarliestCertExpiry.Set(float64(getEarliestCertExpiry(&state).Unix()))
 		probeTLSVersion.WithLabelValues(getTLSVersion(&state)).Set(1)
+		probeTLSCipher.WithLabelValues(getTLSCipher(&state)).Set(1)
 		probeSSLLastChainExpiryTimestampSeconds.Set(float64(getLastChainExpiry(&state).Unix()))
 		probeSSLLastInformation.WithLabelValues(getFingerprint(&state), getSubject(&state), getIssuer(&state), getDNSNames(&state)).Set(1)
 	}
+	if module.TCP.TLS {
+		reportTLSState(conn.(*tls.Conn).ConnectionState())
+	}
+	// finishStep records the duration of the step in progress. It is also
+	// run on return so that the step which failed the probe is reported.
+	var finishStep func()
+	defer func() {
+		if finishStep != nil {
+			finishStep()
+		}
+	}()
+	// expectBytes makes the scanner return exactly that many bytes as the next
+	// token instead of a line, for expect_hex.
+	expectBytes := 0
+	splitFunc := func(data []byte, atEOF bool) (int, []byte, error) {
+		if expectBytes == 0 {
+			return bufio.ScanLines(data, atEOF)
+		}
+		if len(data) >= expectBytes {
+			return expectBytes, data[:expectBytes], nil
+		}
+		if atEOF && len(data) > 0 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
 	scanner := bufio.NewScanner(conn)
+	scanner.Split(splitFunc)
+	// lineDeadline is the deadline of the current step, line_timeout makes
+	// reading each line expire earlier.
+	lineDeadline := deadline
+	scanLine := func() bool {
+		if module.TCP.LineTimeout > 0 {
+			readDeadline := time.Now().Add(module.TCP.LineTimeout)
+			if !lineDeadline.IsZero() && lineDeadline.Before(readDeadline) {
+				readDeadline = lineDeadline
+			}
+			if err := conn.SetReadDeadline(readDeadline); err != nil {
+				level.Error(logger).Log("msg", "Error setting line deadline", "err", err)
+				return false
+			}
+		}
+		return scanner.Scan()
+	}
+	lineEnding := "\n"
+	if module.TCP.LineEnding == "crlf" {
+		lineEnding = "\r\n"
+	}
+	// bannerPending is set when the banner line has been read but not yet
+	// been offered to a query_response expect.
+	bannerPending := false
+	if module.TCP.CaptureBanner {
+		if !scanLine() {
+			level.Error(logger).Log("msg", "Error reading banner", "err", scanner.Err())
+			return false
+		}
+		banner := sanitizeBanner(scanner.Text())
+		level.Info(logger).Log("msg", "Read banner", "banner", banner)
+		probeBannerInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_tcp_banner_info",
+			Help: "Contains the first line received on the connection",
+		}, []string{"banner"})
+		registry.MustRegister(probeBannerInfo)
+		probeBannerInfo.WithLabelValues(banner).Set(1)
+		bannerPending = true
+	}
 	for i, qr := range module.TCP.QueryResponse {
 		level.Info(logger).Log("msg", "Processing query response entry", "entry_number", i)
+		stepStart := time.Now()
+		stepGauge := probeQueryResponseDurationGaugeVec.WithLabelValues(strconv.Itoa(i))
+		finishStep = func() {
+			stepGauge.Set(time.Since(stepStart).Seconds())
+		}
+		stepDeadline := deadline
+		if qr.Timeout > 0 {
+			stepDeadline = stepStart.Add(qr.Timeout)
+			if !deadline.IsZero() && deadline.Before(stepDeadline) {
+				stepDeadline = deadline
+			}
+			if err := conn.SetDeadline(stepDeadline); err != nil {
+				level.Error(logger).Log("msg", "Error setting step deadline", "err", err)
+				return false
+			}
+		}
+		lineDeadline = stepDeadline
 		send := qr.Send
+		if len(qr.ExpectHex) > 0 {
+			expectBytes = len(qr.ExpectHex)
+			ok := scanner.Scan()
+			expectBytes = 0
+			if !ok {
+				err := scanner.Err()
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				level.Error(logger).Log("msg", "Error reading from connection", "err", err)
+				return false
+			}
+			if !bytes.Equal(scanner.Bytes(), qr.ExpectHex) {
+				level.Error(logger).Log("msg", "Received bytes did not match expect_hex", "expected", fmt.Sprintf("%x", []byte(qr.ExpectHex)), "received", fmt.Sprintf("%x", scanner.Bytes()))
+				return false
+			}
+			level.Info(logger).Log("msg", "Received bytes matched expect_hex", "received", fmt.Sprintf("%x", scanner.Bytes()))
+		}
 		if qr.Expect.Regexp != nil {
 			var match []int
 			// Read lines until one of them matches the configured regexp.
-			for scanner.Scan() {
+			for bannerPending || scanLine() {
+				bannerPending = false
 				level.Debug(logger).Log("msg", "Read line", "line", scanner.Text())
 				match = qr.Expect.Regexp.FindSubmatchIndex(scanner.Bytes())
 				if match != nil {
@@ -182,7 +488,14 @@ func ProbeTCP(ctx context.Context, target string, module config.Module, registry
 		}
 		if send != "" {
 			level.Debug(logger).Log("msg", "Sending line", "line", send)
-			if _, err := fmt.Fprintf(conn, "%s\n", send); err != nil {
+			if _, err := fmt.Fprintf(conn, "%s%s", send, lineEnding); err != nil {
+				level.Error(logger).Log("msg", "Failed to send", "err", err)
+				return false
+			}
+		}
+		if len(qr.SendHex) > 0 {
+			level.Debug(logger).Log("msg", "Sending bytes", "bytes", fmt.Sprintf("%x", []byte(qr.SendHex)))
+			if _, err := conn.Write(qr.SendHex); err != nil {
 				level.Error(logger).Log("msg", "Failed to send", "err", err)
 				return false
 			}
@@ -203,22 +516,76 @@ func ProbeTCP(ctx context.Context, target string, module config.Module, registry
 			defer tlsConn.Close()
 
 			// Initiate TLS handshake (required here to get TLS state).
-			if err := tlsConn.Handshake(); err != nil {
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
 				level.Error(logger).Log("msg", "TLS Handshake (client) failed", "err", err)
 				return false
 			}
 			level.Info(logger).Log("msg", "TLS Handshake (client) succeeded.")
 			conn = net.Conn(tlsConn)
 			scanner = bufio.NewScanner(conn)
+			scanner.Split(splitFunc)
+			bannerPending = false
 
 			// Get certificate expiry.
-			state := tlsConn.ConnectionState()
-			registry.MustRegister(probeSSLEarliestCertExpiry, probeTLSVersion, probeSSLLastChainExpiryTimestampSeconds, probeSSLLastInformation)
-			probeSSLEarliestCertExpiry.Set(float64(getEarliestCertExpiry(&state).Unix()))
-			probeTLSVersion.WithLabelValues(getTLSVersion(&state)).Set(1)
-			probeSSLLastChainExpiryTimestampSeconds.Set(float64(getLastChainExpiry(&state).Unix()))
-			probeSSLLastInformation.WithLabelValues(getFingerprint(&state), getSubject(&state), getIssuer(&state), getDNSNames(&state)).Set(1)
+			reportTLSState(tlsConn.ConnectionState())
 		}
+		if qr.Timeout > 0 || module.TCP.LineTimeout > 0 {
+			// Restore the probe deadline for the following steps.
+			if err := conn.SetDeadline(deadline); err != nil {
+				level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+				return false
+			}
+		}
+		finishStep()
+		finishStep = nil
+	}
+	if module.TCP.IdleHold > 0 {
+		return holdTCPIdle(conn, deadline, module.TCP.IdleHold, registry, logger)
 	}
 	return true
 }
+
+// holdTCPIdle keeps the connection open without sending any data for the idle
+// duration, and fails if it is closed in the meantime, for example by a NAT
+// or firewall dropping idle connections.
+func holdTCPIdle(conn net.Conn, deadline time.Time, idle time.Duration, registry *prometheus.Registry, logger log.Logger) bool {
+	probeIdleSurvival := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_idle_survival_seconds",
+		Help: "How long the connection stayed open while idle",
+	})
+	registry.MustRegister(probeIdleSurvival)
+
+	start := time.Now()
+	holdUntil := start.Add(idle)
+	clamped := holdUntil.After(deadline)
+	if clamped {
+		holdUntil = deadline
+	}
+	if err := conn.SetReadDeadline(holdUntil); err != nil {
+		level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+		return false
+	}
+	level.Info(logger).Log("msg", "Holding connection idle", "duration", idle)
+	buf := make([]byte, 4096)
+	for {
+		// Data sent by the target is discarded, only the connection being
+		// closed ends the idle period early.
+		_, err := conn.Read(buf)
+		if err == nil {
+			continue
+		}
+		survival := time.Since(start).Seconds()
+		probeIdleSurvival.Set(survival)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if clamped {
+				// The connection was not held for the configured duration.
+				level.Error(logger).Log("msg", "Probe timeout is shorter than the idle period", "duration_seconds", survival, "idle_hold", idle)
+				return false
+			}
+			level.Info(logger).Log("msg", "Connection survived idle period", "duration_seconds", survival)
+			return true
+		}
+		level.Error(logger).Log("msg", "Connection dropped while idle", "duration_seconds", survival, "err", err)
+		return false
+	}
+}
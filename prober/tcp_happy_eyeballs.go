@@ -0,0 +1,175 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// happyEyeballsLoserWait is how long to wait for the connection attempt of
+// the other address family once the first one succeeded, to measure by how
+// much it lost.
+const happyEyeballsLoserWait = time.Second
+
+// happyEyeballsAttempt is the address of one address family of a dual-stack
+// target.
+type happyEyeballsAttempt struct {
+	protocol string
+	ip       net.IP
+	address  string
+}
+
+type happyEyeballsResult struct {
+	attempt  happyEyeballsAttempt
+	conn     net.Conn
+	err      error
+	duration time.Duration
+}
+
+// resolveHappyEyeballs returns the first IPv6 and the first IPv4 address of
+// the target, or only one of them if the target is not dual-stack.
+func resolveHappyEyeballs(ctx context.Context, target, port string, registry *prometheus.Registry, logger log.Logger) ([]happyEyeballsAttempt, error) {
+	probeDNSLookupTimeSeconds := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_dns_lookup_time_seconds",
+		Help: "Returns the time taken for probe dns lookup in seconds",
+	})
+	registry.MustRegister(probeDNSLookupTimeSeconds)
+
+	level.Info(logger).Log("msg", "Resolving target address", "target", target, "ip_protocol", "ip4 and ip6")
+	resolveStart := time.Now()
+	ips, err := (&net.Resolver{}).LookupIPAddr(ctx, target)
+	probeDNSLookupTimeSeconds.Set(time.Since(resolveStart).Seconds())
+	if err != nil {
+		level.Error(logger).Log("msg", "Resolution failed", "target", target, "err", err)
+		return nil, err
+	}
+
+	var ip4, ip6 *happyEyeballsAttempt
+	for _, ip := range ips {
+		switch {
+		case ip.IP.To4() != nil && ip4 == nil:
+			ip4 = &happyEyeballsAttempt{protocol: "ip4", ip: ip.IP, address: net.JoinHostPort(ip.String(), port)}
+		case ip.IP.To4() == nil && ip6 == nil:
+			ip6 = &happyEyeballsAttempt{protocol: "ip6", ip: ip.IP, address: net.JoinHostPort(ip.String(), port)}
+		}
+	}
+	var attempts []happyEyeballsAttempt
+	for _, attempt := range []*happyEyeballsAttempt{ip6, ip4} {
+		if attempt != nil {
+			level.Info(logger).Log("msg", "Resolved target address", "target", target, "ip", attempt.ip)
+			if err := checkTargetAddr(ctx, attempt.ip); err != nil {
+				level.Error(logger).Log("msg", "Resolved target address is not allowed", "target", target, "ip", attempt.ip)
+				reportTargetDenied(registry)
+				return nil, err
+			}
+			attempts = append(attempts, *attempt)
+		}
+	}
+	if len(attempts) == 0 {
+		return nil, errors.New("unable to find ip")
+	}
+	return attempts, nil
+}
+
+// dialHappyEyeballs connects to all addresses concurrently and returns the
+// first established connection. The outcome of each address family is
+// exported, as well as by how much the winner was faster if both succeeded.
+func (d *tcpDialer) dialHappyEyeballs(ctx context.Context) (net.Conn, error) {
+	probeIPProtocolGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_ip_protocol",
+		Help: "Specifies whether probe ip protocol is IP4 or IP6",
+	})
+	probeIPAddrHash := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_ip_addr_hash",
+		Help: "Specifies the hash of IP address. It's useful to detect if the IP address changes.",
+	})
+	probeConnectSuccess := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_tcp_happy_eyeballs_success",
+		Help: "Whether the connection attempt of the address family succeeded",
+	}, []string{"ip_protocol"})
+	probeConnectDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_tcp_happy_eyeballs_connect_duration_seconds",
+		Help: "Time taken to connect over the address family",
+	}, []string{"ip_protocol"})
+	probeMargin := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_happy_eyeballs_margin_seconds",
+		Help: "How much faster the winning address family connected than the other one",
+	})
+	d.registry.MustRegister(probeIPProtocolGauge, probeIPAddrHash, probeConnectSuccess, probeConnectDuration)
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	results := make(chan happyEyeballsResult, len(d.happyEyeballs))
+	pending := len(d.happyEyeballs)
+	defer func() {
+		cancel()
+		// Close the connections of attempts which were given up on.
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				if result := <-results; result.conn != nil {
+					result.conn.Close()
+				}
+			}
+		}(pending)
+	}()
+	start := time.Now()
+	for _, attempt := range d.happyEyeballs {
+		probeConnectSuccess.WithLabelValues(attempt.protocol).Set(0)
+		go func(attempt happyEyeballsAttempt) {
+			conn, err := d.dialer.DialContext(attemptCtx, "tcp", attempt.address)
+			results <- happyEyeballsResult{attempt: attempt, conn: conn, err: err, duration: time.Since(start)}
+		}(attempt)
+	}
+
+	var winner *happyEyeballsResult
+	var lastErr error
+	var timeout <-chan time.Time
+	for ; pending > 0; pending-- {
+		var result happyEyeballsResult
+		select {
+		case result = <-results:
+		case <-timeout:
+			level.Info(d.logger).Log("msg", "Gave up waiting for the other address family")
+			return winner.conn, nil
+		}
+		if result.err != nil {
+			level.Info(d.logger).Log("msg", "Connection attempt failed", "ip_protocol", result.attempt.protocol, "err", result.err)
+			lastErr = result.err
+			continue
+		}
+		level.Info(d.logger).Log("msg", "Connection attempt succeeded", "ip_protocol", result.attempt.protocol, "duration_seconds", result.duration.Seconds())
+		probeConnectSuccess.WithLabelValues(result.attempt.protocol).Set(1)
+		probeConnectDuration.WithLabelValues(result.attempt.protocol).Set(result.duration.Seconds())
+		if winner != nil {
+			result.conn.Close()
+			d.registry.MustRegister(probeMargin)
+			probeMargin.Set((result.duration - winner.duration).Seconds())
+			continue
+		}
+		winner = &result
+		probeIPProtocolGauge.Set(protocolToGauge[result.attempt.protocol])
+		probeIPAddrHash.Set(ipHash(result.attempt.ip))
+		timeout = time.After(happyEyeballsLoserWait)
+	}
+	if winner == nil {
+		return nil, lastErr
+	}
+	return winner.conn, nil
+}
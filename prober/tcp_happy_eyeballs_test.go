@@ -0,0 +1,116 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDialHappyEyeballs(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name            string
+		ip6Address      string
+		expectedSuccess map[string]float64
+		expectMargin    bool
+	}{
+		{
+			name:            "ip6 broken",
+			ip6Address:      closedAddr,
+			expectedSuccess: map[string]float64{"ip4": 1, "ip6": 0},
+		},
+		{
+			name:            "both succeed",
+			ip6Address:      ln.Addr().String(),
+			expectedSuccess: map[string]float64{"ip4": 1, "ip6": 1},
+			expectMargin:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			// Both addresses are IPv4 as the test can not rely on IPv6
+			// being available, only the labels differ.
+			d := &tcpDialer{
+				dialer: &net.Dialer{},
+				logger: log.NewNopLogger(),
+				happyEyeballs: []happyEyeballsAttempt{
+					{protocol: "ip6", ip: net.ParseIP("127.0.0.1"), address: test.ip6Address},
+					{protocol: "ip4", ip: net.ParseIP("127.0.0.1"), address: ln.Addr().String()},
+				},
+				registry: registry,
+			}
+			testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			conn, err := d.dial(testCTX)
+			if err != nil {
+				t.Fatalf("Error dialing: %s", err)
+			}
+			conn.Close()
+
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			foundMargin := false
+			success := map[string]float64{}
+			for _, mf := range mfs {
+				switch mf.GetName() {
+				case "probe_tcp_happy_eyeballs_margin_seconds":
+					foundMargin = true
+				case "probe_tcp_happy_eyeballs_success":
+					for _, m := range mf.GetMetric() {
+						success[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+					}
+				}
+			}
+			for protocol, expected := range test.expectedSuccess {
+				if success[protocol] != expected {
+					t.Fatalf("Expected probe_tcp_happy_eyeballs_success{ip_protocol=%q} to be %v, got %v", protocol, expected, success[protocol])
+				}
+			}
+			if foundMargin != test.expectMargin {
+				t.Fatalf("Expected margin metric to be exported: %t, got %t", test.expectMargin, foundMargin)
+			}
+		})
+	}
+}
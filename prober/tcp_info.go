@@ -0,0 +1,82 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errTCPInfoUnsupported = errors.New("reading TCP_INFO is not supported")
+
+// tcpInfo holds the socket statistics of a TCP connection kept by the kernel.
+type tcpInfo struct {
+	rtt         time.Duration
+	rttVariance time.Duration
+	retransmits uint32
+	mss         uint32
+}
+
+// underlyingTCPConn returns the TCP connection conn is layered on.
+func underlyingTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *bufferedConn:
+			conn = c.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+// reportTCPInfo exports the socket statistics of the connection, if the
+// platform supports reading them.
+func reportTCPInfo(conn net.Conn, registry *prometheus.Registry, logger log.Logger) {
+	info, err := readTCPInfo(conn)
+	if err != nil {
+		level.Debug(logger).Log("msg", "Could not read TCP_INFO", "err", err)
+		return
+	}
+	probeTCPRTT := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_rtt_seconds",
+		Help: "Smoothed round trip time of the connection measured by the kernel",
+	})
+	probeTCPRTTVariance := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_rtt_variance_seconds",
+		Help: "Round trip time variance of the connection measured by the kernel",
+	})
+	probeTCPRetransmits := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_retransmits",
+		Help: "Number of segments retransmitted on the connection",
+	})
+	probeTCPMSS := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_mss_bytes",
+		Help: "Maximum segment size used to send on the connection",
+	})
+	registry.MustRegister(probeTCPRTT, probeTCPRTTVariance, probeTCPRetransmits, probeTCPMSS)
+	probeTCPRTT.Set(info.rtt.Seconds())
+	probeTCPRTTVariance.Set(info.rttVariance.Seconds())
+	probeTCPRetransmits.Set(float64(info.retransmits))
+	probeTCPMSS.Set(float64(info.mss))
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// tcpScanConcurrency is the number of ports dialed in parallel by a scan.
+const tcpScanConcurrency = 64
+
+func portInRanges(port uint16, ranges []config.PortRange) bool {
+	for _, r := range ranges {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeTCPPorts connects to every configured port of the target and reports
+// which of them accepted the connection. The probe fails if the set of open
+// ports differs from expected_open_ports.
+func probeTCPPorts(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probePortOpen := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_tcp_port_open",
+		Help: "Indicates if the TCP port accepted a connection",
+	}, []string{"port"})
+	probeUnexpectedPorts := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_ports_unexpected",
+		Help: "Number of scanned ports whose state differs from expected_open_ports",
+	})
+	registry.MustRegister(probePortOpen, probeUnexpectedPorts)
+
+	// The target may be given with or without a port, a port is ignored.
+	targetAddress := target
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		targetAddress = host
+	}
+
+	ip, _, err := chooseProtocol(ctx, module.TCP.IPProtocol, module.TCP.IPProtocolFallback, targetAddress, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+	dialProtocol := "tcp4"
+	if ip.IP.To4() == nil {
+		dialProtocol = "tcp6"
+	}
+
+	dialer := &net.Dialer{}
+	if module.TCP.DSCP > 0 {
+		dialer.Control = dscpControl(module.TCP.DSCP)
+	}
+	srcIP, err := chooseSourceIP(module.TCP.SourceIPAddress, module.TCP.SourceInterface, ip.IP, logger)
+	if err != nil {
+		return false
+	}
+	if srcIP != nil {
+		level.Info(logger).Log("msg", "Using local address", "srcIP", srcIP)
+		dialer.LocalAddr = &net.TCPAddr{IP: srcIP}
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		unexpected int
+		sem        = make(chan struct{}, tcpScanConcurrency)
+	)
+	level.Info(logger).Log("msg", "Scanning ports", "target", ip.String())
+	for _, r := range module.TCP.Ports {
+		for p := int(r.First); p <= int(r.Last); p++ {
+			port := uint16(p)
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				open := 0.0
+				conn, err := dialer.DialContext(ctx, dialProtocol, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+				if err == nil {
+					conn.Close()
+					open = 1
+				}
+				probePortOpen.WithLabelValues(strconv.Itoa(int(port))).Set(open)
+
+				if expected := portInRanges(port, module.TCP.ExpectedOpenPorts); expected != (open == 1) {
+					level.Warn(logger).Log("msg", "Port state differs from expectation", "port", port, "open", open == 1, "expected_open", expected)
+					mu.Lock()
+					unexpected++
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	probeUnexpectedPorts.Set(float64(unexpected))
+	if unexpected > 0 {
+		level.Error(logger).Log("msg", "Open ports differ from expected_open_ports", "unexpected", unexpected)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func listenPort(t *testing.T) (net.Listener, uint16) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	return ln, uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func TestTCPPortScan(t *testing.T) {
+	open, openPort := listenPort(t)
+	defer open.Close()
+	closed, closedPort := listenPort(t)
+	closed.Close()
+
+	ports := []config.PortRange{{First: openPort, Last: openPort}, {First: closedPort, Last: closedPort}}
+	tests := []struct {
+		expectedOpen  []config.PortRange
+		shouldSucceed bool
+		unexpected    float64
+	}{
+		{[]config.PortRange{{First: openPort, Last: openPort}}, true, 0},
+		{nil, false, 1},
+		{ports, false, 1},
+	}
+
+	for i, test := range tests {
+		module := config.Module{TCP: config.TCPProbe{
+			IPProtocol:        "ip4",
+			Ports:             ports,
+			ExpectedOpenPorts: test.expectedOpen,
+		}}
+		testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		registry := prometheus.NewRegistry()
+		if got := ProbeTCP(testCTX, "127.0.0.1", module, registry, log.NewNopLogger()); got != test.shouldSucceed {
+			t.Fatalf("Test %d had unexpected result: %v", i, got)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRegistryResults(map[string]float64{"probe_tcp_ports_unexpected": test.unexpected}, mfs, t)
+
+		for _, mf := range mfs {
+			if mf.GetName() != "probe_tcp_port_open" {
+				continue
+			}
+			for _, m := range mf.Metric {
+				want := 0.0
+				if m.GetLabel()[0].GetValue() == strconv.Itoa(int(openPort)) {
+					want = 1
+				}
+				if got := m.GetGauge().GetValue(); got != want {
+					t.Fatalf("Test %d: unexpected state for port %s: %v", i, m.GetLabel()[0].GetValue(), got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+
+	tcpHeaderLen = 20
+)
+
+// tcpSegment holds the fields of a TCP header the half-open probe cares about.
+type tcpSegment struct {
+	srcPort uint16
+	dstPort uint16
+	seq     uint32
+	ack     uint32
+	flags   uint8
+}
+
+// parseTCPSegment parses the header of a TCP segment received on a raw socket.
+func parseTCPSegment(b []byte) (tcpSegment, error) {
+	if len(b) < tcpHeaderLen {
+		return tcpSegment{}, errors.New("tcp segment too short")
+	}
+	return tcpSegment{
+		srcPort: binary.BigEndian.Uint16(b[0:2]),
+		dstPort: binary.BigEndian.Uint16(b[2:4]),
+		seq:     binary.BigEndian.Uint32(b[4:8]),
+		ack:     binary.BigEndian.Uint32(b[8:12]),
+		flags:   b[13],
+	}, nil
+}
+
+// tcpChecksum computes the TCP checksum of segment, including the pseudo
+// header built from the source and destination addresses.
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		add(src4)
+		add(dst4)
+		add([]byte{0, 6})
+		add([]byte{byte(len(segment) >> 8), byte(len(segment))})
+	} else {
+		add(src.To16())
+		add(dst.To16())
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(segment)))
+		add(length)
+		add([]byte{0, 0, 0, 6})
+	}
+	add(segment)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// buildSYN returns a TCP SYN segment with a MSS option and a valid checksum.
+func buildSYN(src, dst net.IP, srcPort, dstPort uint16, seq uint32) []byte {
+	b := make([]byte, tcpHeaderLen+4)
+	binary.BigEndian.PutUint16(b[0:2], srcPort)
+	binary.BigEndian.PutUint16(b[2:4], dstPort)
+	binary.BigEndian.PutUint32(b[4:8], seq)
+	// Data offset in 32-bit words, including the MSS option.
+	b[12] = byte(len(b)/4) << 4
+	b[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(b[14:16], 65535)
+	// MSS option.
+	b[20], b[21] = 2, 4
+	binary.BigEndian.PutUint16(b[22:24], 1460)
+	binary.BigEndian.PutUint16(b[16:18], tcpChecksum(src, dst, b))
+	return b
+}
+
+// localIPFor returns the source address the kernel would use to reach dst.
+func localIPFor(dst net.IP) (net.IP, error) {
+	// Connecting a UDP socket does not send any packet, it only selects
+	// a route and therefore a source address.
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// probeTCPHalfOpen sends a single SYN and waits for the SYN/ACK without
+// completing the handshake. The kernel answers the SYN/ACK with a RST as no
+// socket is bound to the source port, which tears the half-open connection
+// down on the target.
+func probeTCPHalfOpen(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeSynAckDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tcp_syn_ack_duration_seconds",
+		Help: "Time between sending the SYN and receiving the SYN/ACK",
+	})
+	registry.MustRegister(probeSynAckDuration)
+
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
+		return false
+	}
+	dstPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || dstPort == 0 {
+		level.Error(logger).Log("msg", "Invalid target port", "port", port)
+		return false
+	}
+
+	ip, _, err := chooseProtocol(ctx, module.TCP.IPProtocol, module.TCP.IPProtocolFallback, targetAddress, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+
+	srcIP, err := chooseSourceIP(module.TCP.SourceIPAddress, module.TCP.SourceInterface, ip.IP, logger)
+	if err != nil {
+		return false
+	}
+	if srcIP == nil {
+		if srcIP, err = localIPFor(ip.IP); err != nil {
+			level.Error(logger).Log("msg", "Error determining source address", "err", err)
+			return false
+		}
+	}
+
+	network := "ip4:tcp"
+	if ip.IP.To4() == nil {
+		network = "ip6:tcp"
+	}
+	conn, err := net.ListenPacket(network, srcIP.String())
+	if err != nil {
+		level.Error(logger).Log("msg", "Error listening to raw socket", "err", err)
+		return false
+	}
+	defer conn.Close()
+	if module.TCP.DSCP > 0 {
+		if ip.IP.To4() != nil {
+			err = ipv4.NewPacketConn(conn).SetTOS(module.TCP.DSCP << 2)
+		} else {
+			err = ipv6.NewPacketConn(conn).SetTrafficClass(module.TCP.DSCP << 2)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "Error setting DSCP", "err", err)
+			return false
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+		return false
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	srcPort := uint16(32768 + r.Intn(28232))
+	seq := r.Uint32()
+	syn := buildSYN(srcIP, ip.IP, srcPort, uint16(dstPort), seq)
+
+	level.Info(logger).Log("msg", "Sending SYN", "src", net.JoinHostPort(srcIP.String(), strconv.Itoa(int(srcPort))), "dst", net.JoinHostPort(ip.String(), port))
+	start := time.Now()
+	if _, err := conn.WriteTo(syn, &net.IPAddr{IP: ip.IP, Zone: ip.Zone}); err != nil {
+		level.Error(logger).Log("msg", "Error sending SYN", "err", err)
+		return false
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reading from raw socket", "err", err)
+			return false
+		}
+		if peerAddr, ok := peer.(*net.IPAddr); !ok || !peerAddr.IP.Equal(ip.IP) {
+			continue
+		}
+		segment, err := parseTCPSegment(buf[:n])
+		if err != nil {
+			continue
+		}
+		if segment.srcPort != uint16(dstPort) || segment.dstPort != srcPort || segment.ack != seq+1 {
+			continue
+		}
+		switch {
+		case segment.flags&tcpFlagRST != 0:
+			level.Error(logger).Log("msg", "Received RST, port is closed")
+			return false
+		case segment.flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK:
+			rtt := time.Since(start).Seconds()
+			probeSynAckDuration.Set(rtt)
+			level.Info(logger).Log("msg", "Received SYN/ACK", "duration_seconds", rtt)
+			return true
+		}
+	}
+}
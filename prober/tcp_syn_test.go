@@ -0,0 +1,83 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestBuildSYN(t *testing.T) {
+	src := net.ParseIP("192.0.2.1")
+	dst := net.ParseIP("198.51.100.2")
+	syn := buildSYN(src, dst, 40000, 443, 12345)
+
+	segment, err := parseTCPSegment(syn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segment.srcPort != 40000 || segment.dstPort != 443 || segment.seq != 12345 || segment.flags != tcpFlagSYN {
+		t.Fatalf("unexpected SYN segment: %+v", segment)
+	}
+	// The checksum of a segment including its own checksum must be zero.
+	if sum := tcpChecksum(src, dst, syn); sum != 0 {
+		t.Fatalf("invalid checksum, got residue %#x", sum)
+	}
+
+	src6 := net.ParseIP("2001:db8::1")
+	dst6 := net.ParseIP("2001:db8::2")
+	if sum := tcpChecksum(src6, dst6, buildSYN(src6, dst6, 40000, 443, 1)); sum != 0 {
+		t.Fatalf("invalid IPv6 checksum, got residue %#x", sum)
+	}
+}
+
+func TestTCPHalfOpen(t *testing.T) {
+	// Raw sockets require privileges, skip if they are not available.
+	rawConn, err := net.ListenPacket("ip4:tcp", "127.0.0.1")
+	if err != nil {
+		t.Skipf("skipping; raw sockets not available: %s", err)
+	}
+	rawConn.Close()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+
+	module := config.Module{TCP: config.TCPProbe{IPProtocol: "ip4", HalfOpen: true}}
+	testCTX, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if !ProbeTCP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TCP half-open probe failed, expected success.")
+	}
+
+	// A closed port answers with a RST.
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	testCTX, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	registry = prometheus.NewRegistry()
+	if ProbeTCP(testCTX, net.JoinHostPort("127.0.0.1", port), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TCP half-open probe to closed port %s succeeded, expected failure.", port)
+	}
+}
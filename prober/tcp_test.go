@@ -14,6 +14,7 @@
 package prober
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -22,9 +23,15 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -61,6 +68,44 @@ func TestTCPConnection(t *testing.T) {
 	<-ch
 }
 
+func TestTCPConnectionSocketMetrics(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is only read on Linux")
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if !ProbeTCP(testCTX, ln.Addr().String(), config.Module{TCP: config.TCPProbe{IPProtocol: "ip4", IPProtocolFallback: true}}, registry, log.NewNopLogger()) {
+		t.Fatalf("TCP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_tcp_retransmits": 0}, mfs, t)
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "probe_tcp_rtt_seconds", "probe_tcp_mss_bytes":
+			if mf.GetMetric()[0].GetGauge().GetValue() <= 0 {
+				t.Fatalf("Expected %s to be positive", mf.GetName())
+			}
+		}
+	}
+}
+
 func TestTCPConnectionFails(t *testing.T) {
 	// Invalid port number.
 	registry := prometheus.NewRegistry()
@@ -71,6 +116,156 @@ func TestTCPConnectionFails(t *testing.T) {
 	}
 }
 
+func TestTCPConnectionConnectCount(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			accepted <- struct{}{}
+		}
+	}()
+
+	module := config.Module{TCP: config.TCPProbe{IPProtocol: "ip4", IPProtocolFallback: true, ConnectCount: 5}}
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	if !ProbeTCP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TCP module failed, expected success.")
+	}
+	for i := 0; i < 5; i++ {
+		<-accepted
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_tcp_connect_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			stats[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	if len(stats) != 4 {
+		t.Fatalf("Expected min, max, avg and stddev, got %v", stats)
+	}
+	if stats["min"] <= 0 || stats["min"] > stats["avg"] || stats["avg"] > stats["max"] || stats["stddev"] < 0 {
+		t.Fatalf("Inconsistent connect duration statistics: %v", stats)
+	}
+}
+
+func TestTCPConnectionIdleHold(t *testing.T) {
+	tests := []struct {
+		name       string
+		closeAfter time.Duration
+		timeout    time.Duration
+		success    bool
+	}{
+		{name: "survives", success: true},
+		{name: "dropped", closeAfter: 50 * time.Millisecond, success: false},
+		{name: "timeout shorter than idle period", timeout: 100 * time.Millisecond, success: false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer ln.Close()
+			done := make(chan struct{})
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				if test.closeAfter > 0 {
+					time.Sleep(test.closeAfter)
+					return
+				}
+				<-done
+			}()
+			defer close(done)
+
+			module := config.Module{TCP: config.TCPProbe{IPProtocol: "ip4", IPProtocolFallback: true, IdleHold: 300 * time.Millisecond, KeepAliveInterval: time.Second}}
+			timeout := 10 * time.Second
+			if test.timeout > 0 {
+				timeout = test.timeout
+			}
+			testCTX, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			registry := prometheus.NewRegistry()
+			if ProbeTCP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) != test.success {
+				t.Fatalf("TCP module returned %t, expected %t", !test.success, test.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mf := range mfs {
+				if mf.GetName() != "probe_tcp_idle_survival_seconds" {
+					continue
+				}
+				survival := mf.GetMetric()[0].GetGauge().GetValue()
+				if test.success && survival < 0.3 {
+					t.Fatalf("Expected the connection to survive the idle period, survived %fs", survival)
+				}
+				if !test.success && survival >= 0.3 {
+					t.Fatalf("Expected the connection to be dropped early, survived %fs", survival)
+				}
+			}
+		})
+	}
+}
+
+func TestTCPConnectionExpectFailure(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	module := config.Module{TCP: config.TCPProbe{IPProtocol: "ip4", IPProtocolFallback: true, ExpectFailure: true}}
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if ProbeTCP(testCTX, ln.Addr().String(), module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("TCP module succeeded on an open port, expected failure.")
+	}
+	if !ProbeTCP(testCTX, closedAddr, module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("TCP module failed on a closed port, expected success.")
+	}
+}
+
 func TestTCPConnectionWithTLS(t *testing.T) {
 	if os.Getenv("CI") == "true" {
 		t.Skip("skipping; CI is failing on ipv6 dns requests")
@@ -197,6 +392,7 @@ func TestTCPConnectionWithTLS(t *testing.T) {
 		"probe_ssl_earliest_cert_expiry": float64(certExpiry.Unix()),
 		"probe_ssl_last_chain_info":      1,
 		"probe_tls_version_info":         1,
+		"probe_tls_cipher_info":          1,
 	}
 	checkRegistryResults(expectedResults, mfs, t)
 }
@@ -581,6 +777,505 @@ func TestTCPConnectionQueryResponseMatching(t *testing.T) {
 
 }
 
+func TestTCPConnectionQueryResponseStepTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	module := config.Module{
+		TCP: config.TCPProbe{
+			IPProtocolFallback: true,
+			QueryResponse: []config.QueryResponse{
+				{
+					Send: "PING",
+				},
+				{
+					Expect:  config.MustNewRegexp("^[+]PONG"),
+					Send:    "INFO",
+					Timeout: time.Second,
+				},
+				{
+					Expect:  config.MustNewRegexp("^redis_version:"),
+					Timeout: 100 * time.Millisecond,
+				},
+			},
+		},
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		var cmd string
+		fmt.Fscanf(conn, "%s\n", &cmd)
+		fmt.Fprintf(conn, "+PONG\n")
+		// Never answer the second command.
+		<-ch
+	}()
+	registry := prometheus.NewRegistry()
+	start := time.Now()
+	if ProbeTCP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TCP module succeeded, expected failure.")
+	}
+	close(ch)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Step timeout was not honoured, probe took %v", elapsed)
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_tcp_query_response_duration_seconds" {
+			continue
+		}
+		if len(mf.Metric) != 3 {
+			t.Fatalf("Expected 3 step durations, got %d", len(mf.Metric))
+		}
+		for _, m := range mf.Metric[1:] {
+			if m.GetGauge().GetValue() <= 0 {
+				t.Fatalf("Expected positive step duration, got %v", m)
+			}
+		}
+		return
+	}
+	t.Fatal("probe_tcp_query_response_duration_seconds not found")
+}
+
+func TestTCPConnectionQueryResponseLineMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		lineDelay time.Duration
+		success   bool
+	}{
+		{name: "lines within line_timeout", lineDelay: 50 * time.Millisecond, success: true},
+		{name: "line_timeout exceeded", lineDelay: time.Second, success: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer ln.Close()
+
+			ch := make(chan string, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					panic(fmt.Sprintf("Error accepting on socket: %s", err))
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				// A multi-line FTP greeting, slower than line_timeout in total.
+				for i := 0; i < 4; i++ {
+					fmt.Fprintf(conn, "220-Welcome\r\n")
+					time.Sleep(test.lineDelay)
+				}
+				fmt.Fprintf(conn, "220 Ready\r\n")
+				buf := make([]byte, 64)
+				n, _ := conn.Read(buf)
+				ch <- string(buf[:n])
+				fmt.Fprintf(conn, "331 Password required\r\n")
+			}()
+
+			module := config.Module{
+				TCP: config.TCPProbe{
+					IPProtocol:         "ip4",
+					IPProtocolFallback: true,
+					LineTimeout:        150 * time.Millisecond,
+					LineEnding:         "crlf",
+					QueryResponse: []config.QueryResponse{
+						{Expect: config.MustNewRegexp("^220 "), Send: "USER anonymous"},
+						{Expect: config.MustNewRegexp("^331 ")},
+					},
+				},
+			}
+			testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			registry := prometheus.NewRegistry()
+			if ProbeTCP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) != test.success {
+				t.Fatalf("TCP module returned %t, expected %t", !test.success, test.success)
+			}
+			if test.success {
+				if got := <-ch; got != "USER anonymous\r\n" {
+					t.Fatalf("Expected a CRLF terminated line, got %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestTCPConnectionCaptureBanner(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	module := config.Module{
+		TCP: config.TCPProbe{
+			IPProtocolFallback: true,
+			CaptureBanner:      true,
+			QueryResponse: []config.QueryResponse{
+				{
+					Expect: config.MustNewRegexp("^SSH-2.0-"),
+				},
+			},
+		},
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "SSH-2.0-OpenSSH_9.6\x07 %s\r\n", strings.Repeat("x", 200))
+	}()
+	registry := prometheus.NewRegistry()
+	if !ProbeTCP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TCP module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedBanner := "SSH-2.0-OpenSSH_9.6 " + strings.Repeat("x", maxBannerLength-len("SSH-2.0-OpenSSH_9.6 "))
+	expectedLabels := map[string]map[string]string{
+		"probe_tcp_banner_info": {
+			"banner": expectedBanner,
+		},
+	}
+	checkRegistryLabels(expectedLabels, mfs, t)
+}
+
+func TestTCPConnectionQueryResponseHex(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(time.Second))
+			req := make([]byte, 4)
+			if _, err := io.ReadFull(conn, req); err == nil && bytes.Equal(req, []byte{0x00, 0x01, 0x0a, 0xff}) {
+				conn.Write([]byte{0xca, 0xfe, 0x0a, 0x00, 0x42})
+			}
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		expectHex     config.HexBytes
+		shouldSucceed bool
+	}{
+		{config.HexBytes{0xca, 0xfe, 0x0a, 0x00}, true},
+		{config.HexBytes{0xca, 0xfe, 0x0a, 0x01}, false},
+		{config.HexBytes{0xca, 0xfe, 0x0a, 0x00, 0x42, 0x00}, false},
+	}
+	for i, test := range tests {
+		testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		module := config.Module{
+			TCP: config.TCPProbe{
+				IPProtocolFallback: true,
+				QueryResponse: []config.QueryResponse{
+					{SendHex: config.HexBytes{0x00, 0x01, 0x0a, 0xff}},
+					{ExpectHex: test.expectHex},
+				},
+			},
+		}
+		registry := prometheus.NewRegistry()
+		if got := ProbeTCP(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()); got != test.shouldSucceed {
+			t.Fatalf("Test %d had unexpected result: %v", i, got)
+		}
+	}
+}
+
+// serveSOCKS5 answers a single SOCKS5 CONNECT request on ln, requiring
+// username/password authentication if user is set. Instead of connecting to
+// the requested destination, it sends its address back as a line.
+func serveSOCKS5(ln net.Listener, user, password string) error {
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return err
+	}
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		gotUser := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, gotUser); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		gotPassword := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, gotPassword); err != nil {
+			return err
+		}
+		if string(gotUser) != user || string(gotPassword) != password {
+			conn.Write([]byte{1, 1})
+			return fmt.Errorf("invalid credentials %q:%q", gotUser, gotPassword)
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:net.IPv4len]); err != nil {
+			return err
+		}
+		host = net.IP(buf[:net.IPv4len]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		name := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return err
+		}
+		host = string(name)
+	case 4:
+		if _, err := io.ReadFull(conn, buf[:net.IPv6len]); err != nil {
+			return err
+		}
+		host = net.IP(buf[:net.IPv6len]).String()
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	port := int(buf[0])<<8 | int(buf[1])
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	fmt.Fprintf(conn, "%s\n", net.JoinHostPort(host, fmt.Sprint(port)))
+	return nil
+}
+
+func TestTCPConnectionSOCKS5Proxy(t *testing.T) {
+	tests := []struct {
+		name           string
+		proxyUser      string
+		proxyURL       string
+		target         string
+		expectedTarget string
+		success        bool
+	}{
+		{
+			name:           "socks5",
+			proxyURL:       "socks5://%s",
+			target:         "127.0.0.1:1234",
+			expectedTarget: "127.0.0.1:1234",
+			success:        true,
+		},
+		{
+			name:           "socks5h resolves on the proxy",
+			proxyURL:       "socks5h://%s",
+			target:         "target.invalid:1234",
+			expectedTarget: "target.invalid:1234",
+			success:        true,
+		},
+		{
+			name:           "authentication",
+			proxyUser:      "prober",
+			proxyURL:       "socks5://prober:secret@%s",
+			target:         "127.0.0.1:1234",
+			expectedTarget: "127.0.0.1:1234",
+			success:        true,
+		},
+		{
+			name:      "wrong password",
+			proxyUser: "prober",
+			proxyURL:  "socks5://prober:wrong@%s",
+			target:    "127.0.0.1:1234",
+			success:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer ln.Close()
+
+			ch := make(chan error, 1)
+			go func() {
+				ch <- serveSOCKS5(ln, test.proxyUser, "secret")
+			}()
+
+			proxyURL, err := url.Parse(fmt.Sprintf(test.proxyURL, ln.Addr().String()))
+			if err != nil {
+				t.Fatalf("Error parsing proxy URL: %s", err)
+			}
+			module := config.Module{
+				TCP: config.TCPProbe{
+					IPProtocol:         "ip4",
+					IPProtocolFallback: true,
+					ProxyURL:           pconfig.URL{URL: proxyURL},
+					QueryResponse: []config.QueryResponse{
+						{Expect: config.MustNewRegexp("^" + regexp.QuoteMeta(test.expectedTarget) + "$")},
+					},
+				},
+			}
+
+			testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			registry := prometheus.NewRegistry()
+			if ProbeTCP(testCTX, test.target, module, registry, log.NewNopLogger()) != test.success {
+				t.Fatalf("TCP module returned %t, expected %t", !test.success, test.success)
+			}
+			if err := <-ch; test.success && err != nil {
+				t.Fatalf("SOCKS5 server failed: %s", err)
+			}
+		})
+	}
+}
+
+func TestTCPConnectionHTTPConnectProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxyURL string
+		success  bool
+	}{
+		{name: "authenticated", proxyURL: "http://prober:secret@%s", success: true},
+		{name: "wrong password", proxyURL: "http://prober:wrong@%s", success: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer ln.Close()
+
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if user, password, ok := parseProxyAuthorization(req.Header.Get("Proxy-Authorization")); !ok || user != "prober" || password != "secret" {
+					fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				// Send the banner of the tunnelled connection along with the
+				// response, as a proxy may do.
+				fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\n%s %s\n", req.Method, req.Host)
+			}()
+
+			proxyURL, err := url.Parse(fmt.Sprintf(test.proxyURL, ln.Addr().String()))
+			if err != nil {
+				t.Fatalf("Error parsing proxy URL: %s", err)
+			}
+			module := config.Module{
+				TCP: config.TCPProbe{
+					IPProtocolFallback: true,
+					ProxyURL:           pconfig.URL{URL: proxyURL},
+					QueryResponse: []config.QueryResponse{
+						{Expect: config.MustNewRegexp("^CONNECT target.invalid:443$")},
+					},
+				},
+			}
+			testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			registry := prometheus.NewRegistry()
+			if ProbeTCP(testCTX, "target.invalid:443", module, registry, log.NewNopLogger()) != test.success {
+				t.Fatalf("TCP module returned %t, expected %t", !test.success, test.success)
+			}
+		})
+	}
+}
+
+func TestTCPConnectionProxyTargetFilter(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Error("Unexpected connection to the proxy")
+			conn.Close()
+		}
+	}()
+
+	proxyURL, err := url.Parse("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Error parsing proxy URL: %s", err)
+	}
+	module := config.Module{TCP: config.TCPProbe{IPProtocolFallback: true, ProxyURL: pconfig.URL{URL: proxyURL}}}
+	filter := &config.TargetFilter{DenyCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testCTX = withTargetFilters(testCTX, filter)
+
+	// Denied addresses and host names whose address cannot be checked are
+	// not dialed.
+	for _, target := range []string{"10.0.0.1:22", "target.invalid:22"} {
+		registry := prometheus.NewRegistry()
+		if ProbeTCP(testCTX, target, module, registry, log.NewNopLogger()) {
+			t.Fatalf("TCP module succeeded for %s, expected failure", target)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRegistryResults(map[string]float64{"probe_target_denied": 1}, mfs, t)
+	}
+}
+
+// parseProxyAuthorization parses the credentials of a Basic
+// Proxy-Authorization header.
+func parseProxyAuthorization(header string) (string, string, bool) {
+	req := &http.Request{Header: http.Header{"Authorization": []string{header}}}
+	return req.BasicAuth()
+}
+
 func TestTCPConnectionProtocol(t *testing.T) {
 	if os.Getenv("CI") == "true" {
 		t.Skip("skipping; CI is failing on ipv6 dns requests")
@@ -0,0 +1,156 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptionEcho            = 1
+	telnetOptionSuppressGoAhead = 3
+	telnetMaxBannerSize         = 1 << 16
+)
+
+// telnetReader strips the commands of the Telnet protocol from the data
+// received and answers option negotiation. The server may echo and suppress
+// go ahead, all other options are refused.
+type telnetReader struct {
+	conn net.Conn
+	// state is the position in a command split across reads.
+	state        byte
+	negotiations int
+}
+
+// read reads data from the connection and returns the data without commands.
+func (r *telnetReader) read() ([]byte, error) {
+	buf := make([]byte, 4096)
+	n, err := r.conn.Read(buf)
+	var data, reply []byte
+	for _, b := range buf[:n] {
+		switch r.state {
+		case 0:
+			if b == telnetIAC {
+				r.state = telnetIAC
+			} else {
+				data = append(data, b)
+			}
+		case telnetIAC:
+			r.state = 0
+			switch b {
+			case telnetIAC:
+				data = append(data, b)
+			case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+				r.state = b
+			case telnetSB:
+				r.state = telnetSB
+			}
+		case telnetDO:
+			r.state = 0
+			r.negotiations++
+			reply = append(reply, telnetIAC, telnetWONT, b)
+		case telnetWILL:
+			r.state = 0
+			r.negotiations++
+			if b == telnetOptionEcho || b == telnetOptionSuppressGoAhead {
+				reply = append(reply, telnetIAC, telnetDO, b)
+			} else {
+				reply = append(reply, telnetIAC, telnetDONT, b)
+			}
+		case telnetDONT, telnetWONT:
+			// Options are never enabled by the probe, so there is nothing to
+			// acknowledge.
+			r.state = 0
+			r.negotiations++
+		case telnetSB:
+			// Subnegotiation lasts until IAC SE.
+			if b == telnetIAC {
+				r.state = telnetSE
+			}
+		case telnetSE:
+			if b == telnetSE {
+				r.state = 0
+			} else {
+				r.state = telnetSB
+			}
+		}
+	}
+	if len(reply) > 0 {
+		if _, err := r.conn.Write(reply); err != nil {
+			return data, err
+		}
+	}
+	return data, err
+}
+
+// ProbeTelnet connects to a Telnet server, answers its option negotiation and
+// waits for a login banner.
+func ProbeTelnet(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeTelnetDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_telnet_duration_seconds",
+		Help: "Duration of each phase of the Telnet session",
+	}, []string{"phase"})
+	probeTelnetNegotiations := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_telnet_option_negotiations",
+		Help: "Number of option negotiation commands received from the server",
+	})
+	registry.MustRegister(probeTelnetDuration, probeTelnetNegotiations)
+
+	start := time.Now()
+	conn, _, err := dialTCPTarget(ctx, target, module.Telnet.IPProtocol, module.Telnet.IPProtocolFallback, module.Telnet.SourceIPAddress, registry, logger)
+	probeTelnetDuration.WithLabelValues("connect").Set(time.Since(start).Seconds())
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	r := &telnetReader{conn: conn}
+	var banner []byte
+	start = time.Now()
+	for {
+		data, err := r.read()
+		banner = append(banner, data...)
+		probeTelnetNegotiations.Set(float64(r.negotiations))
+		if module.Telnet.Expect.Match(banner) {
+			probeTelnetDuration.WithLabelValues("banner").Set(time.Since(start).Seconds())
+			level.Info(logger).Log("msg", "Banner matched", "regexp", module.Telnet.Expect.Regexp)
+			return true
+		}
+		if err == nil && len(banner) > telnetMaxBannerSize {
+			err = errors.New("banner too large")
+		}
+		if err != nil {
+			probeTelnetDuration.WithLabelValues("banner").Set(time.Since(start).Seconds())
+			level.Error(logger).Log("msg", "Banner did not match", "regexp", module.Telnet.Expect.Regexp, "banner", string(banner), "err", err)
+			return false
+		}
+	}
+}
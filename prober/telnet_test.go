@@ -0,0 +1,99 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestTelnetProbe(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				// Like many network devices, the server only sends its banner
+				// once the client answered the negotiation. The window size
+				// subnegotiation contains an escaped IAC.
+				conn.Write([]byte{
+					telnetIAC, telnetWILL, telnetOptionEcho,
+					telnetIAC, telnetWILL, telnetOptionSuppressGoAhead,
+					telnetIAC, telnetDO, 24, // Terminal type.
+					telnetIAC, telnetSB, 31, 0, telnetIAC, telnetIAC, 0, 24, telnetIAC, telnetSE,
+				})
+				expected := []byte{
+					telnetIAC, telnetDO, telnetOptionEcho,
+					telnetIAC, telnetDO, telnetOptionSuppressGoAhead,
+					telnetIAC, telnetWONT, 24,
+				}
+				reply := make([]byte, len(expected))
+				if _, err := io.ReadFull(conn, reply); err != nil || !bytes.Equal(reply, expected) {
+					t.Errorf("Unexpected negotiation reply %v: %v", reply, err)
+					return
+				}
+				conn.Write([]byte("\r\nUser Access Verification\r\n\r\nUser"))
+				time.Sleep(10 * time.Millisecond)
+				// The IAC NOP command is split across writes.
+				conn.Write([]byte{'n', 'a', telnetIAC})
+				time.Sleep(10 * time.Millisecond)
+				conn.Write([]byte{241, 'm', 'e', ':', ' '})
+			}()
+		}
+	}()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name    string
+		expect  string
+		success bool
+	}{
+		{name: "default banner", expect: `(?i)(login|user ?name|password)\s*:`, success: true},
+		{name: "split command", expect: "\r\nUsername: $", success: true},
+		{name: "missing banner", expect: "Password:"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			module := config.Module{Telnet: config.TelnetProbe{IPProtocol: "ip4", Expect: config.MustNewRegexp(tc.expect)}}
+			registry := prometheus.NewRegistry()
+			if ProbeTelnet(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{"probe_telnet_option_negotiations": 3}, mfs, t)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	tftpOpRRQ   = 1
+	tftpOpData  = 3
+	tftpOpAck   = 4
+	tftpOpError = 5
+
+	// tftpBlockSize is the size of all but the last DATA block.
+	tftpBlockSize = 512
+)
+
+// tftpError is an ERROR packet sent by the server.
+type tftpError struct {
+	code    uint16
+	message string
+}
+
+func (e *tftpError) Error() string {
+	return fmt.Sprintf("TFTP error %d: %s", e.code, e.message)
+}
+
+// tftpReadRequest encodes an RRQ for the file in octet mode.
+func tftpReadRequest(filename string) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, tftpOpRRQ)
+	packet = append(packet, filename...)
+	packet = append(packet, 0)
+	packet = append(packet, "octet"...)
+	return append(packet, 0)
+}
+
+// tftpPacket encodes an ACK or ERROR packet.
+func tftpPacket(opcode, value uint16, message string) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, opcode)
+	packet = binary.BigEndian.AppendUint16(packet, value)
+	if opcode == tftpOpError {
+		packet = append(packet, message...)
+		packet = append(packet, 0)
+	}
+	return packet
+}
+
+// parseTFTPData decodes a DATA packet, returning an error for ERROR packets.
+func parseTFTPData(packet []byte) (uint16, []byte, error) {
+	if len(packet) < 4 {
+		return 0, nil, errors.New("truncated packet")
+	}
+	switch binary.BigEndian.Uint16(packet) {
+	case tftpOpData:
+		return binary.BigEndian.Uint16(packet[2:]), packet[4:], nil
+	case tftpOpError:
+		message, _, _ := strings.Cut(string(packet[4:]), "\x00")
+		return 0, nil, &tftpError{code: binary.BigEndian.Uint16(packet[2:]), message: message}
+	default:
+		return 0, nil, fmt.Errorf("unexpected opcode %d", binary.BigEndian.Uint16(packet))
+	}
+}
+
+// ProbeTFTP downloads a file over TFTP and checks the first block against a
+// regular expression.
+func ProbeTFTP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeTFTPDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_tftp_duration_seconds",
+		Help: "Duration of each phase of the TFTP transfer",
+	}, []string{"phase"})
+	probeTFTPTransferSize := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tftp_transfer_size_bytes",
+		Help: "Size of the transferred file",
+	})
+	probeTFTPErrorCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tftp_error_code",
+		Help: "Error code of the TFTP ERROR packet sent by the server",
+	})
+	registry.MustRegister(probeTFTPDuration)
+
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
+		return false
+	}
+	ip, _, err := chooseProtocol(ctx, module.TFTP.IPProtocol, module.TFTP.IPProtocolFallback, targetAddress, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+	server, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		level.Error(logger).Log("msg", "Error parsing port", "err", err)
+		return false
+	}
+	srcIP, err := chooseSourceIP(module.TFTP.SourceIPAddress, "", ip.IP, logger)
+	if err != nil {
+		return false
+	}
+	listenProtocol := "udp4"
+	if ip.IP.To4() == nil {
+		listenProtocol = "udp6"
+	}
+	// The server replies from a new port, so the socket is not connected.
+	conn, err := net.ListenUDP(listenProtocol, &net.UDPAddr{IP: srcIP})
+	if err != nil {
+		level.Error(logger).Log("msg", "Error listening on UDP", "err", err)
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+			return false
+		}
+	}
+
+	var (
+		serverTID *net.UDPAddr
+		block     uint16
+		size      int
+	)
+	buf := make([]byte, 4+tftpBlockSize)
+	// readBlock waits for the next DATA block from the server and
+	// acknowledges it, returning its payload.
+	readBlock := func() ([]byte, error) {
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return nil, err
+			}
+			if serverTID != nil && (!addr.IP.Equal(serverTID.IP) || addr.Port != serverTID.Port) {
+				// Packets from other transfers are ignored.
+				continue
+			}
+			number, data, err := parseTFTPData(buf[:n])
+			if err != nil {
+				return nil, err
+			}
+			if serverTID == nil {
+				serverTID = addr
+			}
+			if _, err := conn.WriteToUDP(tftpPacket(tftpOpAck, number, ""), serverTID); err != nil {
+				return nil, err
+			}
+			// A retransmitted block is acknowledged again, as its ACK was
+			// lost.
+			if number == block+1 {
+				block = number
+				size += len(data)
+				return data, nil
+			}
+		}
+	}
+
+	step := newStepTimer(probeTFTPDuration, "phase", "TFTP phase", logger, func(err error) {
+		var tftpErr *tftpError
+		if errors.As(err, &tftpErr) {
+			registry.MustRegister(probeTFTPErrorCode)
+			probeTFTPErrorCode.Set(float64(tftpErr.code))
+		}
+	})
+
+	var data []byte
+	if !step("first_block", func() error {
+		if _, err := conn.WriteToUDP(tftpReadRequest(module.TFTP.Filename), server); err != nil {
+			return err
+		}
+		data, err = readBlock()
+		return err
+	}) {
+		return false
+	}
+
+	if module.TFTP.Expect.Regexp != nil {
+		if !module.TFTP.Expect.Match(data) {
+			level.Error(logger).Log("msg", "Regexp did not match", "regexp", module.TFTP.Expect.Regexp)
+			// Tell the server to stop sending the rest of the file.
+			conn.WriteToUDP(tftpPacket(tftpOpError, 0, "unexpected content"), serverTID)
+			return false
+		}
+		level.Info(logger).Log("msg", "Regexp matched", "regexp", module.TFTP.Expect.Regexp)
+	}
+
+	if !step("transfer", func() error {
+		// The transfer ends with a block shorter than the block size.
+		for len(data) == tftpBlockSize {
+			if data, err = readBlock(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}) {
+		return false
+	}
+	registry.MustRegister(probeTFTPTransferSize)
+	probeTFTPTransferSize.Set(float64(size))
+	return true
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+var tftpTestFiles = map[string][]byte{
+	"pxelinux.0": append([]byte("PXELINUX"), bytes.Repeat([]byte{0xaa}, 1200)...),
+	"aligned":    bytes.Repeat([]byte{0x55}, 2*tftpBlockSize),
+}
+
+// serveTFTP runs a minimal TFTP server serving the tftpTestFiles. Every
+// transfer is answered from a new port, as required by the protocol.
+func serveTFTP(t *testing.T, conn net.PacketConn) {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		fields := strings.Split(string(buf[2:n]), "\x00")
+		if binary.BigEndian.Uint16(buf) != tftpOpRRQ || len(fields) != 3 || fields[1] != "octet" {
+			t.Errorf("Unexpected request %q", buf[:n])
+			return
+		}
+		go func(filename string) {
+			transfer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Errorf("Error listening on socket: %s", err)
+				return
+			}
+			defer transfer.Close()
+			content, ok := tftpTestFiles[filename]
+			if !ok {
+				transfer.WriteTo(tftpPacket(tftpOpError, 1, "File not found"), addr)
+				return
+			}
+			ack := make([]byte, maxUDPPacketSize)
+			for block := 1; ; block++ {
+				data := content[min(len(content), (block-1)*tftpBlockSize):min(len(content), block*tftpBlockSize)]
+				packet := tftpPacket(tftpOpData, uint16(block), "")
+				transfer.WriteTo(append(packet, data...), addr)
+				n, _, err := transfer.ReadFrom(ack)
+				if err != nil || n != 4 || binary.BigEndian.Uint16(ack) != tftpOpAck || int(binary.BigEndian.Uint16(ack[2:])) != block {
+					return
+				}
+				if len(data) < tftpBlockSize {
+					return
+				}
+			}
+		}(fields[0])
+	}
+}
+
+func TestTFTPTransfer(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer conn.Close()
+	go serveTFTP(t, conn)
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	expect, err := config.NewRegexp("^PXELINUX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		module    config.TFTPProbe
+		success   bool
+		metrics   map[string]float64
+		durations map[string]struct{}
+	}{
+		{
+			name:      "file with expected content",
+			module:    config.TFTPProbe{Filename: "pxelinux.0", Expect: expect},
+			success:   true,
+			metrics:   map[string]float64{"probe_tftp_transfer_size_bytes": 1208},
+			durations: map[string]struct{}{"first_block": {}, "transfer": {}},
+		},
+		{
+			name:      "file ending with an empty block",
+			module:    config.TFTPProbe{Filename: "aligned"},
+			success:   true,
+			metrics:   map[string]float64{"probe_tftp_transfer_size_bytes": 2 * tftpBlockSize},
+			durations: map[string]struct{}{"first_block": {}, "transfer": {}},
+		},
+		{
+			name:      "unexpected content",
+			module:    config.TFTPProbe{Filename: "aligned", Expect: expect},
+			durations: map[string]struct{}{"first_block": {}},
+		},
+		{
+			name:      "missing file",
+			module:    config.TFTPProbe{Filename: "missing"},
+			metrics:   map[string]float64{"probe_tftp_error_code": 1},
+			durations: map[string]struct{}{"first_block": {}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.module.IPProtocol = "ip4"
+			registry := prometheus.NewRegistry()
+			if ProbeTFTP(testCTX, conn.LocalAddr().String(), config.Module{TFTP: tc.module}, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMetrics(map[string]map[string]map[string]struct{}{"probe_tftp_duration_seconds": {"phase": tc.durations}}, mfs, t)
+			if tc.metrics != nil {
+				checkRegistryResults(tc.metrics, mfs, t)
+			}
+		})
+	}
+}
@@ -14,11 +14,18 @@
 package prober
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"strings"
 	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
 )
 
 func getEarliestCertExpiry(state *tls.ConnectionState) time.Time {
@@ -87,3 +94,60 @@ func getTLSVersion(state *tls.ConnectionState) string {
 func getTLSCipher(state *tls.ConnectionState) string {
 	return tls.CipherSuiteName(state.CipherSuite)
 }
+
+// ProbeTLS connects to the target and performs a TLS handshake without
+// sending any application data.
+func ProbeTLS(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeTLSHandshakeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tls_handshake_duration_seconds",
+		Help: "Duration of the TLS handshake",
+	})
+	registry.MustRegister(probeTLSHandshakeDuration)
+
+	tlsConfig, err := newTargetTLSConfig(&module.TLS.TLSConfig, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error creating TLS configuration", "err", err)
+		return false
+	}
+	conn, _, err := dialTCPTarget(ctx, target, module.TLS.IPProtocol, module.TLS.IPProtocolFallback, module.TLS.SourceIPAddress, registry, logger)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	start := time.Now()
+	err = tlsConn.HandshakeContext(ctx)
+	probeTLSHandshakeDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		level.Error(logger).Log("msg", "TLS handshake failed", "err", err)
+		return false
+	}
+	level.Info(logger).Log("msg", "TLS handshake succeeded")
+
+	state := tlsConn.ConnectionState()
+	reportTLSConnectionState(&state, registry)
+	return true
+}
+
+// reportTLSConnectionState exports the certificate, version and cipher
+// metrics of an established TLS connection.
+func reportTLSConnectionState(state *tls.ConnectionState, registry *prometheus.Registry) {
+	probeSSLEarliestCertExpiry := prometheus.NewGauge(sslEarliestCertExpiryGaugeOpts)
+	probeSSLLastChainExpiryTimestampSeconds := prometheus.NewGauge(sslChainExpiryInTimeStampGaugeOpts)
+	probeSSLLastInformation := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_ssl_last_chain_info",
+			Help: "Contains SSL leaf certificate information",
+		},
+		[]string{"fingerprint_sha256", "subject", "issuer", "subjectalternative"},
+	)
+	probeTLSVersion := prometheus.NewGaugeVec(probeTLSInfoGaugeOpts, []string{"version"})
+	probeTLSCipher := prometheus.NewGaugeVec(probeTLSCipherGaugeOpts, []string{"cipher"})
+	registry.MustRegister(probeSSLEarliestCertExpiry, probeSSLLastChainExpiryTimestampSeconds, probeSSLLastInformation, probeTLSVersion, probeTLSCipher)
+	probeSSLEarliestCertExpiry.Set(float64(getEarliestCertExpiry(state).Unix()))
+	probeSSLLastChainExpiryTimestampSeconds.Set(float64(getLastChainExpiry(state).Unix()))
+	probeSSLLastInformation.WithLabelValues(getFingerprint(state), getSubject(state), getIssuer(state), getDNSNames(state)).Set(1)
+	probeTLSVersion.WithLabelValues(getTLSVersion(state)).Set(1)
+	probeTLSCipher.WithLabelValues(getTLSCipher(state)).Set(1)
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestTLSHandshake(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+	_, listenPort, _ := net.SplitHostPort(ln.Addr().String())
+
+	certExpiry := time.Now().AddDate(0, 0, 1)
+	rootCertTmpl := generateCertificateTemplate(certExpiry, false)
+	rootCertTmpl.IsCA = true
+	_, rootCertPem, rootKey := generateSelfSignedCertificate(rootCertTmpl)
+	rootKeyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rootKey)})
+	testcert, err := tls.X509KeyPair(rootCertPem, rootKeyPem)
+	if err != nil {
+		t.Fatalf("Failed to decode TLS testing keypair: %s", err)
+	}
+
+	tmpCaFile, err := os.CreateTemp("", "cafile.pem")
+	if err != nil {
+		t.Fatalf("Error creating CA tempfile: %s", err)
+	}
+	if _, err := tmpCaFile.Write(rootCertPem); err != nil {
+		t.Fatalf("Error writing CA tempfile: %s", err)
+	}
+	if err := tmpCaFile.Close(); err != nil {
+		t.Fatalf("Error closing CA tempfile: %s", err)
+	}
+	defer os.Remove(tmpCaFile.Name())
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := tls.Server(conn, &tls.Config{
+				Certificates: []tls.Certificate{testcert},
+				MinVersion:   tls.VersionTLS12,
+				MaxVersion:   tls.VersionTLS12,
+			})
+			tlsConn.Handshake()
+			tlsConn.Close()
+		}
+	}()
+
+	module := config.Module{
+		TLS: config.TLSProbe{
+			IPProtocol:         "ip4",
+			IPProtocolFallback: true,
+			TLSConfig: pconfig.TLSConfig{
+				CAFile: tmpCaFile.Name(),
+			},
+		},
+	}
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The certificate does not contain the IP address.
+	registry := prometheus.NewRegistry()
+	if ProbeTLS(testCTX, ln.Addr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TLS module succeeded, expected failure.")
+	}
+
+	registry = prometheus.NewRegistry()
+	if !ProbeTLS(testCTX, net.JoinHostPort("localhost", listenPort), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TLS module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := map[string]map[string]string{
+		"probe_tls_version_info": {
+			"version": "TLS 1.2",
+		},
+	}
+	checkRegistryLabels(expectedLabels, mfs, t)
+	expectedResults := map[string]float64{
+		"probe_ssl_earliest_cert_expiry": float64(certExpiry.Unix()),
+		"probe_ssl_last_chain_info":      1,
+		"probe_tls_version_info":         1,
+		"probe_tls_cipher_info":          1,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+	for _, mf := range mfs {
+		if mf.GetName() == "probe_tls_handshake_duration_seconds" && mf.GetMetric()[0].GetGauge().GetValue() <= 0 {
+			t.Fatalf("Expected a positive handshake duration")
+		}
+	}
+}
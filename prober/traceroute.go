@@ -0,0 +1,189 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// tracerouteReply is the answer to an echo request sent with a limited TTL.
+type tracerouteReply struct {
+	peer    net.IP
+	reached bool
+}
+
+// echoIDSeq returns the identifier and sequence number of the echo request
+// quoted in an ICMP error message, which starts with the IP header of the
+// request.
+func echoIDSeq(data []byte, ipv6Packet bool) (int, int, bool) {
+	headerLen := ipv6.HeaderLen
+	if !ipv6Packet {
+		if len(data) < ipv4.HeaderLen {
+			return 0, 0, false
+		}
+		headerLen = int(data[0]&0x0f) * 4
+	}
+	if len(data) < headerLen+8 {
+		return 0, 0, false
+	}
+	echo := data[headerLen:]
+	return int(binary.BigEndian.Uint16(echo[4:6])), int(binary.BigEndian.Uint16(echo[6:8])), true
+}
+
+// ProbeTraceroute sends echo requests with increasing TTLs and records the
+// routers answering with a time exceeded message, until the target replies.
+func ProbeTraceroute(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeHopCount := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_traceroute_hop_count",
+		Help: "Number of hops to the target, or the number of hops probed if it was not reached",
+	})
+	probeDestinationReached := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_traceroute_destination_reached",
+		Help: "Whether the target replied to an echo request",
+	})
+	probeHopRTT := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_traceroute_hop_rtt_seconds",
+		Help: "Round trip time to each responding hop, by hop number and address",
+	}, []string{"hop", "address"})
+	registry.MustRegister(probeHopCount, probeDestinationReached, probeHopRTT)
+
+	dstIPAddr, _, err := chooseProtocol(ctx, module.Traceroute.IPProtocol, module.Traceroute.IPProtocolFallback, target, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+	srcIP, err := chooseSourceIP(module.Traceroute.SourceIPAddress, "", dstIPAddr.IP, logger)
+	if err != nil {
+		return false
+	}
+
+	isIPv6 := dstIPAddr.IP.To4() == nil
+	network, requestType, proto := "ip4:icmp", icmp.Type(ipv4.ICMPTypeEcho), 1
+	if isIPv6 {
+		network, requestType, proto = "ip6:ipv6-icmp", ipv6.ICMPTypeEchoRequest, 58
+	}
+	listenAddress := ""
+	if srcIP != nil {
+		listenAddress = srcIP.String()
+	} else if isIPv6 {
+		listenAddress = "::"
+	} else {
+		listenAddress = "0.0.0.0"
+	}
+	// Time exceeded messages can only be received on raw sockets.
+	conn, err := icmp.ListenPacket(network, listenAddress)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error listening to socket", "err", err)
+		return false
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	rb := make([]byte, 1500)
+	for ttl := 1; ttl <= module.Traceroute.MaxHops; ttl++ {
+		if isIPv6 {
+			err = conn.IPv6PacketConn().SetHopLimit(ttl)
+		} else {
+			err = conn.IPv4PacketConn().SetTTL(ttl)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "Error setting TTL", "err", err)
+			return false
+		}
+
+		seq := int(getICMPSequence())
+		wm := icmp.Message{
+			Type: requestType,
+			Body: &icmp.Echo{ID: icmpID, Seq: seq, Data: []byte("Prometheus Blackbox Exporter")},
+		}
+		wb, err := wm.Marshal(nil)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error marshalling packet", "err", err)
+			return false
+		}
+		hopDeadline := time.Now().Add(module.Traceroute.HopTimeout)
+		if !deadline.IsZero() && deadline.Before(hopDeadline) {
+			hopDeadline = deadline
+		}
+		if err := conn.SetReadDeadline(hopDeadline); err != nil {
+			level.Error(logger).Log("msg", "Error setting socket deadline", "err", err)
+			return false
+		}
+
+		start := time.Now()
+		if _, err := conn.WriteTo(wb, dstIPAddr); err != nil {
+			level.Error(logger).Log("msg", "Error writing to socket", "err", err)
+			return false
+		}
+		probeHopCount.Set(float64(ttl))
+
+		reply, err := readTracerouteReply(conn, rb, proto, isIPv6, dstIPAddr.IP, seq)
+		if err != nil {
+			if ctx.Err() != nil {
+				level.Error(logger).Log("msg", "Probe timed out", "hop", ttl)
+				return false
+			}
+			level.Info(logger).Log("msg", "No reply from hop", "hop", ttl, "err", err)
+			continue
+		}
+		rtt := time.Since(start).Seconds()
+		level.Info(logger).Log("msg", "Reply from hop", "hop", ttl, "address", reply.peer, "rtt", rtt)
+		probeHopRTT.WithLabelValues(strconv.Itoa(ttl), reply.peer.String()).Set(rtt)
+		if reply.reached {
+			probeDestinationReached.Set(1)
+			return true
+		}
+	}
+	level.Error(logger).Log("msg", "Target not reached", "max_hops", module.Traceroute.MaxHops)
+	return false
+}
+
+// readTracerouteReply waits for the echo reply or the time exceeded message
+// answering the echo request with the sequence number.
+func readTracerouteReply(conn *icmp.PacketConn, rb []byte, proto int, isIPv6 bool, dst net.IP, seq int) (*tracerouteReply, error) {
+	for {
+		n, peer, err := conn.ReadFrom(rb)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := icmp.ParseMessage(proto, rb[:n])
+		if err != nil {
+			continue
+		}
+		peerIP := peer.(*net.IPAddr).IP
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if (msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply) && body.ID == icmpID && body.Seq == seq && peerIP.Equal(dst) {
+				return &tracerouteReply{peer: peerIP, reached: true}, nil
+			}
+		case *icmp.TimeExceeded:
+			if id, replySeq, ok := echoIDSeq(body.Data, isIPv6); ok && id == icmpID && replySeq == seq {
+				return &tracerouteReply{peer: peerIP}, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestTracerouteLocalhost(t *testing.T) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "127.0.0.1")
+	if err != nil {
+		t.Skip("Raw ICMP sockets are not available")
+	}
+	conn.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	module := config.Module{Traceroute: config.TracerouteProbe{IPProtocol: "ip4", MaxHops: 5, HopTimeout: time.Second}}
+	if !ProbeTraceroute(testCTX, "127.0.0.1", module, registry, log.NewNopLogger()) {
+		t.Fatalf("Traceroute module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := map[string]float64{
+		"probe_traceroute_hop_count":           1,
+		"probe_traceroute_destination_reached": 1,
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+	expectedLabels := map[string]map[string]string{
+		"probe_traceroute_hop_rtt_seconds": {
+			"hop":     "1",
+			"address": "127.0.0.1",
+		},
+	}
+	checkRegistryLabels(expectedLabels, mfs, t)
+}
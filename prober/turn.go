@@ -0,0 +1,155 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+const (
+	turnAllocate = 0x0003
+	turnRefresh  = 0x0004
+
+	stunAttrUsername           = 0x0006
+	stunAttrLifetime           = 0x000d
+	stunAttrXORRelayedAddress  = 0x0016
+	stunAttrRealm              = 0x0014
+	stunAttrNonce              = 0x0015
+	stunAttrRequestedTransport = 0x0019
+
+	turnUnauthorized = 401
+	turnStaleNonce   = 438
+)
+
+// turnClient sends requests authenticated with the long-term credential
+// mechanism of RFC 8489, learning realm and nonce from the server.
+type turnClient struct {
+	conn     net.Conn
+	username string
+	password string
+	realm    []byte
+	nonce    []byte
+	key      []byte
+}
+
+// request sends a request, repeating it with credentials if the server asks
+// for them or the nonce became stale.
+func (c *turnClient) request(method uint16, attributes ...stunAttribute) (*stunMessage, error) {
+	for attempt := 0; ; attempt++ {
+		request := newSTUNRequest(method, attributes...)
+		if c.key != nil {
+			request.attributes = append(request.attributes,
+				stunAttribute{typ: stunAttrUsername, value: []byte(c.username)},
+				stunAttribute{typ: stunAttrRealm, value: c.realm},
+				stunAttribute{typ: stunAttrNonce, value: c.nonce},
+			)
+		}
+		response, err := stunRoundTrip(c.conn, request, c.key)
+		var responseErr *stunError
+		if attempt > 0 || !errors.As(err, &responseErr) {
+			return response, err
+		}
+		if responseErr.code != turnStaleNonce && (responseErr.code != turnUnauthorized || c.key != nil || c.username == "") {
+			return response, err
+		}
+		realm, _ := response.get(stunAttrRealm)
+		nonce, _ := response.get(stunAttrNonce)
+		if len(realm) == 0 || len(nonce) == 0 {
+			return response, err
+		}
+		c.realm, c.nonce = realm, nonce
+		key := md5.Sum([]byte(c.username + ":" + string(realm) + ":" + c.password))
+		c.key = key[:]
+	}
+}
+
+// ProbeTURN allocates a relayed transport address on a TURN server and
+// releases it again.
+func ProbeTURN(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeTURNDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_turn_duration_seconds",
+		Help: "Duration of each phase of the TURN allocation",
+	}, []string{"phase"})
+	probeTURNRelayedAddressIPProtocol := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_turn_relayed_address_ip_protocol",
+		Help: "Specifies whether the relayed transport address is IPv4 or IPv6",
+	})
+	probeTURNLifetime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_turn_allocation_lifetime_seconds",
+		Help: "Lifetime of the allocation granted by the server",
+	})
+	probeTURNErrorCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_turn_error_code",
+		Help: "Code of the error response of the server",
+	})
+	registry.MustRegister(probeTURNDuration)
+
+	// step runs and times one phase of the allocation.
+	step := newStepTimer(probeTURNDuration, "phase", "TURN phase", logger, func(err error) {
+		var responseErr *stunError
+		if errors.As(err, &responseErr) {
+			registry.MustRegister(probeTURNErrorCode)
+			probeTURNErrorCode.Set(float64(responseErr.code))
+		}
+	})
+
+	conn, err := dialUDPTarget(ctx, target, module.TURN.IPProtocol, module.TURN.IPProtocolFallback, module.TURN.SourceIPAddress, registry, logger)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	c := &turnClient{conn: conn, username: module.TURN.Username, password: string(module.TURN.Password)}
+
+	if !step("allocate", func() error {
+		// The relayed transport address is requested for UDP.
+		response, err := c.request(turnAllocate, stunAttribute{typ: stunAttrRequestedTransport, value: []byte{17, 0, 0, 0}})
+		if err != nil {
+			return err
+		}
+		address, err := response.address(stunAttrXORRelayedAddress, true)
+		if err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "Allocated relayed transport address", "address", address)
+		registry.MustRegister(probeTURNRelayedAddressIPProtocol)
+		if address.IP.To4() != nil {
+			probeTURNRelayedAddressIPProtocol.Set(4)
+		} else {
+			probeTURNRelayedAddressIPProtocol.Set(6)
+		}
+		if lifetime, ok := response.get(stunAttrLifetime); ok && len(lifetime) == 4 {
+			registry.MustRegister(probeTURNLifetime)
+			probeTURNLifetime.Set(float64(binary.BigEndian.Uint32(lifetime)))
+		}
+		return nil
+	}) {
+		return false
+	}
+
+	// A lifetime of zero deletes the allocation.
+	return step("refresh", func() error {
+		_, err := c.request(turnRefresh, stunAttribute{typ: stunAttrLifetime, value: []byte{0, 0, 0, 0}})
+		return err
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// turnTestHandler answers as a TURN server with the realm "example.org",
+// accepting the user "prober" with the password "secret".
+func turnTestHandler(t *testing.T) func(request *stunMessage, addr *net.UDPAddr) (*stunMessage, []byte) {
+	key := md5.Sum([]byte("prober:example.org:secret"))
+	return func(request *stunMessage, addr *net.UDPAddr) (*stunMessage, []byte) {
+		method := request.typ
+		unauthorized := &stunMessage{typ: method | stunClassError, attributes: []stunAttribute{
+			{typ: stunAttrErrorCode, value: []byte("\x00\x00\x04\x01Unauthorized")},
+			{typ: stunAttrRealm, value: []byte("example.org")},
+			{typ: stunAttrNonce, value: []byte("f00d")},
+		}}
+		integrity, ok := request.get(stunAttrMessageIntegrity)
+		if !ok || request.attributes[len(request.attributes)-1].typ != stunAttrMessageIntegrity {
+			return unauthorized, nil
+		}
+		// The digest is reproduced by encoding the preceding attributes.
+		unsigned := &stunMessage{typ: method, transactionID: request.transactionID, attributes: request.attributes[:len(request.attributes)-1]}
+		encoded := unsigned.encode(key[:])
+		if username, _ := request.get(stunAttrUsername); string(username) != "prober" || !bytes.Equal(encoded[len(encoded)-len(integrity):], integrity) {
+			return unauthorized, nil
+		}
+
+		response := &stunMessage{typ: method | stunClassSuccess, transactionID: request.transactionID}
+		switch method {
+		case turnAllocate:
+			if transport, _ := request.get(stunAttrRequestedTransport); !bytes.Equal(transport, []byte{17, 0, 0, 0}) {
+				t.Errorf("Unexpected requested transport %x", transport)
+			}
+			response.attributes = []stunAttribute{
+				stunTestAddress(response, stunAttrXORRelayedAddress, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 49152}, true),
+				stunTestAddress(response, stunAttrXORMappedAddress, addr, true),
+				{typ: stunAttrLifetime, value: []byte{0, 0, 0x02, 0x58}},
+			}
+		case turnRefresh:
+			if lifetime, _ := request.get(stunAttrLifetime); !bytes.Equal(lifetime, []byte{0, 0, 0, 0}) {
+				t.Errorf("Unexpected lifetime %x", lifetime)
+			}
+			response.attributes = []stunAttribute{{typ: stunAttrLifetime, value: []byte{0, 0, 0, 0}}}
+		default:
+			t.Errorf("Unexpected method 0x%04x", method)
+		}
+		return response, key[:]
+	}
+}
+
+func TestTURNAllocation(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer conn.Close()
+	go serveSTUN(t, conn, turnTestHandler(t))
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	module := config.Module{TURN: config.TURNProbe{IPProtocol: "ip4", Username: "prober", Password: "secret"}}
+	registry := prometheus.NewRegistry()
+	if !ProbeTURN(testCTX, conn.LocalAddr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TURN module failed, expected success.")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := map[string]map[string]map[string]struct{}{
+		"probe_turn_duration_seconds": {
+			"phase": {
+				"allocate": {},
+				"refresh":  {},
+			},
+		},
+	}
+	checkMetrics(expectedMetrics, mfs, t)
+	checkRegistryResults(map[string]float64{
+		"probe_turn_relayed_address_ip_protocol": 4,
+		"probe_turn_allocation_lifetime_seconds": 600,
+	}, mfs, t)
+
+	module.TURN.Password = "wrong"
+	registry = prometheus.NewRegistry()
+	if ProbeTURN(testCTX, conn.LocalAddr().String(), module, registry, log.NewNopLogger()) {
+		t.Fatalf("TURN module succeeded with a wrong password, expected failure.")
+	}
+	mfs, err = registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults(map[string]float64{"probe_turn_error_code": 401}, mfs, t)
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// maxUDPPacketSize is the largest UDP payload which can be received.
+const maxUDPPacketSize = 65535
+
+// ProbeUDP sends a datagram to the target and checks the response, resending
+// the datagram up to retries times when no response arrives within
+// packet_timeout.
+func ProbeUDP(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeUDPAttempts := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_udp_attempts",
+		Help: "Number of datagrams sent until a response was received",
+	})
+	probeUDPResponseDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_udp_response_duration_seconds",
+		Help: "Time between sending the last datagram and receiving the response",
+	})
+	probeFailedDueToRegex := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_failed_due_to_regex",
+		Help: "Indicates if probe failed due to regex",
+	})
+	registry.MustRegister(probeUDPAttempts, probeUDPResponseDuration, probeFailedDueToRegex)
+
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
+		return false
+	}
+
+	ip, _, err := chooseProtocol(ctx, module.UDP.IPProtocol, module.UDP.IPProtocolFallback, targetAddress, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return false
+	}
+
+	dialer := &net.Dialer{}
+	srcIP, err := chooseSourceIP(module.UDP.SourceIPAddress, "", ip.IP, logger)
+	if err != nil {
+		return false
+	}
+	if srcIP != nil {
+		level.Info(logger).Log("msg", "Using local address", "srcIP", srcIP)
+		dialer.LocalAddr = &net.UDPAddr{IP: srcIP}
+	}
+
+	dialProtocol := "udp4"
+	if ip.IP.To4() == nil {
+		dialProtocol = "udp6"
+	}
+	conn, err := dialer.DialContext(ctx, dialProtocol, net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		level.Error(logger).Log("msg", "Error dialing UDP", "err", err)
+		return false
+	}
+	defer conn.Close()
+
+	payload := []byte(module.UDP.Send)
+	if len(module.UDP.SendHex) > 0 {
+		payload = module.UDP.SendHex
+	}
+
+	deadline, _ := ctx.Deadline()
+	buf := make([]byte, maxUDPPacketSize)
+	var response []byte
+	for attempt := 0; attempt <= module.UDP.Retries; attempt++ {
+		readDeadline := deadline
+		if module.UDP.PacketTimeout > 0 {
+			if d := time.Now().Add(module.UDP.PacketTimeout); d.Before(readDeadline) {
+				readDeadline = d
+			}
+		}
+		if err := conn.SetDeadline(readDeadline); err != nil {
+			level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+			return false
+		}
+
+		level.Info(logger).Log("msg", "Sending datagram", "attempt", attempt+1, "length", len(payload))
+		start := time.Now()
+		probeUDPAttempts.Inc()
+		if _, err := conn.Write(payload); err != nil {
+			level.Error(logger).Log("msg", "Error sending datagram", "err", err)
+			return false
+		}
+
+		n, err := conn.Read(buf)
+		if err == nil {
+			probeUDPResponseDuration.Set(time.Since(start).Seconds())
+			response = buf[:n]
+			break
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) || ctx.Err() != nil {
+			level.Error(logger).Log("msg", "Error reading response", "err", err)
+			return false
+		}
+		level.Info(logger).Log("msg", "Timeout waiting for response", "attempt", attempt+1)
+	}
+	if response == nil {
+		level.Error(logger).Log("msg", "No response received", "attempts", module.UDP.Retries+1)
+		return false
+	}
+	level.Info(logger).Log("msg", "Received response", "length", len(response))
+
+	if len(module.UDP.ExpectHex) > 0 && !bytes.HasPrefix(response, module.UDP.ExpectHex) {
+		level.Error(logger).Log("msg", "Response did not match expect_hex", "expected", fmt.Sprintf("%x", []byte(module.UDP.ExpectHex)), "received", fmt.Sprintf("%x", response))
+		return false
+	}
+	if module.UDP.Expect.Regexp != nil {
+		if !module.UDP.Expect.Match(response) {
+			level.Error(logger).Log("msg", "Regexp did not match", "regexp", module.UDP.Expect.Regexp)
+			probeFailedDueToRegex.Set(1)
+			return false
+		}
+		level.Info(logger).Log("msg", "Regexp matched", "regexp", module.UDP.Expect.Regexp)
+	}
+	return true
+}
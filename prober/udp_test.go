@@ -0,0 +1,117 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// serveUDPEcho answers every datagram on conn with the reply, after ignoring
+// the first drop datagrams.
+func serveUDPEcho(conn net.PacketConn, reply []byte, drop int) {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		_, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if drop > 0 {
+			drop--
+			continue
+		}
+		conn.WriteTo(reply, addr)
+	}
+}
+
+func TestUDPProbe(t *testing.T) {
+	tests := []struct {
+		name             string
+		reply            string
+		drop             int
+		probe            config.UDPProbe
+		success          bool
+		expectedAttempts float64
+	}{
+		{
+			name:             "expect regexp",
+			reply:            "pong v1.2",
+			probe:            config.UDPProbe{Send: "ping", Expect: config.MustNewRegexp("^pong v[0-9.]+$")},
+			success:          true,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "expect regexp mismatch",
+			reply:            "error",
+			probe:            config.UDPProbe{Send: "ping", Expect: config.MustNewRegexp("^pong")},
+			success:          false,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "expect_hex prefix",
+			reply:            "\xff\xff\xff\xffinfoResponse",
+			probe:            config.UDPProbe{SendHex: config.HexBytes("\xff\xff\xff\xffgetinfo"), ExpectHex: config.HexBytes("\xff\xff\xff\xff")},
+			success:          true,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "retries",
+			reply:            "pong",
+			drop:             2,
+			probe:            config.UDPProbe{Send: "ping", PacketTimeout: 100 * time.Millisecond, Retries: 2},
+			success:          true,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "retries exhausted",
+			reply:            "pong",
+			drop:             3,
+			probe:            config.UDPProbe{Send: "ping", PacketTimeout: 100 * time.Millisecond, Retries: 2},
+			success:          false,
+			expectedAttempts: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Error listening on socket: %s", err)
+			}
+			defer conn.Close()
+			go serveUDPEcho(conn, []byte(test.reply), test.drop)
+
+			test.probe.IPProtocol = "ip4"
+			test.probe.IPProtocolFallback = true
+			testCTX, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			registry := prometheus.NewRegistry()
+			if ProbeUDP(testCTX, conn.LocalAddr().String(), config.Module{UDP: test.probe}, registry, log.NewNopLogger()) != test.success {
+				t.Fatalf("UDP module returned %t, expected %t", !test.success, test.success)
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{"probe_udp_attempts": test.expectedAttempts}, mfs, t)
+		})
+	}
+}
@@ -15,15 +15,20 @@ package prober
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"net"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 
 	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
 )
 
 var protocolToGauge = map[string]float64{
@@ -52,6 +57,16 @@ func chooseProtocol(ctx context.Context, IPProtocol string, fallbackIPProtocol b
 	registry.MustRegister(probeDNSLookupTimeSeconds)
 	registry.MustRegister(probeIPAddrHash)
 
+	defer func() {
+		if err == nil && ip != nil {
+			if err = checkTargetAddr(ctx, ip.IP); err != nil {
+				level.Error(logger).Log("msg", "Resolved target address is not allowed", "target", target, "ip", ip.String())
+				reportTargetDenied(registry)
+				ip = nil
+			}
+		}
+	}()
+
 	if IPProtocol == "ip6" || IPProtocol == "" {
 		IPProtocol = "ip6"
 		fallbackProtocol = "ip4"
@@ -78,6 +93,8 @@ func chooseProtocol(ctx context.Context, IPProtocol string, fallbackIPProtocol b
 				probeIPAddrHash.Set(ipHash(ip))
 				return &net.IPAddr{IP: ip}, lookupTime, nil
 			}
+			// The lookup can succeed without returning an address.
+			err = &net.DNSError{Err: "no such host", Name: target, IsNotFound: true}
 		}
 		level.Error(logger).Log("msg", "Resolution with IP protocol failed", "target", target, "ip_protocol", IPProtocol, "err", err)
 		return nil, 0.0, err
@@ -133,6 +150,63 @@ func chooseProtocol(ctx context.Context, IPProtocol string, fallbackIPProtocol b
 	return fallback, lookupTime, nil
 }
 
+// chooseSourceIP returns the local address a probe to dstIP should be sent
+// from, either the configured source IP address or an address of the same
+// family as dstIP on the configured interface. It returns nil if neither is
+// configured.
+func chooseSourceIP(sourceIPAddress, sourceInterface string, dstIP net.IP, logger log.Logger) (net.IP, error) {
+	if len(sourceIPAddress) > 0 {
+		srcIP := net.ParseIP(sourceIPAddress)
+		if srcIP == nil {
+			level.Error(logger).Log("msg", "Error parsing source ip address", "srcIP", sourceIPAddress)
+			return nil, fmt.Errorf("error parsing source ip address: %s", sourceIPAddress)
+		}
+		if dstIP != nil && (srcIP.To4() == nil) != (dstIP.To4() == nil) {
+			level.Error(logger).Log("msg", "Source ip address does not match the IP protocol of the target", "srcIP", srcIP, "dstIP", dstIP)
+			return nil, fmt.Errorf("source ip address %s does not match the IP protocol of target %s", srcIP, dstIP)
+		}
+		return srcIP, nil
+	}
+	if len(sourceInterface) == 0 {
+		return nil, nil
+	}
+
+	iface, err := net.InterfaceByName(sourceInterface)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error looking up source interface", "interface", sourceInterface, "err", err)
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		level.Error(logger).Log("msg", "Error listing source interface addresses", "interface", sourceInterface, "err", err)
+		return nil, err
+	}
+	var fallback net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if dstIP != nil && (ipNet.IP.To4() == nil) != (dstIP.To4() == nil) {
+			continue
+		}
+		// Prefer addresses which can reach beyond the local link.
+		if !ipNet.IP.IsLinkLocalUnicast() {
+			level.Info(logger).Log("msg", "Using source interface address", "interface", sourceInterface, "srcIP", ipNet.IP)
+			return ipNet.IP, nil
+		}
+		if fallback == nil {
+			fallback = ipNet.IP
+		}
+	}
+	if fallback == nil {
+		level.Error(logger).Log("msg", "No suitable address found on source interface", "interface", sourceInterface)
+		return nil, fmt.Errorf("no suitable address found on interface %s", sourceInterface)
+	}
+	level.Info(logger).Log("msg", "Using source interface address", "interface", sourceInterface, "srcIP", fallback)
+	return fallback, nil
+}
+
 func ipHash(ip net.IP) float64 {
 	h := fnv.New32a()
 	if ip.To4() != nil {
@@ -142,3 +216,239 @@ func ipHash(ip net.IP) float64 {
 	}
 	return float64(h.Sum32())
 }
+
+// durationStats summarizes a series of durations in seconds.
+type durationStats struct {
+	min, max, avg, stddev float64
+	// jitter is the mean difference between consecutive durations.
+	jitter float64
+}
+
+func computeDurationStats(durations []float64) durationStats {
+	if len(durations) == 0 {
+		return durationStats{}
+	}
+	stats := durationStats{min: durations[0], max: durations[0]}
+	sum := 0.0
+	for i, duration := range durations {
+		stats.min = math.Min(stats.min, duration)
+		stats.max = math.Max(stats.max, duration)
+		sum += duration
+		if i > 0 {
+			stats.jitter += math.Abs(duration - durations[i-1])
+		}
+	}
+	n := float64(len(durations))
+	stats.avg = sum / n
+	if len(durations) > 1 {
+		stats.jitter /= n - 1
+	}
+	variance := 0.0
+	for _, duration := range durations {
+		variance += (duration - stats.avg) * (duration - stats.avg)
+	}
+	stats.stddev = math.Sqrt(variance / n)
+	return stats
+}
+
+// onLinkInterface returns the Ethernet interface dstIP is directly connected
+// to, or the named one, along with its address to send packets to dstIP from.
+// Link-local addresses are only used for link-local targets.
+func onLinkInterface(name string, dstIP net.IP) (*net.Interface, net.IP, error) {
+	var ifaces []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		ifaces = []net.Interface{*iface}
+	} else {
+		if dstIP.IsLinkLocalUnicast() {
+			return nil, nil, fmt.Errorf("an interface is required for link-local target %s", dstIP)
+		}
+		var err error
+		if ifaces, err = net.Interfaces(); err != nil {
+			return nil, nil, err
+		}
+	}
+	isIPv4 := dstIP.To4() != nil
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, nil, err
+		}
+		var fallback net.IP
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || (ipNet.IP.To4() != nil) != isIPv4 || ipNet.IP.IsLinkLocalUnicast() != dstIP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ipNet.Contains(dstIP) {
+				return iface, ipNet.IP, nil
+			}
+			if fallback == nil {
+				fallback = ipNet.IP
+			}
+		}
+		// A named interface may be used for targets outside of its subnets.
+		if name != "" && fallback != nil {
+			return iface, fallback, nil
+		}
+	}
+	if name != "" {
+		return nil, nil, fmt.Errorf("no suitable address found on Ethernet interface %s", name)
+	}
+	return nil, nil, fmt.Errorf("%s is not on a directly connected Ethernet network", dstIP)
+}
+
+// dialTCPTarget resolves the host of a "host:port" target and connects to it,
+// returning the connection and the host. The connection uses the deadline of
+// ctx.
+func dialTCPTarget(ctx context.Context, target, ipProtocol string, ipProtocolFallback bool, sourceIPAddress string, registry *prometheus.Registry, logger log.Logger) (net.Conn, string, error) {
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
+		return nil, "", err
+	}
+	ip, _, err := chooseProtocol(ctx, ipProtocol, ipProtocolFallback, targetAddress, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return nil, "", err
+	}
+	dialer := &net.Dialer{}
+	srcIP, err := chooseSourceIP(sourceIPAddress, "", ip.IP, logger)
+	if err != nil {
+		return nil, "", err
+	}
+	if srcIP != nil {
+		level.Info(logger).Log("msg", "Using local address", "srcIP", srcIP)
+		dialer.LocalAddr = &net.TCPAddr{IP: srcIP}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		level.Error(logger).Log("msg", "Error dialing TCP", "err", err)
+		return nil, "", err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+			return nil, "", err
+		}
+	}
+	return conn, targetAddress, nil
+}
+
+// dialUDPTarget resolves the host of a "host:port" target and connects a UDP
+// socket to it. The connection uses the deadline of ctx.
+func dialUDPTarget(ctx context.Context, target, ipProtocol string, ipProtocolFallback bool, sourceIPAddress string, registry *prometheus.Registry, logger log.Logger) (net.Conn, error) {
+	targetAddress, port, err := net.SplitHostPort(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error splitting target address and port", "err", err)
+		return nil, err
+	}
+	ip, _, err := chooseProtocol(ctx, ipProtocol, ipProtocolFallback, targetAddress, registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return nil, err
+	}
+	dialer := &net.Dialer{}
+	srcIP, err := chooseSourceIP(sourceIPAddress, "", ip.IP, logger)
+	if err != nil {
+		return nil, err
+	}
+	if srcIP != nil {
+		level.Info(logger).Log("msg", "Using local address", "srcIP", srcIP)
+		dialer.LocalAddr = &net.UDPAddr{IP: srcIP}
+	}
+	dialProtocol := "udp4"
+	if ip.IP.To4() == nil {
+		dialProtocol = "udp6"
+	}
+	conn, err := dialer.DialContext(ctx, dialProtocol, net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		level.Error(logger).Log("msg", "Error dialing UDP", "err", err)
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			level.Error(logger).Log("msg", "Error setting deadline", "err", err)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// newHTTPClient creates a client for probers of HTTP based APIs. Connections
+// to the host of the target URL go to its resolved address, so the IP protocol
+// settings apply, while other hosts are resolved as usual.
+func newHTTPClient(ctx context.Context, targetURL *url.URL, ipProtocol string, ipProtocolFallback bool, sourceIPAddress string, httpClientConfig pconfig.HTTPClientConfig, name string, registry *prometheus.Registry, logger log.Logger) (*http.Client, error) {
+	ip, _, err := chooseProtocol(ctx, ipProtocol, ipProtocolFallback, targetURL.Hostname(), registry, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error resolving address", "err", err)
+		return nil, err
+	}
+	dialer := &net.Dialer{Control: targetFilterControl(ctx, registry)}
+	srcIP, err := chooseSourceIP(sourceIPAddress, "", ip.IP, logger)
+	if err != nil {
+		return nil, err
+	}
+	if srcIP != nil {
+		level.Info(logger).Log("msg", "Using local address", "srcIP", srcIP)
+		dialer.LocalAddr = &net.TCPAddr{IP: srcIP}
+	}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(address); err == nil && host == targetURL.Hostname() {
+			address = net.JoinHostPort(ip.String(), port)
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+	client, err := pconfig.NewClientFromConfig(httpClientConfig, name, pconfig.WithKeepAlivesDisabled(), pconfig.WithDialContextFunc(dial))
+	if err != nil {
+		level.Error(logger).Log("msg", "Error generating HTTP client", "err", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// newStepTimer returns a function that runs and times one step of a probe,
+// e.g. a command of a protocol session. The duration of the step is set in
+// durations with the step as value of label, and its outcome is logged as
+// what, e.g. "IMAP command". onError, if not nil, is called with the error
+// of a failed step, e.g. to export the error code of the protocol.
+func newStepTimer(durations *prometheus.GaugeVec, label, what string, logger log.Logger, onError func(error)) func(step string, f func() error) bool {
+	return func(step string, f func() error) bool {
+		start := time.Now()
+		err := f()
+		durations.WithLabelValues(step).Set(time.Since(start).Seconds())
+		if err != nil {
+			level.Error(logger).Log("msg", what+" failed", label, step, "err", err)
+			if onError != nil {
+				onError(err)
+			}
+			return false
+		}
+		level.Debug(logger).Log("msg", what+" succeeded", label, step)
+		return true
+	}
+}
+
+// newTargetTLSConfig returns the TLS configuration for connections to a
+// "host:port" target dialed with dialTCPTarget. The resolved IP address is
+// dialed, so the host of the target is set as server name, unless one is
+// configured, to enable hostname verification.
+func newTargetTLSConfig(cfg *pconfig.TLSConfig, target string) (*tls.Config, error) {
+	tlsConfig, err := pconfig.NewTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil && len(tlsConfig.ServerName) == 0 {
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig, nil
+}
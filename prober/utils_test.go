@@ -17,10 +17,13 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"net"
 	"os"
@@ -30,7 +33,9 @@ import (
 	"github.com/go-kit/log"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
+	pconfig "github.com/prometheus/common/config"
 )
 
 // Check if expected results are in the registry
@@ -103,6 +108,31 @@ func generateCertificateTemplate(expiry time.Time, IPAddressSAN bool) *x509.Cert
 	return template
 }
 
+// newTestTLSServerConfig returns the TLS configuration of a test server with
+// a certificate for localhost, and a CA file trusting it.
+func newTestTLSServerConfig(t *testing.T) (*tls.Config, string) {
+	certTmpl := generateCertificateTemplate(time.Now().AddDate(0, 0, 1), true)
+	certTmpl.IsCA = true
+	_, certPem, key := generateSelfSignedCertificate(certTmpl)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatalf("Failed to decode TLS testing keypair: %s", err)
+	}
+	caFile, err := os.CreateTemp("", "cafile.pem")
+	if err != nil {
+		t.Fatalf("Error creating CA tempfile: %s", err)
+	}
+	t.Cleanup(func() { os.Remove(caFile.Name()) })
+	if _, err := caFile.Write(certPem); err != nil {
+		t.Fatalf("Error writing CA tempfile: %s", err)
+	}
+	if err := caFile.Close(); err != nil {
+		t.Fatalf("Error closing CA tempfile: %s", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, caFile.Name()
+}
+
 func generateCertificate(template, parent *x509.Certificate, publickey *rsa.PublicKey, privatekey *rsa.PrivateKey) (*x509.Certificate, []byte) {
 	derCert, err := x509.CreateCertificate(rand.Reader, template, template, publickey, privatekey)
 	if err != nil {
@@ -173,6 +203,50 @@ func TestChooseProtocol(t *testing.T) {
 	}
 }
 
+func TestChooseSourceIP(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	srcIP, err := chooseSourceIP("", "", net.ParseIP("127.0.0.1"), logger)
+	if err != nil || srcIP != nil {
+		t.Fatalf("expected no source address, got %v, %v", srcIP, err)
+	}
+
+	srcIP, err = chooseSourceIP("127.0.0.1", "", net.ParseIP("127.0.0.1"), logger)
+	if err != nil || !srcIP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("expected 127.0.0.1, got %v, %v", srcIP, err)
+	}
+
+	if _, err := chooseSourceIP("::1", "", net.ParseIP("127.0.0.1"), logger); err == nil {
+		t.Fatalf("Expected an error for an IPv6 source address with an IPv4 target")
+	}
+	if _, err := chooseSourceIP("not-an-ip", "", net.ParseIP("127.0.0.1"), logger); err == nil {
+		t.Fatal("expected an error for an invalid source address")
+	}
+
+	if _, err := chooseSourceIP("", "does-not-exist0", net.ParseIP("127.0.0.1"), logger); err == nil {
+		t.Fatal("expected an error for an unknown interface")
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		srcIP, err := chooseSourceIP("", iface.Name, net.ParseIP("127.0.0.1"), logger)
+		if err != nil {
+			t.Fatalf("unexpected error for interface %s: %v", iface.Name, err)
+		}
+		if !srcIP.IsLoopback() || srcIP.To4() == nil {
+			t.Fatalf("expected an IPv4 loopback address on %s, got %v", iface.Name, srcIP)
+		}
+		return
+	}
+	t.Skip("skipping; no loopback interface found")
+}
+
 func checkMetrics(expected map[string]map[string]map[string]struct{}, mfs []*dto.MetricFamily, t *testing.T) {
 	type (
 		valueValidation struct {
@@ -252,3 +326,65 @@ func checkMetrics(expected map[string]map[string]map[string]struct{}, mfs []*dto
 		}
 	}
 }
+
+func TestComputeDurationStats(t *testing.T) {
+	stats := computeDurationStats([]float64{0.1, 0.3, 0.2, 0.2})
+	expected := durationStats{min: 0.1, max: 0.3, avg: 0.2, stddev: math.Sqrt(0.005), jitter: 0.1}
+	for name, values := range map[string][2]float64{
+		"min":    {expected.min, stats.min},
+		"max":    {expected.max, stats.max},
+		"avg":    {expected.avg, stats.avg},
+		"stddev": {expected.stddev, stats.stddev},
+		"jitter": {expected.jitter, stats.jitter},
+	} {
+		if math.Abs(values[0]-values[1]) > 1e-9 {
+			t.Errorf("Expected %s to be %v, got %v", name, values[0], values[1])
+		}
+	}
+	if stats := computeDurationStats(nil); stats != (durationStats{}) {
+		t.Errorf("Expected zero statistics without durations, got %+v", stats)
+	}
+}
+
+func TestStepTimer(t *testing.T) {
+	durations := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "probe_test_duration_seconds"}, []string{"phase"})
+	var failed error
+	step := newStepTimer(durations, "phase", "Test phase", log.NewNopLogger(), func(err error) { failed = err })
+
+	if !step("connect", func() error { return nil }) {
+		t.Fatal("Expected the step to succeed")
+	}
+	errRejected := errors.New("rejected")
+	if step("login", func() error { time.Sleep(10 * time.Millisecond); return errRejected }) {
+		t.Fatal("Expected the step to fail")
+	}
+	if failed != errRejected {
+		t.Errorf("Expected the error of the failed step, got %v", failed)
+	}
+	if d := testutil.ToFloat64(durations.WithLabelValues("login")); d < 0.01 {
+		t.Errorf("Expected the duration of the failed step, got %v", d)
+	}
+	if n := testutil.CollectAndCount(durations); n != 2 {
+		t.Errorf("Expected the durations of 2 steps, got %d", n)
+	}
+}
+
+func TestNewTargetTLSConfig(t *testing.T) {
+	for _, test := range []struct {
+		serverName string
+		target     string
+		expected   string
+	}{
+		{target: "example.com:993", expected: "example.com"},
+		{target: "[2001:db8::1]:993", expected: "2001:db8::1"},
+		{serverName: "mail.example.com", target: "example.com:993", expected: "mail.example.com"},
+	} {
+		tlsConfig, err := newTargetTLSConfig(&pconfig.TLSConfig{ServerName: test.serverName}, test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tlsConfig.ServerName != test.expected {
+			t.Errorf("Expected server name %q for %q, got %q", test.expected, test.target, tlsConfig.ServerName)
+		}
+	}
+}
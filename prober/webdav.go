@@ -0,0 +1,139 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// webdavPropfindBody asks for the properties present on files and
+// collections alike.
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getlastmodified/></prop></propfind>`
+
+// webdavMultistatus is the body of a 207 Multi-Status response.
+type webdavMultistatus struct {
+	XMLName   xml.Name `xml:"DAV: multistatus"`
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Status    string `xml:"DAV: status"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// webdavStatusOK reports whether an HTTP status line, e.g.
+// "HTTP/1.1 200 OK", has a 2xx status code.
+func webdavStatusOK(status string) bool {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return false
+	}
+	code, err := strconv.Atoi(fields[1])
+	return err == nil && code >= 200 && code < 300
+}
+
+// ProbeWebDAV issues a PROPFIND request for the target and checks that the
+// multistatus response describes the resources successfully.
+func ProbeWebDAV(ctx context.Context, target string, module config.Module, registry *prometheus.Registry, logger log.Logger) bool {
+	probeWebDAVDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_webdav_propfind_duration_seconds",
+		Help: "Duration of the PROPFIND request, including connection setup",
+	})
+	probeWebDAVStatusCode := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_webdav_status_code",
+		Help: "Response HTTP status code",
+	})
+	probeWebDAVResources := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_webdav_resources",
+		Help: "Number of resources in the multistatus response",
+	})
+	registry.MustRegister(probeWebDAVDuration, probeWebDAVStatusCode)
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not parse target URL", "err", err)
+		return false
+	}
+	client, err := newHTTPClient(ctx, targetURL, module.WebDAV.IPProtocol, module.WebDAV.IPProtocolFallback, module.WebDAV.SourceIPAddress, module.WebDAV.HTTPClientConfig, "webdav_probe", registry, logger)
+	if err != nil {
+		return false
+	}
+
+	var multistatus webdavMultistatus
+	start := time.Now()
+	err = func() error {
+		request, err := http.NewRequestWithContext(ctx, "PROPFIND", targetURL.String(), strings.NewReader(webdavPropfindBody))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("User-Agent", userAgentDefaultHeader)
+		request.Header.Set("Content-Type", "application/xml; charset=utf-8")
+		request.Header.Set("Depth", strconv.Itoa(module.WebDAV.Depth))
+		resp, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		probeWebDAVStatusCode.Set(float64(resp.StatusCode))
+		if resp.StatusCode != http.StatusMultiStatus {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&multistatus)
+	}()
+	probeWebDAVDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		level.Error(logger).Log("msg", "Error reading PROPFIND response", "err", err)
+		return false
+	}
+
+	registry.MustRegister(probeWebDAVResources)
+	probeWebDAVResources.Set(float64(len(multistatus.Responses)))
+	if len(multistatus.Responses) == 0 {
+		level.Error(logger).Log("msg", "Multistatus response contains no resources")
+		return false
+	}
+	for _, response := range multistatus.Responses {
+		// A resource succeeds as a whole or with at least one property, as
+		// properties like getlastmodified may be missing.
+		ok := webdavStatusOK(response.Status)
+		for _, propstat := range response.Propstats {
+			ok = ok || webdavStatusOK(propstat.Status)
+		}
+		if !ok {
+			level.Error(logger).Log("msg", "Resource has no successful status", "href", response.Href, "status", response.Status)
+			return false
+		}
+	}
+	level.Info(logger).Log("msg", "PROPFIND succeeded", "resources", len(multistatus.Responses))
+	return true
+}
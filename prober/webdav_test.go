@@ -0,0 +1,100 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestWebDAVProbe(t *testing.T) {
+	const resource = `<D:response><D:href>%s</D:href><D:propstat><D:prop><D:resourcetype/></D:prop><D:status>HTTP/1.1 %s</D:status></D:propstat>` +
+		`<D:propstat><D:prop><D:getlastmodified/></D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat></D:response>`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			t.Errorf("Unexpected method %s", r.Method)
+		}
+		if body, _ := io.ReadAll(r.Body); !strings.Contains(string(body), "<resourcetype/>") {
+			t.Errorf("Unexpected body %q", body)
+		}
+		if username, password, _ := r.BasicAuth(); username != "prober" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		status := "200 OK"
+		if r.URL.Path == "/broken/" {
+			status = "403 Forbidden"
+		} else if r.URL.Path != "/docs/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:">`)
+		fmt.Fprintf(w, resource, r.URL.Path, status)
+		if r.Header.Get("Depth") == "1" {
+			fmt.Fprintf(w, resource, r.URL.Path+"report.pdf", status)
+		}
+		fmt.Fprint(w, `</D:multistatus>`)
+	}))
+	defer ts.Close()
+
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name      string
+		path      string
+		depth     int
+		password  string
+		success   bool
+		resources float64
+	}{
+		{name: "collection", path: "/docs/", password: "secret", success: true, resources: 1},
+		{name: "members", path: "/docs/", depth: 1, password: "secret", success: true, resources: 2},
+		{name: "forbidden properties", path: "/broken/", password: "secret", resources: 1},
+		{name: "missing collection", path: "/missing/", password: "secret"},
+		{name: "wrong password", path: "/docs/", password: "wrong"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			httpClientConfig := pconfig.DefaultHTTPClientConfig
+			httpClientConfig.BasicAuth = &pconfig.BasicAuth{Username: "prober", Password: pconfig.Secret(tc.password)}
+			module := config.Module{WebDAV: config.WebDAVProbe{IPProtocol: "ip4", HTTPClientConfig: httpClientConfig, Depth: tc.depth}}
+			registry := prometheus.NewRegistry()
+			if ProbeWebDAV(testCTX, ts.URL+tc.path, module, registry, log.NewNopLogger()) != tc.success {
+				t.Fatalf("Expected success %v", tc.success)
+			}
+			if tc.resources == 0 {
+				return
+			}
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRegistryResults(map[string]float64{"probe_webdav_resources": tc.resources, "probe_webdav_status_code": http.StatusMultiStatus}, mfs, t)
+		})
+	}
+}
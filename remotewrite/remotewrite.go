@@ -0,0 +1,317 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotewrite pushes the results of probes to a Prometheus remote
+// write endpoint, so that exporters that cannot be scraped, e.g. behind NAT,
+// can still report.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// maxBatchSize is the maximum number of series sent in one request.
+const maxBatchSize = 500
+
+// minBackoff is the delay before the first retry of a failed request.
+const minBackoff = 100 * time.Millisecond
+
+type label struct {
+	name, value string
+}
+
+// series is a series with a single sample.
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// Queue buffers the results of probes and sends them to the remote write
+// endpoint of the current configuration, retrying failed requests.
+type Queue struct {
+	config func() *config.RemoteWriteConfig
+	logger log.Logger
+
+	mtx     sync.Mutex
+	pending []series
+	notify  chan struct{}
+
+	// client is the HTTP client for clientConfig.
+	client       *http.Client
+	clientConfig *config.RemoteWriteConfig
+
+	samplesSent    prometheus.Counter
+	samplesFailed  prometheus.Counter
+	samplesDropped prometheus.Counter
+}
+
+// NewQueue returns a queue for the remote write configuration returned by
+// cfg, which is nil if remote write is disabled.
+func NewQueue(cfg func() *config.RemoteWriteConfig, logger log.Logger, reg prometheus.Registerer) *Queue {
+	return &Queue{
+		config: cfg,
+		logger: logger,
+		notify: make(chan struct{}, 1),
+		samplesSent: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "blackbox_remote_write_samples_total",
+			Help: "Count of samples sent to the remote write endpoint",
+		}),
+		samplesFailed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "blackbox_remote_write_samples_failed_total",
+			Help: "Count of samples rejected by the remote write endpoint",
+		}),
+		samplesDropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "blackbox_remote_write_samples_dropped_total",
+			Help: "Count of samples dropped because the queue was full",
+		}),
+	}
+}
+
+// Push queues the metrics of a probe. The series are labeled with the module
+// and with the target as instance.
+func (q *Queue) Push(module, target string, mfs []*dto.MetricFamily) {
+	cfg := q.config()
+	if cfg == nil {
+		return
+	}
+	base := []label{{"instance", target}, {"module", module}}
+	for name, value := range cfg.ExternalLabels {
+		if name != "instance" && name != "module" {
+			base = append(base, label{name, value})
+		}
+	}
+	timestamp := time.Now().UnixMilli()
+
+	var added []series
+	add := func(name string, m *dto.Metric, value float64, extra ...label) {
+		labels := append([]label{{"__name__", name}}, base...)
+		for _, l := range m.Label {
+			labels = append(labels, label{l.GetName(), l.GetValue()})
+		}
+		labels = append(labels, extra...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		added = append(added, series{labels: labels, value: value, timestamp: timestamp})
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			switch {
+			case m.Gauge != nil:
+				add(mf.GetName(), m, m.Gauge.GetValue())
+			case m.Counter != nil:
+				add(mf.GetName(), m, m.Counter.GetValue())
+			case m.Untyped != nil:
+				add(mf.GetName(), m, m.Untyped.GetValue())
+			case m.Histogram != nil:
+				for _, b := range m.Histogram.Bucket {
+					add(mf.GetName()+"_bucket", m, float64(b.GetCumulativeCount()), label{"le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)})
+				}
+				add(mf.GetName()+"_bucket", m, float64(m.Histogram.GetSampleCount()), label{"le", "+Inf"})
+				add(mf.GetName()+"_sum", m, m.Histogram.GetSampleSum())
+				add(mf.GetName()+"_count", m, float64(m.Histogram.GetSampleCount()))
+			}
+		}
+	}
+
+	q.mtx.Lock()
+	q.pending = append(q.pending, added...)
+	if dropped := len(q.pending) - cfg.QueueCapacity; dropped > 0 {
+		q.pending = q.pending[dropped:]
+		q.samplesDropped.Add(float64(dropped))
+	}
+	q.mtx.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the queued series until the context is canceled. The series not
+// sent yet stay queued, so they can be sent by Flush.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.notify:
+		}
+		for {
+			batch := q.next()
+			if len(batch) == 0 {
+				break
+			}
+			if !q.sendWithRetry(ctx, batch) {
+				q.requeue(batch)
+				return
+			}
+		}
+	}
+}
+
+// Flush sends the queued series until the queue is empty, e.g. on shutdown.
+// It returns an error if the context is canceled first. It must not be called
+// while Run is running.
+func (q *Queue) Flush(ctx context.Context) error {
+	for {
+		batch := q.next()
+		if len(batch) == 0 {
+			return nil
+		}
+		if !q.sendWithRetry(ctx, batch) {
+			q.requeue(batch)
+			q.mtx.Lock()
+			defer q.mtx.Unlock()
+			return fmt.Errorf("%d samples were not sent: %w", len(q.pending), ctx.Err())
+		}
+	}
+}
+
+// next removes the next batch from the queue.
+func (q *Queue) next() []series {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	n := min(len(q.pending), maxBatchSize)
+	batch := q.pending[:n:n]
+	q.pending = q.pending[n:]
+	return batch
+}
+
+// requeue puts a batch that was not sent back at the front of the queue.
+func (q *Queue) requeue(batch []series) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.pending = append(batch, q.pending...)
+}
+
+// sendWithRetry sends the batch, retrying with exponential backoff until it
+// is accepted or rejected. It returns false if the context was canceled.
+func (q *Queue) sendWithRetry(ctx context.Context, batch []series) bool {
+	backoff := minBackoff
+	for {
+		cfg := q.config()
+		if cfg == nil {
+			// Remote write was disabled by a reload.
+			return true
+		}
+		err := q.send(ctx, cfg, batch)
+		if err == nil {
+			q.samplesSent.Add(float64(len(batch)))
+			return true
+		}
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			level.Error(q.logger).Log("msg", "Remote write endpoint rejected samples", "count", len(batch), "err", err)
+			q.samplesFailed.Add(float64(len(batch)))
+			return true
+		}
+		level.Warn(q.logger).Log("msg", "Error sending samples to remote write endpoint, retrying", "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, cfg.MaxBackoff)
+	}
+}
+
+// rejectedError is returned for requests that must not be retried.
+type rejectedError struct {
+	status int
+	body   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %d: %s", e.status, e.body)
+}
+
+func (q *Queue) send(ctx context.Context, cfg *config.RemoteWriteConfig, batch []series) error {
+	if q.clientConfig != cfg {
+		client, err := pconfig.NewClientFromConfig(cfg.HTTPClientConfig, "remote_write")
+		if err != nil {
+			return err
+		}
+		q.client, q.clientConfig = client, cfg
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL.String(), bytes.NewReader(snappy.Encode(nil, encodeWriteRequest(batch))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "Blackbox Exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("server returned HTTP status %d: %s", resp.StatusCode, body)
+	default:
+		return &rejectedError{status: resp.StatusCode, body: string(body)}
+	}
+}
+
+// encodeWriteRequest encodes the series as a remote write WriteRequest
+// protobuf message.
+func encodeWriteRequest(batch []series) []byte {
+	var b []byte
+	for _, s := range batch {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
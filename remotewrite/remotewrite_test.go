@@ -0,0 +1,295 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	pconfig "github.com/prometheus/common/config"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// decodeWriteRequest decodes a WriteRequest into one string per series of
+// the form `name=value,... value`, with the labels in the order sent.
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+	next := func(b []byte) (protowire.Number, []byte, []byte) {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("Error decoding tag: %s", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			v = b[:n]
+		case protowire.VarintType:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			v = b[:n]
+		}
+		if n < 0 {
+			t.Fatalf("Error decoding field %d: %s", num, protowire.ParseError(n))
+		}
+		return num, v, b[n:]
+	}
+
+	var result []string
+	for len(b) > 0 {
+		var ts []byte
+		_, ts, b = next(b)
+		var labels []string
+		var value string
+		for len(ts) > 0 {
+			var num protowire.Number
+			var v []byte
+			num, v, ts = next(ts)
+			switch num {
+			case 1:
+				_, name, rest := next(v)
+				_, val, _ := next(rest)
+				labels = append(labels, string(name)+"="+string(val))
+			case 2:
+				_, f, _ := next(v)
+				bits, _ := protowire.ConsumeFixed64(f)
+				value = strconv.FormatFloat(math.Float64frombits(bits), 'g', -1, 64)
+			}
+		}
+		result = append(result, strings.Join(labels, ",")+" "+value)
+	}
+	return result
+}
+
+type writeServer struct {
+	mtx      sync.Mutex
+	statuses []int
+	requests [][]string
+	received chan struct{}
+}
+
+func newWriteServer(t *testing.T, statuses ...int) (*writeServer, *httptest.Server) {
+	ws := &writeServer{statuses: statuses, received: make(chan struct{}, 10)}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("Error decoding body: %s", err)
+		}
+		ws.mtx.Lock()
+		status := http.StatusNoContent
+		if len(ws.statuses) > 0 {
+			status, ws.statuses = ws.statuses[0], ws.statuses[1:]
+		}
+		ws.requests = append(ws.requests, decodeWriteRequest(t, body))
+		ws.mtx.Unlock()
+		w.WriteHeader(status)
+		ws.received <- struct{}{}
+	}))
+	t.Cleanup(ts.Close)
+	return ws, ts
+}
+
+func (ws *writeServer) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-ws.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for request %d", i+1)
+		}
+	}
+}
+
+func newTestQueue(t *testing.T, serverURL string, capacity int) (*Queue, *prometheus.Registry) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.RemoteWriteConfig{
+		URL:              pconfig.URL{URL: u},
+		ExternalLabels:   map[string]string{"region": "edge"},
+		QueueCapacity:    capacity,
+		MaxBackoff:       time.Second,
+		HTTPClientConfig: pconfig.DefaultHTTPClientConfig,
+	}
+	registry := prometheus.NewRegistry()
+	return NewQueue(func() *config.RemoteWriteConfig { return cfg }, log.NewNopLogger(), registry), registry
+}
+
+func probeMetrics(success float64) []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "probe_success"})
+	g.Set(success)
+	gv := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "probe_http_duration_seconds"}, []string{"phase"})
+	gv.WithLabelValues("connect").Set(0.5)
+	registry.MustRegister(g, gv)
+	mfs, _ := registry.Gather()
+	return mfs
+}
+
+func TestPush(t *testing.T) {
+	ws, ts := newWriteServer(t)
+	q, registry := newTestQueue(t, ts.URL, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	q.Push("http_2xx", "example.com", probeMetrics(1))
+	ws.wait(t, 1)
+
+	expected := []string{
+		"__name__=probe_http_duration_seconds,instance=example.com,module=http_2xx,phase=connect,region=edge 0.5",
+		"__name__=probe_success,instance=example.com,module=http_2xx,region=edge 1",
+	}
+	ws.mtx.Lock()
+	got := ws.requests[0]
+	ws.mtx.Unlock()
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected series, got:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	waitForCounter(t, registry, "blackbox_remote_write_samples_total", 2)
+}
+
+func TestPushRetry(t *testing.T) {
+	ws, ts := newWriteServer(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	q, registry := newTestQueue(t, ts.URL, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	q.Push("http_2xx", "example.com", probeMetrics(1))
+	ws.wait(t, 2)
+	waitForCounter(t, registry, "blackbox_remote_write_samples_total", 2)
+	if v := counterValue(t, registry, "blackbox_remote_write_samples_failed_total"); v != 0 {
+		t.Fatalf("Expected no failed samples, got %v", v)
+	}
+}
+
+func TestPushRejected(t *testing.T) {
+	ws, ts := newWriteServer(t, http.StatusBadRequest)
+	q, registry := newTestQueue(t, ts.URL, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	q.Push("http_2xx", "example.com", probeMetrics(0))
+	ws.wait(t, 1)
+	waitForCounter(t, registry, "blackbox_remote_write_samples_failed_total", 2)
+	if v := counterValue(t, registry, "blackbox_remote_write_samples_total"); v != 0 {
+		t.Fatalf("Expected no sent samples, got %v", v)
+	}
+}
+
+func TestPushQueueFull(t *testing.T) {
+	q, registry := newTestQueue(t, "http://127.0.0.1:1/", 3)
+	// The queue is not running, so the oldest samples are dropped.
+	q.Push("http_2xx", "a.example.com", probeMetrics(1))
+	q.Push("http_2xx", "b.example.com", probeMetrics(1))
+	if v := counterValue(t, registry, "blackbox_remote_write_samples_dropped_total"); v != 1 {
+		t.Fatalf("Expected 1 dropped sample, got %v", v)
+	}
+	if len(q.pending) != 3 {
+		t.Fatalf("Expected 3 pending samples, got %d", len(q.pending))
+	}
+	if q.pending[0].labels[1].value != "a.example.com" {
+		t.Fatalf("Expected the oldest sample to be dropped, got %v", q.pending[0].labels)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	ws, ts := newWriteServer(t)
+	q, registry := newTestQueue(t, ts.URL, 100)
+	// The queue is not running, the samples are sent by Flush.
+	q.Push("http_2xx", "example.com", probeMetrics(1))
+	if err := q.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ws.wait(t, 1)
+	if v := counterValue(t, registry, "blackbox_remote_write_samples_total"); v != 2 {
+		t.Fatalf("Expected 2 sent samples, got %v", v)
+	}
+	if len(q.pending) != 0 {
+		t.Fatalf("Expected no pending samples, got %d", len(q.pending))
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	q, _ := newTestQueue(t, "http://127.0.0.1:1/", 100)
+	q.Push("http_2xx", "example.com", probeMetrics(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.Flush(ctx); err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the flush to time out, got %v", err)
+	}
+	if len(q.pending) != 2 {
+		t.Fatalf("Expected the samples to stay queued, got %d", len(q.pending))
+	}
+}
+
+func TestPushDisabled(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	q := NewQueue(func() *config.RemoteWriteConfig { return nil }, log.NewNopLogger(), registry)
+	q.Push("http_2xx", "example.com", probeMetrics(1))
+	if len(q.pending) != 0 {
+		t.Fatalf("Expected no pending samples, got %d", len(q.pending))
+	}
+}
+
+func counterValue(t *testing.T, registry *prometheus.Registry, name string) float64 {
+	t.Helper()
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.Metric[0].Counter.GetValue()
+		}
+	}
+	t.Fatalf("Metric %s not found", name)
+	return 0
+}
+
+func waitForCounter(t *testing.T, registry *prometheus.Registry, name string, expected float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for counterValue(t, registry, name) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to be %v, got %v", name, expected, counterValue(t, registry, name))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
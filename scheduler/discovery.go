@@ -0,0 +1,309 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	pconfig "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// targetGroup is a group of targets with common labels, in the format of
+// Prometheus file and HTTP service discovery.
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// discovery is a source of targets.
+type discovery interface {
+	// refresh returns the current targets.
+	refresh(ctx context.Context) ([]targetGroup, error)
+	interval() time.Duration
+}
+
+// discoverer runs a discovery and keeps the targets it returned last.
+type discoverer struct {
+	cancel context.CancelFunc
+	groups []targetGroup
+}
+
+// discoveryKey identifies the configuration of a discovery, so that the
+// targets of a discovery are kept across reloads that do not change it.
+func discoveryKey(kind string, cfg interface{}) string {
+	b, _ := yaml.Marshal(cfg)
+	return kind + "\n" + string(b)
+}
+
+// discoveries returns the discoveries of the scheduled probe by their keys.
+func discoveries(sp config.ScheduledProbe) map[string]discovery {
+	result := map[string]discovery{}
+	for _, cfg := range sp.FileSDConfigs {
+		result[discoveryKey("file", cfg)] = fileDiscovery{cfg: cfg}
+	}
+	for _, cfg := range sp.DNSSDConfigs {
+		result[discoveryKey("dns", cfg)] = dnsDiscovery{cfg: cfg}
+	}
+	for _, cfg := range sp.HTTPSDConfigs {
+		result[discoveryKey("http", cfg)] = &httpDiscovery{cfg: cfg}
+	}
+	return result
+}
+
+// syncDiscoverers restarts the discoveries of the configuration. A
+// discovery whose configuration did not change keeps its targets until its
+// first refresh, so its jobs are not interrupted by a reload.
+func (s *Scheduler) syncDiscoverers(ctx context.Context, c *config.Config) {
+	wanted := map[string]discovery{}
+	for _, sp := range c.ScheduledProbes {
+		for key, d := range discoveries(sp) {
+			wanted[key] = d
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	previous := s.discoverers
+	s.discoverers = map[string]*discoverer{}
+	for key, d := range wanted {
+		discoveryCtx, cancel := context.WithCancel(ctx)
+		dr := &discoverer{cancel: cancel}
+		if p, ok := previous[key]; ok {
+			dr.groups = p.groups
+		}
+		s.discoverers[key] = dr
+		go s.discover(discoveryCtx, dr, d)
+	}
+	for _, dr := range previous {
+		dr.cancel()
+	}
+}
+
+// discover refreshes the targets of the discovery on its interval until the
+// context is canceled. The previous targets are kept if a refresh fails.
+func (s *Scheduler) discover(ctx context.Context, dr *discoverer, d discovery) {
+	ticker := time.NewTicker(d.interval())
+	defer ticker.Stop()
+	for {
+		groups, err := d.refresh(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				level.Error(s.logger).Log("msg", "Error discovering targets", "err", err)
+			}
+		} else {
+			s.mtx.Lock()
+			if ctx.Err() == nil {
+				dr.groups = groups
+				s.discovered.Add(1)
+			}
+			s.mtx.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// targets returns the static and discovered targets of the scheduled probe
+// that are kept by its relabel configs. It must be called with the mutex
+// held.
+func (s *Scheduler) targets(sp config.ScheduledProbe) []string {
+	groups := []targetGroup{{Targets: sp.Targets}}
+	for key := range discoveries(sp) {
+		if dr, ok := s.discoverers[key]; ok {
+			groups = append(groups, dr.groups...)
+		}
+	}
+	var targets []string
+	for _, group := range groups {
+		for _, target := range group.Targets {
+			labels := map[string]string{}
+			for name, value := range group.Labels {
+				labels[name] = value
+			}
+			labels["__address__"] = target
+			if relabel(labels, sp.RelabelConfigs) && labels["__address__"] != "" {
+				targets = append(targets, labels["__address__"])
+			}
+		}
+	}
+	return targets
+}
+
+// relabel applies the relabel configs to the labels of a target. It returns
+// false if the target is dropped.
+func relabel(labels map[string]string, cfgs []config.RelabelConfig) bool {
+	for _, cfg := range cfgs {
+		values := make([]string, 0, len(cfg.SourceLabels))
+		for _, name := range cfg.SourceLabels {
+			values = append(values, labels[name])
+		}
+		value := strings.Join(values, cfg.Separator)
+		switch cfg.Action {
+		case "keep":
+			if !cfg.Regex.MatchString(value) {
+				return false
+			}
+		case "drop":
+			if cfg.Regex.MatchString(value) {
+				return false
+			}
+		case "replace":
+			indexes := cfg.Regex.FindStringSubmatchIndex(value)
+			if indexes == nil {
+				continue
+			}
+			result := cfg.Regex.ExpandString(nil, cfg.Replacement, value, indexes)
+			if len(result) == 0 {
+				delete(labels, cfg.TargetLabel)
+			} else {
+				labels[cfg.TargetLabel] = string(result)
+			}
+		}
+	}
+	return true
+}
+
+type fileDiscovery struct {
+	cfg config.FileSDConfig
+}
+
+func (d fileDiscovery) interval() time.Duration {
+	return d.cfg.RefreshInterval
+}
+
+func (d fileDiscovery) refresh(ctx context.Context) ([]targetGroup, error) {
+	var result []targetGroup
+	for _, pattern := range d.cfg.Files {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %s", pattern, err)
+		}
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			var groups []targetGroup
+			// YAML is a superset of JSON.
+			if err := yaml.Unmarshal(content, &groups); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %s", file, err)
+			}
+			for _, group := range groups {
+				result = append(result, withLabel(group, "__meta_filepath", file))
+			}
+		}
+	}
+	return result, nil
+}
+
+type dnsDiscovery struct {
+	cfg config.DNSSDConfig
+}
+
+func (d dnsDiscovery) interval() time.Duration {
+	return d.cfg.RefreshInterval
+}
+
+func (d dnsDiscovery) refresh(ctx context.Context) ([]targetGroup, error) {
+	var result []targetGroup
+	for _, name := range d.cfg.Names {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			port := strconv.Itoa(int(record.Port))
+			result = append(result, targetGroup{
+				Targets: []string{net.JoinHostPort(host, port)},
+				Labels: map[string]string{
+					"__meta_dns_name":              name,
+					"__meta_dns_srv_record_target": record.Target,
+					"__meta_dns_srv_record_port":   port,
+				},
+			})
+		}
+	}
+	return result, nil
+}
+
+type httpDiscovery struct {
+	cfg    config.HTTPSDConfig
+	client *http.Client
+}
+
+func (d *httpDiscovery) interval() time.Duration {
+	return d.cfg.RefreshInterval
+}
+
+func (d *httpDiscovery) refresh(ctx context.Context) ([]targetGroup, error) {
+	if d.client == nil {
+		client, err := pconfig.NewClientFromConfig(d.cfg.HTTPClientConfig, "http_sd")
+		if err != nil {
+			return nil, err
+		}
+		d.client = client
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.cfg.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned HTTP status %s", d.cfg.URL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var groups []targetGroup
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, fmt.Errorf("error parsing the response of %s: %s", d.cfg.URL, err)
+	}
+	for i, group := range groups {
+		groups[i] = withLabel(group, "__meta_url", d.cfg.URL.String())
+	}
+	return groups, nil
+}
+
+// withLabel returns the group with a label added.
+func withLabel(group targetGroup, name, value string) targetGroup {
+	labels := map[string]string{name: value}
+	for n, v := range group.Labels {
+		labels[n] = v
+	}
+	return targetGroup{Targets: group.Targets, Labels: labels}
+}
@@ -0,0 +1,223 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	pconfig "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus/blackbox_exporter/config"
+	"github.com/prometheus/blackbox_exporter/prober"
+)
+
+func mustRelabelConfigs(t *testing.T, s string) []config.RelabelConfig {
+	t.Helper()
+	var cfgs []config.RelabelConfig
+	if err := yaml.Unmarshal([]byte(s), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	return cfgs
+}
+
+func TestRelabel(t *testing.T) {
+	cfgs := mustRelabelConfigs(t, `
+- source_labels: [__meta_env]
+  regex: prod|staging
+  action: keep
+- source_labels: [__address__]
+  regex: .*\.internal
+  action: drop
+- source_labels: [__address__, __meta_port]
+  separator: ":"
+  regex: (.*):(\d+)
+  target_label: __address__
+  replacement: https://$1:$2/
+`)
+	for _, test := range []struct {
+		labels   map[string]string
+		expected string
+	}{
+		{
+			labels:   map[string]string{"__address__": "example.com", "__meta_env": "prod"},
+			expected: "example.com",
+		},
+		{
+			labels:   map[string]string{"__address__": "example.com", "__meta_env": "staging", "__meta_port": "8443"},
+			expected: "https://example.com:8443/",
+		},
+		{
+			// The regex is anchored.
+			labels: map[string]string{"__address__": "example.com", "__meta_env": "production"},
+		},
+		{
+			labels: map[string]string{"__address__": "db.internal", "__meta_env": "prod"},
+		},
+	} {
+		kept := relabel(test.labels, cfgs)
+		if test.expected == "" {
+			if kept {
+				t.Errorf("Expected %v to be dropped", test.labels)
+			}
+			continue
+		}
+		if !kept || test.labels["__address__"] != test.expected {
+			t.Errorf("Expected %v to be kept with address %q", test.labels, test.expected)
+		}
+	}
+}
+
+func TestRelabelReplace(t *testing.T) {
+	cfgs := mustRelabelConfigs(t, `
+- source_labels: [__address__]
+  regex: (.*):\d+
+  target_label: __address__
+  replacement: https://$1/
+`)
+	labels := map[string]string{"__address__": "example.com:443"}
+	if !relabel(labels, cfgs) || labels["__address__"] != "https://example.com/" {
+		t.Fatalf("Unexpected labels %v", labels)
+	}
+	// Targets that do not match are not changed.
+	labels = map[string]string{"__address__": "example.com"}
+	if !relabel(labels, cfgs) || labels["__address__"] != "example.com" {
+		t.Fatalf("Unexpected labels %v", labels)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte(`[{"targets": ["a.example.com"], "labels": {"env": "prod"}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.yml"), []byte("- targets: [b.example.com, c.example.com]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := fileDiscovery{cfg: config.FileSDConfig{Files: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yml")}}}
+	groups, err := d.refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []targetGroup{
+		{Targets: []string{"a.example.com"}, Labels: map[string]string{"env": "prod", "__meta_filepath": filepath.Join(dir, "a.json")}},
+		{Targets: []string{"b.example.com", "c.example.com"}, Labels: map[string]string{"__meta_filepath": filepath.Join(dir, "b.yml")}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("Unexpected groups, got %v, expected %v", groups, expected)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "c.yml"), []byte("targets: invalid\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.refresh(context.Background()); err == nil {
+		t.Fatal("Expected an error for an invalid file")
+	}
+}
+
+func TestHTTPDiscovery(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/targets" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"targets": ["a.example.com"], "labels": {"env": "prod"}}]`))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/targets")
+	d := &httpDiscovery{cfg: config.HTTPSDConfig{URL: pconfig.URL{URL: u}, HTTPClientConfig: pconfig.DefaultHTTPClientConfig}}
+	groups, err := d.refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []targetGroup{
+		{Targets: []string{"a.example.com"}, Labels: map[string]string{"env": "prod", "__meta_url": u.String()}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("Unexpected groups, got %v, expected %v", groups, expected)
+	}
+
+	u, _ = url.Parse(ts.URL + "/missing")
+	d = &httpDiscovery{cfg: config.HTTPSDConfig{URL: pconfig.URL{URL: u}, HTTPClientConfig: pconfig.DefaultHTTPClientConfig}}
+	if _, err := d.refresh(context.Background()); err == nil {
+		t.Fatal("Expected an error for a failed request")
+	}
+}
+
+func TestDiscoveredTargets(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "targets.json")
+	if err := os.WriteFile(file, []byte(`[{"targets": ["a.example.com", "b.example.com"]}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sp := config.ScheduledProbe{
+		Module:         "http_2xx",
+		Targets:        []string{"static.example.com"},
+		Interval:       time.Hour,
+		FileSDConfigs:  []config.FileSDConfig{{Files: []string{file}, RefreshInterval: time.Hour}},
+		RelabelConfigs: mustRelabelConfigs(t, "- source_labels: [__address__]\n  regex: b\\..*\n  action: drop\n"),
+	}
+	c := &config.Config{
+		Modules:         map[string]config.Module{"http_2xx": {Prober: "http"}},
+		ScheduledProbes: []config.ScheduledProbe{sp},
+	}
+	s := New(func() *config.Config { return c }, log.NewNopLogger(), &prober.ResultHistory{MaxResults: 10}, 0, level.AllowNone(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	targets := func() []string {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		targets := s.targets(sp)
+		sort.Strings(targets)
+		return targets
+	}
+	s.syncDiscoverers(ctx, c)
+	waitFor(t, func() bool { return s.discovered.Load() > 0 })
+	expected := []string{"a.example.com", "static.example.com"}
+	if got := targets(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected targets, got %v, expected %v", got, expected)
+	}
+
+	// A reload keeps the discovered targets until the discovery refreshed,
+	// which never happens with a canceled context.
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancelReload := context.WithCancel(ctx)
+	cancelReload()
+	s.syncDiscoverers(canceled, c)
+	if got := targets(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected targets after reload, got %v, expected %v", got, expected)
+	}
+	s.syncDiscoverers(ctx, c)
+	waitFor(t, func() bool { return s.discovered.Load() > 1 })
+	expected = []string{"static.example.com"}
+	if got := targets(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected targets after refresh, got %v, expected %v", got, expected)
+	}
+}
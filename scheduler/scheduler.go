@@ -0,0 +1,216 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scheduler probes the scheduled_probes of the configuration on their
+// own intervals, independent of scrapes, which turns the exporter into a
+// self-contained synthetic monitoring agent.
+package scheduler
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/blackbox_exporter/config"
+	"github.com/prometheus/blackbox_exporter/prober"
+)
+
+// syncInterval is how often the scheduler checks for a new configuration and
+// for changes of the discovered targets.
+const syncInterval = time.Second
+
+// job is a target that is probed with a module on an interval.
+type job struct {
+	module   string
+	target   string
+	interval time.Duration
+}
+
+// Scheduler runs the scheduled probes of the current configuration and keeps
+// the metrics of the latest probe of each target.
+type Scheduler struct {
+	config         func() *config.Config
+	logger         log.Logger
+	history        *prober.ResultHistory
+	timeoutOffset  float64
+	logLevelProber level.Option
+	// pusher also receives the results, e.g. to push them to a remote
+	// write endpoint. It can be nil.
+	pusher prober.ResultPusher
+
+	mtx         sync.Mutex
+	jobs        map[job]context.CancelFunc
+	results     map[job][]*dto.MetricFamily
+	discoverers map[string]*discoverer
+	// discovered is incremented whenever a discovery refreshed its targets.
+	discovered atomic.Int64
+}
+
+// New returns a scheduler for the configuration returned by cfg. The probes
+// are recorded in the history like those of /probe requests.
+func New(cfg func() *config.Config, logger log.Logger, rh *prober.ResultHistory, timeoutOffset float64, logLevelProber level.Option, pusher prober.ResultPusher) *Scheduler {
+	return &Scheduler{
+		config:         cfg,
+		logger:         logger,
+		history:        rh,
+		timeoutOffset:  timeoutOffset,
+		logLevelProber: logLevelProber,
+		pusher:         pusher,
+		jobs:           map[job]context.CancelFunc{},
+		results:        map[job][]*dto.MetricFamily{},
+		discoverers:    map[string]*discoverer{},
+	}
+}
+
+// Run starts and stops the probes as the configuration and the discovered
+// targets change, until the context is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	var applied *config.Config
+	var discovered int64
+	for {
+		c := s.config()
+		if c != applied {
+			s.syncDiscoverers(ctx, c)
+		}
+		if d := s.discovered.Load(); c != applied || d != discovered {
+			s.sync(ctx, c)
+			applied, discovered = c, d
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync starts the jobs of the configuration that are not running yet and
+// stops those that were removed, dropping their results.
+func (s *Scheduler) sync(ctx context.Context, c *config.Config) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	wanted := map[job]bool{}
+	seen := map[[2]string]bool{}
+	for _, sp := range c.ScheduledProbes {
+		for _, target := range s.targets(sp) {
+			// The results of a target are only exposed once per module.
+			if seen[[2]string{sp.Module, target}] {
+				continue
+			}
+			seen[[2]string{sp.Module, target}] = true
+			wanted[job{module: sp.Module, target: target, interval: sp.Interval}] = true
+		}
+	}
+
+	for j, cancel := range s.jobs {
+		if !wanted[j] {
+			cancel()
+			delete(s.jobs, j)
+			delete(s.results, j)
+		}
+	}
+	for j := range wanted {
+		if _, ok := s.jobs[j]; !ok {
+			jobCtx, cancel := context.WithCancel(ctx)
+			s.jobs[j] = cancel
+			go s.run(jobCtx, j)
+		}
+	}
+}
+
+// run probes the target of the job on its interval until the context is
+// canceled.
+func (s *Scheduler) run(ctx context.Context, j job) {
+	// Spread the probes over the interval, like Prometheus spreads scrapes.
+	h := fnv.New64a()
+	h.Write([]byte(j.module + "\x00" + j.target))
+	offset := time.NewTimer(time.Duration(h.Sum64() % uint64(j.interval)))
+	defer offset.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-offset.C:
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		s.probe(ctx, j)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe runs a probe through the handler of /probe requests, so that it
+// behaves exactly like a scraped one.
+func (s *Scheduler) probe(ctx context.Context, j job) {
+	params := url.Values{"module": {j.module}, "target": {j.target}}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/probe?"+params.Encode(), nil)
+	if err != nil {
+		level.Error(s.logger).Log("msg", "Error creating probe request", "module", j.module, "target", j.target, "err", err)
+		return
+	}
+	// A probe must finish before the next one starts.
+	r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(j.interval.Seconds(), 'f', -1, 64))
+
+	mfs, err := prober.Probe(r, s.config(), s.logger, s.history, s.timeoutOffset, params, s.logLevelProber, s.pusher)
+	if err != nil {
+		// The probe was not run, e.g. because a target filter denies the
+		// target.
+		level.Warn(s.logger).Log("msg", "Scheduled probe was not run", "module", j.module, "target", j.target, "err", err)
+	}
+	s.store(ctx, j, mfs)
+}
+
+// store replaces the results of the job, unless it was stopped.
+func (s *Scheduler) store(ctx context.Context, j job, mfs []*dto.MetricFamily) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	if mfs == nil {
+		delete(s.results, j)
+		return
+	}
+	s.results[j] = mfs
+}
+
+// Gather implements prometheus.Gatherer. It returns the metrics of the latest
+// probe of each target, labeled with the module and the target.
+func (s *Scheduler) Gather() ([]*dto.MetricFamily, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	results := make([]prober.LabeledMetrics, 0, len(s.results))
+	for j, mfs := range s.results {
+		results = append(results, prober.LabeledMetrics{
+			Labels:   map[string]string{"module": j.module, "target": j.target},
+			Families: mfs,
+		})
+	}
+	return prober.MergeMetrics(results), nil
+}
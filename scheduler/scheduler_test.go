@@ -0,0 +1,165 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	dto "github.com/prometheus/client_model/go"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+	"github.com/prometheus/blackbox_exporter/prober"
+)
+
+// probeSuccess returns the probe_success values gathered from the scheduler
+// by target.
+func probeSuccess(t *testing.T, s *Scheduler) map[string]float64 {
+	t.Helper()
+	mfs, err := s.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_success" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			labels := map[string]string{}
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["module"] != "http_2xx" {
+				t.Fatalf("Unexpected labels %v", labels)
+			}
+			values[labels["target"]] = m.Gauge.GetValue()
+		}
+	}
+	return values
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type testPusher struct {
+	mtx     sync.Mutex
+	targets map[string]bool
+}
+
+func (p *testPusher) Push(module, target string, mfs []*dto.MetricFamily) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.targets[target] = true
+}
+
+func TestScheduler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	module := config.Module{
+		Prober:  "http",
+		Timeout: time.Second,
+		HTTP:    config.HTTPProbe{IPProtocolFallback: true, HTTPClientConfig: pconfig.DefaultHTTPClientConfig},
+	}
+	var mtx sync.Mutex
+	c := &config.Config{
+		Modules: map[string]config.Module{"http_2xx": module},
+		ScheduledProbes: []config.ScheduledProbe{
+			{Module: "http_2xx", Targets: []string{ts.URL, ts.URL + "/missing"}, Interval: 50 * time.Millisecond},
+			// Duplicates of a target are ignored.
+			{Module: "http_2xx", Targets: []string{ts.URL}, Interval: time.Hour},
+		},
+	}
+	pusher := &testPusher{targets: map[string]bool{}}
+	rh := &prober.ResultHistory{MaxResults: 10}
+	s := New(func() *config.Config {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return c
+	}, log.NewNopLogger(), rh, 0, level.AllowNone(), pusher)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.sync(ctx, c)
+
+	waitFor(t, func() bool { return len(probeSuccess(t, s)) == 2 })
+	values := probeSuccess(t, s)
+	if values[ts.URL] != 1 || values[ts.URL+"/missing"] != 0 {
+		t.Fatalf("Unexpected probe_success values %v", values)
+	}
+	pusher.mtx.Lock()
+	if !pusher.targets[ts.URL] || !pusher.targets[ts.URL+"/missing"] {
+		t.Errorf("Expected the results to be pushed, got %v", pusher.targets)
+	}
+	pusher.mtx.Unlock()
+	if len(rh.List()) == 0 {
+		t.Errorf("Expected the probes to be recorded in the history")
+	}
+
+	// Removing a target drops its results.
+	mtx.Lock()
+	c = &config.Config{
+		Modules: c.Modules,
+		ScheduledProbes: []config.ScheduledProbe{
+			{Module: "http_2xx", Targets: []string{ts.URL}, Interval: 50 * time.Millisecond},
+		},
+	}
+	mtx.Unlock()
+	s.sync(ctx, c)
+	values = probeSuccess(t, s)
+	if len(values) != 1 || values[ts.URL] != 1 {
+		t.Fatalf("Unexpected probe_success values after reload %v", values)
+	}
+	if len(s.jobs) != 1 {
+		t.Fatalf("Expected 1 job, got %d", len(s.jobs))
+	}
+}
+
+func TestSchedulerDeniedTarget(t *testing.T) {
+	c := &config.Config{
+		TargetFilter: config.TargetFilter{DenyHostnames: []config.Regexp{config.MustNewRegexp("^(?:denied\\.example\\.com)$")}},
+		Modules: map[string]config.Module{"http_2xx": {
+			Prober:  "http",
+			Timeout: time.Second,
+		}},
+	}
+	// The handler rejects host names that a filter denies before probing.
+	s := New(func() *config.Config { return c }, log.NewNopLogger(), &prober.ResultHistory{MaxResults: 10}, 0, level.AllowNone(), nil)
+	j := job{module: "http_2xx", target: "http://denied.example.com", interval: time.Minute}
+	s.results[j] = []*dto.MetricFamily{{}}
+	s.probe(context.Background(), j)
+	if _, ok := s.results[j]; ok {
+		t.Fatal("Expected the results of a denied target to be dropped")
+	}
+}